@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"microauth.io/core/internal/audit"
+)
+
+const verifyAuditUsage = `usage: server verify-audit [flags] [export.jsonl]
+
+without a file walks the audit chain of every organization in the database
+and reports the first break, checkpoint signatures are checked when a key
+is configured. with a file checks an export offline, only audit.public_key
+or audit.signing_key is needed. exits with 1 on a break.
+
+flags are the database and audit flags of the server, see server -h`
+
+const exportAuditUsage = `usage: server export-audit [flags] <organizationID> [from] [to]
+
+writes the audit events of the organization from sequence from to sequence
+to, the whole chain by default, as JSONL to the standard output, followed
+by the range signed with audit.signing_key. verify-audit checks the file.
+
+flags are the database and audit flags of the server, see server -h`
+
+func runVerifyAudit(args []string) {
+	cfg, args := loadConfig("verify-audit", args)
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, verifyAuditUsage)
+		os.Exit(2)
+	}
+	publicKey, err := cfg.Audit.VerifyingKey()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if len(args) == 1 {
+		if publicKey == nil {
+			log.Fatalln("verifying an export needs audit.public_key or audit.signing_key")
+		}
+		file, err := os.Open(args[0])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer file.Close()
+		exported, err := audit.VerifyExport(file, publicKey)
+		reportBreak(err)
+		log.Printf("export of %q is intact, sequences %d to %d", exported.OrganizationID, exported.FirstSequence, exported.LastSequence)
+		return
+	}
+
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+	if publicKey == nil {
+		log.Println("no audit key is configured, checkpoint signatures are not checked")
+	}
+	db := connect(cfg.Database)
+	defer db.Close()
+	checked, err := audit.New(db, audit.Options{}).Verify(context.Background(), publicKey)
+	reportBreak(err)
+	log.Println("audit chains are intact,", checked, "events checked")
+}
+
+// reportBreak exits with 1 on a broken chain and 2 when it could not be
+// checked
+func reportBreak(err error) {
+	var broken *audit.Break
+	if errors.As(err, &broken) {
+		log.Println(broken)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func runExportAudit(args []string) {
+	cfg, args := loadConfig("export-audit", args)
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+	if len(args) < 1 || len(args) > 3 {
+		fmt.Fprintln(os.Stderr, exportAuditUsage)
+		os.Exit(2)
+	}
+	bounds := make([]int64, 2)
+	for i, arg := range args[1:] {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 1 {
+			fmt.Fprintln(os.Stderr, exportAuditUsage)
+			os.Exit(2)
+		}
+		bounds[i] = n
+	}
+	options, err := cfg.Audit.Options()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db := connect(cfg.Database)
+	defer db.Close()
+	exported, err := audit.New(db, options).Export(context.Background(), os.Stdout, args[0], bounds[0], bounds[1])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("exported sequences %d to %d", exported.FirstSequence, exported.LastSequence)
+}
+
+func runAuditKeygen(args []string) {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: server audit-keygen\n\nprints a new audit.signing_key and its audit.public_key")
+		os.Exit(2)
+	}
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println("signing_key:", base64.StdEncoding.EncodeToString(privateKey.Seed()))
+	fmt.Println("public_key:", base64.StdEncoding.EncodeToString(publicKey))
+}
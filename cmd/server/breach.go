@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"microauth.io/core/internal/password"
+)
+
+const breachCorpusUsage = `usage: server breach-corpus -in LIST -out FILE [-fp RATE]
+
+builds the bloom filter of the breach check from a breached password list,
+either the SHA-1 lines of a k-anonymity download (HASH or HASH:COUNT) or
+one password per line, and writes it to the file named by
+password.breach_corpus`
+
+func runBreachCorpus(args []string) {
+	flags := flag.NewFlagSet("breach-corpus", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, breachCorpusUsage)
+		flags.PrintDefaults()
+	}
+	in := flags.String("in", "", "breached password list")
+	out := flags.String("out", "", "bloom filter to write")
+	falsePositive := flags.Float64("fp", 0.001, "false positive rate, the share of good passwords rejected")
+	flags.Parse(args)
+
+	if *in == "" || *out == "" || *falsePositive <= 0 || *falsePositive >= 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	list, err := os.Open(*in)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer list.Close()
+
+	// The filter is sized from the number of entries, the list is read
+	// twice rather than held in memory
+	count, err := scanCorpus(list, func([20]byte) {})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	_, err = list.Seek(0, io.SeekStart)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	bloom := password.NewBloom(count, *falsePositive)
+	_, err = scanCorpus(list, bloom.AddDigest)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	writer := bufio.NewWriter(file)
+	_, err = bloom.WriteTo(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("wrote", count, "breached passwords to", *out)
+}
+
+func scanCorpus(r io.Reader, add func([20]byte)) (int, error) {
+	scanner := bufio.NewScanner(r)
+	count := 0
+	for scanner.Scan() {
+		digest, ok := password.ParseCorpusLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		add(digest)
+		count++
+	}
+	return count, scanner.Err()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/hasher"
+	"microauth.io/core/internal/user"
+	"microauth.io/core/internal/validate"
+)
+
+const importUsersUsage = `usage: server import-users [flags] <format> <file>
+
+formats:
+  jsonl      one user per line: {"email", "first_name", "last_name",
+             "email_verified", "password_hash"}, the hash is a PHC string,
+             a bcrypt hash or a Django pbkdf2_sha256 or pbkdf2_sha1 hash
+  firebase   the json written by firebase auth:export, the hash parameters
+             of the project are read from the hash.firebase_* settings
+
+existing emails are skipped, imported passwords are rehashed with
+hash.algorithm on the first login. flags are the database and hash flags
+of the server, see server -h`
+
+// importedUser is a user of the jsonl format
+type importedUser struct {
+	Email         string `json:"email"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	EmailVerified bool   `json:"email_verified"`
+	PasswordHash  string `json:"password_hash"`
+}
+
+// firebaseExport is the part of a firebase auth:export file the import
+// reads
+type firebaseExport struct {
+	Users []struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"emailVerified"`
+		DisplayName   string `json:"displayName"`
+		PasswordHash  string `json:"passwordHash"`
+		Salt          string `json:"salt"`
+	} `json:"users"`
+}
+
+func runImportUsers(args []string) {
+	cfg, args := loadConfig("import-users", args)
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, importUsersUsage)
+		os.Exit(2)
+	}
+
+	file, err := os.Open(args[1])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer file.Close()
+
+	var users []importedUser
+	switch args[0] {
+	case "jsonl":
+		users, err = readJSONLines(file)
+	case "firebase":
+		users, err = readFirebaseExport(file, cfg.Hash.Options().Firebase)
+	default:
+		fmt.Fprintln(os.Stderr, importUsersUsage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db := connect(cfg.Database)
+	defer db.Close()
+	userService := user.New(db, user.Options{
+		Hasher:  newHasher(cfg.Hash),
+		Auditor: audit.New(db, audit.Options{}),
+	})
+
+	ctx := context.Background()
+	var imported, skipped int
+	for _, u := range users {
+		email := strings.ToLower(strings.TrimSpace(u.Email))
+		if !validate.Email(email, "") {
+			log.Println("skipping", u.Email, "invalid email address")
+			skipped++
+			continue
+		}
+		_, err := userService.ImportUser(ctx, u.FirstName, u.LastName, email, u.PasswordHash, u.EmailVerified)
+		if errors.Is(err, user.EmailTaken) {
+			log.Println("skipping", email, "already registered")
+			skipped++
+			continue
+		}
+		if err != nil {
+			log.Println("skipping", email, err)
+			skipped++
+			continue
+		}
+		imported++
+	}
+	log.Println("imported", imported, "users, skipped", skipped)
+}
+
+func readJSONLines(r io.Reader) ([]importedUser, error) {
+	var users []importedUser
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var u importedUser
+		err := json.Unmarshal(scanner.Bytes(), &u)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		users = append(users, u)
+	}
+	return users, scanner.Err()
+}
+
+// readFirebaseExport converts the firebase users, users without a password
+// such as federated ones are left out
+func readFirebaseExport(r io.Reader, params hasher.FirebaseParams) ([]importedUser, error) {
+	var export firebaseExport
+	err := json.NewDecoder(r).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]importedUser, 0, len(export.Users))
+	for _, u := range export.Users {
+		if u.PasswordHash == "" {
+			log.Println("skipping", u.Email, "no password")
+			continue
+		}
+		passwordHash, err := hasher.FromFirebase(u.PasswordHash, u.Salt, params)
+		if err != nil {
+			log.Println("skipping", u.Email, err)
+			continue
+		}
+		firstName, lastName, _ := strings.Cut(strings.TrimSpace(u.DisplayName), " ")
+		users = append(users, importedUser{
+			Email:         u.Email,
+			FirstName:     firstName,
+			LastName:      strings.TrimSpace(lastName),
+			EmailVerified: u.EmailVerified,
+			PasswordHash:  passwordHash,
+		})
+	}
+	return users, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"microauth.io/core/internal/migrate"
+)
+
+const migrateUsage = `usage: server migrate [flags] <command>
+
+commands:
+  up         apply all pending migrations
+  down N     revert the last N migrations
+  status     list migrations and the current version
+  force V    set the version without running migrations
+
+flags are the database flags of the server, see server -h`
+
+func runMigrate(args []string) {
+	cfg, args := loadConfig("migrate", args)
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	db := connect(cfg.Database)
+	migrator := newMigrator(db)
+
+	var err error
+	switch args[0] {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx, parseNumber(args))
+	case "force":
+		err = migrator.Force(ctx, uint(parseNumber(args)))
+	case "status":
+		err = printStatus(ctx, migrator)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	if err == migrate.NoChange {
+		log.Println("no change")
+		return
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	current, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("database version:", current, "dirty:", dirty)
+}
+
+func parseNumber(args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 {
+		log.Fatalln("invalid number:", args[1])
+	}
+	return n
+}
+
+func printStatus(ctx context.Context, migrator *migrate.Migrator) error {
+	statuses, current, dirty, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied"
+		}
+		fmt.Printf("%06d  %-8s %s\n", status.Version, state, status.Name)
+	}
+	if current > migrator.Latest() {
+		fmt.Printf("database version %d is ahead of this binary (%d)\n", current, migrator.Latest())
+	}
+	if dirty {
+		fmt.Printf("database version %d is dirty\n", current)
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+// Package audit records who did what. Services describe an action with an
+// Event, the actor and the client are taken from the request context. The
+// store only appends events and reads them back, newest first, and the
+// events are hash chained so an edit is detected, see chain.go.
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"log"
+	"strconv"
+	"time"
+
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/page"
+)
+
+const (
+	UserCreated              = "user.created"
+	UserImported             = "user.imported"
+	UserProvisioned          = "user.provisioned"
+	UserLoggedIn             = "user.logged_in"
+	UserLoginFailed          = "user.login_failed"
+	UserPasswordChanged      = "user.password_changed"
+	UserNameChanged          = "user.name_changed"
+	UserEmailChangeRequested = "user.email_change_requested"
+	UserEmailChanged         = "user.email_changed"
+	UserDeletionRequested    = "user.deletion_requested"
+	UserDeletionCancelled    = "user.deletion_cancelled"
+	UserDeleted              = "user.deleted"
+	UserDataExported         = "user.data_exported"
+	UserRemoved              = "user.removed"
+	UserRestored             = "user.restored"
+
+	OrganizationCreated   = "organization.created"
+	OrganizationUpdated   = "organization.updated"
+	OrganizationDeleted   = "organization.deleted"
+	OrganizationRestored  = "organization.restored"
+	OrganizationPurged    = "organization.purged"
+	BrandingUpdated       = "organization.branding_updated"
+	PasswordPolicyUpdated = "organization.password_policy_updated"
+	MemberInvited         = "member.invited"
+	InviteAccepted        = "member.invite_accepted"
+	MemberAdded           = "member.added"
+	MemberRoleChanged     = "member.role_changed"
+	MemberRemoved         = "member.removed"
+	MemberRestored        = "member.restored"
+	SCIMTokenCreated      = "organization.scim_token_created"
+	SCIMTokenRevoked      = "organization.scim_token_revoked"
+	TeamCreated           = "team.created"
+	TeamUpdated           = "team.updated"
+	TeamDeleted           = "team.deleted"
+	TeamMemberAdded       = "team.member_added"
+	TeamMemberRemoved     = "team.member_removed"
+
+	// OwnershipTransferStarted targets the recipient, OwnershipTransferred
+	// the new owner
+	OwnershipTransferStarted   = "organization.ownership_transfer_started"
+	OwnershipTransferred       = "organization.ownership_transferred"
+	OwnershipTransferCancelled = "organization.ownership_transfer_cancelled"
+
+	// EventRedacted commits to a redacted event of its chain, see chain.go
+	EventRedacted = "audit.event_redacted"
+)
+
+const (
+	DefaultLimit       = 50
+	MaxLimit           = 200
+	maxUserAgentLength = 512
+)
+
+var (
+	FetchEventsFailed = errs.New(errs.Internal, "fetch_audit_events_failed", "unable to fetch audit events")
+	InvalidRange      = errs.New(errs.Invalid, "invalid_range", "the range is outside of the audit chain")
+	SigningDisabled   = errs.New(errs.Unprocessable, "audit_signing_disabled", "audit exports need the audit signing key")
+	ExportFailed      = errs.New(errs.Internal, "audit_export_failed", "unable to export audit events")
+)
+
+type Event struct {
+	// ID orders the events, it grows with every insert
+	ID int64
+	// ActorID is the user who acted, empty for anonymous requests
+	ActorID string
+	// OrganizationID is empty for events outside of an organization
+	OrganizationID string
+	Action         string
+	// Target is what the action applies to as kind:id, such as user:<id>
+	// or invite:<email>
+	Target    string
+	IP        string
+	UserAgent string
+	Metadata  map[string]string
+	CreatedAt int
+	// Sequence is the position of the event in the chain of its
+	// organization, from 1. Events recorded before chaining have 0.
+	Sequence     int64
+	PreviousHash string
+	Hash         string
+	// Redacted events had the personal data of a deleted user replaced,
+	// they keep their place in the chain but no longer match Hash. An
+	// EventRedacted event later in the chain holds their new digest.
+	Redacted bool
+}
+
+func UserTarget(id string) string {
+	return "user:" + id
+}
+
+func OrganizationTarget(id string) string {
+	return "organization:" + id
+}
+
+func MemberTarget(userID string) string {
+	return "member:" + userID
+}
+
+func TeamTarget(id string) string {
+	return "team:" + id
+}
+
+func InviteTarget(email string) string {
+	return "invite:" + email
+}
+
+func EventTarget(id int64) string {
+	return "event:" + strconv.FormatInt(id, 10)
+}
+
+// Filter selects events, the zero value of a field matches every event
+type Filter struct {
+	OrganizationID string
+	ActorID        string
+	Action         string
+	Target         string
+	// Since and Until bound CreatedAt, both inclusive
+	Since int
+	Until int
+	// Before only keeps the events older than this ID
+	Before int64
+	Limit  int
+}
+
+type Store interface {
+	WithTx(context.Context, func(context.Context) error) error
+	InsertAuditEvent(context.Context, Event) (int64, error)
+	// FetchAuditEvents returns the matching events, newest first
+	FetchAuditEvents(context.Context, Filter) ([]Event, error)
+	// AdvanceAuditChain reserves the next sequence of the chain of the
+	// organization and returns it with the hash of the current head. In a
+	// transaction the chain stays locked until it ends.
+	AdvanceAuditChain(context.Context, string) (int64, string, error)
+	UpdateAuditChainHead(context.Context, string, string) error
+	FetchAuditChainHeads(context.Context) ([]Head, error)
+	// FetchAuditChain returns up to limit events of the chain of the
+	// organization from the given sequence on, in sequence order
+	FetchAuditChain(context.Context, string, int64, int) ([]Event, error)
+	InsertAuditCheckpoint(context.Context, Checkpoint) error
+	// FetchAuditCheckpoints returns the checkpoints of the chain of the
+	// organization in sequence order
+	FetchAuditCheckpoints(context.Context, string) ([]Checkpoint, error)
+	// RedactAuditEvent overwrites the actor, target, client and metadata
+	// of the event and marks it redacted
+	RedactAuditEvent(context.Context, Event) error
+}
+
+// Page is one page of events, NextCursor is empty on the last one
+type Page struct {
+	Events     []Event
+	NextCursor string
+}
+
+type actorKey struct{}
+type clientKey struct{}
+
+type client struct {
+	ip        string
+	userAgent string
+}
+
+// WithActor marks the signed in user of a request
+func WithActor(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+// WithClient marks the address and user agent of a request
+func WithClient(ctx context.Context, ip string, userAgent string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client{ip: ip, userAgent: userAgent})
+}
+
+type Options struct {
+	// SigningKey signs checkpoints and exports, without one neither is
+	// made
+	SigningKey         ed25519.PrivateKey
+	CheckpointInterval time.Duration
+}
+
+type Service struct {
+	store   Store
+	options Options
+}
+
+func New(store Store, options Options) *Service {
+	return &Service{
+		store:   store,
+		options: options,
+	}
+}
+
+// Record appends an event, the actor and the client come from the context
+// unless the event sets them. A failure is only logged, the action it
+// describes already happened.
+func (s *Service) Record(ctx context.Context, event Event) {
+	err := s.record(ctx, event)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Redact stores the event with its personal data replaced and appends to
+// its chain the EventRedacted event committing to what is left of it
+func (s *Service) Redact(ctx context.Context, event Event) error {
+	return s.store.WithTx(ctx, func(ctx context.Context) error {
+		err := s.store.RedactAuditEvent(ctx, event)
+		if err != nil {
+			return err
+		}
+		// events recorded before chaining are in no chain
+		if event.Sequence == 0 {
+			return nil
+		}
+		// no actor nor client, the account may be the one being deleted
+		return s.append(ctx, Event{
+			OrganizationID: event.OrganizationID,
+			Action:         EventRedacted,
+			Target:         EventTarget(event.ID),
+			Metadata: map[string]string{
+				"sequence": strconv.FormatInt(event.Sequence, 10),
+				"hash":     event.Digest(),
+			},
+		})
+	})
+}
+
+func (s *Service) record(ctx context.Context, event Event) error {
+	if event.ActorID == "" {
+		event.ActorID, _ = ctx.Value(actorKey{}).(string)
+	}
+	if c, ok := ctx.Value(clientKey{}).(client); ok {
+		if event.IP == "" {
+			event.IP = c.ip
+		}
+		if event.UserAgent == "" {
+			event.UserAgent = c.userAgent
+		}
+	}
+	if len(event.UserAgent) > maxUserAgentLength {
+		event.UserAgent = event.UserAgent[:maxUserAgentLength]
+	}
+	return s.append(ctx, event)
+}
+
+// append adds the event at the head of the chain of its organization
+func (s *Service) append(ctx context.Context, event Event) error {
+	event.CreatedAt = int(time.Now().Unix())
+
+	return s.store.WithTx(ctx, func(ctx context.Context) error {
+		sequence, previousHash, err := s.store.AdvanceAuditChain(ctx, event.OrganizationID)
+		if err != nil {
+			return err
+		}
+		event.Sequence = sequence
+		event.PreviousHash = previousHash
+		event.Hash = event.Digest()
+		_, err = s.store.InsertAuditEvent(ctx, event)
+		if err != nil {
+			return err
+		}
+		return s.store.UpdateAuditChainHead(ctx, event.OrganizationID, event.Hash)
+	})
+}
+
+// FetchEvents returns a page of the events matching filter, newest first.
+// cursor is the NextCursor of the previous page, empty for the first one.
+func (s *Service) FetchEvents(ctx context.Context, filter Filter, cursor string) (Page, error) {
+	if cursor != "" {
+		before, err := decodeCursor(cursor)
+		if err != nil {
+			return Page{}, page.InvalidCursor
+		}
+		filter.Before = before
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultLimit
+	}
+	if filter.Limit > MaxLimit {
+		filter.Limit = MaxLimit
+	}
+
+	// one more event tells whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	events, err := s.store.FetchAuditEvents(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return Page{}, FetchEventsFailed
+	}
+
+	result := Page{Events: events}
+	if len(events) > limit {
+		result.Events = events[:limit]
+		result.NextCursor = encodeCursor(result.Events[limit-1].ID)
+	}
+	return result, nil
+}
+
+// cursors are opaque to clients, they are not meant to compute them
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(raw), 10, 64)
+}
@@ -0,0 +1,220 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Every organization has its own chain, the events outside of any
+// organization form the chain of the empty organization ID. An event hashes
+// its fields with the hash of the previous event of its chain, editing or
+// removing an event breaks every later hash. Checkpoints sign the head of a
+// chain so a rewritten chain is caught without trusting the database.
+//
+// Deleting an account redacts the events naming the user. A redacted event
+// keeps its hash so the chain still links through it, and an EventRedacted
+// event appended to the chain holds the digest of its redacted fields. A
+// redacted event is verified against that digest instead, so marking an
+// event redacted to edit it is caught like any other edit.
+
+var (
+	InvalidSigningKey = errors.New("invalid audit signing key")
+	InvalidPublicKey  = errors.New("invalid audit public key")
+)
+
+// link is what the hash of an event covers, the json encoding of its fields
+// in this order
+type link struct {
+	OrganizationID string            `json:"organization_id"`
+	Sequence       int64             `json:"sequence"`
+	ActorID        string            `json:"actor_id"`
+	Action         string            `json:"action"`
+	Target         string            `json:"target"`
+	IP             string            `json:"ip"`
+	UserAgent      string            `json:"user_agent"`
+	Metadata       map[string]string `json:"metadata"`
+	CreatedAt      int               `json:"created_at"`
+	PreviousHash   string            `json:"previous_hash"`
+}
+
+// Digest is the hex SHA-256 the event is stored with
+func (e Event) Digest() string {
+	metadata := e.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	payload, _ := json.Marshal(link{
+		OrganizationID: e.OrganizationID,
+		Sequence:       e.Sequence,
+		ActorID:        e.ActorID,
+		Action:         e.Action,
+		Target:         e.Target,
+		IP:             e.IP,
+		UserAgent:      e.UserAgent,
+		Metadata:       metadata,
+		CreatedAt:      e.CreatedAt,
+		PreviousHash:   e.PreviousHash,
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Head is the last event of a chain
+type Head struct {
+	OrganizationID string
+	Sequence       int64
+	Hash           string
+	// Checkpointed is the sequence of the last checkpoint, 0 without any
+	Checkpointed int64
+}
+
+// Checkpoint is a signed chain head
+type Checkpoint struct {
+	OrganizationID string `json:"organization_id"`
+	Sequence       int64  `json:"sequence"`
+	Hash           string `json:"hash"`
+	CreatedAt      int    `json:"created_at"`
+	// Signature is the base64 ed25519 signature of the other fields
+	Signature string `json:"signature"`
+}
+
+func (c Checkpoint) payload() []byte {
+	c.Signature = ""
+	payload, _ := json.Marshal(c)
+	return payload
+}
+
+// Range is an exported part of a chain, from the event following
+// PreviousHash to the one hashed Hash
+type Range struct {
+	OrganizationID string `json:"organization_id"`
+	FirstSequence  int64  `json:"first_sequence"`
+	LastSequence   int64  `json:"last_sequence"`
+	PreviousHash   string `json:"previous_hash"`
+	Hash           string `json:"hash"`
+	ExportedAt     int    `json:"exported_at"`
+	Signature      string `json:"signature"`
+}
+
+func (r Range) payload() []byte {
+	r.Signature = ""
+	payload, _ := json.Marshal(r)
+	return payload
+}
+
+// ParseSigningKey reads a base64 ed25519 seed or private key
+func ParseSigningKey(encoded string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, InvalidSigningKey
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, InvalidSigningKey
+}
+
+// ParsePublicKey reads a base64 ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, InvalidPublicKey
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+func sign(key ed25519.PrivateKey, payload []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+}
+
+func verifySignature(key ed25519.PublicKey, payload []byte, signature string) bool {
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	return err == nil && ed25519.Verify(key, payload, raw)
+}
+
+// Break is the first inconsistency of a chain
+type Break struct {
+	OrganizationID string
+	Sequence       int64
+	Reason         string
+}
+
+func (b *Break) Error() string {
+	chain := b.OrganizationID
+	if chain == "" {
+		chain = "events outside of organizations"
+	}
+	return fmt.Sprintf("audit chain of %s breaks at sequence %d: %s", chain, b.Sequence, b.Reason)
+}
+
+// chainWalker checks the events of one chain in sequence order
+type chainWalker struct {
+	organizationID string
+	next           int64
+	previous       string
+	// redacted holds the digest of the redacted events not yet committed
+	// to by an EventRedacted event, by sequence
+	redacted map[int64]string
+}
+
+func newChainWalker(organizationID string, next int64, previous string) *chainWalker {
+	return &chainWalker{
+		organizationID: organizationID,
+		next:           next,
+		previous:       previous,
+		redacted:       make(map[int64]string),
+	}
+}
+
+func (w *chainWalker) add(event Event) *Break {
+	broken := func(reason string) *Break {
+		return &Break{OrganizationID: w.organizationID, Sequence: w.next, Reason: reason}
+	}
+	switch {
+	case event.OrganizationID != w.organizationID:
+		return broken("event of another organization")
+	case event.Sequence != w.next:
+		return broken(fmt.Sprintf("event %d is missing, found %d", w.next, event.Sequence))
+	case event.PreviousHash != w.previous:
+		return broken("previous hash does not match the event before")
+	case !event.Redacted && event.Hash != event.Digest():
+		return broken("event was modified, its hash does not match")
+	}
+	if event.Redacted {
+		w.redacted[event.Sequence] = event.Digest()
+	}
+	// an event redacted again is committed to by its last redaction
+	if event.Action == EventRedacted {
+		sequence, err := strconv.ParseInt(event.Metadata["sequence"], 10, 64)
+		if err == nil && w.redacted[sequence] == event.Metadata["hash"] {
+			delete(w.redacted, sequence)
+		}
+	}
+	w.next++
+	w.previous = event.Hash
+	return nil
+}
+
+// finish reports the first redacted event no EventRedacted event commits
+// to, once every event was added
+func (w *chainWalker) finish() *Break {
+	sequences := make([]int64, 0, len(w.redacted))
+	for sequence := range w.redacted {
+		sequences = append(sequences, sequence)
+	}
+	if len(sequences) == 0 {
+		return nil
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	return &Break{OrganizationID: w.organizationID, Sequence: sequences[0], Reason: "redacted event does not match a redaction recorded after it"}
+}
@@ -0,0 +1,205 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+const testOrganization = "5b0f3f57-6d3c-4b8e-9a43-0c3f1f1c2a10"
+
+// chain builds a chain of n events of the test organization
+func chain(n int) []Event {
+	events := make([]Event, 0, n)
+	previous := ""
+	for i := 1; i <= n; i++ {
+		event := Event{
+			ID:             int64(i),
+			OrganizationID: testOrganization,
+			ActorID:        "user-1",
+			Action:         UserLoggedIn,
+			Target:         UserTarget("user-1"),
+			IP:             "192.0.2.1",
+			CreatedAt:      1700000000 + i,
+			Sequence:       int64(i),
+			PreviousHash:   previous,
+		}
+		event.Hash = event.Digest()
+		previous = event.Hash
+		events = append(events, event)
+	}
+	return events
+}
+
+// appendEvent chains event after the last one
+func appendEvent(events []Event, event Event) []Event {
+	last := events[len(events)-1]
+	event.ID = last.ID + 1
+	event.OrganizationID = last.OrganizationID
+	event.Sequence = last.Sequence + 1
+	event.PreviousHash = last.Hash
+	event.Hash = event.Digest()
+	return append(events, event)
+}
+
+// redact replaces the personal data of the event at index i and appends
+// its redaction like Service.Redact
+func redact(events []Event, i int) []Event {
+	events[i].ActorID = ""
+	events[i].Target = UserTarget("deleted-1")
+	events[i].IP = ""
+	events[i].Redacted = true
+	return appendEvent(events, Event{
+		Action: EventRedacted,
+		Target: EventTarget(events[i].ID),
+		Metadata: map[string]string{
+			"sequence": strconv.FormatInt(events[i].Sequence, 10),
+			"hash":     events[i].Digest(),
+		},
+	})
+}
+
+func walk(events []Event) *Break {
+	walker := newChainWalker(testOrganization, 1, "")
+	for _, event := range events {
+		if broken := walker.add(event); broken != nil {
+			return broken
+		}
+	}
+	return walker.finish()
+}
+
+func TestChainWalker(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func([]Event) []Event
+		// sequence is where the chain breaks, 0 when it is intact
+		sequence int64
+	}{
+		{"intact", func(events []Event) []Event { return events }, 0},
+		{"edited", func(events []Event) []Event {
+			events[2].Target = UserTarget("user-2")
+			return events
+		}, 3},
+		{"removed", func(events []Event) []Event {
+			return append(events[:2], events[3:]...)
+		}, 3},
+		{"inserted", func(events []Event) []Event {
+			forged := events[1]
+			return append(events[:2], append([]Event{forged}, events[2:]...)...)
+		}, 3},
+		{"rehashed", func(events []Event) []Event {
+			events[2].IP = "198.51.100.7"
+			events[2].Hash = events[2].Digest()
+			return events
+		}, 4},
+		{"other organization", func(events []Event) []Event {
+			events[0].OrganizationID = ""
+			return events
+		}, 1},
+		{"redacted", func(events []Event) []Event {
+			return redact(events, 1)
+		}, 0},
+		{"redacted twice", func(events []Event) []Event {
+			events = redact(events, 1)
+			return redact(events, 1)
+		}, 0},
+		{"redacted without a redaction", func(events []Event) []Event {
+			events[1].Target = UserTarget("deleted-1")
+			events[1].Redacted = true
+			return events
+		}, 2},
+		{"edited after its redaction", func(events []Event) []Event {
+			events = redact(events, 1)
+			events[1].Target = UserTarget("user-2")
+			return events
+		}, 2},
+		{"redaction of another digest", func(events []Event) []Event {
+			events = redact(events, 1)
+			events[0].Redacted = true
+			return events
+		}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broken := walk(test.change(chain(5)))
+			if test.sequence == 0 {
+				if broken != nil {
+					t.Errorf("walk: %v", broken)
+				}
+				return
+			}
+			if broken == nil || broken.Sequence != test.sequence || broken.OrganizationID != testOrganization {
+				t.Errorf("walk = %v, want a break at sequence %d", broken, test.sequence)
+			}
+		})
+	}
+}
+
+func export(t *testing.T, key ed25519.PrivateKey, events []Event, exported Range) *bytes.Buffer {
+	t.Helper()
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, event := range events {
+		line := exportedEvent(event)
+		if err := encoder.Encode(exportLine{Event: &line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exported.Signature = sign(key, exported.payload())
+	if err := encoder.Encode(exportLine{Range: &exported}); err != nil {
+		t.Fatal(err)
+	}
+	return &buffer
+}
+
+func TestVerifyExport(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := redact(chain(6), 3)
+	rangeOf := func(first int, last int) Range {
+		return Range{
+			OrganizationID: testOrganization,
+			FirstSequence:  int64(first),
+			LastSequence:   int64(last),
+			PreviousHash:   events[first-1].PreviousHash,
+			Hash:           events[last-1].Hash,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		key      ed25519.PrivateKey
+		events   []Event
+		exported Range
+		err      error
+		broken   bool
+	}{
+		{"whole chain", key, events, rangeOf(1, 7), nil, false},
+		{"middle with the redaction", key, events[2:7], rangeOf(3, 7), nil, false},
+		{"without the redaction", key, events[2:5], rangeOf(3, 5), nil, true},
+		{"missing the last event", key, events[:6], rangeOf(1, 7), nil, true},
+		{"other key", otherKey, events, rangeOf(1, 7), InvalidExport, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := VerifyExport(export(t, test.key, test.events, test.exported), public)
+			var broken *Break
+			if errors.As(err, &broken) != test.broken {
+				t.Fatalf("VerifyExport: got %v, want a break %v", err, test.broken)
+			}
+			if !test.broken && !errors.Is(err, test.err) {
+				t.Errorf("VerifyExport: got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+// chainBatch is the number of events read at once while walking a chain
+const chainBatch = 500
+
+var InvalidExport = errors.New("invalid audit export")
+
+// exportedEvent is an event line of an export
+type exportedEvent struct {
+	ID             int64             `json:"id"`
+	ActorID        string            `json:"actor_id"`
+	OrganizationID string            `json:"organization_id"`
+	Action         string            `json:"action"`
+	Target         string            `json:"target"`
+	IP             string            `json:"ip"`
+	UserAgent      string            `json:"user_agent"`
+	Metadata       map[string]string `json:"metadata"`
+	CreatedAt      int               `json:"created_at"`
+	Sequence       int64             `json:"sequence"`
+	PreviousHash   string            `json:"previous_hash"`
+	Hash           string            `json:"hash"`
+	Redacted       bool              `json:"redacted,omitempty"`
+}
+
+// exportLine is one line of an export, every event and then the signed
+// range
+type exportLine struct {
+	Event *exportedEvent `json:"event,omitempty"`
+	Range *Range         `json:"range,omitempty"`
+}
+
+// Checkpoint signs the head of every chain that grew since its last
+// checkpoint
+func (s *Service) Checkpoint(ctx context.Context) error {
+	if s.options.SigningKey == nil {
+		return nil
+	}
+	heads, err := s.store.FetchAuditChainHeads(ctx)
+	if err != nil {
+		return err
+	}
+	for _, head := range heads {
+		if head.Sequence <= head.Checkpointed {
+			continue
+		}
+		checkpoint := Checkpoint{
+			OrganizationID: head.OrganizationID,
+			Sequence:       head.Sequence,
+			Hash:           head.Hash,
+			CreatedAt:      int(time.Now().Unix()),
+		}
+		checkpoint.Signature = sign(s.options.SigningKey, checkpoint.payload())
+		err = s.store.InsertAuditCheckpoint(ctx, checkpoint)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run checkpoints the chains every CheckpointInterval until the context is
+// cancelled
+func (s *Service) Run(ctx context.Context) {
+	if s.options.SigningKey == nil {
+		return
+	}
+	ticker := time.NewTicker(s.options.CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.Checkpoint(ctx)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// Verify walks every chain and returns the first *Break. Checkpoint
+// signatures are only checked with a public key. It returns the number of
+// events checked.
+func (s *Service) Verify(ctx context.Context, publicKey ed25519.PublicKey) (int64, error) {
+	heads, err := s.store.FetchAuditChainHeads(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var checked int64
+	for _, head := range heads {
+		n, err := s.verifyChain(ctx, head, publicKey)
+		checked += n
+		if err != nil {
+			return checked, err
+		}
+	}
+	return checked, nil
+}
+
+func (s *Service) verifyChain(ctx context.Context, head Head, publicKey ed25519.PublicKey) (int64, error) {
+	checkpoints, err := s.store.FetchAuditCheckpoints(ctx, head.OrganizationID)
+	if err != nil {
+		return 0, err
+	}
+	signed := make(map[int64]string, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		if publicKey != nil && !verifySignature(publicKey, checkpoint.payload(), checkpoint.Signature) {
+			return 0, &Break{OrganizationID: head.OrganizationID, Sequence: checkpoint.Sequence, Reason: "checkpoint signature is invalid"}
+		}
+		signed[checkpoint.Sequence] = checkpoint.Hash
+	}
+
+	walker := newChainWalker(head.OrganizationID, 1, "")
+	for {
+		events, err := s.store.FetchAuditChain(ctx, head.OrganizationID, walker.next, chainBatch)
+		if err != nil {
+			return walker.next - 1, err
+		}
+		for _, event := range events {
+			if broken := walker.add(event); broken != nil {
+				return walker.next - 1, broken
+			}
+			if hash, ok := signed[event.Sequence]; ok && hash != event.Hash {
+				return walker.next - 1, &Break{OrganizationID: head.OrganizationID, Sequence: event.Sequence, Reason: "event does not match its signed checkpoint"}
+			}
+		}
+		if len(events) < chainBatch {
+			break
+		}
+	}
+
+	last := walker.next - 1
+	if last != head.Sequence || walker.previous != head.Hash {
+		return last, &Break{OrganizationID: head.OrganizationID, Sequence: last + 1, Reason: fmt.Sprintf("chain ends at %d, its head is %d", last, head.Sequence)}
+	}
+	for sequence := range signed {
+		if sequence > last {
+			return last, &Break{OrganizationID: head.OrganizationID, Sequence: sequence, Reason: "a signed checkpoint is past the end of the chain"}
+		}
+	}
+	if broken := walker.finish(); broken != nil {
+		return last, broken
+	}
+	return last, nil
+}
+
+// Export writes the events of the chain of the organization from first to
+// last as JSONL, followed by the range signed with the signing key. A last
+// of 0 exports up to the head. A range with redacted events is extended to
+// the events recording their redaction, so that it verifies on its own.
+func (s *Service) Export(ctx context.Context, w io.Writer, organizationID string, first int64, last int64) (Range, error) {
+	if s.options.SigningKey == nil {
+		return Range{}, SigningDisabled
+	}
+	head, err := s.head(ctx, organizationID)
+	if err != nil {
+		log.Println(err)
+		return Range{}, ExportFailed
+	}
+	if first == 0 {
+		first = 1
+	}
+	if last == 0 {
+		last = head.Sequence
+	}
+	if first > last || last > head.Sequence {
+		return Range{}, InvalidRange
+	}
+
+	exported := Range{
+		OrganizationID: organizationID,
+		FirstSequence:  first,
+		LastSequence:   last,
+	}
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	redacted := make(map[string]string)
+	for next := first; (next <= last || len(redacted) > 0) && next <= head.Sequence; {
+		events, err := s.store.FetchAuditChain(ctx, organizationID, next, chainBatch)
+		if err != nil {
+			log.Println(err)
+			return Range{}, ExportFailed
+		}
+		if len(events) == 0 {
+			return Range{}, ExportFailed
+		}
+		for _, event := range events {
+			if event.Sequence > last && len(redacted) == 0 {
+				break
+			}
+			if event.Redacted {
+				redacted[strconv.FormatInt(event.Sequence, 10)] = event.Digest()
+			}
+			sequence := event.Metadata["sequence"]
+			if event.Action == EventRedacted && redacted[sequence] == event.Metadata["hash"] {
+				delete(redacted, sequence)
+			}
+			if event.Sequence == first {
+				exported.PreviousHash = event.PreviousHash
+			}
+			exported.Hash = event.Hash
+			exported.LastSequence = event.Sequence
+			line := exportedEvent(event)
+			err = encoder.Encode(exportLine{Event: &line})
+			if err != nil {
+				return Range{}, err
+			}
+			next = event.Sequence + 1
+		}
+	}
+
+	exported.ExportedAt = int(time.Now().Unix())
+	exported.Signature = sign(s.options.SigningKey, exported.payload())
+	err = encoder.Encode(exportLine{Range: &exported})
+	if err != nil {
+		return Range{}, err
+	}
+	return exported, writer.Flush()
+}
+
+func (s *Service) head(ctx context.Context, organizationID string) (Head, error) {
+	heads, err := s.store.FetchAuditChainHeads(ctx)
+	if err != nil {
+		return Head{}, err
+	}
+	for _, head := range heads {
+		if head.OrganizationID == organizationID {
+			return head, nil
+		}
+	}
+	return Head{OrganizationID: organizationID}, nil
+}
+
+// VerifyExport checks an export without the database: the signature of
+// the range, then that the events chain from its previous hash to its hash.
+// A broken chain is reported as a *Break.
+func VerifyExport(r io.Reader, publicKey ed25519.PublicKey) (Range, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var events []Event
+	var exported *Range
+	for scanner.Scan() {
+		if exported != nil {
+			return Range{}, fmt.Errorf("%w: lines after the range", InvalidExport)
+		}
+		var line exportLine
+		err := json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			return Range{}, fmt.Errorf("%w: %v", InvalidExport, err)
+		}
+		switch {
+		case line.Event != nil:
+			events = append(events, Event(*line.Event))
+		case line.Range != nil:
+			exported = line.Range
+		default:
+			return Range{}, fmt.Errorf("%w: unknown line", InvalidExport)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Range{}, err
+	}
+	if exported == nil {
+		return Range{}, fmt.Errorf("%w: the signed range is missing", InvalidExport)
+	}
+	if !verifySignature(publicKey, exported.payload(), exported.Signature) {
+		return *exported, fmt.Errorf("%w: the range signature is invalid", InvalidExport)
+	}
+
+	walker := newChainWalker(exported.OrganizationID, exported.FirstSequence, exported.PreviousHash)
+	for _, event := range events {
+		if broken := walker.add(event); broken != nil {
+			return *exported, broken
+		}
+	}
+	if walker.next-1 != exported.LastSequence || walker.previous != exported.Hash {
+		return *exported, &Break{OrganizationID: exported.OrganizationID, Sequence: walker.next, Reason: "events are missing before the end of the range"}
+	}
+	// Export extends the range to the redaction of its redacted events
+	if broken := walker.finish(); broken != nil {
+		return *exported, broken
+	}
+	return *exported, nil
+}
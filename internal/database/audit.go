@@ -0,0 +1,308 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"microauth.io/core/internal/audit"
+)
+
+type AuditEventRow struct {
+	ID             int64  `db:"id"`
+	ActorID        string `db:"actor_id"`
+	OrganizationID string `db:"organization_id"`
+	Action         string `db:"action"`
+	Target         string `db:"target"`
+	IP             string `db:"ip"`
+	UserAgent      string `db:"user_agent"`
+	Metadata       string `db:"metadata"`
+	CreatedAt      int    `db:"created_at"`
+	Sequence       int64  `db:"sequence"`
+	PreviousHash   string `db:"previous_hash"`
+	Hash           string `db:"hash"`
+	Redacted       bool   `db:"redacted"`
+}
+
+type AuditChainRow struct {
+	OrganizationID string `db:"organization_id"`
+	Sequence       int64  `db:"sequence"`
+	Hash           string `db:"hash"`
+	Checkpointed   int64  `db:"checkpointed"`
+}
+
+type AuditCheckpointRow struct {
+	OrganizationID string `db:"organization_id"`
+	Sequence       int64  `db:"sequence"`
+	Hash           string `db:"hash"`
+	CreatedAt      int    `db:"created_at"`
+	Signature      string `db:"signature"`
+}
+
+const auditEventColumns = "id, actor_id, organization_id, action, target, ip, user_agent, metadata, created_at, sequence, previous_hash, hash, redacted"
+
+func (row AuditEventRow) toEvent() audit.Event {
+	event := audit.Event{
+		ID:             row.ID,
+		ActorID:        row.ActorID,
+		OrganizationID: row.OrganizationID,
+		Action:         row.Action,
+		Target:         row.Target,
+		IP:             row.IP,
+		UserAgent:      row.UserAgent,
+		CreatedAt:      row.CreatedAt,
+		Sequence:       row.Sequence,
+		PreviousHash:   row.PreviousHash,
+		Hash:           row.Hash,
+		Redacted:       row.Redacted,
+	}
+	err := json.Unmarshal([]byte(row.Metadata), &event.Metadata)
+	if err != nil {
+		log.Println(err)
+	}
+	return event
+}
+
+func (db *Database) InsertAuditEvent(ctx context.Context, event audit.Event) (int64, error) {
+	metadata := []byte("{}")
+	if len(event.Metadata) > 0 {
+		var err error
+		metadata, err = json.Marshal(event.Metadata)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var id int64
+	query := `
+		INSERT INTO audit_events (actor_id, organization_id, action, target, ip, user_agent, metadata, created_at, sequence, previous_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`
+	err := db.conn(ctx).GetContext(ctx, &id, db.rebind(query), event.ActorID, event.OrganizationID, event.Action, event.Target, event.IP, event.UserAgent, string(metadata), event.CreatedAt, event.Sequence, event.PreviousHash, event.Hash)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return id, nil
+}
+
+func (db *Database) FetchAuditEvents(ctx context.Context, filter audit.Filter) ([]audit.Event, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if filter.OrganizationID != "" {
+		where("organization_id = ?", filter.OrganizationID)
+	}
+	if filter.ActorID != "" {
+		where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		where("target = ?", filter.Target)
+	}
+	if filter.Since > 0 {
+		where("created_at >= ?", filter.Since)
+	}
+	if filter.Until > 0 {
+		where("created_at <= ?", filter.Until)
+	}
+	if filter.Before > 0 {
+		where("id < ?", filter.Before)
+	}
+
+	query := "SELECT " + auditEventColumns + " FROM audit_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows := []AuditEventRow{}
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), args...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	events := make([]audit.Event, len(rows))
+	for i, row := range rows {
+		events[i] = row.toEvent()
+	}
+	return events, nil
+}
+
+// FetchAuditEventsMentioning returns the events acted by one of the terms
+// or naming one in their target or metadata, in ID order
+func (db *Database) FetchAuditEventsMentioning(ctx context.Context, terms []string) ([]audit.Event, error) {
+	if len(terms) == 0 {
+		return []audit.Event{}, nil
+	}
+	var conditions []string
+	var args []interface{}
+	for _, term := range terms {
+		conditions = append(conditions, `actor_id = ? OR LOWER(target) LIKE ? ESCAPE '\' OR LOWER(metadata) LIKE ? ESCAPE '\'`)
+		args = append(args, term, contains(term), contains(term))
+	}
+	query := "SELECT " + auditEventColumns + " FROM audit_events WHERE " + strings.Join(conditions, " OR ") + " ORDER BY id"
+
+	rows := []AuditEventRow{}
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), args...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	events := make([]audit.Event, len(rows))
+	for i, row := range rows {
+		events[i] = row.toEvent()
+	}
+	return events, nil
+}
+
+// RedactAuditEvent overwrites the actor, target, client and metadata of the
+// event and marks it redacted, its chain fields are kept
+func (db *Database) RedactAuditEvent(ctx context.Context, event audit.Event) error {
+	metadata := []byte("{}")
+	if len(event.Metadata) > 0 {
+		var err error
+		metadata, err = json.Marshal(event.Metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE audit_events
+		SET actor_id = ?, target = ?, ip = ?, user_agent = ?, metadata = ?, redacted = ?
+		WHERE id = ?
+	`
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), event.ActorID, event.Target, event.IP, event.UserAgent, string(metadata), true, event.ID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+// AdvanceAuditChain creates the chain on its first event. The update locks
+// the chain row, concurrent events of the organization wait for the
+// transaction recording this one.
+func (db *Database) AdvanceAuditChain(ctx context.Context, organizationID string) (int64, string, error) {
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind(`
+		INSERT INTO audit_chains (organization_id, sequence, hash)
+		VALUES (?, 0, '')
+		ON CONFLICT (organization_id) DO NOTHING
+	`), organizationID)
+	if err != nil {
+		log.Println(err)
+		return 0, "", err
+	}
+
+	row := AuditChainRow{}
+	query := `
+		UPDATE audit_chains SET sequence = sequence + 1
+		WHERE organization_id = ?
+		RETURNING organization_id, sequence, hash
+	`
+	err = db.conn(ctx).GetContext(ctx, &row, db.rebind(query), organizationID)
+	if err != nil {
+		log.Println(err)
+		return 0, "", err
+	}
+	return row.Sequence, row.Hash, nil
+}
+
+func (db *Database) UpdateAuditChainHead(ctx context.Context, organizationID string, hash string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE audit_chains SET hash = ? WHERE organization_id = ?"), hash, organizationID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *Database) FetchAuditChainHeads(ctx context.Context) ([]audit.Head, error) {
+	rows := []AuditChainRow{}
+	query := `
+		SELECT h.organization_id, h.sequence, h.hash,
+			COALESCE((SELECT MAX(c.sequence) FROM audit_checkpoints c WHERE c.organization_id = h.organization_id), 0) AS checkpointed
+		FROM audit_chains h
+		ORDER BY h.organization_id
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, query)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	heads := make([]audit.Head, len(rows))
+	for i, row := range rows {
+		heads[i] = audit.Head(row)
+	}
+	return heads, nil
+}
+
+func (db *Database) FetchAuditChain(ctx context.Context, organizationID string, from int64, limit int) ([]audit.Event, error) {
+	rows := []AuditEventRow{}
+	query := "SELECT " + auditEventColumns + `
+		FROM audit_events
+		WHERE organization_id = ? AND sequence >= ?
+		ORDER BY sequence
+		LIMIT ?
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), organizationID, from, limit)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	events := make([]audit.Event, len(rows))
+	for i, row := range rows {
+		events[i] = row.toEvent()
+	}
+	return events, nil
+}
+
+func (db *Database) InsertAuditCheckpoint(ctx context.Context, checkpoint audit.Checkpoint) error {
+	query := `
+		INSERT INTO audit_checkpoints (organization_id, sequence, hash, signature, created_at)
+		VALUES (:organization_id, :sequence, :hash, :signature, :created_at)
+	`
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, AuditCheckpointRow(checkpoint))
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (db *Database) FetchAuditCheckpoints(ctx context.Context, organizationID string) ([]audit.Checkpoint, error) {
+	rows := []AuditCheckpointRow{}
+	query := `
+		SELECT organization_id, sequence, hash, signature, created_at
+		FROM audit_checkpoints
+		WHERE organization_id = ?
+		ORDER BY sequence
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), organizationID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	checkpoints := make([]audit.Checkpoint, len(rows))
+	for i, row := range rows {
+		checkpoints[i] = audit.Checkpoint(row)
+	}
+	return checkpoints, nil
+}
@@ -1,23 +1,183 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite3"
 )
 
+var UnsupportedDriver = errors.New("unsupported database driver")
+
 type Database struct {
 	client *sqlx.DB
+	driver string
 }
 
+// queryer is satisfied by both the pool and a transaction
+type queryer interface {
+	sqlx.ExtContext
+	GetContext(context.Context, interface{}, string, ...interface{}) error
+	SelectContext(context.Context, interface{}, string, ...interface{}) error
+	NamedExecContext(context.Context, string, interface{}) (sql.Result, error)
+}
+
+type txKey struct{}
+
+// txTimeKey holds the time the transaction of the context started
+type txTimeKey struct{}
+
 func New() *Database {
 	return &Database{}
 }
 
 func (d *Database) Connect(driver string, url string) error {
+	switch driver {
+	case Postgres:
+	case SQLite:
+		// foreign keys are off by default in sqlite and are a per
+		// connection setting, the dsn applies it to every connection
+		if !strings.Contains(url, "_foreign_keys") && !strings.Contains(url, "_fk") {
+			separator := "?"
+			if strings.Contains(url, "?") {
+				separator = "&"
+			}
+			url += separator + "_foreign_keys=on&_busy_timeout=5000"
+		}
+	default:
+		return UnsupportedDriver
+	}
+
 	db, err := sqlx.Connect(driver, url)
 	if err != nil {
 		return err
 	}
+
+	// sqlite allows a single writer, sharing one connection avoids busy
+	// errors between transactions and keeps in memory databases alive
+	if driver == SQLite {
+		db.SetMaxOpenConns(1)
+		db.SetConnMaxLifetime(0)
+	}
+
 	d.client = db
+	d.driver = driver
 	return nil
 }
+
+// Ping checks the database is reachable
+func (d *Database) Ping(ctx context.Context) error {
+	return d.client.PingContext(ctx)
+}
+
+// Close releases every connection of the pool
+func (d *Database) Close() error {
+	return d.client.Close()
+}
+
+// Driver is the name of the sql driver the database was opened with
+func (d *Database) Driver() string {
+	return d.driver
+}
+
+// DB exposes the underlying pool for tools such as the migrator
+func (d *Database) DB() *sql.DB {
+	return d.client.DB
+}
+
+// WithTx runs fn inside a transaction carried by the context it is given.
+// Every store method called with that context joins the transaction, which
+// is committed when fn returns nil and rolled back otherwise. Nested calls
+// join the outer transaction.
+func (d *Database) WithTx(ctx context.Context, fn func(context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := d.client.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ctx = context.WithValue(ctx, txTimeKey{}, time.Now().Unix())
+	err = fn(context.WithValue(ctx, txKey{}, tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// now is the time the transaction of the context started, or the current
+// time outside of one. The rows a transaction marks deleted share it so
+// they are restored together.
+func (d *Database) now(ctx context.Context) int64 {
+	if now, ok := ctx.Value(txTimeKey{}).(int64); ok {
+		return now
+	}
+	return time.Now().Unix()
+}
+
+// conn returns the transaction of the context, or the pool outside of one
+func (d *Database) conn(ctx context.Context) queryer {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+	return d.client
+}
+
+// uniqueViolation reports whether err is a unique constraint failure of
+// either driver
+func uniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	return false
+}
+
+// rebind turns the ? placeholders of a query into the bindvars of the driver
+func (d *Database) rebind(query string) string {
+	return d.client.Rebind(query)
+}
+
+// keyset starts a page after the row whose sort columns held the after
+// values, it returns the condition and the order of the query
+func keyset(columns []string, after []interface{}, descending bool) (string, string) {
+	direction, comparison := "", ">"
+	if descending {
+		direction, comparison = " DESC", "<"
+	}
+	order := make([]string, len(columns))
+	for i, column := range columns {
+		order[i] = column + direction
+	}
+	condition := ""
+	if len(after) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(after)), ", ")
+		condition = "(" + strings.Join(columns, ", ") + ") " + comparison + " (" + placeholders + ")"
+	}
+	return condition, strings.Join(order, ", ")
+}
+
+// contains is the LIKE pattern of a case insensitive search for value, to
+// be compared with ESCAPE '\'
+func contains(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(value))
+	return "%" + value + "%"
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"microauth.io/core/internal/lockout"
+)
+
+type LoginAttemptsRow struct {
+	Subject     string `db:"subject"`
+	Failures    int    `db:"failures"`
+	LastFailure int    `db:"last_failure"`
+}
+
+func (row LoginAttemptsRow) toAttempts() lockout.Attempts {
+	return lockout.Attempts{
+		Subject:     row.Subject,
+		Failures:    row.Failures,
+		LastFailure: row.LastFailure,
+	}
+}
+
+func (db *Database) GetLoginAttempts(ctx context.Context, subject string) (lockout.Attempts, error) {
+	row := LoginAttemptsRow{}
+	query := `
+		SELECT subject, failures, last_failure
+		FROM login_attempts
+		WHERE subject = ?
+	`
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind(query), subject)
+	if err != nil {
+		return lockout.Attempts{}, err
+	}
+	return row.toAttempts(), nil
+}
+
+// RecordLoginFailure increments the counter in a single statement so
+// concurrent failures are all counted
+func (db *Database) RecordLoginFailure(ctx context.Context, subject string, at int, since int) (lockout.Attempts, error) {
+	row := LoginAttemptsRow{}
+	query := `
+		INSERT INTO login_attempts (subject, failures, last_failure)
+		VALUES (?, 1, ?)
+		ON CONFLICT (subject) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure = excluded.last_failure
+		RETURNING subject, failures, last_failure
+	`
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind(query), subject, at, since)
+	if err != nil {
+		log.Println(err)
+		return lockout.Attempts{}, err
+	}
+	return row.toAttempts(), nil
+}
+
+func (db *Database) DeleteLoginAttempts(ctx context.Context, subject string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM login_attempts WHERE subject = ?"), subject)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *Database) PurgeLoginAttempts(ctx context.Context, before int) (int, error) {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM login_attempts WHERE last_failure < ?"), before)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return int(rowsAffected), err
+}
@@ -2,8 +2,10 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,6 +18,15 @@ type MemberRow struct {
 	UserID         string      `db:"user_id"`
 	Role           member.Role `db:"role"`
 	AppRole        string      `db:"app_role"`
+	DeletedAt      int         `db:"deleted_at"`
+}
+
+// MemberListingRow is a member joined with their user
+type MemberListingRow struct {
+	MemberRow
+	FirstName string `db:"first_name"`
+	LastName  string `db:"last_name"`
+	Email     string `db:"email"`
 }
 
 type MemberInviteRow struct {
@@ -28,6 +39,14 @@ type MemberInviteRow struct {
 	ExpiresAt      int    `db:"expires_at"`
 }
 
+type OwnershipTransferRow struct {
+	OrganizationID string `db:"organization_id"`
+	FromUserID     string `db:"from_user_id"`
+	ToUserID       string `db:"to_user_id"`
+	CreatedAt      int    `db:"created_at"`
+	ExpiresAt      int    `db:"expires_at"`
+}
+
 var (
 	FetchMemberInviteFailed  = errors.New("unable to get member invite")
 	InsertMemberInviteFailed = errors.New("unable to insert member invite")
@@ -42,14 +61,15 @@ var (
 )
 
 func (db *Database) GetMemberInvite(ctx context.Context, email string, organizationID string) (member.MemberInvite, error) {
+	// invites of a deleted organization can't be accepted
 	query := `
-	SELECT id, email, code, organization_id, created_at, updated_at, expires_at
-	FROM member_invite
-	WHERE email = $1 AND organization_id = $2
-	LIMIT 1
+	SELECT i.id, i.email, i.code, i.organization_id, i.created_at, i.updated_at, i.expires_at
+	FROM member_invite i
+	JOIN organizations o ON o.id = i.organization_id
+	WHERE i.email = ? AND i.organization_id = ? AND o.deleted_at = 0
 	`
 
-	row := db.client.QueryRowxContext(ctx, query, email, organizationID)
+	row := db.conn(ctx).QueryRowxContext(ctx, db.rebind(query), email, organizationID)
 
 	var invite MemberInviteRow
 	err := row.StructScan(&invite)
@@ -71,14 +91,14 @@ func (db *Database) GetMemberInvite(ctx context.Context, email string, organizat
 func (db *Database) InsertMemberInvite(ctx context.Context, email string, organizationID string, code string, expiresAt int) (string, error) {
 	query := `
 	INSERT INTO member_invite (id, email, code, organization_id, created_at, updated_at, expires_at)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
 
 	id := uuid.New().String()
 	createdAt := int(time.Now().Unix())
 	updatedAt := createdAt
 
-	_, err := db.client.ExecContext(ctx, query, id, email, code, organizationID, createdAt, updatedAt, expiresAt)
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), id, email, code, organizationID, createdAt, updatedAt, expiresAt)
 	if err != nil {
 		log.Println(err)
 		return "", InsertMemberInviteFailed
@@ -90,10 +110,10 @@ func (db *Database) InsertMemberInvite(ctx context.Context, email string, organi
 func (db *Database) DeleteMemberInvite(ctx context.Context, email string, organizationID string) (string, error) {
 	query := `
 	DELETE FROM member_invite
-	WHERE email = $1 AND organization_id = $2
+	WHERE email = ? AND organization_id = ?
 	`
 
-	_, err := db.client.ExecContext(ctx, query, email, organizationID)
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), email, organizationID)
 	if err != nil {
 		return "", DeleteMemberInviteFailed
 	}
@@ -101,14 +121,52 @@ func (db *Database) DeleteMemberInvite(ctx context.Context, email string, organi
 	return MemberInviteDeleted, nil
 }
 
+func (db *Database) DeleteMemberInvitesByEmail(ctx context.Context, email string) error {
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM member_invite WHERE email = ?"), email)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// FetchMembersByUser returns the memberships of the user in every
+// organization
+func (db *Database) FetchMembersByUser(ctx context.Context, userID string) ([]member.Member, error) {
+	query := `
+	SELECT id, organization_id, user_id, role, app_role
+	FROM members
+	WHERE user_id = ? AND deleted_at = 0
+	ORDER BY organization_id
+	`
+
+	rows := []MemberRow{}
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), userID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	members := make([]member.Member, len(rows))
+	for i, row := range rows {
+		members[i] = member.Member{
+			ID:             row.ID,
+			OrganizationID: row.OrganizationID,
+			UserID:         row.UserID,
+			Role:           row.Role,
+			AppRole:        row.AppRole,
+		}
+	}
+	return members, nil
+}
+
 func (db *Database) FetchAllMembers(ctx context.Context, organizationID string) ([]member.Member, error) {
 	query := `
 	SELECT id, organization_id, user_id, role, app_role
 	FROM members
-	WHERE organization_id = $1
+	WHERE organization_id = ? AND deleted_at = 0
 	`
 
-	rows, err := db.client.QueryxContext(ctx, query, organizationID)
+	rows, err := db.conn(ctx).QueryxContext(ctx, db.rebind(query), organizationID)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -142,15 +200,83 @@ func (db *Database) FetchAllMembers(ctx context.Context, organizationID string)
 	return members, nil
 }
 
+var memberSortColumns = map[member.Sort][]string{
+	member.SortEmail: {"u.email", "m.id"},
+	member.SortName:  {"u.last_name", "u.first_name", "m.id"},
+	member.SortRole:  {"m.role", "u.email", "m.id"},
+}
+
+func (db *Database) FetchMembers(ctx context.Context, filter member.Filter) ([]member.Listing, error) {
+	conditions := []string{"m.organization_id = ?", "m.deleted_at = 0"}
+	if filter.Deleted {
+		conditions[1] = "m.deleted_at > 0"
+	}
+	args := []interface{}{filter.OrganizationID}
+	if filter.UserID != "" {
+		conditions = append(conditions, "m.user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.Role != "" {
+		conditions = append(conditions, "m.role = ?")
+		args = append(args, filter.Role)
+	}
+	if filter.AppRole != "" {
+		conditions = append(conditions, "m.app_role = ?")
+		args = append(args, filter.AppRole)
+	}
+	if filter.Search != "" {
+		// the full name holds the first and the last name
+		conditions = append(conditions, `(LOWER(u.email) LIKE ? ESCAPE '\' OR LOWER(u.first_name || ' ' || u.last_name) LIKE ? ESCAPE '\')`)
+		pattern := contains(filter.Search)
+		args = append(args, pattern, pattern)
+	}
+	after := make([]interface{}, len(filter.After))
+	for i, key := range filter.After {
+		after[i] = key
+	}
+	condition, order := keyset(memberSortColumns[filter.Sort], after, filter.Descending)
+	if condition != "" {
+		conditions = append(conditions, condition)
+		args = append(args, after...)
+	}
+
+	query := `
+		SELECT m.id, m.organization_id, m.user_id, m.role, m.app_role, m.deleted_at, u.first_name, u.last_name, u.email
+		FROM members m
+		JOIN users u ON u.id = m.user_id
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY ` + order + `
+		LIMIT ?
+	`
+	args = append(args, filter.Limit)
+
+	rows := []MemberListingRow{}
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), args...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	listings := make([]member.Listing, len(rows))
+	for i, row := range rows {
+		listings[i] = member.Listing{
+			Member:    member.Member(row.MemberRow),
+			FirstName: row.FirstName,
+			LastName:  row.LastName,
+			Email:     row.Email,
+		}
+	}
+	return listings, nil
+}
+
 func (db *Database) FetchMemberByID(ctx context.Context, organizationID string, userID string) (member.Member, error) {
 	query := `
 		SELECT * FROM members
-		WHERE organization_id = $1 AND user_id = $2
+		WHERE organization_id = ? AND user_id = ? AND deleted_at = 0
 	`
 
 	var memberRow MemberRow
 
-	err := db.client.GetContext(ctx, &memberRow, query, organizationID, userID)
+	err := db.conn(ctx).GetContext(ctx, &memberRow, db.rebind(query), organizationID, userID)
 	if err != nil {
 		return member.Member{}, err
 	}
@@ -185,8 +311,15 @@ func (db *Database) InsertMember(ctx context.Context, organizationID string, use
 		VALUES (:id, :organization_id, :user_id, :role, :app_role)
 	`
 
-	// Execute the SQL query using named parameters
-	_, err := db.client.NamedExecContext(ctx, query, &member)
+	// A removed member is replaced, the user joins again from scratch
+	err := db.WithTx(ctx, func(ctx context.Context) error {
+		_, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM members WHERE organization_id = ? AND user_id = ? AND deleted_at > 0"), organizationID, userID)
+		if err != nil {
+			return err
+		}
+		_, err = db.conn(ctx).NamedExecContext(ctx, query, &member)
+		return err
+	})
 	if err != nil {
 		log.Println(err)
 		return "", MemberCreateFailed
@@ -200,12 +333,12 @@ func (db *Database) UpdateMember(ctx context.Context, organizationID string, use
 	// Prepare the SQL query
 	query := `
 		UPDATE members
-		SET role = $1, app_role = $2
-		WHERE organization_id = $3 AND user_id = $4
+		SET role = ?, app_role = ?
+		WHERE organization_id = ? AND user_id = ? AND deleted_at = 0
 	`
 
 	// Execute the SQL query
-	_, err := db.client.ExecContext(ctx, query, role, appRole, organizationID, userID)
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), role, appRole, organizationID, userID)
 	if err != nil {
 		return "", MemberUpdateFailed
 	}
@@ -213,15 +346,18 @@ func (db *Database) UpdateMember(ctx context.Context, organizationID string, use
 	return MemberUpdated, nil
 }
 
+// DeleteMember marks the member removed, their team memberships are kept
+// for a restore
 func (db *Database) DeleteMember(ctx context.Context, organizationID string, userID string) (string, error) {
 	// Prepare the SQL query
 	query := `
-		DELETE FROM members
-		WHERE organization_id = $1 AND user_id = $2
+		UPDATE members
+		SET deleted_at = ?
+		WHERE organization_id = ? AND user_id = ? AND deleted_at = 0
 	`
 
 	// Execute the SQL query
-	result, err := db.client.ExecContext(ctx, query, organizationID, userID)
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), db.now(ctx), organizationID, userID)
 	if err != nil {
 		return "", MemberDeleteFailed
 	}
@@ -233,9 +369,84 @@ func (db *Database) DeleteMember(ctx context.Context, organizationID string, use
 	}
 
 	if rowsAffected == 0 {
-		return "", MemberDeleteFailed
+		return "", sql.ErrNoRows
 	}
 
 	return MemberDeleted, nil
 
 }
+
+// RestoreMember undoes DeleteMember while the organization and the user
+// are not deleted
+func (db *Database) RestoreMember(ctx context.Context, organizationID string, userID string) error {
+	query := `
+		UPDATE members
+		SET deleted_at = 0
+		WHERE organization_id = ? AND user_id = ? AND deleted_at > 0
+		AND organization_id IN (SELECT id FROM organizations WHERE deleted_at = 0)
+		AND user_id IN (SELECT id FROM users WHERE deleted_at = 0)
+	`
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), organizationID, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+// PurgeMembers deletes for good the members removed before the time
+func (db *Database) PurgeMembers(ctx context.Context, before int) error {
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM members WHERE deleted_at > 0 AND deleted_at < ?"), before)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// PurgeUserMembers deletes for good the removed memberships of the user
+func (db *Database) PurgeUserMembers(ctx context.Context, userID string) error {
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM members WHERE user_id = ? AND deleted_at > 0"), userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (db *Database) SaveOwnershipTransfer(ctx context.Context, transfer member.OwnershipTransfer) error {
+	query := `
+		INSERT INTO ownership_transfers (organization_id, from_user_id, to_user_id, created_at, expires_at)
+		VALUES (:organization_id, :from_user_id, :to_user_id, :created_at, :expires_at)
+		ON CONFLICT (organization_id) DO UPDATE SET
+			from_user_id = excluded.from_user_id,
+			to_user_id = excluded.to_user_id,
+			created_at = excluded.created_at,
+			expires_at = excluded.expires_at
+	`
+	row := OwnershipTransferRow(transfer)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetOwnershipTransfer(ctx context.Context, organizationID string) (member.OwnershipTransfer, error) {
+	row := OwnershipTransferRow{}
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind("SELECT * FROM ownership_transfers WHERE organization_id = ?"), organizationID)
+	if err != nil {
+		return member.OwnershipTransfer{}, err
+	}
+	return member.OwnershipTransfer(row), nil
+}
+
+func (db *Database) DeleteOwnershipTransfer(ctx context.Context, organizationID string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM ownership_transfers WHERE organization_id = ?"), organizationID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
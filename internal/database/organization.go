@@ -2,20 +2,72 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"microauth.io/core/internal/member"
 	"microauth.io/core/internal/organization"
+	"microauth.io/core/internal/password"
 )
 
 type OrganizationRow struct {
-	ID        string `db:"id"`
-	Name      string `db:"name"`
-	Domain    string `db:"domain"`
-	CreatedAt int    `db:"created_at"`
-	UpdatedAt int    `db:"updated_at"`
+	ID           string `db:"id"`
+	Name         string `db:"name"`
+	Domain       string `db:"domain"`
+	LogoURL      string `db:"logo_url"`
+	PrimaryColor string `db:"primary_color"`
+	SenderName   string `db:"sender_name"`
+	// PasswordPolicy is the json of the policy, empty when there is none
+	PasswordPolicy string `db:"password_policy"`
+	CreatedAt      int    `db:"created_at"`
+	UpdatedAt      int    `db:"updated_at"`
+	DeletedAt      int    `db:"deleted_at"`
+	Role           string `db:"role"`
+}
+
+func (row OrganizationRow) organization() (organization.Organization, error) {
+	policy, err := decodePasswordPolicy(row.PasswordPolicy)
+	if err != nil {
+		return organization.Organization{}, err
+	}
+	return organization.Organization{
+		ID:             row.ID,
+		Name:           row.Name,
+		Domain:         row.Domain,
+		LogoURL:        row.LogoURL,
+		PrimaryColor:   row.PrimaryColor,
+		SenderName:     row.SenderName,
+		PasswordPolicy: policy,
+		CreatedAt:      row.CreatedAt,
+		UpdatedAt:      row.UpdatedAt,
+		DeletedAt:      row.DeletedAt,
+		Role:           member.Role(row.Role),
+	}, nil
+}
+
+// encodePasswordPolicy stores the zero policy as an empty string so
+// organizations without a policy are easy to tell apart
+func encodePasswordPolicy(policy password.Policy) (string, error) {
+	if policy == (password.Policy{}) {
+		return "", nil
+	}
+	encoded, err := json.Marshal(policy)
+	return string(encoded), err
+}
+
+func decodePasswordPolicy(encoded string) (password.Policy, error) {
+	var policy password.Policy
+	if encoded == "" {
+		return policy, nil
+	}
+	err := json.Unmarshal([]byte(encoded), &policy)
+	return policy, err
 }
 
 var (
@@ -28,18 +80,53 @@ var (
 	OrganizationUpdated        = "organization updated"
 )
 
-func (db *Database) GetOrganizationByUserID(ctx context.Context, userID string) ([]organization.Organization, error) {
+var organizationSortColumns = map[organization.Sort][]string{
+	organization.SortName:    {"o.name", "o.id"},
+	organization.SortDomain:  {"o.domain", "o.id"},
+	organization.SortCreated: {"o.created_at", "o.id"},
+}
+
+func (db *Database) FetchUserOrganizations(ctx context.Context, filter organization.Filter) ([]organization.Organization, error) {
+	conditions := []string{"m.user_id = ?", "m.deleted_at = 0", "o.deleted_at = 0"}
+	args := []interface{}{filter.UserID}
+	if filter.Role != "" {
+		conditions = append(conditions, "m.role = ?")
+		args = append(args, filter.Role)
+	}
+	if filter.Search != "" {
+		conditions = append(conditions, `(LOWER(o.name) LIKE ? ESCAPE '\' OR LOWER(o.domain) LIKE ? ESCAPE '\')`)
+		pattern := contains(filter.Search)
+		args = append(args, pattern, pattern)
+	}
+	after := make([]interface{}, len(filter.After))
+	for i, key := range filter.After {
+		after[i] = key
+	}
+	if len(after) > 0 && filter.Sort == organization.SortCreated {
+		// created_at is compared as a number, the service checked the key
+		createdAt, err := strconv.Atoi(filter.After[0])
+		if err != nil {
+			return nil, err
+		}
+		after[0] = createdAt
+	}
+	condition, order := keyset(organizationSortColumns[filter.Sort], after, filter.Descending)
+	if condition != "" {
+		conditions = append(conditions, condition)
+		args = append(args, after...)
+	}
+
 	query := `
-		SELECT id, name, domain, created_at, updated_at
-		FROM organizations
-		WHERE id IN (
-			SELECT organization_id
-			FROM members
-			WHERE user_id = $1
-		)
+		SELECT o.id, o.name, o.domain, o.logo_url, o.primary_color, o.sender_name, o.password_policy, o.created_at, o.updated_at, m.role
+		FROM organizations o
+		JOIN members m ON m.organization_id = o.id
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY ` + order + `
+		LIMIT ?
 	`
+	args = append(args, filter.Limit)
 
-	rows, err := db.client.QueryxContext(ctx, query, userID)
+	rows, err := db.conn(ctx).QueryxContext(ctx, db.rebind(query), args...)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -56,13 +143,12 @@ func (db *Database) GetOrganizationByUserID(ctx context.Context, userID string)
 			return nil, err
 		}
 
-		organizations = append(organizations, organization.Organization{
-			ID:        row.ID,
-			Name:      row.Name,
-			Domain:    row.Domain,
-			CreatedAt: row.CreatedAt,
-			UpdatedAt: row.UpdatedAt,
-		})
+		org, err := row.organization()
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		organizations = append(organizations, org)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -86,7 +172,10 @@ func (db *Database) InsertOrganization(ctx context.Context, name string, domain
 		INSERT INTO organizations (id, name, domain, created_at, updated_at)
 		VALUES (:id, :name, :domain, :created_at, :updated_at)
 	`
-	_, err := db.client.NamedExecContext(ctx, query, &org)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &org)
+	if uniqueViolation(err) {
+		return "", organization.DomainTaken
+	}
 	if err != nil {
 		return "", OrganizationCreationFailed
 	}
@@ -98,57 +187,145 @@ func (db *Database) GetOrganizationByID(ctx context.Context, id string) (organiz
 
 	query := `
 		SELECT * FROM organizations
-		WHERE id = $1
+		WHERE id = ? AND deleted_at = 0
 	`
 
-	err := db.client.GetContext(ctx, &org, query, id)
+	err := db.conn(ctx).GetContext(ctx, &org, db.rebind(query), id)
 
 	if err != nil {
 		return organization.Organization{}, err
 	}
 
-	return organization.Organization{
-		ID:        org.ID,
-		Name:      org.Name,
-		Domain:    org.Domain,
-		CreatedAt: org.CreatedAt,
-		UpdatedAt: org.UpdatedAt,
-	}, nil
+	return org.organization()
 }
 
+// DeleteOrganizationByID marks the organization deleted along with its
+// members, the members keep the same time so RestoreOrganization brings
+// back only those
 func (db *Database) DeleteOrganizationByID(ctx context.Context, id string) (string, error) {
-	query := `
-		DELETE FROM organizations
-		WHERE id = $1
-	`
+	now := time.Now().Unix()
+	err := db.WithTx(ctx, func(ctx context.Context) error {
+		query := `
+			UPDATE organizations
+			SET deleted_at = ?, updated_at = ?
+			WHERE id = ? AND deleted_at = 0
+		`
+		result, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), now, now, id)
+		if err != nil {
+			return OrganizationDeleteFailed
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return OrganizationDeleteFailed
+		}
 
-	result, err := db.client.ExecContext(ctx, query, id)
+		if rowsAffected == 0 {
+			return sql.ErrNoRows
+		}
+
+		_, err = db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE members SET deleted_at = ? WHERE organization_id = ? AND deleted_at = 0"), now, id)
+		if err != nil {
+			log.Println(err)
+			return OrganizationDeleteFailed
+		}
+		return nil
+	})
 	if err != nil {
-		return "", OrganizationDeleteFailed
+		return "", err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	return OrganizationDeleted, nil
+}
+
+// RestoreOrganization undoes DeleteOrganizationByID, members whose user is
+// deleted stay deleted
+func (db *Database) RestoreOrganization(ctx context.Context, id string) error {
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		var deletedAt int
+		err := db.conn(ctx).GetContext(ctx, &deletedAt, db.rebind("SELECT deleted_at FROM organizations WHERE id = ? AND deleted_at > 0"), id)
+		if err != nil {
+			return err
+		}
+		_, err = db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE organizations SET deleted_at = 0, updated_at = ? WHERE id = ?"), time.Now().Unix(), id)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		query := `
+			UPDATE members SET deleted_at = 0
+			WHERE organization_id = ? AND deleted_at = ?
+			AND user_id IN (SELECT id FROM users WHERE deleted_at = 0)
+		`
+		_, err = db.conn(ctx).ExecContext(ctx, db.rebind(query), id, deletedAt)
+		if err != nil {
+			log.Println(err)
+		}
+		return err
+	})
+}
+
+// FetchDeletedOrganizations returns the organizations deleted before the
+// time, oldest first
+func (db *Database) FetchDeletedOrganizations(ctx context.Context, before int) ([]organization.Organization, error) {
+	rows := []OrganizationRow{}
+	query := `
+		SELECT * FROM organizations
+		WHERE deleted_at > 0 AND deleted_at < ?
+		ORDER BY deleted_at, id
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), before)
 	if err != nil {
-		return "", OrganizationDeleteFailed
+		log.Println(err)
+		return nil, err
 	}
-
-	if rowsAffected == 0 {
-		return "", FetchOrganizationFailed
+	organizations := make([]organization.Organization, len(rows))
+	for i, row := range rows {
+		organizations[i], err = row.organization()
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
 	}
+	return organizations, nil
+}
 
-	return OrganizationDeleted, nil
+// PurgeOrganization deletes the organization for good with its invites and
+// members, the schema cascades to the rest
+func (db *Database) PurgeOrganization(ctx context.Context, id string) error {
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		for _, query := range []string{
+			"DELETE FROM member_invite WHERE organization_id = ?",
+			"DELETE FROM members WHERE organization_id = ?",
+		} {
+			_, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), id)
+			if err != nil {
+				log.Println(err)
+				return err
+			}
+		}
+		result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM organizations WHERE id = ?"), id)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		return expectRow(result)
+	})
 }
 
 func (db *Database) UpdateOrganization(ctx context.Context, id string, name string, domain string) (organization.Organization, error) {
 	// Prepare the SQL query
 	query := `
 		UPDATE organizations
-		SET name = $1, domain = $2, updated_at = $3
-		WHERE id = $4
+		SET name = ?, domain = ?, updated_at = ?
+		WHERE id = ? AND deleted_at = 0
 	`
 
 	// Execute the SQL query
-	_, err := db.client.ExecContext(ctx, query, name, domain, time.Now().Unix(), id)
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), name, domain, time.Now().Unix(), id)
+	if uniqueViolation(err) {
+		return organization.Organization{}, organization.DomainTaken
+	}
 	if err != nil {
 		return organization.Organization{}, OrganizationUpdateFailed
 	}
@@ -161,3 +338,40 @@ func (db *Database) UpdateOrganization(ctx context.Context, id string, name stri
 
 	return updatedOrg, nil
 }
+
+func (db *Database) UpdateOrganizationBranding(ctx context.Context, id string, logoURL string, primaryColor string, senderName string) (organization.Organization, error) {
+	query := `
+		UPDATE organizations
+		SET logo_url = ?, primary_color = ?, sender_name = ?, updated_at = ?
+		WHERE id = ? AND deleted_at = 0
+	`
+
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), logoURL, primaryColor, senderName, time.Now().Unix(), id)
+	if err != nil {
+		log.Println(err)
+		return organization.Organization{}, OrganizationUpdateFailed
+	}
+
+	return db.GetOrganizationByID(ctx, id)
+}
+
+func (db *Database) UpdateOrganizationPasswordPolicy(ctx context.Context, id string, policy password.Policy) (organization.Organization, error) {
+	encoded, err := encodePasswordPolicy(policy)
+	if err != nil {
+		return organization.Organization{}, err
+	}
+
+	query := `
+		UPDATE organizations
+		SET password_policy = ?, updated_at = ?
+		WHERE id = ? AND deleted_at = 0
+	`
+
+	_, err = db.conn(ctx).ExecContext(ctx, db.rebind(query), encoded, time.Now().Unix(), id)
+	if err != nil {
+		log.Println(err)
+		return organization.Organization{}, OrganizationUpdateFailed
+	}
+
+	return db.GetOrganizationByID(ctx, id)
+}
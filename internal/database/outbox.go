@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"microauth.io/core/internal/outbox"
+)
+
+type OutboxRow struct {
+	ID            string        `db:"id"`
+	Sender        string        `db:"sender"`
+	Recipients    string        `db:"recipients"`
+	Subject       string        `db:"subject"`
+	Message       string        `db:"message"`
+	Status        outbox.Status `db:"status"`
+	Attempts      int           `db:"attempts"`
+	LastError     string        `db:"last_error"`
+	NextAttemptAt int           `db:"next_attempt_at"`
+	CreatedAt     int           `db:"created_at"`
+	UpdatedAt     int           `db:"updated_at"`
+}
+
+var (
+	InsertOutboxMessageFailed = errors.New("unable to insert outbox message")
+	ClaimOutboxMessagesFailed = errors.New("unable to claim outbox messages")
+	UpdateOutboxMessageFailed = errors.New("unable to update outbox message")
+	FetchOutboxMessageFailed  = errors.New("unable to fetch outbox message")
+	OutboxMessageUpdated      = "outbox message updated"
+)
+
+func (row OutboxRow) toMessage() outbox.Message {
+	return outbox.Message{
+		ID:            row.ID,
+		Sender:        row.Sender,
+		Recipients:    strings.Split(row.Recipients, ","),
+		Subject:       row.Subject,
+		Body:          row.Message,
+		Status:        row.Status,
+		Attempts:      row.Attempts,
+		LastError:     row.LastError,
+		NextAttemptAt: row.NextAttemptAt,
+		CreatedAt:     row.CreatedAt,
+		UpdatedAt:     row.UpdatedAt,
+	}
+}
+
+func (db *Database) InsertOutboxMessage(ctx context.Context, message outbox.Message) (string, error) {
+	row := OutboxRow{
+		ID:            message.ID,
+		Sender:        message.Sender,
+		Recipients:    strings.Join(message.Recipients, ","),
+		Subject:       message.Subject,
+		Message:       message.Body,
+		Status:        message.Status,
+		Attempts:      message.Attempts,
+		LastError:     message.LastError,
+		NextAttemptAt: message.NextAttemptAt,
+		CreatedAt:     message.CreatedAt,
+		UpdatedAt:     message.UpdatedAt,
+	}
+
+	query := `
+		INSERT INTO email_outbox (id, sender, recipients, subject, message, status, attempts, last_error, next_attempt_at, created_at, updated_at)
+		VALUES (:id, :sender, :recipients, :subject, :message, :status, :attempts, :last_error, :next_attempt_at, :created_at, :updated_at)
+	`
+
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return "", InsertOutboxMessageFailed
+	}
+	return message.ID, nil
+}
+
+func (db *Database) ClaimOutboxMessages(ctx context.Context, limit int, leaseUntil int) ([]outbox.Message, error) {
+	// A message is due when it is pending, or when a worker claimed it and
+	// its lease ran out without a result. SQLite has a single writer so it
+	// needs no row locks.
+	lock := "FOR UPDATE SKIP LOCKED"
+	if db.driver == SQLite {
+		lock = ""
+	}
+	query := `
+		UPDATE email_outbox
+		SET status = ?, attempts = attempts + 1, next_attempt_at = ?, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status IN (?, ?) AND next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT ?
+			` + lock + `
+		)
+		RETURNING *
+	`
+
+	now := time.Now().Unix()
+	rows, err := db.conn(ctx).QueryxContext(ctx, db.rebind(query), outbox.Sending, leaseUntil, now, outbox.Pending, outbox.Sending, now, limit)
+	if err != nil {
+		log.Println(err)
+		return nil, ClaimOutboxMessagesFailed
+	}
+	defer rows.Close()
+
+	messages := make([]outbox.Message, 0)
+	for rows.Next() {
+		var row OutboxRow
+		if err := rows.StructScan(&row); err != nil {
+			log.Println(err)
+			return nil, ClaimOutboxMessagesFailed
+		}
+		messages = append(messages, row.toMessage())
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return nil, ClaimOutboxMessagesFailed
+	}
+
+	return messages, nil
+}
+
+func (db *Database) UpdateOutboxMessageStatus(ctx context.Context, id string, status outbox.Status, lastError string, nextAttemptAt int) (string, error) {
+	query := `
+		UPDATE email_outbox
+		SET status = ?, last_error = ?, next_attempt_at = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), status, lastError, nextAttemptAt, time.Now().Unix(), id)
+	if err != nil {
+		log.Println(err)
+		return "", UpdateOutboxMessageFailed
+	}
+
+	return OutboxMessageUpdated, nil
+}
+
+func (db *Database) FetchOutboxMessages(ctx context.Context, status outbox.Status) ([]outbox.Message, error) {
+	query := `
+		SELECT * FROM email_outbox
+		WHERE status = ?
+		ORDER BY updated_at DESC
+	`
+
+	rows, err := db.conn(ctx).QueryxContext(ctx, db.rebind(query), status)
+	if err != nil {
+		log.Println(err)
+		return nil, FetchOutboxMessageFailed
+	}
+	defer rows.Close()
+
+	messages := make([]outbox.Message, 0)
+	for rows.Next() {
+		var row OutboxRow
+		if err := rows.StructScan(&row); err != nil {
+			log.Println(err)
+			return nil, FetchOutboxMessageFailed
+		}
+		messages = append(messages, row.toMessage())
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return nil, FetchOutboxMessageFailed
+	}
+
+	return messages, nil
+}
+
+func (db *Database) RetryOutboxMessage(ctx context.Context, id string) (string, error) {
+	// Retrying gives the message a fresh set of attempts
+	query := `
+		UPDATE email_outbox
+		SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ?
+		WHERE id = ? AND status IN (?, ?)
+	`
+
+	now := time.Now().Unix()
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), outbox.Pending, now, now, id, outbox.Pending, outbox.Dead)
+	if err != nil {
+		log.Println(err)
+		return "", UpdateOutboxMessageFailed
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", UpdateOutboxMessageFailed
+	}
+	if rowsAffected == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	return OutboxMessageUpdated, nil
+}
+
+// DeleteOutboxMessagesTo deletes the messages whose only recipient is the
+// address, whatever their status
+func (db *Database) DeleteOutboxMessagesTo(ctx context.Context, address string) error {
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM email_outbox WHERE recipients = ?"), address)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"microauth.io/core/internal/privacy"
+)
+
+type AccountDeletionRow struct {
+	UserID      string `db:"user_id"`
+	RequestedBy string `db:"requested_by"`
+	RequestedAt int    `db:"requested_at"`
+	DeleteAfter int    `db:"delete_after"`
+}
+
+func (db *Database) InsertAccountDeletion(ctx context.Context, deletion privacy.Deletion) error {
+	query := `
+		INSERT INTO account_deletions (user_id, requested_by, requested_at, delete_after)
+		VALUES (:user_id, :requested_by, :requested_at, :delete_after)
+	`
+	row := AccountDeletionRow(deletion)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetAccountDeletion(ctx context.Context, userID string) (privacy.Deletion, error) {
+	row := AccountDeletionRow{}
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind("SELECT * FROM account_deletions WHERE user_id = ?"), userID)
+	if err != nil {
+		return privacy.Deletion{}, err
+	}
+	return privacy.Deletion(row), nil
+}
+
+func (db *Database) FetchAccountDeletions(ctx context.Context) ([]privacy.Deletion, error) {
+	rows := []AccountDeletionRow{}
+	err := db.conn(ctx).SelectContext(ctx, &rows, "SELECT * FROM account_deletions ORDER BY delete_after, user_id")
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	deletions := make([]privacy.Deletion, len(rows))
+	for i, row := range rows {
+		deletions[i] = privacy.Deletion(row)
+	}
+	return deletions, nil
+}
+
+func (db *Database) DeleteAccountDeletion(ctx context.Context, userID string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM account_deletions WHERE user_id = ?"), userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"microauth.io/core/internal/ratelimit"
+)
+
+type BucketRow struct {
+	Key    string `db:"bucket_key"`
+	FullAt int64  `db:"full_at"`
+}
+
+// TakeToken advances the bucket in a single statement so concurrent
+// requests on every replica each take their own token. The update is
+// skipped when the bucket is empty, no row is then returned.
+func (db *Database) TakeToken(ctx context.Context, key string, now int64, interval int64, capacity int) (ratelimit.Bucket, bool, error) {
+	row := BucketRow{}
+	query := `
+		INSERT INTO rate_limit_buckets (bucket_key, full_at)
+		VALUES (?, ?)
+		ON CONFLICT (bucket_key) DO UPDATE SET
+			full_at = CASE WHEN rate_limit_buckets.full_at < ? THEN excluded.full_at ELSE rate_limit_buckets.full_at + ? END
+		WHERE rate_limit_buckets.full_at + ? <= ?
+		RETURNING bucket_key, full_at
+	`
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind(query), key, now+interval, now, interval, interval, now+int64(capacity)*interval)
+	if err == nil {
+		return ratelimit.Bucket{Key: row.Key, FullAt: row.FullAt}, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		return ratelimit.Bucket{}, false, err
+	}
+
+	err = db.conn(ctx).GetContext(ctx, &row, db.rebind("SELECT bucket_key, full_at FROM rate_limit_buckets WHERE bucket_key = ?"), key)
+	if err != nil {
+		log.Println(err)
+		return ratelimit.Bucket{}, false, err
+	}
+	return ratelimit.Bucket{Key: row.Key, FullAt: row.FullAt}, false, nil
+}
+
+func (db *Database) PurgeBuckets(ctx context.Context, before int64) (int, error) {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM rate_limit_buckets WHERE full_at < ?"), before)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return int(rowsAffected), err
+}
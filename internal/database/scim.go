@@ -0,0 +1,223 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"microauth.io/core/internal/scim"
+)
+
+type SCIMTokenRow struct {
+	ID             string `db:"id"`
+	OrganizationID string `db:"organization_id"`
+	Name           string `db:"name"`
+	Hash           string `db:"token_hash"`
+	CreatedAt      int    `db:"created_at"`
+	LastUsedAt     int    `db:"last_used_at"`
+}
+
+type SCIMIdentityRow struct {
+	OrganizationID string `db:"organization_id"`
+	UserID         string `db:"user_id"`
+	ExternalID     string `db:"external_id"`
+	CreatedAt      int    `db:"created_at"`
+	UpdatedAt      int    `db:"updated_at"`
+}
+
+type SCIMGroupRow struct {
+	ID             string         `db:"id"`
+	OrganizationID string         `db:"organization_id"`
+	DisplayName    string         `db:"display_name"`
+	ExternalID     string         `db:"external_id"`
+	Kind           scim.GroupKind `db:"kind"`
+	Value          string         `db:"value"`
+	CreatedAt      int            `db:"created_at"`
+	UpdatedAt      int            `db:"updated_at"`
+}
+
+func (db *Database) InsertSCIMToken(ctx context.Context, token scim.Token) (string, error) {
+	query := `
+		INSERT INTO scim_tokens (id, organization_id, name, token_hash, created_at, last_used_at)
+		VALUES (:id, :organization_id, :name, :token_hash, :created_at, :last_used_at)
+	`
+	row := SCIMTokenRow(token)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	return token.ID, nil
+}
+
+func (db *Database) GetSCIMTokenByHash(ctx context.Context, hash string) (scim.Token, error) {
+	row := SCIMTokenRow{}
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind("SELECT t.* FROM scim_tokens t JOIN organizations o ON o.id = t.organization_id WHERE t.token_hash = ? AND o.deleted_at = 0"), hash)
+	if err != nil {
+		return scim.Token{}, err
+	}
+	return scim.Token(row), nil
+}
+
+func (db *Database) FetchSCIMTokens(ctx context.Context, organizationID string) ([]scim.Token, error) {
+	rows := []SCIMTokenRow{}
+	query := `
+		SELECT * FROM scim_tokens
+		WHERE organization_id = ?
+		ORDER BY created_at, id
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), organizationID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	tokens := make([]scim.Token, len(rows))
+	for i, row := range rows {
+		tokens[i] = scim.Token(row)
+	}
+	return tokens, nil
+}
+
+func (db *Database) UpdateSCIMTokenLastUsed(ctx context.Context, id string, usedAt int) error {
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE scim_tokens SET last_used_at = ? WHERE id = ?"), usedAt, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (db *Database) DeleteSCIMToken(ctx context.Context, organizationID string, id string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM scim_tokens WHERE organization_id = ? AND id = ?"), organizationID, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+func (db *Database) UpsertSCIMIdentity(ctx context.Context, identity scim.Identity) error {
+	query := `
+		INSERT INTO scim_identities (organization_id, user_id, external_id, created_at, updated_at)
+		VALUES (:organization_id, :user_id, :external_id, :created_at, :updated_at)
+		ON CONFLICT (organization_id, user_id)
+		DO UPDATE SET external_id = excluded.external_id, updated_at = excluded.updated_at
+	`
+	row := SCIMIdentityRow(identity)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetSCIMIdentity(ctx context.Context, organizationID string, userID string) (scim.Identity, error) {
+	row := SCIMIdentityRow{}
+	query := `
+		SELECT * FROM scim_identities
+		WHERE organization_id = ? AND user_id = ?
+	`
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind(query), organizationID, userID)
+	if err != nil {
+		return scim.Identity{}, err
+	}
+	return scim.Identity(row), nil
+}
+
+func (db *Database) FetchSCIMIdentities(ctx context.Context, organizationID string) ([]scim.Identity, error) {
+	rows := []SCIMIdentityRow{}
+	query := `
+		SELECT * FROM scim_identities
+		WHERE organization_id = ?
+		ORDER BY user_id
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), organizationID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	identities := make([]scim.Identity, len(rows))
+	for i, row := range rows {
+		identities[i] = scim.Identity(row)
+	}
+	return identities, nil
+}
+
+func (db *Database) DeleteSCIMIdentity(ctx context.Context, organizationID string, userID string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM scim_identities WHERE organization_id = ? AND user_id = ?"), organizationID, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+func (db *Database) InsertSCIMGroup(ctx context.Context, group scim.Group) (string, error) {
+	query := `
+		INSERT INTO scim_groups (id, organization_id, display_name, external_id, kind, value, created_at, updated_at)
+		VALUES (:id, :organization_id, :display_name, :external_id, :kind, :value, :created_at, :updated_at)
+	`
+	row := SCIMGroupRow(group)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	return group.ID, nil
+}
+
+func (db *Database) GetSCIMGroup(ctx context.Context, organizationID string, id string) (scim.Group, error) {
+	row := SCIMGroupRow{}
+	query := `
+		SELECT * FROM scim_groups
+		WHERE organization_id = ? AND id = ?
+	`
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind(query), organizationID, id)
+	if err != nil {
+		return scim.Group{}, err
+	}
+	return scim.Group(row), nil
+}
+
+func (db *Database) FetchSCIMGroups(ctx context.Context, organizationID string) ([]scim.Group, error) {
+	rows := []SCIMGroupRow{}
+	query := `
+		SELECT * FROM scim_groups
+		WHERE organization_id = ?
+		ORDER BY created_at, id
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), organizationID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	groups := make([]scim.Group, len(rows))
+	for i, row := range rows {
+		groups[i] = scim.Group(row)
+	}
+	return groups, nil
+}
+
+func (db *Database) UpdateSCIMGroup(ctx context.Context, group scim.Group) error {
+	query := `
+		UPDATE scim_groups
+		SET display_name = :display_name, external_id = :external_id, kind = :kind, value = :value, updated_at = :updated_at
+		WHERE organization_id = :organization_id AND id = :id
+	`
+	row := SCIMGroupRow(group)
+	result, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+func (db *Database) DeleteSCIMGroup(ctx context.Context, organizationID string, id string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM scim_groups WHERE organization_id = ? AND id = ?"), organizationID, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/team"
+)
+
+type TeamRow struct {
+	ID             string         `db:"id"`
+	OrganizationID string         `db:"organization_id"`
+	ParentID       sql.NullString `db:"parent_id"`
+	Name           string         `db:"name"`
+	Description    string         `db:"description"`
+	Role           member.Role    `db:"role"`
+	AppRoles       string         `db:"app_roles"`
+	CreatedAt      int            `db:"created_at"`
+	UpdatedAt      int            `db:"updated_at"`
+}
+
+type TeamMemberRow struct {
+	TeamID         string `db:"team_id"`
+	OrganizationID string `db:"organization_id"`
+	UserID         string `db:"user_id"`
+	CreatedAt      int    `db:"created_at"`
+}
+
+func (row TeamRow) toTeam() team.Team {
+	appRoles := []string{}
+	if row.AppRoles != "" {
+		appRoles = strings.Split(row.AppRoles, ",")
+	}
+	return team.Team{
+		ID:             row.ID,
+		OrganizationID: row.OrganizationID,
+		ParentID:       row.ParentID.String,
+		Name:           row.Name,
+		Description:    row.Description,
+		Role:           row.Role,
+		AppRoles:       appRoles,
+		CreatedAt:      row.CreatedAt,
+		UpdatedAt:      row.UpdatedAt,
+	}
+}
+
+func teamRow(t team.Team) TeamRow {
+	return TeamRow{
+		ID:             t.ID,
+		OrganizationID: t.OrganizationID,
+		ParentID:       sql.NullString{String: t.ParentID, Valid: t.ParentID != ""},
+		Name:           t.Name,
+		Description:    t.Description,
+		Role:           t.Role,
+		AppRoles:       strings.Join(t.AppRoles, ","),
+		CreatedAt:      t.CreatedAt,
+		UpdatedAt:      t.UpdatedAt,
+	}
+}
+
+func (db *Database) InsertTeam(ctx context.Context, t team.Team) (string, error) {
+	query := `
+		INSERT INTO teams (id, organization_id, parent_id, name, description, role, app_roles, created_at, updated_at)
+		VALUES (:id, :organization_id, :parent_id, :name, :description, :role, :app_roles, :created_at, :updated_at)
+	`
+	row := teamRow(t)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	return t.ID, nil
+}
+
+func (db *Database) GetTeam(ctx context.Context, organizationID string, id string) (team.Team, error) {
+	row := TeamRow{}
+	query := `
+		SELECT * FROM teams
+		WHERE organization_id = ? AND id = ?
+	`
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind(query), organizationID, id)
+	if err != nil {
+		return team.Team{}, err
+	}
+	return row.toTeam(), nil
+}
+
+func (db *Database) FetchTeams(ctx context.Context, organizationID string) ([]team.Team, error) {
+	rows := []TeamRow{}
+	query := `
+		SELECT * FROM teams
+		WHERE organization_id = ?
+		ORDER BY name, id
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), organizationID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	teams := make([]team.Team, len(rows))
+	for i, row := range rows {
+		teams[i] = row.toTeam()
+	}
+	return teams, nil
+}
+
+func (db *Database) UpdateTeam(ctx context.Context, t team.Team) error {
+	query := `
+		UPDATE teams
+		SET parent_id = :parent_id, name = :name, description = :description, role = :role, app_roles = :app_roles, updated_at = :updated_at
+		WHERE organization_id = :organization_id AND id = :id
+	`
+	row := teamRow(t)
+	result, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+func (db *Database) DeleteTeam(ctx context.Context, organizationID string, id string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM teams WHERE organization_id = ? AND id = ?"), organizationID, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+func (db *Database) InsertTeamMember(ctx context.Context, m team.Member) error {
+	query := `
+		INSERT INTO team_members (team_id, organization_id, user_id, created_at)
+		VALUES (:team_id, :organization_id, :user_id, :created_at)
+	`
+	row := TeamMemberRow(m)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (db *Database) FetchTeamMembers(ctx context.Context, organizationID string, teamID string) ([]team.Member, error) {
+	rows := []TeamMemberRow{}
+	query := `
+		SELECT t.* FROM team_members t
+		JOIN members m ON m.organization_id = t.organization_id AND m.user_id = t.user_id
+		WHERE t.organization_id = ? AND t.team_id = ? AND m.deleted_at = 0
+		ORDER BY t.created_at, t.user_id
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), organizationID, teamID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	members := make([]team.Member, len(rows))
+	for i, row := range rows {
+		members[i] = team.Member(row)
+	}
+	return members, nil
+}
+
+func (db *Database) FetchUserTeams(ctx context.Context, organizationID string, userID string) ([]team.Member, error) {
+	rows := []TeamMemberRow{}
+	query := `
+		SELECT t.* FROM team_members t
+		JOIN members m ON m.organization_id = t.organization_id AND m.user_id = t.user_id
+		WHERE t.organization_id = ? AND t.user_id = ? AND m.deleted_at = 0
+		ORDER BY t.team_id
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), organizationID, userID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	members := make([]team.Member, len(rows))
+	for i, row := range rows {
+		members[i] = team.Member(row)
+	}
+	return members, nil
+}
+
+func (db *Database) DeleteTeamMember(ctx context.Context, organizationID string, teamID string, userID string) error {
+	query := `
+		DELETE FROM team_members
+		WHERE organization_id = ? AND team_id = ? AND user_id = ?
+	`
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), organizationID, teamID, userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
@@ -2,10 +2,12 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"log"
 	"time"
 
 	"github.com/google/uuid"
+	"microauth.io/core/internal/password"
 	"microauth.io/core/internal/user"
 )
 
@@ -15,40 +17,330 @@ type UserRow struct {
 	LastName        string `db:"last_name"`
 	Email           string `db:"email"`
 	IsEmailVerified bool   `db:"is_email_verified"`
+	IsAdmin         bool   `db:"is_admin"`
 	Password        string `db:"password"`
 	CreatedAt       int    `db:"created_at"`
 	UpdatedAt       int    `db:"updated_at"`
 	ResetOtp        string `db:"reset_otp"`
 	ResetExpiry     int    `db:"reset_expiry"`
+	SessionVersion  int    `db:"session_version"`
+	DeletedAt       int    `db:"deleted_at"`
+	ProvisionedBy   string `db:"provisioned_by"`
+}
+
+type EmailChangeRow struct {
+	UserID    string `db:"user_id"`
+	Email     string `db:"email"`
+	CodeHash  string `db:"code_hash"`
+	ExpiresAt int    `db:"expires_at"`
+	CreatedAt int    `db:"created_at"`
+}
+
+type OrganizationPreferenceRow struct {
+	UserID                string         `db:"user_id"`
+	DefaultOrganizationID sql.NullString `db:"default_organization_id"`
+	LastOrganizationID    sql.NullString `db:"last_organization_id"`
+	UpdatedAt             int            `db:"updated_at"`
 }
 
 func (db *Database) GetUserByEmail(ctx context.Context, email string) (user.User, error) {
 	userRow := UserRow{}
-	err := db.client.GetContext(ctx, &userRow, "SELECT id, first_name, last_name, email, is_email_verified, password, created_at, updated_at FROM public.users WHERE email=$1 LIMIT 1", email)
+	err := db.conn(ctx).GetContext(ctx, &userRow, db.rebind("SELECT id, first_name, last_name, email, is_email_verified, is_admin, password, session_version, provisioned_by, created_at, updated_at FROM users WHERE email=? AND deleted_at = 0 LIMIT 1"), email)
 	if err != nil {
 		log.Println(err)
 		return user.User{}, err
 	}
+	return userRow.user(), nil
+}
+
+func (db *Database) GetUserByID(ctx context.Context, id string) (user.User, error) {
+	userRow := UserRow{}
+	err := db.conn(ctx).GetContext(ctx, &userRow, db.rebind("SELECT id, first_name, last_name, email, is_email_verified, is_admin, password, session_version, provisioned_by, created_at, updated_at FROM users WHERE id=? AND deleted_at = 0 LIMIT 1"), id)
+	if err != nil {
+		return user.User{}, err
+	}
+	return userRow.user(), nil
+}
+
+func (row UserRow) user() user.User {
 	return user.User{
-		ID:              userRow.ID,
-		FirstName:       userRow.FirstName,
-		LastName:        userRow.LastName,
-		Email:           userRow.Email,
-		IsEmailVerified: userRow.IsEmailVerified,
-		Password:        userRow.Password,
-		CreatedAt:       userRow.CreatedAt,
-		UpdatedAt:       userRow.UpdatedAt,
-		ResetOtp:        userRow.ResetOtp,
-		ResetExpiry:     userRow.ResetExpiry,
-	}, nil
+		ID:              row.ID,
+		FirstName:       row.FirstName,
+		LastName:        row.LastName,
+		Email:           row.Email,
+		IsEmailVerified: row.IsEmailVerified,
+		IsAdmin:         row.IsAdmin,
+		Password:        row.Password,
+		CreatedAt:       row.CreatedAt,
+		UpdatedAt:       row.UpdatedAt,
+		ResetOtp:        row.ResetOtp,
+		ResetExpiry:     row.ResetExpiry,
+		SessionVersion:  row.SessionVersion,
+		DeletedAt:       row.DeletedAt,
+		ProvisionedBy:   row.ProvisionedBy,
+	}
+}
+
+// EmailExists also counts the deleted users, their address is taken until
+// they are purged
+func (db *Database) EmailExists(ctx context.Context, email string) (bool, error) {
+	var count int
+	err := db.conn(ctx).GetContext(ctx, &count, db.rebind("SELECT COUNT(*) FROM users WHERE email = ?"), email)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return count > 0, nil
 }
 
 func (db *Database) InsertUser(ctx context.Context, firstName string, lastName string, email string, password string, isEmailVerified bool) (string, error) {
 	userID := uuid.New().String()
-	_, err := db.client.ExecContext(ctx, "INSERT INTO public.users (id, first_name, last_name, email, is_email_verified, password, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)", userID, firstName, lastName, email, isEmailVerified, password, time.Now().Unix(), time.Now().Unix())
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind("INSERT INTO users (id, first_name, last_name, email, is_email_verified, password, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"), userID, firstName, lastName, email, isEmailVerified, password, time.Now().Unix(), time.Now().Unix())
 	if err != nil {
 		log.Println(err)
 		return "", err
 	}
 	return userID, nil
 }
+
+func (db *Database) UpdateUserPassword(ctx context.Context, id string, hashedPassword string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE users SET password = ?, updated_at = ? WHERE id = ?"), hashedPassword, time.Now().Unix(), id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *Database) UpdateUserName(ctx context.Context, id string, firstName string, lastName string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE users SET first_name = ?, last_name = ?, updated_at = ? WHERE id = ?"), firstName, lastName, time.Now().Unix(), id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+func (db *Database) UpdateUserProvisioner(ctx context.Context, id string, organizationID string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE users SET provisioned_by = ? WHERE id = ?"), organizationID, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+// UpdateUserEmail fails on the unique index when the address is taken
+func (db *Database) UpdateUserEmail(ctx context.Context, id string, email string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE users SET email = ?, is_email_verified = ?, updated_at = ? WHERE id = ?"), email, true, time.Now().Unix(), id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+func (db *Database) RevokeUserSessions(ctx context.Context, id string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE users SET session_version = session_version + 1, updated_at = ? WHERE id = ?"), time.Now().Unix(), id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+func (db *Database) SaveEmailChange(ctx context.Context, change user.EmailChange) error {
+	query := `
+		INSERT INTO email_changes (user_id, email, code_hash, expires_at, created_at)
+		VALUES (:user_id, :email, :code_hash, :expires_at, :created_at)
+		ON CONFLICT (user_id) DO UPDATE SET
+			email = excluded.email,
+			code_hash = excluded.code_hash,
+			expires_at = excluded.expires_at,
+			created_at = excluded.created_at
+	`
+	row := EmailChangeRow(change)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (db *Database) GetEmailChange(ctx context.Context, userID string) (user.EmailChange, error) {
+	row := EmailChangeRow{}
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind("SELECT * FROM email_changes WHERE user_id = ?"), userID)
+	if err != nil {
+		return user.EmailChange{}, err
+	}
+	return user.EmailChange(row), nil
+}
+
+func (db *Database) DeleteEmailChange(ctx context.Context, userID string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM email_changes WHERE user_id = ?"), userID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+func (db *Database) GetOrganizationPreference(ctx context.Context, userID string) (user.OrganizationPreference, error) {
+	row := OrganizationPreferenceRow{}
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind("SELECT * FROM organization_preferences WHERE user_id = ?"), userID)
+	if err != nil {
+		return user.OrganizationPreference{}, err
+	}
+	return user.OrganizationPreference{
+		UserID:                row.UserID,
+		DefaultOrganizationID: row.DefaultOrganizationID.String,
+		LastOrganizationID:    row.LastOrganizationID.String,
+		UpdatedAt:             row.UpdatedAt,
+	}, nil
+}
+
+// SetDefaultOrganization and SetLastOrganization each leave the other
+// organization of the preference as it is, an empty ID clears it
+func (db *Database) SetDefaultOrganization(ctx context.Context, userID string, organizationID string) error {
+	return db.savePreference(ctx, "default_organization_id", userID, organizationID)
+}
+
+func (db *Database) SetLastOrganization(ctx context.Context, userID string, organizationID string) error {
+	return db.savePreference(ctx, "last_organization_id", userID, organizationID)
+}
+
+func (db *Database) savePreference(ctx context.Context, column string, userID string, organizationID string) error {
+	query := `
+		INSERT INTO organization_preferences (user_id, ` + column + `, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			` + column + ` = excluded.` + column + `,
+			updated_at = excluded.updated_at
+	`
+	id := sql.NullString{String: organizationID, Valid: organizationID != ""}
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), userID, id, int(time.Now().Unix()))
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// DeleteUser marks the user deleted along with their memberships, the
+// memberships keep the same time so RestoreUser brings back only those
+func (db *Database) DeleteUser(ctx context.Context, id string) error {
+	now := db.now(ctx)
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		result, err := db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE users SET deleted_at = ?, updated_at = ? WHERE id = ? AND deleted_at = 0"), now, now, id)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		err = expectRow(result)
+		if err != nil {
+			return err
+		}
+		_, err = db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE members SET deleted_at = ? WHERE user_id = ? AND deleted_at = 0"), now, id)
+		if err != nil {
+			log.Println(err)
+		}
+		return err
+	})
+}
+
+// RestoreUser undoes DeleteUser, memberships of deleted organizations stay
+// deleted
+func (db *Database) RestoreUser(ctx context.Context, id string) error {
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		var deletedAt int
+		err := db.conn(ctx).GetContext(ctx, &deletedAt, db.rebind("SELECT deleted_at FROM users WHERE id = ? AND deleted_at > 0"), id)
+		if err != nil {
+			return err
+		}
+		_, err = db.conn(ctx).ExecContext(ctx, db.rebind("UPDATE users SET deleted_at = 0, updated_at = ? WHERE id = ?"), time.Now().Unix(), id)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		query := `
+			UPDATE members SET deleted_at = 0
+			WHERE user_id = ? AND deleted_at = ?
+			AND organization_id IN (SELECT id FROM organizations WHERE deleted_at = 0)
+		`
+		_, err = db.conn(ctx).ExecContext(ctx, db.rebind(query), id, deletedAt)
+		if err != nil {
+			log.Println(err)
+		}
+		return err
+	})
+}
+
+// FetchDeletedUsers returns the users deleted before the time, oldest first
+func (db *Database) FetchDeletedUsers(ctx context.Context, before int) ([]user.User, error) {
+	rows := []UserRow{}
+	query := `
+		SELECT id, first_name, last_name, email, is_email_verified, is_admin, password, session_version, provisioned_by, created_at, updated_at, deleted_at
+		FROM users
+		WHERE deleted_at > 0 AND deleted_at < ?
+		ORDER BY deleted_at, id
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), before)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	users := make([]user.User, len(rows))
+	for i, row := range rows {
+		users[i] = row.user()
+	}
+	return users, nil
+}
+
+// PurgeUser relies on the cascades of the schema for the email changes,
+// the provider identities and the scheduled deletion of the user
+func (db *Database) PurgeUser(ctx context.Context, id string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM users WHERE id = ?"), id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+// FetchUserPasswordPolicies returns the policies of the organizations the
+// user is a member of, organizations without one are left out
+func (db *Database) FetchUserPasswordPolicies(ctx context.Context, userID string) ([]password.Policy, error) {
+	query := `
+		SELECT organizations.password_policy
+		FROM organizations
+		JOIN members ON members.organization_id = organizations.id
+		WHERE members.user_id = ? AND members.deleted_at = 0
+		AND organizations.deleted_at = 0 AND organizations.password_policy <> ''
+	`
+
+	var encoded []string
+	err := db.conn(ctx).SelectContext(ctx, &encoded, db.rebind(query), userID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	policies := make([]password.Policy, 0, len(encoded))
+	for _, value := range encoded {
+		policy, err := decodePasswordPolicy(value)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"microauth.io/core/internal/webhook"
+)
+
+type WebhookEndpointRow struct {
+	ID             string `db:"id"`
+	OrganizationID string `db:"organization_id"`
+	URL            string `db:"url"`
+	Secret         string `db:"secret"`
+	Events         string `db:"events"`
+	Active         bool   `db:"active"`
+	CreatedAt      int    `db:"created_at"`
+	UpdatedAt      int    `db:"updated_at"`
+}
+
+type WebhookDeliveryRow struct {
+	ID             string         `db:"id"`
+	EndpointID     string         `db:"endpoint_id"`
+	OrganizationID string         `db:"organization_id"`
+	EventID        string         `db:"event_id"`
+	EventType      string         `db:"event_type"`
+	Payload        string         `db:"payload"`
+	Status         webhook.Status `db:"status"`
+	Attempts       int            `db:"attempts"`
+	ResponseStatus int            `db:"response_status"`
+	LastError      string         `db:"last_error"`
+	NextAttemptAt  int            `db:"next_attempt_at"`
+	CreatedAt      int            `db:"created_at"`
+	UpdatedAt      int            `db:"updated_at"`
+}
+
+func (row WebhookEndpointRow) toEndpoint() webhook.Endpoint {
+	return webhook.Endpoint{
+		ID:             row.ID,
+		OrganizationID: row.OrganizationID,
+		URL:            row.URL,
+		Secret:         row.Secret,
+		Events:         strings.Split(row.Events, ","),
+		Active:         row.Active,
+		CreatedAt:      row.CreatedAt,
+		UpdatedAt:      row.UpdatedAt,
+	}
+}
+
+func endpointRow(endpoint webhook.Endpoint) WebhookEndpointRow {
+	return WebhookEndpointRow{
+		ID:             endpoint.ID,
+		OrganizationID: endpoint.OrganizationID,
+		URL:            endpoint.URL,
+		Secret:         endpoint.Secret,
+		Events:         strings.Join(endpoint.Events, ","),
+		Active:         endpoint.Active,
+		CreatedAt:      endpoint.CreatedAt,
+		UpdatedAt:      endpoint.UpdatedAt,
+	}
+}
+
+func (db *Database) InsertWebhookEndpoint(ctx context.Context, endpoint webhook.Endpoint) (string, error) {
+	query := `
+		INSERT INTO webhook_endpoints (id, organization_id, url, secret, events, active, created_at, updated_at)
+		VALUES (:id, :organization_id, :url, :secret, :events, :active, :created_at, :updated_at)
+	`
+	row := endpointRow(endpoint)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	return endpoint.ID, nil
+}
+
+func (db *Database) GetWebhookEndpoint(ctx context.Context, organizationID string, id string) (webhook.Endpoint, error) {
+	row := WebhookEndpointRow{}
+	query := `
+		SELECT * FROM webhook_endpoints
+		WHERE organization_id = ? AND id = ?
+	`
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind(query), organizationID, id)
+	if err != nil {
+		return webhook.Endpoint{}, err
+	}
+	return row.toEndpoint(), nil
+}
+
+func (db *Database) FetchWebhookEndpoints(ctx context.Context, organizationID string) ([]webhook.Endpoint, error) {
+	rows := []WebhookEndpointRow{}
+	query := `
+		SELECT * FROM webhook_endpoints
+		WHERE organization_id = ?
+		ORDER BY created_at, id
+	`
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), organizationID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	endpoints := make([]webhook.Endpoint, len(rows))
+	for i, row := range rows {
+		endpoints[i] = row.toEndpoint()
+	}
+	return endpoints, nil
+}
+
+func (db *Database) UpdateWebhookEndpoint(ctx context.Context, endpoint webhook.Endpoint) error {
+	query := `
+		UPDATE webhook_endpoints
+		SET url = :url, events = :events, active = :active, updated_at = :updated_at
+		WHERE organization_id = :organization_id AND id = :id
+	`
+	row := endpointRow(endpoint)
+	result, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+func (db *Database) DeleteWebhookEndpoint(ctx context.Context, organizationID string, id string) error {
+	result, err := db.conn(ctx).ExecContext(ctx, db.rebind("DELETE FROM webhook_endpoints WHERE organization_id = ? AND id = ?"), organizationID, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return expectRow(result)
+}
+
+// expectRow turns a statement that changed nothing into sql.ErrNoRows
+func expectRow(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *Database) InsertWebhookDelivery(ctx context.Context, delivery webhook.Delivery) (string, error) {
+	query := `
+		INSERT INTO webhook_deliveries (id, endpoint_id, organization_id, event_id, event_type, payload, status, attempts, response_status, last_error, next_attempt_at, created_at, updated_at)
+		VALUES (:id, :endpoint_id, :organization_id, :event_id, :event_type, :payload, :status, :attempts, :response_status, :last_error, :next_attempt_at, :created_at, :updated_at)
+	`
+	row := WebhookDeliveryRow(delivery)
+	_, err := db.conn(ctx).NamedExecContext(ctx, query, &row)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	return delivery.ID, nil
+}
+
+func (db *Database) GetWebhookDelivery(ctx context.Context, endpointID string, id string) (webhook.Delivery, error) {
+	row := WebhookDeliveryRow{}
+	query := `
+		SELECT * FROM webhook_deliveries
+		WHERE endpoint_id = ? AND id = ?
+	`
+	err := db.conn(ctx).GetContext(ctx, &row, db.rebind(query), endpointID, id)
+	if err != nil {
+		return webhook.Delivery{}, err
+	}
+	return webhook.Delivery(row), nil
+}
+
+func (db *Database) FetchWebhookDeliveries(ctx context.Context, endpointID string, status webhook.Status, limit int) ([]webhook.Delivery, error) {
+	query := "SELECT * FROM webhook_deliveries WHERE endpoint_id = ?"
+	args := []interface{}{endpointID}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY created_at DESC, id LIMIT ?"
+	args = append(args, limit)
+
+	rows := []WebhookDeliveryRow{}
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), args...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	deliveries := make([]webhook.Delivery, len(rows))
+	for i, row := range rows {
+		deliveries[i] = webhook.Delivery(row)
+	}
+	return deliveries, nil
+}
+
+func (db *Database) ClaimWebhookDeliveries(ctx context.Context, limit int, leaseUntil int) ([]webhook.Delivery, error) {
+	// Claimed like the email outbox, a delivery whose lease ran out without
+	// a result is due again
+	lock := "FOR UPDATE SKIP LOCKED"
+	if db.driver == SQLite {
+		lock = ""
+	}
+	query := `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = attempts + 1, next_attempt_at = ?, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status IN (?, ?) AND next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT ?
+			` + lock + `
+		)
+		RETURNING *
+	`
+
+	now := time.Now().Unix()
+	rows := []WebhookDeliveryRow{}
+	err := db.conn(ctx).SelectContext(ctx, &rows, db.rebind(query), webhook.Sending, leaseUntil, now, webhook.Pending, webhook.Sending, now, limit)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	deliveries := make([]webhook.Delivery, len(rows))
+	for i, row := range rows {
+		deliveries[i] = webhook.Delivery(row)
+	}
+	return deliveries, nil
+}
+
+func (db *Database) UpdateWebhookDeliveryStatus(ctx context.Context, id string, status webhook.Status, responseStatus int, lastError string, nextAttemptAt int) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = ?, response_status = ?, last_error = ?, next_attempt_at = ?, updated_at = ?
+		WHERE id = ?
+	`
+	_, err := db.conn(ctx).ExecContext(ctx, db.rebind(query), status, responseStatus, lastError, nextAttemptAt, time.Now().Unix(), id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
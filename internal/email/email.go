@@ -1,44 +1,157 @@
 package email
 
 import (
+	"context"
 	"errors"
+	"log"
+	"net"
+	"net/mail"
 	"net/smtp"
 )
 
 type Service struct {
-	username string
-	password string
-	url      string
-	port     string
+	username  string
+	password  string
+	url       string
+	port      string
+	from      string
+	templates *Templates
 }
 
 var (
-	UnableToSend = errors.New("unable to send email")
-	EmailSent    = "email sent successfully"
+	UnableToSend  = errors.New("unable to send email")
+	NotConfigured = errors.New("smtp host is not configured")
+	EmailSent     = "email sent successfully"
 )
 
-func New(username string, password string, url string, port string) *Service {
+// InviteData is the data of the invite template
+type InviteData struct {
+	Code      string
+	URL       string
+	ExpiresAt string
+}
+
+// PasswordResetData is the data of the password reset template
+type PasswordResetData struct {
+	Code string
+	URL  string
+}
+
+// VerificationData is the data of the verification template
+type VerificationData struct {
+	Code string
+	URL  string
+}
+
+// EmailChangeData is the data of the email change template, sent to the
+// new address
+type EmailChangeData struct {
+	Code      string
+	URL       string
+	ExpiresAt string
+}
+
+// OwnershipTransferData is the data of the ownership transfer template,
+// sent to the member the organization is handed to
+type OwnershipTransferData struct {
+	From      string
+	URL       string
+	ExpiresAt string
+}
+
+// NewDeviceData is the data of the new device alert template
+type NewDeviceData struct {
+	Device string
+	IP     string
+	Time   string
+}
+
+func New(username string, password string, url string, port string, from string) *Service {
+	templates, err := LoadTemplates()
+	if err != nil {
+		// templates are embedded in the binary, failing to parse them is a programming error
+		log.Fatalln("unable to load email templates:", err)
+	}
 	return &Service{
-		username: username,
-		password: password,
-		url:      url,
-		port:     port,
+		username:  username,
+		password:  password,
+		url:       url,
+		port:      port,
+		from:      from,
+		templates: templates,
 	}
 }
 
-func (s *Service) SendEmail(to string, subject string, body string) (string, error) {
-	auth := smtp.PlainAuth("", s.username, s.password, s.url)
-	addr := s.url + ":" + s.port
+// Render builds a multipart message from a named template
+func (s *Service) Render(to string, name string, locale string, branding Branding, data interface{}) (Message, error) {
+	recipient, err := ParseAddress(to)
+	if err != nil {
+		return Message{}, err
+	}
 
-	msg := []byte("To: " + to + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"\r\n" +
-		body + "\r\n")
+	subject, text, html, err := s.templates.Render(name, locale, branding, data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		From:    mail.Address{Name: branding.withDefaults().SenderName, Address: s.from},
+		To:      []mail.Address{recipient},
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	}, nil
+}
 
-	err := smtp.SendMail(addr, auth, s.username, []string{to}, msg)
+// Send delivers a message over smtp
+func (s *Service) Send(msg Message) (string, error) {
+	raw, err := msg.Bytes()
 	if err != nil {
+		log.Println(err)
+		return "", UnableToSend
+	}
+
+	err = s.Deliver(msg.From.Address, msg.Recipients(), raw)
+	if err != nil {
+		log.Println(err)
 		return "", UnableToSend
 	}
 
 	return EmailSent, nil
 }
+
+// Deliver hands an already serialized message to the smtp server
+func (s *Service) Deliver(from string, to []string, raw []byte) error {
+	auth := smtp.PlainAuth("", s.username, s.password, s.url)
+	addr := s.url + ":" + s.port
+
+	return smtp.SendMail(addr, auth, from, to, raw)
+}
+
+// Ping opens a session with the smtp server without sending anything
+func (s *Service) Ping(ctx context.Context) error {
+	if s.url == "" {
+		return NotConfigured
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.url, s.port))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.url)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Noop(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
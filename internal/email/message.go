@@ -0,0 +1,153 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var (
+	InvalidAddress     = errors.New("invalid email address")
+	InvalidHeaderValue = errors.New("invalid email header value")
+	EmptyMessage       = errors.New("email message has no body")
+)
+
+// Message is a rendered email ready to be serialized as RFC 5322 / MIME.
+type Message struct {
+	From    mail.Address
+	To      []mail.Address
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Recipients returns the bare envelope addresses of the message.
+func (m Message) Recipients() []string {
+	recipients := make([]string, len(m.To))
+	for i, to := range m.To {
+		recipients[i] = to.Address
+	}
+	return recipients
+}
+
+// ParseAddress parses a single address and rejects anything that could
+// smuggle extra headers into the message.
+func ParseAddress(address string) (mail.Address, error) {
+	if containsLineBreak(address) {
+		return mail.Address{}, InvalidAddress
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return mail.Address{}, InvalidAddress
+	}
+	return *parsed, nil
+}
+
+// Bytes serializes the message as multipart/alternative with a text and an
+// html part. Every header value is checked for line breaks and non-ascii
+// values are encoded with RFC 2047.
+func (m Message) Bytes() ([]byte, error) {
+	if m.Text == "" && m.HTML == "" {
+		return nil, EmptyMessage
+	}
+	if len(m.To) == 0 {
+		return nil, InvalidAddress
+	}
+
+	for _, address := range append([]mail.Address{m.From}, m.To...) {
+		if containsLineBreak(address.Name) || containsLineBreak(address.Address) {
+			return nil, InvalidHeaderValue
+		}
+	}
+	if containsLineBreak(m.Subject) {
+		return nil, InvalidHeaderValue
+	}
+
+	to := make([]string, len(m.To))
+	for i, address := range m.To {
+		to[i] = address.String()
+	}
+
+	messageID, err := newMessageID(m.From.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	headers := []struct {
+		key   string
+		value string
+	}{
+		{"From", m.From.String()},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": writer.Boundary()})},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header.key, header.value)
+	}
+	buf.WriteString("\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+	for _, part := range parts {
+		if part.body == "" {
+			continue
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(partWriter)
+		if _, err := encoder.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func containsLineBreak(value string) bool {
+	return strings.ContainsAny(value, "\r\n")
+}
+
+func newMessageID(from string) (string, error) {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at != -1 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), domain), nil
+}
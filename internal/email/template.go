@@ -0,0 +1,162 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+)
+
+const (
+	InviteTemplate            = "invite"
+	PasswordResetTemplate     = "password_reset"
+	VerificationTemplate      = "verification"
+	NewDeviceTemplate         = "new_device"
+	EmailChangeTemplate       = "email_change"
+	OwnershipTransferTemplate = "ownership_transfer"
+
+	DefaultLocale     = "en"
+	DefaultSenderName = "microauth"
+	DefaultColor      = "#4f46e5"
+)
+
+var (
+	TemplateNotFound = errors.New("email template not found")
+	RenderFailed     = errors.New("unable to render email template")
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Branding is the per organization look of outgoing emails.
+type Branding struct {
+	Name         string
+	SenderName   string
+	LogoURL      string
+	PrimaryColor string
+}
+
+// withDefaults fills the blanks of a branding so templates never have to
+// check for empty values.
+func (b Branding) withDefaults() Branding {
+	if b.SenderName == "" {
+		b.SenderName = b.Name
+	}
+	if b.SenderName == "" {
+		b.SenderName = DefaultSenderName
+	}
+	if !colorPattern.MatchString(b.PrimaryColor) {
+		b.PrimaryColor = DefaultColor
+	}
+	return b
+}
+
+type templateData struct {
+	Branding Branding
+	Data     interface{}
+}
+
+type localizedTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Templates holds every named template in every locale. Each
+// templates/<locale>/<name>.tmpl file defines a "subject", a "text" and a
+// "html" block, the html block is rendered inside templates/layout.html.tmpl.
+type Templates struct {
+	templates map[string]localizedTemplate
+}
+
+func LoadTemplates() (*Templates, error) {
+	layout, err := fs.ReadFile(templateFS, "templates/layout.html.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Glob(templateFS, "templates/*/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]localizedTemplate)
+	for _, file := range files {
+		content, err := fs.ReadFile(templateFS, file)
+		if err != nil {
+			return nil, err
+		}
+
+		locale := path.Base(path.Dir(file))
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+
+		text, err := texttemplate.New(name).Parse(string(content))
+		if err != nil {
+			return nil, err
+		}
+		html, err := htmltemplate.New("layout").Parse(string(layout))
+		if err != nil {
+			return nil, err
+		}
+		html, err = html.Parse(string(content))
+		if err != nil {
+			return nil, err
+		}
+
+		templates[templateKey(locale, name)] = localizedTemplate{text: text, html: html}
+	}
+
+	return &Templates{templates: templates}, nil
+}
+
+// lookup finds the template for the locale, falling back from "fr-CA" to
+// "fr" and finally to the default locale.
+func (t *Templates) lookup(name string, locale string) (localizedTemplate, error) {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	candidates := []string{locale}
+	if i := strings.Index(locale, "-"); i != -1 {
+		candidates = append(candidates, locale[:i])
+	}
+	candidates = append(candidates, DefaultLocale)
+
+	for _, candidate := range candidates {
+		if tmpl, ok := t.templates[templateKey(candidate, name)]; ok {
+			return tmpl, nil
+		}
+	}
+	return localizedTemplate{}, TemplateNotFound
+}
+
+// Render executes the named template and returns the subject, the text
+// body and the html body.
+func (t *Templates) Render(name string, locale string, branding Branding, data interface{}) (string, string, string, error) {
+	tmpl, err := t.lookup(name, locale)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	input := templateData{Branding: branding.withDefaults(), Data: data}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", input); err != nil {
+		return "", "", "", RenderFailed
+	}
+	if err := tmpl.text.ExecuteTemplate(&text, "text", input); err != nil {
+		return "", "", "", RenderFailed
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "layout", input); err != nil {
+		return "", "", "", RenderFailed
+	}
+
+	return strings.TrimSpace(subject.String()), strings.TrimSpace(text.String()), html.String(), nil
+}
+
+func templateKey(locale string, name string) string {
+	return locale + "/" + name
+}
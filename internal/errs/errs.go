@@ -0,0 +1,95 @@
+// Package errs is the error model shared by the services. Each service
+// declares its sentinel errors with New, the kind decides how a transport
+// reports the error and the code is a stable identifier clients can match
+// on.
+package errs
+
+import "errors"
+
+type Kind int
+
+const (
+	// Internal errors are failures of the service itself, their details
+	// are not shown to clients
+	Internal Kind = iota
+	Invalid
+	Unauthenticated
+	Forbidden
+	NotFound
+	Conflict
+	Gone
+	Unprocessable
+	// Throttled errors ask the client to slow down and retry later
+	Throttled
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Invalid:
+		return "invalid"
+	case Unauthenticated:
+		return "unauthenticated"
+	case Forbidden:
+		return "forbidden"
+	case NotFound:
+		return "not_found"
+	case Conflict:
+		return "conflict"
+	case Gone:
+		return "gone"
+	case Unprocessable:
+		return "unprocessable"
+	case Throttled:
+		return "throttled"
+	}
+	return "internal"
+}
+
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+}
+
+func New(kind Kind, code string, message string) *Error {
+	return &Error{
+		Kind:    kind,
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Detailed copies the sentinel with a message naming what is wrong, it
+// still matches the sentinel with errors.Is
+func Detailed(sentinel *Error, detail string) error {
+	return New(sentinel.Kind, sentinel.Code, sentinel.Message+": "+detail)
+}
+
+// Is matches errors with the same code so a copy of a sentinel, such as
+// one decoded from another service, still compares equal
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// As returns the domain error in the chain of err, errors that are not
+// domain errors are internal ones
+func As(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// KindOf is the kind of the domain error in the chain of err
+func KindOf(err error) Kind {
+	if e, ok := As(err); ok {
+		return e.Kind
+	}
+	return Internal
+}
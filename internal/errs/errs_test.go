@@ -0,0 +1,81 @@
+package errs
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDetailed(t *testing.T) {
+	sentinel := New(Conflict, "last_admin", "the last admin can't leave")
+	err := Detailed(sentinel, "Acme")
+	if !errors.Is(err, sentinel) {
+		t.Errorf("Detailed doesn't match its sentinel")
+	}
+	if err.Error() != "the last admin can't leave: Acme" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	if sentinel.Message != "the last admin can't leave" {
+		t.Errorf("Detailed changed the sentinel to %q", sentinel.Message)
+	}
+}
+
+// TestCodesUnique reads the sources for the sentinels, Is matches errors
+// by code so two sentinels sharing one would match each other
+func TestCodesUnique(t *testing.T) {
+	seen := map[string]string{}
+	root := filepath.Join("..", "..")
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && path != root && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(file, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok || len(call.Args) != 3 {
+				return true
+			}
+			selector, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || selector.Sel.Name != "New" {
+				return true
+			}
+			if pkg, ok := selector.X.(*ast.Ident); !ok || pkg.Name != "errs" {
+				return true
+			}
+			literal, ok := call.Args[1].(*ast.BasicLit)
+			if !ok {
+				return true
+			}
+			code, _ := strconv.Unquote(literal.Value)
+			position := fset.Position(call.Pos()).String()
+			if previous, ok := seen[code]; ok {
+				t.Errorf("code %s of %s is already used at %s", code, position, previous)
+			}
+			seen[code] = position
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) == 0 {
+		t.Fatal("found no sentinel")
+	}
+}
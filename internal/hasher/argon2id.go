@@ -0,0 +1,81 @@
+package hasher
+
+import (
+	"crypto/subtle"
+	"strconv"
+
+	"golang.org/x/crypto/argon2"
+)
+
+type Argon2idParams struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+type argon2idAlgorithm struct {
+	params Argon2idParams
+}
+
+func (a argon2idAlgorithm) Hash(password string) (string, error) {
+	salt, err := randomSalt()
+	if err != nil {
+		return "", err
+	}
+	p := phc{
+		id:      Argon2id,
+		version: argon2.Version,
+		params: map[string]string{
+			"m": strconv.FormatUint(uint64(a.params.Memory), 10),
+			"t": strconv.FormatUint(uint64(a.params.Iterations), 10),
+			"p": strconv.FormatUint(uint64(a.params.Parallelism), 10),
+		},
+		salt: salt,
+		hash: argon2.IDKey([]byte(password), salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, keyLength),
+	}
+	return p.format("m", "t", "p"), nil
+}
+
+func (a argon2idAlgorithm) Verify(password string, encoded string) (bool, error) {
+	p, params, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(p.hash)))
+	return subtle.ConstantTimeCompare(key, p.hash) == 1, nil
+}
+
+func (a argon2idAlgorithm) Validate(encoded string) error {
+	_, _, err := parseArgon2id(encoded)
+	return err
+}
+
+func (a argon2idAlgorithm) Current(encoded string) bool {
+	_, params, err := parseArgon2id(encoded)
+	return err == nil && params == a.params
+}
+
+func parseArgon2id(encoded string) (phc, Argon2idParams, error) {
+	p, err := parsePHC(encoded)
+	if err != nil || p.id != Argon2id || p.version != argon2.Version || !p.complete() {
+		return phc{}, Argon2idParams{}, InvalidHash
+	}
+	memory, err := p.uintParam("m", 32)
+	if err != nil {
+		return phc{}, Argon2idParams{}, err
+	}
+	iterations, err := p.uintParam("t", 32)
+	if err != nil {
+		return phc{}, Argon2idParams{}, err
+	}
+	parallelism, err := p.uintParam("p", 8)
+	if err != nil {
+		return phc{}, Argon2idParams{}, err
+	}
+	return p, Argon2idParams{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+	}, nil
+}
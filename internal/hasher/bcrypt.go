@@ -0,0 +1,46 @@
+package hasher
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type BcryptParams struct {
+	Cost int
+}
+
+// bcryptAlgorithm keeps the modular crypt format, it is what every bcrypt
+// implementation reads
+type bcryptAlgorithm struct {
+	params BcryptParams
+}
+
+func (b bcryptAlgorithm) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.params.Cost)
+	return string(hash), err
+}
+
+func (b bcryptAlgorithm) Verify(password string, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, InvalidHash
+	}
+	return true, nil
+}
+
+func (b bcryptAlgorithm) Validate(encoded string) error {
+	_, err := bcrypt.Cost([]byte(encoded))
+	if err != nil || len(encoded) != 60 {
+		return InvalidHash
+	}
+	return nil
+}
+
+func (b bcryptAlgorithm) Current(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err == nil && cost == b.params.Cost
+}
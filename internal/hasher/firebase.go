@@ -0,0 +1,84 @@
+package hasher
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+	"microauth.io/core/internal/errs"
+)
+
+var (
+	FirebaseNotConfigured = errs.New(errs.Internal, "firebase_hash_not_configured", "the firebase hash parameters are not configured")
+)
+
+// FirebaseParams are the hash parameters of a Firebase project, the
+// console shows them base64 encoded. Rounds and MemCost are stored in every
+// imported hash, only the importer reads them.
+type FirebaseParams struct {
+	SignerKey     string
+	SaltSeparator string
+	Rounds        int
+	MemCost       int
+}
+
+// Validate checks the encoding of the secrets, empty ones are valid
+func (f FirebaseParams) Validate() error {
+	if _, err := base64.StdEncoding.DecodeString(f.SignerKey); err != nil {
+		return fmt.Errorf("%w: the firebase signer key must be base64", InvalidOptions)
+	}
+	if _, err := base64.StdEncoding.DecodeString(f.SaltSeparator); err != nil {
+		return fmt.Errorf("%w: the firebase salt separator must be base64", InvalidOptions)
+	}
+	return nil
+}
+
+// firebaseVerifier checks the modified scrypt of Firebase: the scrypt key of
+// the password encrypts the signer key of the project with AES-256-CTR
+//
+//	$firebase-scrypt$ln=<mem_cost>,r=<rounds>$<salt>$<hash>
+type firebaseVerifier struct {
+	params FirebaseParams
+}
+
+func (v firebaseVerifier) Verify(password string, encoded string) (bool, error) {
+	p, params, err := parseFirebase(encoded)
+	if err != nil {
+		return false, err
+	}
+	signerKey, _ := base64.StdEncoding.DecodeString(v.params.SignerKey)
+	separator, _ := base64.StdEncoding.DecodeString(v.params.SaltSeparator)
+	if len(signerKey) == 0 {
+		return false, FirebaseNotConfigured
+	}
+
+	salt := append(append([]byte{}, p.salt...), separator...)
+	key, err := scrypt.Key([]byte(password), salt, 1<<params.LogN, params.R, params.P, keyLength)
+	if err != nil {
+		return false, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return false, err
+	}
+	derived := make([]byte, len(signerKey))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(derived, signerKey)
+	return subtle.ConstantTimeCompare(derived, p.hash) == 1, nil
+}
+
+func (v firebaseVerifier) Validate(encoded string) error {
+	_, _, err := parseFirebase(encoded)
+	return err
+}
+
+func parseFirebase(encoded string) (phc, ScryptParams, error) {
+	p, err := parsePHC(encoded)
+	if err != nil || p.id != FirebaseScrypt || !p.complete() {
+		return phc{}, ScryptParams{}, InvalidHash
+	}
+	params, err := scryptParams(p)
+	return p, params, err
+}
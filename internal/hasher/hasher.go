@@ -0,0 +1,209 @@
+// Package hasher stores passwords. New hashes use the configured algorithm,
+// argon2id, scrypt or bcrypt, and are written as PHC strings:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//
+// bcrypt keeps its own $2a$ format. Verification accepts every registered
+// format, including the ones imported from other systems, and reports when
+// a hash should be replaced by one of the configured algorithm.
+package hasher
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	"microauth.io/core/internal/errs"
+)
+
+const (
+	Argon2id       = "argon2id"
+	Scrypt         = "scrypt"
+	Bcrypt         = "bcrypt"
+	PBKDF2SHA1     = "pbkdf2-sha1"
+	PBKDF2SHA256   = "pbkdf2-sha256"
+	PBKDF2SHA512   = "pbkdf2-sha512"
+	FirebaseScrypt = "firebase-scrypt"
+
+	saltLength = 16
+	keyLength  = 32
+)
+
+var (
+	UnknownFormat  = errs.New(errs.Invalid, "unknown_hash_format", "unsupported password hash format")
+	InvalidHash    = errs.New(errs.Invalid, "invalid_password_hash", "malformed password hash")
+	InvalidOptions = errors.New("invalid hasher options")
+)
+
+// Verifier checks passwords against the hashes of one format
+type Verifier interface {
+	// Verify reports whether password matches encoded
+	Verify(password string, encoded string) (bool, error)
+	// Validate reports whether encoded is a well formed hash of the format
+	// without deriving anything, importers use it
+	Validate(encoded string) error
+}
+
+// Algorithm is a Verifier that also derives new hashes
+type Algorithm interface {
+	Verifier
+	// Hash derives a hash of password with a random salt
+	Hash(password string) (string, error)
+	// Current reports whether encoded was derived with the parameters the
+	// algorithm is configured with
+	Current(encoded string) bool
+}
+
+type Options struct {
+	// Algorithm hashes new passwords, argon2id, scrypt or bcrypt
+	Algorithm string
+	Argon2id  Argon2idParams
+	Scrypt    ScryptParams
+	Bcrypt    BcryptParams
+	// Firebase verifies the hashes imported from Firebase, it is only
+	// needed when there are some
+	Firebase FirebaseParams
+}
+
+// DefaultOptions follow the OWASP recommendations
+func DefaultOptions() Options {
+	return Options{
+		Algorithm: Argon2id,
+		Argon2id: Argon2idParams{
+			Memory:      19 * 1024,
+			Iterations:  2,
+			Parallelism: 1,
+		},
+		Scrypt: ScryptParams{
+			LogN: 17,
+			R:    8,
+			P:    1,
+		},
+		Bcrypt: BcryptParams{
+			Cost: 12,
+		},
+	}
+}
+
+type Hasher struct {
+	id        string
+	algorithm Algorithm
+	verifiers map[string]Verifier
+}
+
+func New(options Options) (*Hasher, error) {
+	algorithms := map[string]Algorithm{
+		Argon2id: argon2idAlgorithm{options.Argon2id},
+		Scrypt:   scryptAlgorithm{options.Scrypt},
+		Bcrypt:   bcryptAlgorithm{options.Bcrypt},
+	}
+	algorithm, ok := algorithms[options.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown algorithm %q", InvalidOptions, options.Algorithm)
+	}
+	err := options.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Hasher{
+		id:        options.Algorithm,
+		algorithm: algorithm,
+		verifiers: make(map[string]Verifier),
+	}
+	for id, algorithm := range algorithms {
+		h.Register(id, algorithm)
+	}
+	h.Register(PBKDF2SHA1, pbkdf2Verifier{PBKDF2SHA1})
+	h.Register(PBKDF2SHA256, pbkdf2Verifier{PBKDF2SHA256})
+	h.Register(PBKDF2SHA512, pbkdf2Verifier{PBKDF2SHA512})
+	h.Register(FirebaseScrypt, firebaseVerifier{options.Firebase})
+	return h, nil
+}
+
+// Default hashes with DefaultOptions
+func Default() *Hasher {
+	h, err := New(DefaultOptions())
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+func (o Options) validate() error {
+	switch {
+	case o.Argon2id.Iterations < 1 || o.Argon2id.Parallelism < 1 || o.Argon2id.Memory < 8*uint32(o.Argon2id.Parallelism):
+		return fmt.Errorf("%w: argon2id needs an iteration, a thread and 8 KiB of memory per thread", InvalidOptions)
+	case o.Scrypt.LogN < 1 || o.Scrypt.LogN > 30 || o.Scrypt.R < 1 || o.Scrypt.P < 1:
+		return fmt.Errorf("%w: scrypt needs log_n between 1 and 30 and positive r and p", InvalidOptions)
+	case o.Bcrypt.Cost < 4 || o.Bcrypt.Cost > 31:
+		return fmt.Errorf("%w: bcrypt cost must be between 4 and 31", InvalidOptions)
+	}
+	return o.Firebase.Validate()
+}
+
+// Register adds a format to verify, such as the one of a system users are
+// migrated from. The id is the PHC identifier of its hashes.
+func (h *Hasher) Register(id string, verifier Verifier) {
+	h.verifiers[id] = verifier
+}
+
+// Hash derives the hash of a new password with the configured algorithm
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.algorithm.Hash(password)
+}
+
+// Verify reports whether password matches encoded. Rehash is set on a match
+// when encoded uses another algorithm or other parameters than the
+// configured ones, the caller then stores a new Hash of password.
+func (h *Hasher) Verify(password string, encoded string) (match bool, rehash bool, err error) {
+	id, verifier, err := h.verifier(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	match, err = verifier.Verify(password, encoded)
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, id != h.id || !h.algorithm.Current(encoded), nil
+}
+
+// Validate reports whether encoded is a hash Verify can check
+func (h *Hasher) Validate(encoded string) error {
+	_, verifier, err := h.verifier(encoded)
+	if err != nil {
+		return err
+	}
+	return verifier.Validate(encoded)
+}
+
+func (h *Hasher) verifier(encoded string) (string, Verifier, error) {
+	id := identify(encoded)
+	verifier, ok := h.verifiers[id]
+	if !ok {
+		return "", nil, UnknownFormat
+	}
+	return id, verifier, nil
+}
+
+// identify returns the PHC identifier of encoded, the modular crypt
+// prefixes of bcrypt all map to bcrypt
+func identify(encoded string) string {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return Bcrypt
+		}
+	}
+	if !strings.HasPrefix(encoded, "$") {
+		return ""
+	}
+	id, _, _ := strings.Cut(encoded[1:], "$")
+	return id
+}
+
+func randomSalt() ([]byte, error) {
+	salt := make([]byte, saltLength)
+	_, err := rand.Read(salt)
+	return salt, err
+}
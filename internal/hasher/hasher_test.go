@@ -0,0 +1,177 @@
+package hasher
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fastOptions keep the tests quick, they are far below DefaultOptions
+func fastOptions(algorithm string) Options {
+	return Options{
+		Algorithm: algorithm,
+		Argon2id:  Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1},
+		Scrypt:    ScryptParams{LogN: 4, R: 8, P: 1},
+		Bcrypt:    BcryptParams{Cost: 4},
+	}
+}
+
+func newHasher(t *testing.T, options Options) *Hasher {
+	t.Helper()
+	h, err := New(options)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return h
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Options)
+		err    error
+	}{
+		{"default", func(o *Options) { *o = DefaultOptions() }, nil},
+		{"fast", func(o *Options) {}, nil},
+		{"unknown algorithm", func(o *Options) { o.Algorithm = "md5" }, InvalidOptions},
+		{"pbkdf2 only verifies", func(o *Options) { o.Algorithm = PBKDF2SHA256 }, InvalidOptions},
+		{"argon2id without iterations", func(o *Options) { o.Argon2id.Iterations = 0 }, InvalidOptions},
+		{"argon2id memory per thread", func(o *Options) { o.Argon2id.Parallelism = 16 }, InvalidOptions},
+		{"scrypt log_n", func(o *Options) { o.Scrypt.LogN = 31 }, InvalidOptions},
+		{"bcrypt cost", func(o *Options) { o.Bcrypt.Cost = 3 }, InvalidOptions},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := fastOptions(Argon2id)
+			test.change(&options)
+			if _, err := New(options); !errors.Is(err, test.err) {
+				t.Errorf("New: got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{Argon2id, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{Scrypt, "$scrypt$ln=4,r=8,p=1$"},
+		{Bcrypt, "$2a$04$"},
+	}
+	for _, test := range tests {
+		t.Run(test.algorithm, func(t *testing.T) {
+			h := newHasher(t, fastOptions(test.algorithm))
+			encoded, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !strings.HasPrefix(encoded, test.prefix) {
+				t.Errorf("Hash = %q, want the prefix %q", encoded, test.prefix)
+			}
+			if again, _ := h.Hash("correct horse"); again == encoded {
+				t.Error("Hash is the same twice, the salt is not random")
+			}
+
+			match, rehash, err := h.Verify("correct horse", encoded)
+			if !match || rehash || err != nil {
+				t.Errorf("Verify = %v, %v, %v, want a match without rehash", match, rehash, err)
+			}
+			match, rehash, err = h.Verify("battery staple", encoded)
+			if match || rehash || err != nil {
+				t.Errorf("Verify of a wrong password = %v, %v, %v, want no match", match, rehash, err)
+			}
+			if err := h.Validate(encoded); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestRehash(t *testing.T) {
+	stronger := fastOptions(Argon2id)
+	stronger.Argon2id.Iterations = 2
+	strongerBcrypt := fastOptions(Bcrypt)
+	strongerBcrypt.Bcrypt.Cost = 5
+	tests := []struct {
+		name    string
+		hashed  Options
+		current Options
+		rehash  bool
+	}{
+		{"same parameters", fastOptions(Argon2id), fastOptions(Argon2id), false},
+		{"other algorithm", fastOptions(Bcrypt), fastOptions(Argon2id), true},
+		{"other argon2id parameters", fastOptions(Argon2id), stronger, true},
+		{"other bcrypt cost", fastOptions(Bcrypt), strongerBcrypt, true},
+		{"scrypt to argon2id", fastOptions(Scrypt), fastOptions(Argon2id), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := newHasher(t, test.hashed).Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			match, rehash, err := newHasher(t, test.current).Verify("correct horse", encoded)
+			if !match || err != nil || rehash != test.rehash {
+				t.Errorf("Verify = %v, %v, %v, want a match with rehash %v", match, rehash, err, test.rehash)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	h := newHasher(t, fastOptions(Argon2id))
+	tests := []struct {
+		name     string
+		exported string
+		want     string
+		err      error
+	}{
+		{"django sha256", "pbkdf2_sha256$1000$seasalt$mQnueSakb748zqBAC1tmWVZsZbi2zPGZarEzTGdfmso=", "$pbkdf2-sha256$i=1000$c2Vhc2FsdA$mQnueSakb748zqBAC1tmWVZsZbi2zPGZarEzTGdfmso", nil},
+		{"django sha1", " pbkdf2_sha1$1000$seasalt$iQvkNOF1wEL4Khh8eogJ8rUhipM= ", "$pbkdf2-sha1$i=1000$c2Vhc2FsdA$iQvkNOF1wEL4Khh8eogJ8rUhipM", nil},
+		{"phc sha512", "$pbkdf2-sha512$i=1000$c2Vhc2FsdA$CBx2rkpvjyEAoopNVuRZWOOxTTRwoV6JjrHARZAmWoRGf7Bll/+N6ttV/4gWvtd16NJ94OpVpZHm44IdMrFULg", "$pbkdf2-sha512$i=1000$c2Vhc2FsdA$CBx2rkpvjyEAoopNVuRZWOOxTTRwoV6JjrHARZAmWoRGf7Bll/+N6ttV/4gWvtd16NJ94OpVpZHm44IdMrFULg", nil},
+		{"django without iterations", "pbkdf2_sha256$$seasalt$mQnueSakb748zqBAC1tmWVZsZbi2zPGZarEzTGdfmso=", "", InvalidHash},
+		{"django without salt", "pbkdf2_sha256$1000$$mQnueSakb748zqBAC1tmWVZsZbi2zPGZarEzTGdfmso=", "", InvalidHash},
+		{"phc without hash", "$pbkdf2-sha256$i=1000$c2Vhc2FsdA", "", InvalidHash},
+		{"md5", "$1$seasalt$hash", "", UnknownFormat},
+		{"plain text", "correct horse", "", UnknownFormat},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			imported, err := h.Import(test.exported)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Import: got %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if imported != test.want {
+				t.Errorf("Import = %q, want %q", imported, test.want)
+			}
+			match, rehash, err := h.Verify("correct horse", imported)
+			if !match || !rehash || err != nil {
+				t.Errorf("Verify = %v, %v, %v, want a match asking for a rehash", match, rehash, err)
+			}
+		})
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	h := newHasher(t, fastOptions(Argon2id))
+	tests := []struct {
+		encoded string
+		err     error
+	}{
+		{"", UnknownFormat},
+		{"$sha1$hash", UnknownFormat},
+		{"$argon2id$v=19$m=64,t=1,p=1$salt", InvalidHash},
+		{"$scrypt$ln=4$c2FsdA$aGFzaA", InvalidHash},
+	}
+	for _, test := range tests {
+		match, _, err := h.Verify("correct horse", test.encoded)
+		if match || !errors.Is(err, test.err) {
+			t.Errorf("Verify(%q) = %v, %v, want %v", test.encoded, match, err, test.err)
+		}
+	}
+}
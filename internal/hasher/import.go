@@ -0,0 +1,100 @@
+package hasher
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// djangoAlgorithms maps the Django PBKDF2 hashers to PHC identifiers
+var djangoAlgorithms = map[string]string{
+	"pbkdf2_sha1":   PBKDF2SHA1,
+	"pbkdf2_sha256": PBKDF2SHA256,
+}
+
+// Import converts a hash exported by another system to the format stored
+// for it. PHC strings and bcrypt hashes are kept as they are, Django PBKDF2
+// hashes are converted with FromDjango.
+func (h *Hasher) Import(encoded string) (string, error) {
+	encoded = strings.TrimSpace(encoded)
+	if algorithm, _, _ := strings.Cut(encoded, "$"); djangoAlgorithms[algorithm] != "" {
+		var err error
+		encoded, err = FromDjango(encoded)
+		if err != nil {
+			return "", err
+		}
+	}
+	err := h.Validate(encoded)
+	if err != nil {
+		return "", err
+	}
+	return encoded, nil
+}
+
+// FromDjango converts a hash of the Django PBKDF2 hashers,
+// pbkdf2_sha256$<iterations>$<salt>$<base64 hash>. Django uses the salt as
+// text, its bytes become the PHC salt.
+func FromDjango(encoded string) (string, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 4 {
+		return "", InvalidHash
+	}
+	id, ok := djangoAlgorithms[fields[0]]
+	if !ok {
+		return "", UnknownFormat
+	}
+	iterations, err := strconv.Atoi(fields[1])
+	if err != nil || iterations < 1 {
+		return "", InvalidHash
+	}
+	hash, err := base64.StdEncoding.DecodeString(fields[3])
+	if err != nil || fields[2] == "" || len(hash) == 0 {
+		return "", InvalidHash
+	}
+
+	p := phc{
+		id:     id,
+		params: map[string]string{"i": fields[1]},
+		salt:   []byte(fields[2]),
+		hash:   hash,
+	}
+	return p.format("i"), nil
+}
+
+// FromFirebase converts the base64 passwordHash and salt of a user of a
+// Firebase auth export. Rounds and MemCost of the project are stored in the
+// hash, the signer key and the salt separator stay in the configuration.
+func FromFirebase(passwordHash string, salt string, params FirebaseParams) (string, error) {
+	hash, err := decodeBase64(passwordHash)
+	if err != nil || len(hash) == 0 {
+		return "", InvalidHash
+	}
+	saltBytes, err := decodeBase64(salt)
+	if err != nil || len(saltBytes) == 0 {
+		return "", InvalidHash
+	}
+	if params.Rounds < 1 || params.MemCost < 1 || params.MemCost > 30 {
+		return "", InvalidOptions
+	}
+
+	p := phc{
+		id: FirebaseScrypt,
+		params: map[string]string{
+			"ln": strconv.Itoa(params.MemCost),
+			"r":  strconv.Itoa(params.Rounds),
+		},
+		salt: saltBytes,
+		hash: hash,
+	}
+	return p.format("ln", "r"), nil
+}
+
+// decodeBase64 accepts the standard and the url alphabet, exports use
+// either
+func decodeBase64(value string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return base64.URLEncoding.DecodeString(value)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,52 @@
+package hasher
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// pbkdf2Verifier checks imported PBKDF2 hashes, new passwords are never
+// hashed with it
+//
+//	$pbkdf2-sha256$i=<iterations>$<salt>$<hash>
+type pbkdf2Verifier struct {
+	id string
+}
+
+func (v pbkdf2Verifier) digest() func() hash.Hash {
+	switch v.id {
+	case PBKDF2SHA1:
+		return sha1.New
+	case PBKDF2SHA512:
+		return sha512.New
+	}
+	return sha256.New
+}
+
+func (v pbkdf2Verifier) Verify(password string, encoded string) (bool, error) {
+	p, iterations, err := v.parse(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := pbkdf2.Key([]byte(password), p.salt, iterations, len(p.hash), v.digest())
+	return subtle.ConstantTimeCompare(key, p.hash) == 1, nil
+}
+
+func (v pbkdf2Verifier) Validate(encoded string) error {
+	_, _, err := v.parse(encoded)
+	return err
+}
+
+func (v pbkdf2Verifier) parse(encoded string) (phc, int, error) {
+	p, err := parsePHC(encoded)
+	if err != nil || p.id != v.id || !p.complete() {
+		return phc{}, 0, InvalidHash
+	}
+	iterations, err := p.intParam("i")
+	return p, iterations, err
+}
@@ -0,0 +1,117 @@
+package hasher
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// phc is a hash in the PHC string format
+//
+//	$<id>[$v=<version>][$<param>=<value>(,<param>=<value>)*][$<salt>[$<hash>]]
+//
+// salt and hash are base64 without padding
+type phc struct {
+	id      string
+	version int
+	params  map[string]string
+	salt    []byte
+	hash    []byte
+}
+
+var phcEncoding = base64.RawStdEncoding
+
+func parsePHC(encoded string) (phc, error) {
+	if !strings.HasPrefix(encoded, "$") {
+		return phc{}, InvalidHash
+	}
+	fields := strings.Split(encoded[1:], "$")
+	p := phc{
+		id:     fields[0],
+		params: make(map[string]string),
+	}
+	fields = fields[1:]
+
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "v=") {
+		version, err := strconv.Atoi(fields[0][2:])
+		if err != nil {
+			return phc{}, InvalidHash
+		}
+		p.version = version
+		fields = fields[1:]
+	}
+	// base64 without padding never holds a =, only parameters do
+	if len(fields) > 0 && strings.Contains(fields[0], "=") {
+		for _, pair := range strings.Split(fields[0], ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok || name == "" {
+				return phc{}, InvalidHash
+			}
+			p.params[name] = value
+		}
+		fields = fields[1:]
+	}
+
+	var err error
+	switch len(fields) {
+	case 2:
+		p.hash, err = phcEncoding.DecodeString(fields[1])
+		if err != nil {
+			return phc{}, InvalidHash
+		}
+		fallthrough
+	case 1:
+		p.salt, err = phcEncoding.DecodeString(fields[0])
+		if err != nil {
+			return phc{}, InvalidHash
+		}
+	case 0:
+	default:
+		return phc{}, InvalidHash
+	}
+	return p, nil
+}
+
+// format writes p with the parameters in the given order, the PHC format
+// fixes the order per algorithm
+func (p phc) format(order ...string) string {
+	var b strings.Builder
+	b.WriteString("$" + p.id)
+	if p.version != 0 {
+		b.WriteString("$v=" + strconv.Itoa(p.version))
+	}
+	for i, name := range order {
+		if i == 0 {
+			b.WriteString("$")
+		} else {
+			b.WriteString(",")
+		}
+		b.WriteString(name + "=" + p.params[name])
+	}
+	b.WriteString("$" + phcEncoding.EncodeToString(p.salt))
+	b.WriteString("$" + phcEncoding.EncodeToString(p.hash))
+	return b.String()
+}
+
+// intParam reads a positive integer parameter
+func (p phc) intParam(name string) (int, error) {
+	n, err := strconv.Atoi(p.params[name])
+	if err != nil || n < 1 {
+		return 0, InvalidHash
+	}
+	return n, nil
+}
+
+// complete reports whether p has the salt and hash every format needs
+func (p phc) complete() bool {
+	return len(p.salt) > 0 && len(p.hash) > 0
+}
+
+// uintParam reads a positive integer parameter of at most bitSize bits
+func (p phc) uintParam(name string, bitSize int) (uint64, error) {
+	n, err := strconv.ParseUint(p.params[name], 10, bitSize)
+	if err != nil || n == 0 {
+		return 0, InvalidHash
+	}
+	return n, nil
+}
@@ -0,0 +1,92 @@
+package hasher
+
+import (
+	"crypto/subtle"
+	"strconv"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+type ScryptParams struct {
+	// LogN is the base 2 logarithm of the cost parameter N
+	LogN int
+	R    int
+	P    int
+}
+
+type scryptAlgorithm struct {
+	params ScryptParams
+}
+
+func (s scryptAlgorithm) Hash(password string) (string, error) {
+	salt, err := randomSalt()
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<s.params.LogN, s.params.R, s.params.P, keyLength)
+	if err != nil {
+		return "", err
+	}
+	p := phc{
+		id: Scrypt,
+		params: map[string]string{
+			"ln": strconv.Itoa(s.params.LogN),
+			"r":  strconv.Itoa(s.params.R),
+			"p":  strconv.Itoa(s.params.P),
+		},
+		salt: salt,
+		hash: key,
+	}
+	return p.format("ln", "r", "p"), nil
+}
+
+func (s scryptAlgorithm) Verify(password string, encoded string) (bool, error) {
+	p, params, err := parseScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := scrypt.Key([]byte(password), p.salt, 1<<params.LogN, params.R, params.P, len(p.hash))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, p.hash) == 1, nil
+}
+
+func (s scryptAlgorithm) Validate(encoded string) error {
+	_, _, err := parseScrypt(encoded)
+	return err
+}
+
+func (s scryptAlgorithm) Current(encoded string) bool {
+	_, params, err := parseScrypt(encoded)
+	return err == nil && params == s.params
+}
+
+func parseScrypt(encoded string) (phc, ScryptParams, error) {
+	p, err := parsePHC(encoded)
+	if err != nil || p.id != Scrypt || !p.complete() {
+		return phc{}, ScryptParams{}, InvalidHash
+	}
+	params, err := scryptParams(p)
+	return p, params, err
+}
+
+// scryptParams reads the ln, r and p parameters, p defaults to 1
+func scryptParams(p phc) (ScryptParams, error) {
+	logN, err := p.intParam("ln")
+	if err != nil || logN > 30 {
+		return ScryptParams{}, InvalidHash
+	}
+	r, err := p.intParam("r")
+	if err != nil {
+		return ScryptParams{}, err
+	}
+	parallelism := 1
+	if _, ok := p.params["p"]; ok {
+		parallelism, err = p.intParam("p")
+		if err != nil {
+			return ScryptParams{}, err
+		}
+	}
+	return ScryptParams{LogN: logN, R: r, P: parallelism}, nil
+}
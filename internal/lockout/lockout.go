@@ -0,0 +1,231 @@
+// Package lockout slows down password guessing. Failed logins are counted
+// per account and per client address: past a few free attempts each new
+// attempt has to wait twice as long as the previous one, past the maximum
+// the account or the address is locked until the failures expire.
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"microauth.io/core/internal/errs"
+)
+
+var (
+	LoginThrottled = errs.New(errs.Throttled, "login_throttled", "too many failed logins, wait before trying again")
+	AccountLocked  = errs.New(errs.Throttled, "account_locked", "too many failed logins, the account is temporarily locked")
+	AddressLocked  = errs.New(errs.Throttled, "address_locked", "too many failed logins from this address, try again later")
+	CheckFailed    = errs.New(errs.Internal, "lockout_check_failed", "unable to check failed logins")
+	NotLocked      = errs.New(errs.NotFound, "not_locked", "no failed logins recorded")
+	UnlockFailed   = errs.New(errs.Internal, "unlock_failed", "unable to unlock")
+	Unlocked       = "unlocked"
+)
+
+// Attempts are the recent failed logins of one subject, an account or an
+// address
+type Attempts struct {
+	Subject     string
+	Failures    int
+	LastFailure int
+}
+
+type AttemptStore interface {
+	GetLoginAttempts(context.Context, string) (Attempts, error)
+	// RecordLoginFailure counts a failure at the given time, the count
+	// restarts when the previous failure is older than the last argument
+	RecordLoginFailure(context.Context, string, int, int) (Attempts, error)
+	DeleteLoginAttempts(context.Context, string) error
+	// PurgeLoginAttempts deletes the subjects whose last failure is older
+	// than the given time
+	PurgeLoginAttempts(context.Context, int) (int, error)
+}
+
+// Limits are the thresholds of one kind of subject
+type Limits struct {
+	// FreeAttempts fail without any delay
+	FreeAttempts int
+	// MaxFailures locks the subject until its failures expire
+	MaxFailures int
+}
+
+type Options struct {
+	Account Limits
+	Address Limits
+	// BaseDelay is the wait after the first failure past the free ones, it
+	// doubles with each further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Duration is how long failures are remembered, and so how long a lock
+	// lasts
+	Duration      time.Duration
+	PurgeInterval time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Account:       Limits{FreeAttempts: 3, MaxFailures: 10},
+		Address:       Limits{FreeAttempts: 20, MaxFailures: 100},
+		BaseDelay:     time.Second,
+		MaxDelay:      time.Minute,
+		Duration:      15 * time.Minute,
+		PurgeInterval: time.Hour,
+	}
+}
+
+// Blocked is returned while a subject has to wait, the client may retry
+// after RetryAfter
+type Blocked struct {
+	Err  *errs.Error
+	Wait time.Duration
+}
+
+func (b *Blocked) Error() string {
+	return b.Err.Message
+}
+
+func (b *Blocked) Unwrap() error {
+	return b.Err
+}
+
+func (b *Blocked) RetryAfter() time.Duration {
+	return b.Wait
+}
+
+type Service struct {
+	store   AttemptStore
+	options Options
+}
+
+func New(store AttemptStore, options Options) *Service {
+	return &Service{
+		store:   store,
+		options: options,
+	}
+}
+
+// accountSubject keys failures by email so unknown accounts are counted
+// like known ones
+func accountSubject(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func addressSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a Blocked error while the account or the address has to
+// wait before the next attempt
+func (s *Service) Check(ctx context.Context, email string, ip string) error {
+	err := s.check(ctx, addressSubject(ip), s.options.Address, AddressLocked)
+	if err != nil {
+		return err
+	}
+	return s.check(ctx, accountSubject(email), s.options.Account, AccountLocked)
+}
+
+func (s *Service) check(ctx context.Context, subject string, limits Limits, locked *errs.Error) error {
+	attempts, err := s.store.GetLoginAttempts(ctx, subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		log.Println(err)
+		return CheckFailed
+	}
+
+	now := time.Now()
+	last := time.Unix(int64(attempts.LastFailure), 0)
+	if now.Sub(last) >= s.options.Duration {
+		return nil
+	}
+	if attempts.Failures >= limits.MaxFailures {
+		return &Blocked{Err: locked, Wait: last.Add(s.options.Duration).Sub(now)}
+	}
+	if wait := last.Add(s.delay(attempts.Failures, limits)).Sub(now); wait > 0 {
+		return &Blocked{Err: LoginThrottled, Wait: wait}
+	}
+	return nil
+}
+
+// delay is the wait after the given number of failures
+func (s *Service) delay(failures int, limits Limits) time.Duration {
+	if failures < limits.FreeAttempts {
+		return 0
+	}
+	exponent := float64(failures - limits.FreeAttempts)
+	delay := float64(s.options.BaseDelay) * math.Pow(2, exponent)
+	if delay > float64(s.options.MaxDelay) {
+		return s.options.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+// Failed counts a failed login against the account and the address
+func (s *Service) Failed(ctx context.Context, email string, ip string) error {
+	now := time.Now()
+	since := int(now.Add(-s.options.Duration).Unix())
+	for _, subject := range []string{accountSubject(email), addressSubject(ip)} {
+		_, err := s.store.RecordLoginFailure(ctx, subject, int(now.Unix()), since)
+		if err != nil {
+			log.Println(err)
+			return CheckFailed
+		}
+	}
+	return nil
+}
+
+// Succeeded forgets the failures of the account. Those of the address are
+// kept, a valid login must not clear the guesses made against other
+// accounts.
+func (s *Service) Succeeded(ctx context.Context, email string, ip string) error {
+	err := s.store.DeleteLoginAttempts(ctx, accountSubject(email))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		return CheckFailed
+	}
+	return nil
+}
+
+func (s *Service) UnlockAccount(ctx context.Context, email string) (string, error) {
+	return s.unlock(ctx, accountSubject(email))
+}
+
+func (s *Service) UnlockAddress(ctx context.Context, ip string) (string, error) {
+	return s.unlock(ctx, addressSubject(ip))
+}
+
+func (s *Service) unlock(ctx context.Context, subject string) (string, error) {
+	err := s.store.DeleteLoginAttempts(ctx, subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", NotLocked
+	}
+	if err != nil {
+		log.Println(err)
+		return "", UnlockFailed
+	}
+	return Unlocked, nil
+}
+
+// Run deletes expired failures every PurgeInterval until the context is
+// cancelled
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.options.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := int(time.Now().Add(-s.options.Duration).Unix())
+			_, err := s.store.PurgeLoginAttempts(ctx, before)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// attemptStore keeps the attempts in a map, like the memory store
+type attemptStore map[string]Attempts
+
+func (s attemptStore) GetLoginAttempts(ctx context.Context, subject string) (Attempts, error) {
+	attempts, ok := s[subject]
+	if !ok {
+		return Attempts{}, sql.ErrNoRows
+	}
+	return attempts, nil
+}
+
+func (s attemptStore) RecordLoginFailure(ctx context.Context, subject string, now int, since int) (Attempts, error) {
+	attempts := s[subject]
+	if attempts.LastFailure < since {
+		attempts.Failures = 0
+	}
+	attempts.Subject = subject
+	attempts.Failures++
+	attempts.LastFailure = now
+	s[subject] = attempts
+	return attempts, nil
+}
+
+func (s attemptStore) DeleteLoginAttempts(ctx context.Context, subject string) error {
+	if _, ok := s[subject]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s, subject)
+	return nil
+}
+
+func (s attemptStore) PurgeLoginAttempts(ctx context.Context, before int) (int, error) {
+	purged := 0
+	for subject, attempts := range s {
+		if attempts.LastFailure < before {
+			delete(s, subject)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func TestDelay(t *testing.T) {
+	service := New(attemptStore{}, DefaultOptions())
+	limits := Limits{FreeAttempts: 3, MaxFailures: 10}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{9, time.Minute},
+		{40, time.Minute},
+	}
+	for _, test := range tests {
+		if got := service.delay(test.failures, limits); got != test.want {
+			t.Errorf("delay(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	now := int(time.Now().Unix())
+	tests := []struct {
+		name     string
+		attempts []Attempts
+		err      error
+	}{
+		{"no failures", nil, nil},
+		{"free attempts", []Attempts{{Subject: "account:a@x.io", Failures: 2, LastFailure: now}}, nil},
+		{"throttled", []Attempts{{Subject: "account:a@x.io", Failures: 5, LastFailure: now}}, LoginThrottled},
+		{"throttle over", []Attempts{{Subject: "account:a@x.io", Failures: 3, LastFailure: now - 2}}, nil},
+		{"account locked", []Attempts{{Subject: "account:a@x.io", Failures: 10, LastFailure: now - 600}}, AccountLocked},
+		{"lock expired", []Attempts{{Subject: "account:a@x.io", Failures: 10, LastFailure: now - 900}}, nil},
+		{"address locked", []Attempts{{Subject: "ip:192.0.2.1", Failures: 100, LastFailure: now}}, AddressLocked},
+		{"address throttled", []Attempts{{Subject: "ip:192.0.2.1", Failures: 21, LastFailure: now}}, LoginThrottled},
+		{"other account", []Attempts{{Subject: "account:b@x.io", Failures: 10, LastFailure: now}}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := attemptStore{}
+			for _, attempts := range test.attempts {
+				store[attempts.Subject] = attempts
+			}
+			service := New(store, DefaultOptions())
+
+			err := service.Check(ctx, "A@x.io", "192.0.2.1")
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Check: got %v, want %v", err, test.err)
+			}
+			var blocked *Blocked
+			if errors.As(err, &blocked) && blocked.RetryAfter() <= 0 {
+				t.Errorf("Check: RetryAfter = %v, want a wait", blocked.RetryAfter())
+			}
+		})
+	}
+}
+
+func TestFailedAndSucceeded(t *testing.T) {
+	ctx := context.Background()
+	store := attemptStore{}
+	options := DefaultOptions()
+	options.BaseDelay = 0
+	service := New(store, options)
+
+	for i := 0; i < options.Account.MaxFailures; i++ {
+		if err := service.Failed(ctx, "a@x.io", "192.0.2.1"); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+	}
+	if err := service.Check(ctx, "A@X.IO", "192.0.2.2"); !errors.Is(err, AccountLocked) {
+		t.Errorf("Check after %d failures: got %v, want AccountLocked", options.Account.MaxFailures, err)
+	}
+
+	if err := service.Succeeded(ctx, "a@x.io", "192.0.2.1"); err != nil {
+		t.Fatalf("Succeeded: %v", err)
+	}
+	if err := service.Check(ctx, "a@x.io", "192.0.2.2"); err != nil {
+		t.Errorf("Check after a success: %v", err)
+	}
+	if store["ip:192.0.2.1"].Failures != options.Account.MaxFailures {
+		t.Errorf("address failures = %d, want them kept after a success", store["ip:192.0.2.1"].Failures)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	ctx := context.Background()
+	store := attemptStore{"account:a@x.io": {Subject: "account:a@x.io", Failures: 10, LastFailure: int(time.Now().Unix())}}
+	service := New(store, DefaultOptions())
+
+	if result, err := service.UnlockAccount(ctx, "A@x.io"); err != nil || result != Unlocked {
+		t.Errorf("UnlockAccount = %q, %v, want %q", result, err, Unlocked)
+	}
+	if _, err := service.UnlockAccount(ctx, "a@x.io"); !errors.Is(err, NotLocked) {
+		t.Errorf("UnlockAccount of an unlocked account: got %v, want NotLocked", err)
+	}
+	if _, err := service.UnlockAddress(ctx, "192.0.2.1"); !errors.Is(err, NotLocked) {
+		t.Errorf("UnlockAddress of an unlocked address: got %v, want NotLocked", err)
+	}
+}
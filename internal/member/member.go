@@ -2,37 +2,94 @@ package member
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"strconv"
 	"time"
 
+	"microauth.io/core/internal/audit"
+	mailer "microauth.io/core/internal/email"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/outbox"
+	"microauth.io/core/internal/page"
+	"microauth.io/core/internal/password"
 	"microauth.io/core/internal/user"
+	"microauth.io/core/internal/webhook"
 )
 
 type Role string
 
 const (
+	// Owner is held by exactly one member, it is only handed over with an
+	// ownership transfer
+	Owner Role = "owner"
 	Admin Role = "admin"
 	Staff Role = "staff"
 	User  Role = "user"
 )
 
+// Sort orders the members of an organization, the member ID breaks ties
+type Sort string
+
+const (
+	SortEmail Sort = "email"
+	// SortName orders by last name then first name
+	SortName Sort = "name"
+	// SortRole orders by role then email
+	SortRole Sort = "role"
+)
+
+var ranks = map[Role]int{User: 1, Staff: 2, Admin: 3, Owner: 4}
+
+// Valid reports whether the role is one of the member roles
+func (r Role) Valid() bool {
+	return ranks[r] > 0
+}
+
+// Outranks reports whether r grants more than other, owner over admin over
+// staff over user
+func (r Role) Outranks(other Role) bool {
+	return ranks[r] > ranks[other]
+}
+
 var (
-	AdminPermissionFailed   = errors.New("you aren't a administrator")
-	FetchMemberFailed       = errors.New("unable to fetch member")
-	MemberCreateFailed      = errors.New("unable to create member")
+	AdminPermissionFailed   = errs.New(errs.Forbidden, "admin_required", "you aren't a administrator")
+	NotAMember              = errs.New(errs.Forbidden, "not_a_member", "you aren't a member of this organization")
+	FetchMemberFailed       = errs.New(errs.Internal, "fetch_member_failed", "unable to fetch member")
+	MemberNotFound          = errs.New(errs.NotFound, "member_not_found", "member not found")
+	AlreadyMember           = errs.New(errs.Conflict, "already_member", "user is already a member of this organization")
+	MemberCreateFailed      = errs.New(errs.Internal, "member_creation_failed", "unable to create member")
 	MemberAdded             = "member added"
-	MemberUpdateFailed      = errors.New("unable to update member")
+	MemberUpdateFailed      = errs.New(errs.Internal, "member_update_failed", "unable to update member")
 	MemberUpdated           = "member updated"
-	MemberDeleteFailed      = errors.New("unable to delete member")
+	MemberDeleteFailed      = errs.New(errs.Internal, "member_delete_failed", "unable to delete member")
 	MemberDeleted           = "member deleted"
+	MemberRestoreFailed     = errs.New(errs.Internal, "member_restore_failed", "unable to restore member")
+	RemovedMemberNotFound   = errs.New(errs.NotFound, "removed_member_not_found", "no removed member to restore, they may have been purged")
+	MemberRestored          = "member restored"
 	InviteSent              = "invite sent successfully"
-	InviteFailed            = errors.New("unable to send invite")
-	FetchMemberInviteFailed = errors.New("unable to fetch member invite")
-	UserCreationFailed      = errors.New("unable to create new user")
-	InvalidInviteCode       = errors.New("invalid invite code")
+	InviteFailed            = errs.New(errs.Internal, "invite_failed", "unable to send invite")
+	FetchMemberInviteFailed = errs.New(errs.Internal, "fetch_invite_failed", "unable to fetch member invite")
+	InviteNotFound          = errs.New(errs.NotFound, "invite_not_found", "no pending invite for this email address")
+	InviteExpired           = errs.New(errs.Gone, "invite_expired", "invite has expired")
+	InvalidInviteCode       = errs.New(errs.Unprocessable, "invalid_invite_code", "invalid invite code")
+	AccountDetailsRequired  = errs.New(errs.Unprocessable, "account_details_required", "first name, last name and password are required to create the account")
+	InvalidSort             = errs.New(errs.Invalid, "invalid_member_sort", "members are sorted by email, name or role")
+	InvalidRole             = errs.New(errs.Invalid, "invalid_role", "the role is owner, admin, staff or user")
+	OwnerRoleReserved       = errs.New(errs.Unprocessable, "owner_role_reserved", "the owner role is only handed over with an ownership transfer")
+	OwnerCannotBeRemoved    = errs.New(errs.Conflict, "owner_cannot_be_removed", "the owner can't be removed or demoted, they must transfer the ownership first")
+	LastAdmin               = errs.New(errs.Conflict, "last_admin", "an organization keeps at least one administrator, make another member administrator first")
+	OwnerPermissionFailed   = errs.New(errs.Forbidden, "owner_required", "you aren't the owner of this organization")
+	InvalidTransferTarget   = errs.New(errs.Unprocessable, "invalid_transfer_recipient", "the ownership is handed to another member of the organization")
+	TransferNotFound        = errs.New(errs.NotFound, "ownership_transfer_not_found", "no ownership transfer is pending")
+	NotTransferRecipient    = errs.New(errs.Forbidden, "not_transfer_recipient", "the ownership transfer is for another member")
+	TransferExpired         = errs.New(errs.Gone, "ownership_transfer_expired", "the ownership transfer expired, the owner can start a new one")
+	TransferFailed          = errs.New(errs.Internal, "ownership_transfer_failed", "unable to transfer the ownership")
+	OwnershipTransferred    = "ownership transferred"
+	TransferCancelled       = "ownership transfer cancelled"
 )
 
 type Member struct {
@@ -43,6 +100,54 @@ type Member struct {
 	UserID         string
 	Role           Role
 	AppRole        string
+	// DeletedAt is when the member was removed, 0 while they are one
+	DeletedAt int
+}
+
+// Listing is a member with the name and email of their user
+type Listing struct {
+	Member
+	FirstName string
+	LastName  string
+	Email     string
+}
+
+// Keys are the sort keys of the listing for the sort, its ID comes last
+func (l Listing) Keys(sort Sort) []string {
+	switch sort {
+	case SortName:
+		return []string{l.LastName, l.FirstName, l.ID}
+	case SortRole:
+		return []string{string(l.Role), l.Email, l.ID}
+	}
+	return []string{l.Email, l.ID}
+}
+
+// Filter selects a page of the members of an organization, the zero value
+// of a field matches every member
+type Filter struct {
+	OrganizationID string
+	UserID         string
+	Role           Role
+	AppRole        string
+	// Search matches part of the email, the first, the last or the full
+	// name, ignoring case
+	Search string
+	// Deleted selects the removed members instead, they can be restored
+	// until they are purged
+	Deleted    bool
+	Sort       Sort
+	Descending bool
+	// After holds the keys of the last member of the previous page, the
+	// page starts after it
+	After []string
+	Limit int
+}
+
+// Page is one page of members, NextCursor is empty on the last one
+type Page struct {
+	Members    []Listing
+	NextCursor string
 }
 
 type MemberInvite struct {
@@ -55,37 +160,105 @@ type MemberInvite struct {
 	ExpiresAt      int
 }
 
+// OwnershipTransfer hands the organization from its owner to another
+// member, who accepts it before it expires. An organization has at most one
+// pending transfer.
+type OwnershipTransfer struct {
+	OrganizationID string
+	FromUserID     string
+	ToUserID       string
+	CreatedAt      int
+	ExpiresAt      int
+}
+
 type MemberStore interface {
+	WithTx(context.Context, func(context.Context) error) error
 	FetchMemberByID(context.Context, string, string) (Member, error)
 	FetchAllMembers(context.Context, string) ([]Member, error)
+	// FetchMembers returns up to limit members matching the filter in its
+	// sort order
+	FetchMembers(context.Context, Filter) ([]Listing, error)
 	InsertMember(context.Context, string, string, Role, string) (string, error)
 	UpdateMember(context.Context, string, string, Role, string) (string, error)
+	// DeleteMember marks the member removed, RestoreMember brings them
+	// back with their teams
 	DeleteMember(context.Context, string, string) (string, error)
+	RestoreMember(context.Context, string, string) error
 	GetMemberInvite(context.Context, string, string) (MemberInvite, error)
 	InsertMemberInvite(context.Context, string, string, string, int) (string, error)
 	DeleteMemberInvite(context.Context, string, string) (string, error)
+	// SaveOwnershipTransfer replaces the pending transfer of the
+	// organization
+	SaveOwnershipTransfer(context.Context, OwnershipTransfer) error
+	GetOwnershipTransfer(context.Context, string) (OwnershipTransfer, error)
+	DeleteOwnershipTransfer(context.Context, string) error
 }
 
 type UserService interface {
 	GetUserByEmail(context.Context, string) (user.User, error)
-	CreateUser(context.Context, string, string, string, string) (string, error)
+	CreateUser(context.Context, string, string, string, string, ...password.Policy) (string, error)
+}
+
+type OrganizationService interface {
+	GetBranding(context.Context, string) (mailer.Branding, error)
+	GetPasswordPolicy(context.Context, string) (password.Policy, error)
 }
 
 type EmailService interface {
-	SendEmail(string, string, string) (string, error)
+	Render(string, string, string, mailer.Branding, interface{}) (mailer.Message, error)
+}
+
+type OutboxService interface {
+	Enqueue(context.Context, outbox.Message) (string, error)
+}
+
+// TeamService resolves the highest role the teams of a member grant them,
+// empty when they grant none
+type TeamService interface {
+	GrantedRole(context.Context, string, string) (Role, error)
+}
+
+type Auditor interface {
+	Record(context.Context, audit.Event)
+}
+
+type Publisher interface {
+	Publish(context.Context, string, string, interface{})
+}
+
+type Options struct {
+	// ClientURL is the base url of the client that accepts invites and
+	// ownership transfers
+	ClientURL    string
+	InviteExpiry time.Duration
+	// TransferExpiry is how long the recipient of an ownership transfer
+	// has to accept it
+	TransferExpiry time.Duration
 }
 
 type Service struct {
-	store        MemberStore
-	userService  UserService
-	emailService EmailService
+	store               MemberStore
+	userService         UserService
+	organizationService OrganizationService
+	emailService        EmailService
+	outboxService       OutboxService
+	teamService         TeamService
+	auditor             Auditor
+	publisher           Publisher
+	options             Options
 }
 
-func New(store MemberStore, userService UserService, emailService EmailService) *Service {
+func New(store MemberStore, userService UserService, organizationService OrganizationService, emailService EmailService, outboxService OutboxService, teamService TeamService, auditor Auditor, publisher Publisher, options Options) *Service {
 	return &Service{
-		store:        store,
-		userService:  userService,
-		emailService: emailService,
+		store:               store,
+		userService:         userService,
+		organizationService: organizationService,
+		emailService:        emailService,
+		outboxService:       outboxService,
+		teamService:         teamService,
+		auditor:             auditor,
+		publisher:           publisher,
+		options:             options,
 	}
 }
 
@@ -103,54 +276,94 @@ func generateOTP() string {
 	return string(otp)
 }
 
-func (s *Service) InviteMember(ctx context.Context, email string, userID string, organizationID string) (string, error) {
-	// Check if the email exists
-	_, err := s.userService.GetUserByEmail(ctx, email)
-	newUser := false
+func (s *Service) InviteMember(ctx context.Context, email string, userID string, organizationID string, locale string) (string, error) {
+	email = user.NormalizeEmail(email)
+
+	// Check access before anything about the email is looked up
+	err := s.RequireAdmin(ctx, organizationID, userID)
 	if err != nil {
-		newUser = true
+		return "", err
 	}
 
-	// Check access
-	member, err := s.store.FetchMemberByID(ctx, organizationID, userID)
+	// Check if the email exists
+	_, err = s.userService.GetUserByEmail(ctx, email)
+	newUser := false
 	if err != nil {
-		return "", FetchMemberFailed
-	}
-	if member.Role != Admin {
-		return "", AdminPermissionFailed
+		newUser = true
 	}
 
 	// Generate an OTP
 	otp := generateOTP()
-
-	// Store the OTP and insert member invite with an expiry of 72 hours
-	expiresAt := int(time.Now().Add(72 * time.Hour).Unix())
-	_, err = s.store.InsertMemberInvite(ctx, email, organizationID, otp, expiresAt)
-	if err != nil {
-		return "", err
-	}
+	expiry := time.Now().Add(s.options.InviteExpiry)
 
 	// Construct the invitation URL
-	clientURL := "https://example.com" // Replace with your actual client URL
-	invitationURL := fmt.Sprintf("%s/auth/login?organizationID=%s&otp=%s", clientURL, organizationID, otp)
+	invitationURL := fmt.Sprintf("%s/auth/login?organizationID=%s&otp=%s", s.options.ClientURL, organizationID, otp)
 	if newUser {
 		invitationURL += "&new_user=true"
 	}
 
-	// Send the OTP in the email
-	subject := "Invitation OTP"
-	body := fmt.Sprintf("Your invitation OTP: %s\n\nTo accept the invitation, please click the following link:\n%s", otp, invitationURL)
-	_, err = s.emailService.SendEmail(email, subject, body)
+	// The invite email carries the organization branding
+	branding, err := s.organizationService.GetBranding(ctx, organizationID)
+	if err != nil {
+		return "", InviteFailed
+	}
+
+	// Render the OTP email
+	data := mailer.InviteData{
+		Code:      otp,
+		URL:       invitationURL,
+		ExpiresAt: expiry.UTC().Format(time.RFC1123),
+	}
+	msg, err := s.emailService.Render(email, mailer.InviteTemplate, locale, branding, data)
+	if err != nil {
+		log.Println(err)
+		return "", InviteFailed
+	}
+	raw, err := msg.Bytes()
+	if err != nil {
+		log.Println(err)
+		return "", InviteFailed
+	}
+
+	// Store the OTP with its expiry and queue the email in the same
+	// transaction. The new invite replaces the pending one, whose code no
+	// longer works.
+	message := outbox.NewMessage(msg.From.Address, msg.Recipients(), msg.Subject, raw)
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		_, err := s.store.DeleteMemberInvite(ctx, email, organizationID)
+		if err != nil {
+			return err
+		}
+		_, err = s.store.InsertMemberInvite(ctx, email, organizationID, otp, int(expiry.Unix()))
+		if err != nil {
+			return err
+		}
+		_, err = s.outboxService.Enqueue(ctx, message)
+		return err
+	})
 	if err != nil {
+		log.Println(err)
 		return "", InviteFailed
 	}
+	s.auditor.Record(ctx, audit.Event{
+		ActorID:        userID,
+		OrganizationID: organizationID,
+		Action:         audit.MemberInvited,
+		Target:         audit.InviteTarget(email),
+	})
+	s.publisher.Publish(ctx, organizationID, webhook.InviteSent, webhook.InviteData{Email: email, ExpiresAt: int(expiry.Unix())})
 
 	return InviteSent, nil
 }
 
 func (s *Service) AcceptInvite(ctx context.Context, email string, code string, organizationID string, firstName string, lastName string, password string) (string, error) {
+	email = user.NormalizeEmail(email)
+
 	// Check MemberInvite for error
 	memberInvite, err := s.store.GetMemberInvite(ctx, email, organizationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", InviteNotFound
+	}
 	if err != nil {
 		log.Println(err)
 		return "", FetchMemberInviteFailed
@@ -158,86 +371,366 @@ func (s *Service) AcceptInvite(ctx context.Context, email string, code string, o
 
 	// Check if the code matches with the entry in the table
 	if memberInvite.Code != code {
-		log.Println(err)
 		return "", InvalidInviteCode
 	}
+	if int64(memberInvite.ExpiresAt) < time.Now().Unix() {
+		return "", InviteExpired
+	}
 
-	// Check if the user exists for the given email
-	existingUser, err := s.userService.GetUserByEmail(ctx, email)
-	if err != nil {
-		// Create a new user
-		newUserID, err := s.userService.CreateUser(ctx, firstName, lastName, email, password)
+	// The user, the membership and the consumed invite are written together
+	var memberUserID string
+	newUser := false
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		// Check if the user exists for the given email
+		existingUser, err := s.userService.GetUserByEmail(ctx, email)
+		if err == nil {
+			_, err = s.store.FetchMemberByID(ctx, organizationID, existingUser.ID)
+			if err == nil {
+				return AlreadyMember
+			}
+		} else if errors.Is(err, user.UnableToFindUser) {
+			if firstName == "" || lastName == "" || password == "" {
+				return AccountDetailsRequired
+			}
+
+			// The account is created for this organization so its policy
+			// applies from the start
+			policy, err := s.organizationService.GetPasswordPolicy(ctx, organizationID)
+			if err != nil {
+				return err
+			}
+			newUserID, err := s.userService.CreateUser(ctx, firstName, lastName, email, password, policy)
+			if err != nil {
+				log.Println(err)
+				return err
+			}
+			// Set the user ID as the newly created user
+			existingUser.ID = newUserID
+			newUser = true
+		} else {
+			return err
+		}
+
+		// Add the member with the userID and role
+		defaultRole := "user"
+		_, err = s.store.InsertMember(ctx, organizationID, existingUser.ID, Role(defaultRole), "")
 		if err != nil {
 			log.Println(err)
-			return "", UserCreationFailed
+			return MemberCreateFailed
 		}
-		// Set the user ID as the newly created user
-		existingUser.ID = newUserID
-	}
 
-	// Add the member with the userID and role
-	defaultRole := "user"
-	_, err = s.store.InsertMember(ctx, organizationID, existingUser.ID, Role(defaultRole), "")
-	if err != nil {
-		log.Println(err)
-		return "", MemberCreateFailed
-	}
+		// Delete the member invite entry
+		_, err = s.store.DeleteMemberInvite(ctx, email, organizationID)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
 
-	// Delete the member invite entry
-	_, err = s.store.DeleteMemberInvite(ctx, email, organizationID)
+		memberUserID = existingUser.ID
+		return nil
+	})
 	if err != nil {
-		log.Println(err)
 		return "", err
 	}
+	s.auditor.Record(ctx, audit.Event{
+		ActorID:        memberUserID,
+		OrganizationID: organizationID,
+		Action:         audit.InviteAccepted,
+		Target:         audit.MemberTarget(memberUserID),
+		Metadata:       map[string]string{"email": email, "role": string(User), "new_user": strconv.FormatBool(newUser)},
+	})
+	if newUser {
+		s.publisher.Publish(ctx, organizationID, webhook.UserCreated, webhook.UserData{
+			ID:        memberUserID,
+			Email:     email,
+			FirstName: firstName,
+			LastName:  lastName,
+		})
+	}
+	s.publisher.Publish(ctx, organizationID, webhook.InviteAccepted, webhook.InviteData{Email: email, UserID: memberUserID, NewUser: newUser})
+	s.publisher.Publish(ctx, organizationID, webhook.MemberAdded, webhook.MemberData{UserID: memberUserID, Role: string(User)})
 
 	return MemberAdded, nil
 }
 
-func (s *Service) FetchAllMembers(ctx context.Context, organizationID string, userID string) ([]Member, error) {
+// FetchMembers returns a page of the members matching the filter, sorted
+// by email by default. cursor is the NextCursor of the previous page, empty
+// for the first one.
+func (s *Service) FetchMembers(ctx context.Context, userID string, filter Filter, cursor string) (Page, error) {
 	// check access
-	member, err := s.store.FetchMemberByID(ctx, organizationID, userID)
+	err := s.RequireAdmin(ctx, filter.OrganizationID, userID)
 	if err != nil {
-		return []Member{}, FetchMemberFailed
+		return Page{}, err
+	}
+
+	if filter.Sort == "" {
+		filter.Sort = SortEmail
+	}
+	if filter.Sort != SortEmail && filter.Sort != SortName && filter.Sort != SortRole {
+		return Page{}, InvalidSort
 	}
-	if member.Role != Admin {
-		return []Member{}, AdminPermissionFailed
+	if filter.Role != "" && !filter.Role.Valid() {
+		return Page{}, InvalidRole
+	}
+	if cursor != "" {
+		keys := len(Listing{}.Keys(filter.Sort))
+		filter.After, err = page.Decode(cursor, string(filter.Sort), filter.Descending, keys)
+		if err != nil {
+			return Page{}, err
+		}
 	}
 
-	members, err := s.store.FetchAllMembers(ctx, organizationID)
+	// one more member tells whether there is a next page
+	limit := page.Limit(filter.Limit)
+	filter.Limit = limit + 1
+	members, err := s.store.FetchMembers(ctx, filter)
 	if err != nil {
-		return []Member{}, FetchMemberFailed
+		log.Println(err)
+		return Page{}, FetchMemberFailed
+	}
+
+	result := Page{Members: members}
+	if len(members) > limit {
+		result.Members = members[:limit]
+		last := result.Members[limit-1]
+		result.NextCursor = page.Encode(string(filter.Sort), filter.Descending, last.Keys(filter.Sort)...)
 	}
-	return members, nil
+	return result, nil
 }
 
 func (s *Service) FetchMember(ctx context.Context, organizationID string, userID string) (Member, error) {
 	member, err := s.store.FetchMemberByID(ctx, organizationID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Member{}, MemberNotFound
+	}
 	if err != nil {
+		log.Println(err)
 		return Member{}, FetchMemberFailed
 	}
 	return member, nil
 }
 
+// FetchListing returns the member of the organization with the name and
+// email of their user
+func (s *Service) FetchListing(ctx context.Context, organizationID string, userID string) (Listing, error) {
+	filter := Filter{OrganizationID: organizationID, UserID: userID, Sort: SortEmail, Limit: 1}
+	members, err := s.store.FetchMembers(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return Listing{}, FetchMemberFailed
+	}
+	if len(members) == 0 {
+		return Listing{}, MemberNotFound
+	}
+	return members[0], nil
+}
+
+// EffectiveRole is the role of the member or the higher role one of their
+// teams grants
+func (s *Service) EffectiveRole(ctx context.Context, member Member) (Role, error) {
+	granted, err := s.teamService.GrantedRole(ctx, member.OrganizationID, member.UserID)
+	if err != nil {
+		return "", err
+	}
+	if granted.Outranks(member.Role) {
+		return granted, nil
+	}
+	return member.Role, nil
+}
+
+// RequireAdmin fails unless the user is an admin of the organization, on
+// their own or through a team, the owner is one too. A user outside of it
+// is told so rather than that a member is missing.
+func (s *Service) RequireAdmin(ctx context.Context, organizationID string, userID string) error {
+	member, err := s.FetchMember(ctx, organizationID, userID)
+	if errors.Is(err, MemberNotFound) {
+		return NotAMember
+	}
+	if err != nil {
+		return err
+	}
+	role, err := s.EffectiveRole(ctx, member)
+	if err != nil {
+		return err
+	}
+	if role != Admin && role != Owner {
+		return AdminPermissionFailed
+	}
+	return nil
+}
+
+// RequireOwner fails unless the user is the owner of the organization
+func (s *Service) RequireOwner(ctx context.Context, organizationID string, userID string) error {
+	member, err := s.FetchMember(ctx, organizationID, userID)
+	if errors.Is(err, MemberNotFound) {
+		return NotAMember
+	}
+	if err != nil {
+		return err
+	}
+	if member.Role != Owner {
+		return OwnerPermissionFailed
+	}
+	return nil
+}
+
+// CheckRemoval fails when removing the member would break the invariants
+// of the organization, see guard
+func (s *Service) CheckRemoval(ctx context.Context, member Member) error {
+	return s.guard(ctx, member, "")
+}
+
+// guard keeps the invariants of the roles of an organization when the
+// member moves to role, empty when they are removed: the owner stays until
+// they hand the ownership over, nobody else becomes owner, and an admin is
+// always left. The owner counts as an admin. Unlike RequireAdmin, admins
+// through a team don't count on purpose: teams are edited without this
+// guard, so an organization left with only such admins could lose them all
+// with one team change.
+func (s *Service) guard(ctx context.Context, member Member, role Role) error {
+	if member.Role == Owner && role != Owner {
+		return OwnerCannotBeRemoved
+	}
+	if role == Owner && member.Role != Owner {
+		return OwnerRoleReserved
+	}
+	if member.Role != Admin || role == Admin {
+		return nil
+	}
+	members, err := s.store.FetchAllMembers(ctx, member.OrganizationID)
+	if err != nil {
+		log.Println(err)
+		return FetchMemberFailed
+	}
+	for _, other := range members {
+		if other.UserID != member.UserID && (other.Role == Admin || other.Role == Owner) {
+			return nil
+		}
+	}
+	return LastAdmin
+}
+
+// AddMember adds the user to the organization, they can't join as its
+// owner
 func (s *Service) AddMember(ctx context.Context, organizationID string, userID string, role Role, appRole string) (string, error) {
+	if role == Owner {
+		return "", OwnerRoleReserved
+	}
 	memberID, err := s.store.InsertMember(ctx, organizationID, userID, role, appRole)
 	if err != nil {
 		return "", MemberCreateFailed
 	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: organizationID,
+		Action:         audit.MemberAdded,
+		Target:         audit.MemberTarget(userID),
+		Metadata:       map[string]string{"role": string(role), "app_role": appRole},
+	})
+	s.publisher.Publish(ctx, organizationID, webhook.MemberAdded, webhook.MemberData{UserID: userID, Role: string(role), AppRole: appRole})
 	return memberID, nil
 }
 
+// UpdateMember replaces the role and the app role of the member, the owner
+// keeps their role until they transfer the ownership
 func (s *Service) UpdateMember(ctx context.Context, organizationID string, userID string, role Role, appRole string) (string, error) {
-	_, err := s.store.UpdateMember(ctx, organizationID, userID, role, appRole)
+	var previous Member
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		previous, err = s.FetchMember(ctx, organizationID, userID)
+		if err != nil {
+			return err
+		}
+		err = s.guard(ctx, previous, role)
+		if err != nil {
+			return err
+		}
+		_, err = s.store.UpdateMember(ctx, organizationID, userID, role, appRole)
+		if err != nil {
+			log.Println(err)
+			return MemberUpdateFailed
+		}
+		return nil
+	})
 	if err != nil {
-		return "", MemberUpdateFailed
+		return "", err
 	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: organizationID,
+		Action:         audit.MemberRoleChanged,
+		Target:         audit.MemberTarget(userID),
+		Metadata: map[string]string{
+			"previous_role":     string(previous.Role),
+			"role":              string(role),
+			"previous_app_role": previous.AppRole,
+			"app_role":          appRole,
+		},
+	})
+	s.publisher.Publish(ctx, organizationID, webhook.MemberUpdated, webhook.MemberData{
+		UserID:          userID,
+		Role:            string(role),
+		AppRole:         appRole,
+		PreviousRole:    string(previous.Role),
+		PreviousAppRole: previous.AppRole,
+	})
 	return MemberUpdated, nil
 }
 
+// DeleteMember removes the member until RestoreMember brings them back,
+// adding the user again starts a new membership instead. The owner and the
+// last admin can't be removed.
 func (s *Service) DeleteMember(ctx context.Context, organizationID string, userID string) (string, error) {
-	_, err := s.store.DeleteMember(ctx, organizationID, userID)
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		previous, err := s.FetchMember(ctx, organizationID, userID)
+		if err != nil {
+			return err
+		}
+		err = s.guard(ctx, previous, "")
+		if err != nil {
+			return err
+		}
+		_, err = s.store.DeleteMember(ctx, organizationID, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return MemberNotFound
+		}
+		if err != nil {
+			log.Println(err)
+			return MemberDeleteFailed
+		}
+		return nil
+	})
 	if err != nil {
-		return "", MemberDeleteFailed
+		return "", err
 	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: organizationID,
+		Action:         audit.MemberRemoved,
+		Target:         audit.MemberTarget(userID),
+	})
+	s.publisher.Publish(ctx, organizationID, webhook.MemberRemoved, webhook.MemberData{UserID: userID})
 	return MemberDeleted, nil
 }
+
+// RestoreMember brings back a removed member with their role and teams,
+// the organization and the user must not be deleted
+func (s *Service) RestoreMember(ctx context.Context, organizationID string, userID string) (string, error) {
+	err := s.store.RestoreMember(ctx, organizationID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", RemovedMemberNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", MemberRestoreFailed
+	}
+	member, err := s.FetchMember(ctx, organizationID, userID)
+	if err != nil {
+		return "", err
+	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: organizationID,
+		Action:         audit.MemberRestored,
+		Target:         audit.MemberTarget(userID),
+		Metadata:       map[string]string{"role": string(member.Role), "app_role": member.AppRole},
+	})
+	s.publisher.Publish(ctx, organizationID, webhook.MemberAdded, webhook.MemberData{UserID: userID, Role: string(member.Role), AppRole: member.AppRole})
+	return MemberRestored, nil
+}
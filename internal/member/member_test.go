@@ -0,0 +1,346 @@
+package member
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/page"
+)
+
+const organizationID = "organization-1"
+
+// memberStore keeps the members and the transfer of one organization. Its
+// listings are sorted by email and paginated like the store.
+type memberStore struct {
+	MemberStore
+	members  map[string]Member
+	transfer *OwnershipTransfer
+	listings []Listing
+	// limits are the limits of the pages fetched
+	limits []int
+}
+
+func newMemberStore(members ...Member) *memberStore {
+	s := &memberStore{members: map[string]Member{}}
+	for _, m := range members {
+		m.OrganizationID = organizationID
+		s.members[m.UserID] = m
+	}
+	return s
+}
+
+func (s *memberStore) WithTx(ctx context.Context, f func(context.Context) error) error {
+	return f(ctx)
+}
+
+func (s *memberStore) FetchMemberByID(ctx context.Context, organizationID string, userID string) (Member, error) {
+	m, ok := s.members[userID]
+	if !ok {
+		return Member{}, sql.ErrNoRows
+	}
+	return m, nil
+}
+
+func (s *memberStore) FetchAllMembers(ctx context.Context, organizationID string) ([]Member, error) {
+	members := []Member{}
+	for _, m := range s.members {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func (s *memberStore) FetchMembers(ctx context.Context, filter Filter) ([]Listing, error) {
+	s.limits = append(s.limits, filter.Limit)
+	listings := []Listing{}
+	for _, l := range s.listings {
+		if filter.After != nil && l.Email <= filter.After[0] {
+			continue
+		}
+		if len(listings) == filter.Limit {
+			break
+		}
+		listings = append(listings, l)
+	}
+	return listings, nil
+}
+
+func (s *memberStore) InsertMember(ctx context.Context, organizationID string, userID string, role Role, appRole string) (string, error) {
+	s.members[userID] = Member{ID: "member-" + userID, OrganizationID: organizationID, UserID: userID, Role: role, AppRole: appRole}
+	return "member-" + userID, nil
+}
+
+func (s *memberStore) UpdateMember(ctx context.Context, organizationID string, userID string, role Role, appRole string) (string, error) {
+	m := s.members[userID]
+	m.Role = role
+	m.AppRole = appRole
+	s.members[userID] = m
+	return "", nil
+}
+
+func (s *memberStore) DeleteMember(ctx context.Context, organizationID string, userID string) (string, error) {
+	delete(s.members, userID)
+	return "", nil
+}
+
+func (s *memberStore) SaveOwnershipTransfer(ctx context.Context, transfer OwnershipTransfer) error {
+	s.transfer = &transfer
+	return nil
+}
+
+func (s *memberStore) GetOwnershipTransfer(ctx context.Context, organizationID string) (OwnershipTransfer, error) {
+	if s.transfer == nil {
+		return OwnershipTransfer{}, sql.ErrNoRows
+	}
+	return *s.transfer, nil
+}
+
+func (s *memberStore) DeleteOwnershipTransfer(ctx context.Context, organizationID string) error {
+	if s.transfer == nil {
+		return sql.ErrNoRows
+	}
+	s.transfer = nil
+	return nil
+}
+
+// teamService grants roles to users by ID
+type teamService map[string]Role
+
+func (s teamService) GrantedRole(ctx context.Context, organizationID string, userID string) (Role, error) {
+	return s[userID], nil
+}
+
+type auditor struct{}
+
+func (auditor) Record(context.Context, audit.Event) {}
+
+type publisher struct{}
+
+func (publisher) Publish(context.Context, string, string, interface{}) {}
+
+func newService(store *memberStore, grants teamService) *Service {
+	return New(store, nil, nil, nil, nil, grants, auditor{}, publisher{}, Options{})
+}
+
+func TestRoleInvariants(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []Member
+		// grants are the roles teams grant
+		grants teamService
+		user   string
+		// role is the new role of the user, empty to remove them
+		role Role
+		err  error
+	}{
+		{"demote the owner", []Member{{UserID: "owner", Role: Owner}, {UserID: "admin", Role: Admin}}, nil, "owner", Admin, OwnerCannotBeRemoved},
+		{"remove the owner", []Member{{UserID: "owner", Role: Owner}, {UserID: "admin", Role: Admin}}, nil, "owner", "", OwnerCannotBeRemoved},
+		{"promote to owner", []Member{{UserID: "owner", Role: Owner}, {UserID: "admin", Role: Admin}}, nil, "admin", Owner, OwnerRoleReserved},
+		{"demote the last admin", []Member{{UserID: "admin", Role: Admin}, {UserID: "user", Role: User}}, nil, "admin", Staff, LastAdmin},
+		{"remove the last admin", []Member{{UserID: "admin", Role: Admin}, {UserID: "user", Role: User}}, nil, "admin", "", LastAdmin},
+		{"team admins don't count", []Member{{UserID: "admin", Role: Admin}, {UserID: "user", Role: User}}, teamService{"user": Admin}, "admin", "", LastAdmin},
+		{"demote an admin beside the owner", []Member{{UserID: "owner", Role: Owner}, {UserID: "admin", Role: Admin}}, nil, "admin", User, nil},
+		{"remove an admin beside another", []Member{{UserID: "first", Role: Admin}, {UserID: "second", Role: Admin}}, nil, "first", "", nil},
+		{"remove a user", []Member{{UserID: "admin", Role: Admin}, {UserID: "user", Role: User}}, nil, "user", "", nil},
+		{"promote a user", []Member{{UserID: "admin", Role: Admin}, {UserID: "user", Role: User}}, nil, "user", Admin, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newMemberStore(test.members...)
+			service := newService(store, test.grants)
+			before := store.members[test.user]
+
+			var err error
+			if test.role == "" {
+				_, err = service.DeleteMember(context.Background(), organizationID, test.user)
+			} else {
+				_, err = service.UpdateMember(context.Background(), organizationID, test.user, test.role, "")
+			}
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			after, ok := store.members[test.user]
+			switch {
+			case test.err != nil && after != before:
+				t.Errorf("refused change stored %+v", after)
+			case test.err == nil && test.role == "" && ok:
+				t.Errorf("member was not removed")
+			case test.err == nil && test.role != "" && after.Role != test.role:
+				t.Errorf("role = %q, want %q", after.Role, test.role)
+			}
+		})
+	}
+}
+
+func TestAddMemberOwner(t *testing.T) {
+	store := newMemberStore(Member{UserID: "owner", Role: Owner})
+	service := newService(store, nil)
+	if _, err := service.AddMember(context.Background(), organizationID, "user", Owner, ""); !errors.Is(err, OwnerRoleReserved) {
+		t.Fatalf("AddMember as owner: got %v, want OwnerRoleReserved", err)
+	}
+	if _, ok := store.members["user"]; ok {
+		t.Errorf("AddMember as owner stored the member")
+	}
+	if _, err := service.AddMember(context.Background(), organizationID, "user", Admin, ""); err != nil {
+		t.Errorf("AddMember as admin: %v", err)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	store := newMemberStore(
+		Member{UserID: "owner", Role: Owner},
+		Member{UserID: "admin", Role: Admin},
+		Member{UserID: "lead", Role: User},
+		Member{UserID: "user", Role: User},
+	)
+	service := newService(store, teamService{"lead": Admin, "user": Staff})
+	tests := []struct {
+		user string
+		err  error
+	}{
+		{"owner", nil},
+		{"admin", nil},
+		{"lead", nil},
+		{"user", AdminPermissionFailed},
+		{"stranger", NotAMember},
+	}
+	for _, test := range tests {
+		if err := service.RequireAdmin(context.Background(), organizationID, test.user); !errors.Is(err, test.err) {
+			t.Errorf("RequireAdmin(%s): got %v, want %v", test.user, err, test.err)
+		}
+	}
+}
+
+func TestOwnershipTransfer(t *testing.T) {
+	now := int(time.Now().Unix())
+	pending := OwnershipTransfer{OrganizationID: organizationID, FromUserID: "owner", ToUserID: "admin", CreatedAt: now, ExpiresAt: now + 3600}
+	expired := OwnershipTransfer{OrganizationID: organizationID, FromUserID: "owner", ToUserID: "admin", CreatedAt: now - 7200, ExpiresAt: now - 3600}
+	tests := []struct {
+		name     string
+		transfer *OwnershipTransfer
+		// action is accept or cancel, by user
+		action string
+		user   string
+		err    error
+		// owner is who owns the organization afterwards
+		owner string
+		// left tells whether the transfer is still pending
+		left bool
+	}{
+		{"accept", &pending, "accept", "admin", nil, "admin", false},
+		{"accept for someone else", &pending, "accept", "user", NotTransferRecipient, "owner", true},
+		{"accept expired", &expired, "accept", "admin", TransferExpired, "owner", true},
+		{"accept expired for someone else", &expired, "accept", "user", NotTransferRecipient, "owner", true},
+		{"accept without a transfer", nil, "accept", "admin", TransferNotFound, "owner", false},
+		{"cancel by the owner", &pending, "cancel", "owner", nil, "owner", false},
+		{"decline by the recipient", &pending, "cancel", "admin", nil, "owner", false},
+		{"cancel by someone else", &pending, "cancel", "user", OwnerPermissionFailed, "owner", true},
+		{"cancel without a transfer", nil, "cancel", "owner", TransferNotFound, "owner", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newMemberStore(
+				Member{UserID: "owner", Role: Owner},
+				Member{UserID: "admin", Role: Admin},
+				Member{UserID: "user", Role: User},
+			)
+			if test.transfer != nil {
+				transfer := *test.transfer
+				store.transfer = &transfer
+			}
+			service := newService(store, nil)
+
+			var err error
+			if test.action == "accept" {
+				_, err = service.AcceptOwnershipTransfer(context.Background(), organizationID, test.user)
+			} else {
+				_, err = service.CancelOwnershipTransfer(context.Background(), organizationID, test.user)
+			}
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			owners := []string{}
+			for _, m := range store.members {
+				if m.Role == Owner {
+					owners = append(owners, m.UserID)
+				}
+			}
+			if len(owners) != 1 || owners[0] != test.owner {
+				t.Errorf("owners = %v, want %s", owners, test.owner)
+			}
+			if test.owner == "admin" && store.members["owner"].Role != Admin {
+				t.Errorf("previous owner is %q, want admin", store.members["owner"].Role)
+			}
+			if (store.transfer != nil) != test.left {
+				t.Errorf("transfer pending = %v, want %v", store.transfer != nil, test.left)
+			}
+		})
+	}
+}
+
+func TestFetchMembersPages(t *testing.T) {
+	store := newMemberStore(Member{UserID: "admin", Role: Admin})
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"} {
+		store.listings = append(store.listings, Listing{Member: Member{ID: "member-" + email}, Email: email})
+	}
+	service := newService(store, nil)
+
+	// pages of 2 are fetched 3 at a time, the third member tells there is
+	// a next page
+	emails := []string{}
+	cursor := ""
+	for pages := 0; pages < 3; pages++ {
+		result, err := service.FetchMembers(context.Background(), "admin", Filter{OrganizationID: organizationID, Limit: 2}, cursor)
+		if err != nil {
+			t.Fatalf("page %d: %v", pages+1, err)
+		}
+		for _, l := range result.Members {
+			emails = append(emails, l.Email)
+		}
+		cursor = result.NextCursor
+		if (cursor == "") != (pages == 2) {
+			t.Fatalf("page %d has next cursor %q", pages+1, cursor)
+		}
+	}
+	if want := []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"}; !reflect.DeepEqual(emails, want) {
+		t.Errorf("members = %v, want %v", emails, want)
+	}
+	if !reflect.DeepEqual(store.limits, []int{3, 3, 3}) {
+		t.Errorf("fetched pages of %v, want one more than the limit", store.limits)
+	}
+}
+
+func TestFetchMembersRejected(t *testing.T) {
+	store := newMemberStore(Member{UserID: "admin", Role: Admin})
+	service := newService(store, nil)
+	cursor := page.Encode(string(SortEmail), false, "b@example.com", "member-2")
+	raw, _ := base64.RawURLEncoding.DecodeString(cursor)
+	raw[len(raw)-1] = ','
+
+	tests := []struct {
+		name   string
+		filter Filter
+		cursor string
+		err    error
+	}{
+		{"unknown sort", Filter{Sort: "created_at"}, "", InvalidSort},
+		{"invalid cursor", Filter{}, "not a cursor", page.InvalidCursor},
+		{"tampered cursor", Filter{}, base64.RawURLEncoding.EncodeToString(raw), page.InvalidCursor},
+		{"cursor of another sort", Filter{Sort: SortName}, cursor, page.InvalidCursor},
+		{"cursor of another order", Filter{Descending: true}, cursor, page.InvalidCursor},
+		{"valid cursor", Filter{}, cursor, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.filter.OrganizationID = organizationID
+			if _, err := service.FetchMembers(context.Background(), "admin", test.filter, test.cursor); !errors.Is(err, test.err) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package member
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"microauth.io/core/internal/audit"
+	mailer "microauth.io/core/internal/email"
+	"microauth.io/core/internal/outbox"
+	"microauth.io/core/internal/webhook"
+)
+
+// TransferOwnership offers the organization to another of its members, the
+// owner stays owner until they accept it. A new transfer replaces the
+// pending one, the recipient is told by email.
+func (s *Service) TransferOwnership(ctx context.Context, organizationID string, userID string, recipientID string, locale string) (OwnershipTransfer, error) {
+	err := s.RequireOwner(ctx, organizationID, userID)
+	if err != nil {
+		return OwnershipTransfer{}, err
+	}
+	if recipientID == userID {
+		return OwnershipTransfer{}, InvalidTransferTarget
+	}
+	recipient, err := s.FetchListing(ctx, organizationID, recipientID)
+	if errors.Is(err, MemberNotFound) {
+		return OwnershipTransfer{}, InvalidTransferTarget
+	}
+	if err != nil {
+		return OwnershipTransfer{}, err
+	}
+	owner, err := s.FetchListing(ctx, organizationID, userID)
+	if err != nil {
+		return OwnershipTransfer{}, err
+	}
+	branding, err := s.organizationService.GetBranding(ctx, organizationID)
+	if err != nil {
+		return OwnershipTransfer{}, TransferFailed
+	}
+
+	now := time.Now()
+	expiry := now.Add(s.options.TransferExpiry)
+	data := mailer.OwnershipTransferData{
+		From:      owner.FirstName + " " + owner.LastName,
+		URL:       fmt.Sprintf("%s/organizations/%s/ownership-transfer", s.options.ClientURL, organizationID),
+		ExpiresAt: expiry.UTC().Format(time.RFC1123),
+	}
+	msg, err := s.emailService.Render(recipient.Email, mailer.OwnershipTransferTemplate, locale, branding, data)
+	if err != nil {
+		log.Println(err)
+		return OwnershipTransfer{}, TransferFailed
+	}
+	raw, err := msg.Bytes()
+	if err != nil {
+		log.Println(err)
+		return OwnershipTransfer{}, TransferFailed
+	}
+
+	// The transfer is stored and its email queued in the same transaction
+	transfer := OwnershipTransfer{
+		OrganizationID: organizationID,
+		FromUserID:     userID,
+		ToUserID:       recipientID,
+		CreatedAt:      int(now.Unix()),
+		ExpiresAt:      int(expiry.Unix()),
+	}
+	message := outbox.NewMessage(msg.From.Address, msg.Recipients(), msg.Subject, raw)
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		err := s.store.SaveOwnershipTransfer(ctx, transfer)
+		if err != nil {
+			return err
+		}
+		_, err = s.outboxService.Enqueue(ctx, message)
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+		return OwnershipTransfer{}, TransferFailed
+	}
+	s.auditor.Record(ctx, audit.Event{
+		ActorID:        userID,
+		OrganizationID: organizationID,
+		Action:         audit.OwnershipTransferStarted,
+		Target:         audit.MemberTarget(recipientID),
+		Metadata:       map[string]string{"expires_at": strconv.Itoa(transfer.ExpiresAt)},
+	})
+	return transfer, nil
+}
+
+// GetOwnershipTransfer returns the pending transfer of the organization to
+// its owner, its recipient and its admins, an expired one is left out
+func (s *Service) GetOwnershipTransfer(ctx context.Context, organizationID string, userID string) (OwnershipTransfer, error) {
+	_, err := s.FetchMember(ctx, organizationID, userID)
+	if errors.Is(err, MemberNotFound) {
+		return OwnershipTransfer{}, NotAMember
+	}
+	if err != nil {
+		return OwnershipTransfer{}, err
+	}
+	transfer, err := s.pendingTransfer(ctx, organizationID)
+	if errors.Is(err, TransferExpired) {
+		return OwnershipTransfer{}, TransferNotFound
+	}
+	if err != nil {
+		return OwnershipTransfer{}, err
+	}
+	if userID != transfer.FromUserID && userID != transfer.ToUserID {
+		err = s.RequireAdmin(ctx, organizationID, userID)
+		if err != nil {
+			return OwnershipTransfer{}, err
+		}
+	}
+	return transfer, nil
+}
+
+// pendingTransfer fails with TransferExpired, along with the transfer, once
+// it can no longer be accepted
+func (s *Service) pendingTransfer(ctx context.Context, organizationID string) (OwnershipTransfer, error) {
+	transfer, err := s.store.GetOwnershipTransfer(ctx, organizationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OwnershipTransfer{}, TransferNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return OwnershipTransfer{}, TransferFailed
+	}
+	if int64(transfer.ExpiresAt) < time.Now().Unix() {
+		return transfer, TransferExpired
+	}
+	return transfer, nil
+}
+
+// AcceptOwnershipTransfer makes the recipient of the pending transfer the
+// owner of the organization and the previous owner an admin
+func (s *Service) AcceptOwnershipTransfer(ctx context.Context, organizationID string, userID string) (string, error) {
+	var owner, recipient Member
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		// only the recipient learns that the transfer expired
+		transfer, err := s.pendingTransfer(ctx, organizationID)
+		if err != nil && !errors.Is(err, TransferExpired) {
+			return err
+		}
+		if transfer.ToUserID != userID {
+			return NotTransferRecipient
+		}
+		if err != nil {
+			return err
+		}
+
+		recipient, err = s.FetchMember(ctx, organizationID, userID)
+		if errors.Is(err, MemberNotFound) {
+			return NotAMember
+		}
+		if err != nil {
+			return err
+		}
+		// the owner can't leave, a transfer of another owner is stale
+		owner, err = s.FetchMember(ctx, organizationID, transfer.FromUserID)
+		if errors.Is(err, MemberNotFound) || (err == nil && owner.Role != Owner) {
+			return TransferNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = s.store.UpdateMember(ctx, organizationID, owner.UserID, Admin, owner.AppRole)
+		if err == nil {
+			_, err = s.store.UpdateMember(ctx, organizationID, recipient.UserID, Owner, recipient.AppRole)
+		}
+		if err == nil {
+			err = s.store.DeleteOwnershipTransfer(ctx, organizationID)
+		}
+		if err != nil {
+			log.Println(err)
+			return TransferFailed
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	s.auditor.Record(ctx, audit.Event{
+		ActorID:        userID,
+		OrganizationID: organizationID,
+		Action:         audit.OwnershipTransferred,
+		Target:         audit.MemberTarget(userID),
+		Metadata:       map[string]string{"previous_owner": owner.UserID},
+	})
+	s.publisher.Publish(ctx, organizationID, webhook.MemberUpdated, webhook.MemberData{
+		UserID:          owner.UserID,
+		Role:            string(Admin),
+		AppRole:         owner.AppRole,
+		PreviousRole:    string(Owner),
+		PreviousAppRole: owner.AppRole,
+	})
+	s.publisher.Publish(ctx, organizationID, webhook.MemberUpdated, webhook.MemberData{
+		UserID:          recipient.UserID,
+		Role:            string(Owner),
+		AppRole:         recipient.AppRole,
+		PreviousRole:    string(recipient.Role),
+		PreviousAppRole: recipient.AppRole,
+	})
+	return OwnershipTransferred, nil
+}
+
+// CancelOwnershipTransfer drops the pending transfer, the owner cancels it
+// and the recipient declines it
+func (s *Service) CancelOwnershipTransfer(ctx context.Context, organizationID string, userID string) (string, error) {
+	_, err := s.FetchMember(ctx, organizationID, userID)
+	if errors.Is(err, MemberNotFound) {
+		return "", NotAMember
+	}
+	if err != nil {
+		return "", err
+	}
+	transfer, err := s.store.GetOwnershipTransfer(ctx, organizationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", TransferNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", TransferFailed
+	}
+	if userID != transfer.FromUserID && userID != transfer.ToUserID {
+		return "", OwnerPermissionFailed
+	}
+
+	err = s.store.DeleteOwnershipTransfer(ctx, organizationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", TransferNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", TransferFailed
+	}
+	s.auditor.Record(ctx, audit.Event{
+		ActorID:        userID,
+		OrganizationID: organizationID,
+		Action:         audit.OwnershipTransferCancelled,
+		Target:         audit.MemberTarget(transfer.ToUserID),
+	})
+	return TransferCancelled, nil
+}
@@ -0,0 +1,364 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	DatabaseAhead     = errors.New("database schema is newer than this binary")
+	DatabaseDirty     = errors.New("database schema is dirty, fix it and force a version")
+	NoChange          = errors.New("no change")
+	UnknownVersion    = errors.New("unknown migration version")
+	InvalidMigration  = errors.New("invalid migration file name")
+	MissingMigration  = errors.New("missing down migration")
+	InvalidStepNumber = errors.New("number of steps must be positive")
+)
+
+// advisoryLockID keeps concurrent replicas from migrating at the same time
+const advisoryLockID = 7243915160012
+
+// Migration is a pair of up and down scripts named like the files
+// golang-migrate creates: 000001_name.up.sql and 000001_name.down.sql
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version uint
+	Name    string
+	Applied bool
+}
+
+// Migrator applies the embedded migrations. It keeps its state in the
+// schema_migrations table used by the golang-migrate cli, so databases
+// migrated with the cli keep working.
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+func New(db *sql.DB, driver string, source fs.FS) (*Migrator, error) {
+	migrations, err := load(source)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		driver:     driver,
+		migrations: migrations,
+	}, nil
+}
+
+func load(source fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(source, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, file := range files {
+		name := path.Base(file)
+		direction := ""
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("%w: %s", InvalidMigration, name)
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(name, "."+direction+".sql"), "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: %s", InvalidMigration, name)
+		}
+		version, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", InvalidMigration, name)
+		}
+
+		content, err := fs.ReadFile(source, file)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[uint(version)]
+		if !ok {
+			migration = &Migration{Version: uint(version), Name: parts[1]}
+			byVersion[uint(version)] = migration
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Latest is the version of the newest embedded migration
+func (m *Migrator) Latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)`)
+	return err
+}
+
+// Version returns the applied version, zero when nothing was applied yet
+func (m *Migrator) Version(ctx context.Context) (uint, bool, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return 0, false, err
+	}
+	return version(ctx, conn)
+}
+
+func (m *Migrator) rebind(query string) string {
+	return sqlx.Rebind(sqlx.BindType(m.driver), query)
+}
+
+func version(ctx context.Context, conn *sql.Conn) (uint, bool, error) {
+	var (
+		current int64
+		dirty   bool
+	)
+	err := conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&current, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint(current), dirty, nil
+}
+
+func (m *Migrator) setVersion(ctx context.Context, tx *sql.Tx, version uint, dirty bool) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if version == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, m.rebind(`INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)`), int64(version), dirty)
+	return err
+}
+
+// locked runs fn on a single connection holding the migration lock. SQLite
+// databases are local to one process and need no lock.
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.driver == "postgres" {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockID); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, advisoryLockID)
+	}
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// apply runs one script and records the resulting version in the same
+// transaction, a failed migration leaves the previous version in place
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script string, version uint) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if strings.TrimSpace(script) != "" {
+		if _, err := tx.ExecContext(ctx, script); err != nil {
+			return err
+		}
+	}
+	if err := m.setVersion(ctx, tx, version, false); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) index(version uint) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// Up applies every migration newer than the current version
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return DatabaseDirty
+		}
+		if current > m.Latest() {
+			return DatabaseAhead
+		}
+
+		applied := 0
+		for _, migration := range m.migrations {
+			if migration.Version <= current {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration.Up, migration.Version); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied++
+		}
+
+		if applied == 0 {
+			return NoChange
+		}
+		return nil
+	})
+}
+
+// Down reverts the given number of migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if steps <= 0 {
+		return InvalidStepNumber
+	}
+
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return DatabaseDirty
+		}
+		if current == 0 {
+			return NoChange
+		}
+
+		i := m.index(current)
+		if i == -1 {
+			return fmt.Errorf("%w: %d", UnknownVersion, current)
+		}
+
+		for ; steps > 0 && i >= 0; steps-- {
+			migration := m.migrations[i]
+			if migration.Down == "" {
+				return fmt.Errorf("%w: %d_%s", MissingMigration, migration.Version, migration.Name)
+			}
+
+			previous := uint(0)
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+			if err := m.apply(ctx, conn, migration.Down, previous); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			i--
+		}
+		return nil
+	})
+}
+
+// Force records a version without running anything, it is the way out of
+// a dirty database once the schema was repaired by hand
+func (m *Migrator) Force(ctx context.Context, target uint) error {
+	if target != 0 && m.index(target) == -1 {
+		return fmt.Errorf("%w: %d", UnknownVersion, target)
+	}
+
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := m.setVersion(ctx, tx, target, false); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// Status lists every embedded migration and whether it is applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, uint, bool, error) {
+	current, dirty, err := m.Version(ctx)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	statuses := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = MigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+			Applied: migration.Version <= current,
+		}
+	}
+	return statuses, current, dirty, nil
+}
+
+// Check refuses a database that is dirty or ahead of the binary. A
+// database that is behind is reported through the returned pending count.
+func (m *Migrator) Check(ctx context.Context) (int, error) {
+	current, dirty, err := m.Version(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return 0, DatabaseDirty
+	}
+	if current > m.Latest() {
+		return 0, fmt.Errorf("%w: database is at version %d, binary knows up to %d", DatabaseAhead, current, m.Latest())
+	}
+
+	pending := 0
+	for _, migration := range m.migrations {
+		if migration.Version > current {
+			pending++
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,248 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"microauth.io/core/migrations"
+)
+
+var scripts = fstest.MapFS{
+	"000001_users.up.sql":      {Data: []byte(`CREATE TABLE users (id TEXT PRIMARY KEY)`)},
+	"000001_users.down.sql":    {Data: []byte(`DROP TABLE users`)},
+	"000002_emails.up.sql":     {Data: []byte(`ALTER TABLE users ADD COLUMN email TEXT`)},
+	"000002_emails.down.sql":   {Data: []byte(`ALTER TABLE users DROP COLUMN email`)},
+	"000010_sessions.up.sql":   {Data: []byte(`CREATE TABLE sessions (id TEXT PRIMARY KEY)`)},
+	"000010_sessions.down.sql": {Data: []byte(`DROP TABLE sessions`)},
+}
+
+func newMigrator(t *testing.T, source fs.FS) (*Migrator, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	m, err := New(db, "sqlite3", source)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return m, db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&count)
+	if err != nil {
+		t.Fatalf("sqlite_master: %v", err)
+	}
+	return count == 1
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		source fstest.MapFS
+		want   []uint
+		err    error
+	}{
+		{"sorted by version", scripts, []uint{1, 2, 10}, nil},
+		{"empty", fstest.MapFS{}, []uint{}, nil},
+		{"no name", fstest.MapFS{"000001.up.sql": {}}, nil, InvalidMigration},
+		{"no direction", fstest.MapFS{"000001_users.sql": {}}, nil, InvalidMigration},
+		{"no version", fstest.MapFS{"first_users.up.sql": {}}, nil, InvalidMigration},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := load(test.source)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("load: got %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if len(migrations) != len(test.want) {
+				t.Fatalf("load = %+v, want versions %v", migrations, test.want)
+			}
+			for i, version := range test.want {
+				if migrations[i].Version != version || migrations[i].Up == "" || migrations[i].Down == "" {
+					t.Errorf("migration %d = %+v, want version %d with both scripts", i, migrations[i], version)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	m, db := newMigrator(t, scripts)
+
+	steps := []struct {
+		name     string
+		run      func() error
+		err      error
+		version  uint
+		sessions bool
+		users    bool
+	}{
+		{"up", func() error { return m.Up(ctx) }, nil, 10, true, true},
+		{"up again", func() error { return m.Up(ctx) }, NoChange, 10, true, true},
+		{"down one", func() error { return m.Down(ctx, 1) }, nil, 2, false, true},
+		{"down past the first", func() error { return m.Down(ctx, 5) }, nil, 0, false, false},
+		{"down at zero", func() error { return m.Down(ctx, 1) }, NoChange, 0, false, false},
+		{"down no steps", func() error { return m.Down(ctx, 0) }, InvalidStepNumber, 0, false, false},
+		{"up from zero", func() error { return m.Up(ctx) }, nil, 10, true, true},
+		{"force", func() error { return m.Force(ctx, 2) }, nil, 2, true, true},
+		{"force unknown", func() error { return m.Force(ctx, 3) }, UnknownVersion, 2, true, true},
+	}
+	for _, step := range steps {
+		err := step.run()
+		if !errors.Is(err, step.err) {
+			t.Fatalf("%s: got %v, want %v", step.name, err, step.err)
+		}
+		version, dirty, err := m.Version(ctx)
+		if err != nil {
+			t.Fatalf("%s: Version: %v", step.name, err)
+		}
+		if version != step.version || dirty {
+			t.Errorf("%s: version = %d, dirty %v, want %d", step.name, version, dirty, step.version)
+		}
+		if tableExists(t, db, "users") != step.users || tableExists(t, db, "sessions") != step.sessions {
+			t.Errorf("%s: users %v, sessions %v, want %v and %v", step.name, tableExists(t, db, "users"), tableExists(t, db, "sessions"), step.users, step.sessions)
+		}
+	}
+}
+
+// TestEmbeddedRoundTrip runs every down script of the schema and applies
+// it again
+func TestEmbeddedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source, err := migrations.Source("sqlite3")
+	if err != nil {
+		t.Fatalf("Source: %v", err)
+	}
+	m, _ := newMigrator(t, source)
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if err := m.Down(ctx, len(m.migrations)); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up after Down: %v", err)
+	}
+	if pending, err := m.Check(ctx); err != nil || pending != 0 {
+		t.Errorf("Check = %d, %v, want nothing pending", pending, err)
+	}
+}
+
+func TestFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	source := fstest.MapFS{
+		"000001_users.up.sql":    scripts["000001_users.up.sql"],
+		"000001_users.down.sql":  scripts["000001_users.down.sql"],
+		"000002_broken.up.sql":   {Data: []byte(`CREATE TABLE broken (`)},
+		"000002_broken.down.sql": {Data: []byte(`DROP TABLE broken`)},
+	}
+	m, db := newMigrator(t, source)
+
+	if err := m.Up(ctx); err == nil {
+		t.Fatal("Up with a broken migration succeeded")
+	}
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		t.Fatalf("Version: %v", err)
+	}
+	if version != 1 || dirty || !tableExists(t, db, "users") {
+		t.Errorf("after a failed migration version = %d, dirty %v, want 1 with users applied", version, dirty)
+	}
+}
+
+func TestMissingDown(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newMigrator(t, fstest.MapFS{"000001_users.up.sql": scripts["000001_users.up.sql"]})
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if err := m.Down(ctx, 1); !errors.Is(err, MissingMigration) {
+		t.Errorf("Down: got %v, want MissingMigration", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		version int64
+		dirty   bool
+		pending int
+		err     error
+	}{
+		{"empty", 0, false, 3, nil},
+		{"behind", 2, false, 1, nil},
+		{"current", 10, false, 0, nil},
+		{"ahead", 11, false, 0, DatabaseAhead},
+		{"dirty", 2, true, 0, DatabaseDirty},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, db := newMigrator(t, scripts)
+			if _, _, err := m.Version(ctx); err != nil {
+				t.Fatalf("Version: %v", err)
+			}
+			if test.version != 0 {
+				_, err := db.Exec(`INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)`, test.version, test.dirty)
+				if err != nil {
+					t.Fatalf("schema_migrations: %v", err)
+				}
+			}
+
+			pending, err := m.Check(ctx)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Check: got %v, want %v", err, test.err)
+			}
+			if pending != test.pending {
+				t.Errorf("Check = %d pending, want %d", pending, test.pending)
+			}
+		})
+	}
+}
+
+func TestRefuseDirtyOrAhead(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		version int64
+		dirty   bool
+		err     error
+	}{
+		{"dirty", 1, true, DatabaseDirty},
+		{"ahead", 11, false, DatabaseAhead},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, db := newMigrator(t, scripts)
+			if _, _, err := m.Version(ctx); err != nil {
+				t.Fatalf("Version: %v", err)
+			}
+			_, err := db.Exec(`INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)`, test.version, test.dirty)
+			if err != nil {
+				t.Fatalf("schema_migrations: %v", err)
+			}
+			if err := m.Up(ctx); !errors.Is(err, test.err) {
+				t.Errorf("Up: got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
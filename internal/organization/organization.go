@@ -2,82 +2,333 @@ package organization
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"log"
+	"strconv"
+
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/email"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/page"
+	"microauth.io/core/internal/password"
+	"microauth.io/core/internal/webhook"
 )
 
 type Organization struct {
-	ID        string
-	Name      string
-	Domain    string
-	CreatedAt int
-	UpdatedAt int
+	ID           string
+	Name         string
+	Domain       string
+	LogoURL      string
+	PrimaryColor string
+	SenderName   string
+	// PasswordPolicy tightens the server policy for the members, the zero
+	// value adds nothing
+	PasswordPolicy password.Policy
+	CreatedAt      int
+	UpdatedAt      int
+	// DeletedAt is when the organization was deleted, 0 while it is not
+	DeletedAt int
+	// Role is the role of the user in the organization, it is only set in
+	// the organizations of a user
+	Role member.Role
+}
+
+// Sort orders the organizations of a user, the organization ID breaks ties
+type Sort string
+
+const (
+	SortName    Sort = "name"
+	SortDomain  Sort = "domain"
+	SortCreated Sort = "created_at"
+)
+
+// Keys are the sort keys of the organization for the sort, its ID comes
+// last
+func (o Organization) Keys(sort Sort) []string {
+	switch sort {
+	case SortDomain:
+		return []string{o.Domain, o.ID}
+	case SortCreated:
+		return []string{strconv.Itoa(o.CreatedAt), o.ID}
+	}
+	return []string{o.Name, o.ID}
+}
+
+// Filter selects a page of the organizations of a user, the zero value of
+// a field matches every organization
+type Filter struct {
+	UserID string
+	// Role is the role of the user in the organization
+	Role member.Role
+	// Search matches part of the name or the domain, ignoring case
+	Search     string
+	Sort       Sort
+	Descending bool
+	// After holds the keys of the last organization of the previous page,
+	// the page starts after it
+	After []string
+	Limit int
+}
+
+// Page is one page of organizations, NextCursor is empty on the last one
+type Page struct {
+	Organizations []Organization
+	NextCursor    string
 }
 
 var (
-	FetchOrganizationFailed    = errors.New("unable to find organization")
-	OrganizationCreationFailed = errors.New("unable to create organization")
-	OrganizationDeleteFailed   = errors.New("unable to delete organization")
-	OrganizationDeleted        = "organization deleted"
-	OrganizationUpdateFailed   = errors.New("unable to update organization")
-	OrganizationUpdated        = "organization updated"
+	FetchOrganizationFailed     = errs.New(errs.Internal, "fetch_organization_failed", "unable to find organization")
+	OrganizationNotFound        = errs.New(errs.NotFound, "organization_not_found", "organization not found")
+	OrganizationCreationFailed  = errs.New(errs.Internal, "organization_creation_failed", "unable to create organization")
+	OrganizationDeleteFailed    = errs.New(errs.Internal, "organization_delete_failed", "unable to delete organization")
+	OrganizationDeleted         = "organization deleted"
+	OrganizationRestoreFailed   = errs.New(errs.Internal, "organization_restore_failed", "unable to restore organization")
+	OrganizationRestored        = "organization restored"
+	DeletedOrganizationNotFound = errs.New(errs.NotFound, "deleted_organization_not_found", "no deleted organization to restore, it may have been purged")
+	OrganizationUpdateFailed    = errs.New(errs.Internal, "organization_update_failed", "unable to update organization")
+	DomainTaken                 = errs.New(errs.Conflict, "domain_taken", "domain is already used by another organization")
+	OrganizationUpdated         = "organization updated"
+	InvalidSort                 = errs.New(errs.Invalid, "invalid_organization_sort", "organizations are sorted by name, domain or created_at")
 )
 
 type OrganizationStore interface {
-	GetOrganizationByUserID(context.Context, string) ([]Organization, error)
+	WithTx(context.Context, func(context.Context) error) error
+	// FetchUserOrganizations returns up to limit organizations matching
+	// the filter in its sort order
+	FetchUserOrganizations(context.Context, Filter) ([]Organization, error)
+	// InsertOrganization and UpdateOrganization return DomainTaken when
+	// another organization has the domain
 	InsertOrganization(context.Context, string, string) (string, error)
 	GetOrganizationByID(context.Context, string) (Organization, error)
+	// DeleteOrganizationByID marks the organization and its members
+	// deleted, RestoreOrganization brings both back
 	DeleteOrganizationByID(context.Context, string) (string, error)
+	RestoreOrganization(context.Context, string) error
 	UpdateOrganization(context.Context, string, string, string) (Organization, error)
+	UpdateOrganizationBranding(context.Context, string, string, string, string) (Organization, error)
+	UpdateOrganizationPasswordPolicy(context.Context, string, password.Policy) (Organization, error)
+	InsertMember(context.Context, string, string, member.Role, string) (string, error)
+}
+
+type Auditor interface {
+	Record(context.Context, audit.Event)
+}
+
+type Publisher interface {
+	Publish(context.Context, string, string, interface{})
 }
 
 type Service struct {
-	store OrganizationStore
+	store     OrganizationStore
+	auditor   Auditor
+	publisher Publisher
 }
 
-func New(store OrganizationStore) *Service {
+func New(store OrganizationStore, auditor Auditor, publisher Publisher) *Service {
 	return &Service{
-		store: store,
+		store:     store,
+		auditor:   auditor,
+		publisher: publisher,
 	}
 }
 
-func (s *Service) FetchUserOrganizations(ctx context.Context, userID string) ([]Organization, error) {
-	organizations, err := s.store.GetOrganizationByUserID(ctx, userID)
+func (s *Service) record(ctx context.Context, id string, action string, metadata map[string]string) {
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: id,
+		Action:         action,
+		Target:         audit.OrganizationTarget(id),
+		Metadata:       metadata,
+	})
+}
+
+// FetchUserOrganizations returns a page of the organizations of the user
+// matching the filter, sorted by name by default. cursor is the NextCursor
+// of the previous page, empty for the first one.
+func (s *Service) FetchUserOrganizations(ctx context.Context, filter Filter, cursor string) (Page, error) {
+	if filter.Sort == "" {
+		filter.Sort = SortName
+	}
+	if filter.Sort != SortName && filter.Sort != SortDomain && filter.Sort != SortCreated {
+		return Page{}, InvalidSort
+	}
+	if filter.Role != "" && !filter.Role.Valid() {
+		return Page{}, member.InvalidRole
+	}
+	if cursor != "" {
+		after, err := page.Decode(cursor, string(filter.Sort), filter.Descending, 2)
+		if err != nil {
+			return Page{}, err
+		}
+		if _, err := strconv.Atoi(after[0]); filter.Sort == SortCreated && err != nil {
+			return Page{}, page.InvalidCursor
+		}
+		filter.After = after
+	}
+
+	// one more organization tells whether there is a next page
+	limit := page.Limit(filter.Limit)
+	filter.Limit = limit + 1
+	organizations, err := s.store.FetchUserOrganizations(ctx, filter)
 	if err != nil {
 		log.Println(err)
-		return []Organization{}, FetchOrganizationFailed
+		return Page{}, FetchOrganizationFailed
 	}
-	return organizations, nil
+
+	result := Page{Organizations: organizations}
+	if len(organizations) > limit {
+		result.Organizations = organizations[:limit]
+		last := result.Organizations[limit-1]
+		result.NextCursor = page.Encode(string(filter.Sort), filter.Descending, last.Keys(filter.Sort)...)
+	}
+	return result, nil
 }
 
 func (s *Service) GetOrganization(ctx context.Context, id string) (Organization, error) {
 	organization, err := s.store.GetOrganizationByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Organization{}, OrganizationNotFound
+	}
 	if err != nil {
+		log.Println(err)
 		return Organization{}, FetchOrganizationFailed
 	}
 	return organization, nil
 }
 
-func (s *Service) CreateOrganization(ctx context.Context, name string, domain string) (string, error) {
-	orgID, err := s.store.InsertOrganization(ctx, name, domain)
+// CreateOrganization creates the organization with its creator as its
+// owner, they stay owner until they hand the ownership to another member
+func (s *Service) CreateOrganization(ctx context.Context, name string, domain string, userID string, appRole string) (string, error) {
+	var orgID string
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		orgID, err = s.store.InsertOrganization(ctx, name, domain)
+		if err != nil {
+			return err
+		}
+		_, err = s.store.InsertMember(ctx, orgID, userID, member.Owner, appRole)
+		return err
+	})
+	if errors.Is(err, DomainTaken) {
+		return "", DomainTaken
+	}
 	if err != nil {
+		log.Println(err)
 		return "", OrganizationCreationFailed
 	}
+	s.record(ctx, orgID, audit.OrganizationCreated, map[string]string{"name": name, "domain": domain})
 	return orgID, nil
 }
 
+// DeleteOrganization removes the organization and its members until
+// RestoreOrganization brings them back, the privacy purge deletes it for
+// good once the retention window is over
 func (s *Service) DeleteOrganization(ctx context.Context, id string) (string, error) {
 	_, err := s.store.DeleteOrganizationByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", OrganizationNotFound
+	}
 	if err != nil {
+		log.Println(err)
 		return "", OrganizationDeleteFailed
 	}
+	s.record(ctx, id, audit.OrganizationDeleted, nil)
 	return OrganizationDeleted, nil
 }
 
+// RestoreOrganization brings back a deleted organization with the members
+// deleted along with it
+func (s *Service) RestoreOrganization(ctx context.Context, id string) (string, error) {
+	err := s.store.RestoreOrganization(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", DeletedOrganizationNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", OrganizationRestoreFailed
+	}
+	s.record(ctx, id, audit.OrganizationRestored, nil)
+	return OrganizationRestored, nil
+}
+
 func (s *Service) EditOrganization(ctx context.Context, id string, name string, domain string) (string, error) {
-	_, err := s.store.UpdateOrganization(ctx, id, name, domain)
+	organization, err := s.store.UpdateOrganization(ctx, id, name, domain)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", OrganizationNotFound
+	}
+	if errors.Is(err, DomainTaken) {
+		return "", DomainTaken
+	}
+	if err != nil {
+		log.Println(err)
+		return "", OrganizationUpdateFailed
+	}
+	s.record(ctx, id, audit.OrganizationUpdated, map[string]string{"name": name, "domain": domain})
+	s.publishUpdated(ctx, organization)
+	return OrganizationUpdated, nil
+}
+
+func (s *Service) GetBranding(ctx context.Context, id string) (email.Branding, error) {
+	organization, err := s.GetOrganization(ctx, id)
+	if err != nil {
+		return email.Branding{}, err
+	}
+	return email.Branding{
+		Name:         organization.Name,
+		SenderName:   organization.SenderName,
+		LogoURL:      organization.LogoURL,
+		PrimaryColor: organization.PrimaryColor,
+	}, nil
+}
+
+func (s *Service) EditBranding(ctx context.Context, id string, logoURL string, primaryColor string, senderName string) (string, error) {
+	organization, err := s.store.UpdateOrganizationBranding(ctx, id, logoURL, primaryColor, senderName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", OrganizationNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", OrganizationUpdateFailed
+	}
+	s.record(ctx, id, audit.BrandingUpdated, map[string]string{"logo_url": logoURL, "primary_color": primaryColor, "sender_name": senderName})
+	s.publishUpdated(ctx, organization)
+	return OrganizationUpdated, nil
+}
+
+// GetPasswordPolicy is the policy the organization adds to the server
+// policy for its members
+func (s *Service) GetPasswordPolicy(ctx context.Context, id string) (password.Policy, error) {
+	organization, err := s.GetOrganization(ctx, id)
 	if err != nil {
+		return password.Policy{}, err
+	}
+	return organization.PasswordPolicy, nil
+}
+
+// EditPasswordPolicy replaces the policy of the organization, the zero
+// policy removes it
+func (s *Service) EditPasswordPolicy(ctx context.Context, id string, policy password.Policy) (string, error) {
+	err := policy.Validate()
+	if err != nil {
+		return "", err
+	}
+
+	organization, err := s.store.UpdateOrganizationPasswordPolicy(ctx, id, policy)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", OrganizationNotFound
+	}
+	if err != nil {
+		log.Println(err)
 		return "", OrganizationUpdateFailed
 	}
+	s.record(ctx, id, audit.PasswordPolicyUpdated, nil)
+	s.publishUpdated(ctx, organization)
 	return OrganizationUpdated, nil
 }
+
+// publishUpdated sends organization.updated after any change of the
+// organization, its name, its branding or its password policy
+func (s *Service) publishUpdated(ctx context.Context, organization Organization) {
+	s.publisher.Publish(ctx, organization.ID, webhook.OrganizationUpdated, webhook.OrganizationData{ID: organization.ID, Name: organization.Name, Domain: organization.Domain})
+}
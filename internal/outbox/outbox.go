@@ -0,0 +1,180 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/worker"
+)
+
+type Status string
+
+const (
+	Pending Status = "pending"
+	Sending Status = "sending"
+	Sent    Status = "sent"
+	Dead    Status = "dead"
+)
+
+var (
+	EnqueueFailed       = errs.New(errs.Internal, "enqueue_failed", "unable to enqueue outbox message")
+	FetchMessagesFailed = errs.New(errs.Internal, "fetch_messages_failed", "unable to fetch outbox messages")
+	MessageNotRetryable = errs.New(errs.NotFound, "message_not_retryable", "no pending or dead message with this id")
+	InvalidStatus       = errs.New(errs.Invalid, "invalid_message_status", "unknown message status")
+	RetryMessageFailed  = errs.New(errs.Internal, "retry_failed", "unable to retry outbox message")
+	MessageRetried      = "message scheduled for retry"
+)
+
+// Message is an email waiting in the outbox. Body holds the complete
+// RFC 5322 message as produced by the email package.
+type Message struct {
+	ID            string
+	Sender        string
+	Recipients    []string
+	Subject       string
+	Body          string
+	Status        Status
+	Attempts      int
+	LastError     string
+	NextAttemptAt int
+	CreatedAt     int
+	UpdatedAt     int
+}
+
+// NewMessage builds a pending message that is due immediately
+func NewMessage(sender string, recipients []string, subject string, body []byte) Message {
+	now := int(time.Now().Unix())
+	return Message{
+		ID:            uuid.New().String(),
+		Sender:        sender,
+		Recipients:    recipients,
+		Subject:       subject,
+		Body:          string(body),
+		Status:        Pending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+type OutboxStore interface {
+	InsertOutboxMessage(context.Context, Message) (string, error)
+	// ClaimOutboxMessages marks due messages as sending until the lease
+	// expires and increments their attempts
+	ClaimOutboxMessages(context.Context, int, int) ([]Message, error)
+	UpdateOutboxMessageStatus(context.Context, string, Status, string, int) (string, error)
+	FetchOutboxMessages(context.Context, Status) ([]Message, error)
+	RetryOutboxMessage(context.Context, string) (string, error)
+}
+
+type Sender interface {
+	Deliver(string, []string, []byte) error
+}
+
+type Options struct {
+	worker.Options
+}
+
+func DefaultOptions() Options {
+	return Options{Options: worker.Options{
+		Workers:      4,
+		BatchSize:    16,
+		MaxAttempts:  8,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+		PollInterval: 5 * time.Second,
+		Lease:        5 * time.Minute,
+	}}
+}
+
+type Service struct {
+	store   OutboxStore
+	sender  Sender
+	options Options
+}
+
+func New(store OutboxStore, sender Sender, options Options) *Service {
+	return &Service{
+		store:   store,
+		sender:  sender,
+		options: options,
+	}
+}
+
+// Run polls the outbox and delivers due messages with a pool of workers
+// until the context is cancelled. In flight deliveries finish before Run
+// returns.
+func (s *Service) Run(ctx context.Context) {
+	worker.Run(ctx, s.options.Options, s.store.ClaimOutboxMessages, s.deliver)
+}
+
+func (s *Service) deliver(message Message) {
+	// deliveries are not tied to the poll context so a shutdown does not
+	// abort a message half way through
+	ctx := context.Background()
+
+	err := s.sender.Deliver(message.Sender, message.Recipients, []byte(message.Body))
+	if err == nil {
+		_, err = s.store.UpdateOutboxMessageStatus(ctx, message.ID, Sent, "", message.NextAttemptAt)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	log.Println("outbox delivery failed:", message.ID, err)
+
+	status := Pending
+	nextAttemptAt, dead := s.options.Retry(message.Attempts)
+	if dead {
+		status = Dead
+	}
+
+	_, err = s.store.UpdateOutboxMessageStatus(ctx, message.ID, status, err.Error(), nextAttemptAt)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Enqueue stores the message for delivery. Called with a transactional
+// context the message is only sent if the transaction commits.
+func (s *Service) Enqueue(ctx context.Context, message Message) (string, error) {
+	id, err := s.store.InsertOutboxMessage(ctx, message)
+	if err != nil {
+		log.Println(err)
+		return "", EnqueueFailed
+	}
+	return id, nil
+}
+
+func (s *Service) FetchMessages(ctx context.Context, status Status) ([]Message, error) {
+	switch status {
+	case Pending, Sending, Sent, Dead:
+	default:
+		return []Message{}, InvalidStatus
+	}
+
+	messages, err := s.store.FetchOutboxMessages(ctx, status)
+	if err != nil {
+		log.Println(err)
+		return []Message{}, FetchMessagesFailed
+	}
+	return messages, nil
+}
+
+func (s *Service) RetryMessage(ctx context.Context, id string) (string, error) {
+	_, err := s.store.RetryOutboxMessage(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", MessageNotRetryable
+	}
+	if err != nil {
+		log.Println(err)
+		return "", RetryMessageFailed
+	}
+	return MessageRetried, nil
+}
@@ -0,0 +1,72 @@
+// Package page encodes the cursors of the paginated lists. A cursor holds
+// the sort keys and the ID of the last item of a page, the next page starts
+// after it. It also holds the sort it was made for, a cursor of another
+// sort is refused rather than skipping or repeating items.
+package page
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"microauth.io/core/internal/errs"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+var (
+	InvalidCursor = errs.New(errs.Invalid, "invalid_cursor", "invalid pagination cursor")
+	InvalidOrder  = errs.New(errs.Invalid, "invalid_order", "the order is asc or desc")
+)
+
+type cursor struct {
+	Sort       string   `json:"s"`
+	Descending bool     `json:"d,omitempty"`
+	Keys       []string `json:"k"`
+}
+
+// Limit bounds the requested size of a page, 0 asks for the default
+func Limit(limit int) int {
+	if limit <= 0 {
+		return DefaultLimit
+	}
+	if limit > MaxLimit {
+		return MaxLimit
+	}
+	return limit
+}
+
+// Descending reads the order of a list, ascending when it is empty
+func Descending(order string) (bool, error) {
+	switch order {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	}
+	return false, InvalidOrder
+}
+
+// Encode returns the cursor of the page after the item with the keys.
+// Cursors are opaque to clients, they are not meant to compute them.
+func Encode(sort string, descending bool, keys ...string) string {
+	raw, _ := json.Marshal(cursor{Sort: sort, Descending: descending, Keys: keys})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// Decode returns the keys of the cursor, it must have been made for the
+// sort and hold n keys
+func Decode(value string, sort string, descending bool, n int) ([]string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, InvalidCursor
+	}
+	var c cursor
+	err = json.Unmarshal(raw, &c)
+	if err != nil || c.Sort != sort || c.Descending != descending || len(c.Keys) != n {
+		return nil, InvalidCursor
+	}
+	return c.Keys, nil
+}
@@ -0,0 +1,99 @@
+package page
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	tests := []struct {
+		name       string
+		sort       string
+		descending bool
+		keys       []string
+	}{
+		{"one key", "email", false, []string{"ada@example.com", "member-1"}},
+		{"descending", "name", true, []string{"Lovelace", "Ada", "member-1"}},
+		{"empty key", "name", false, []string{"", "Ada", "member-1"}},
+		{"separators in keys", "name", false, []string{`O"Brien, "Jr"`, "a.b", "member-1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cursor := Encode(test.sort, test.descending, test.keys...)
+			keys, err := Decode(cursor, test.sort, test.descending, len(test.keys))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(keys, test.keys) {
+				t.Errorf("Decode = %q, want %q", keys, test.keys)
+			}
+		})
+	}
+}
+
+func TestDecodeRejected(t *testing.T) {
+	cursor := Encode("email", false, "ada@example.com", "member-1")
+	raw, _ := base64.RawURLEncoding.DecodeString(cursor)
+	raw[len(raw)-1] = ','
+	tests := []struct {
+		name       string
+		cursor     string
+		sort       string
+		descending bool
+		n          int
+	}{
+		{"not base64", "not a cursor!", "email", false, 2},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("email:ada")), "email", false, 2},
+		{"tampered", base64.RawURLEncoding.EncodeToString(raw), "email", false, 2},
+		{"other sort", cursor, "name", false, 2},
+		{"other order", cursor, "email", true, 2},
+		{"missing keys", cursor, "email", false, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Decode(test.cursor, test.sort, test.descending, test.n); !errors.Is(err, InvalidCursor) {
+				t.Errorf("Decode: got %v, want InvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{-1, DefaultLimit},
+		{0, DefaultLimit},
+		{1, 1},
+		{MaxLimit, MaxLimit},
+		{MaxLimit + 1, MaxLimit},
+	}
+	for _, test := range tests {
+		if got := Limit(test.limit); got != test.want {
+			t.Errorf("Limit(%d) = %d, want %d", test.limit, got, test.want)
+		}
+	}
+}
+
+func TestDescending(t *testing.T) {
+	tests := []struct {
+		order string
+		want  bool
+		err   error
+	}{
+		{"", false, nil},
+		{"asc", false, nil},
+		{"desc", true, nil},
+		{"DESC", false, InvalidOrder},
+		{"newest", false, InvalidOrder},
+	}
+	for _, test := range tests {
+		got, err := Descending(test.order)
+		if got != test.want || !errors.Is(err, test.err) {
+			t.Errorf("Descending(%q) = %v, %v, want %v, %v", test.order, got, err, test.want, test.err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+const bloomMagic = "MABF"
+const bloomVersion = 1
+
+var (
+	InvalidCorpus = errors.New("invalid breached password corpus")
+)
+
+// Bloom is a bloom filter of the SHA-1 digests of breached passwords. Like
+// the k-anonymity range files it is built from, it never holds a password
+// in clear and answers without the network. A false positive rejects a
+// good password, there are no false negatives.
+type Bloom struct {
+	bits []uint64
+	m    uint64
+	k    uint32
+}
+
+// NewBloom sizes a filter for n digests with the given false positive rate
+func NewBloom(n int, falsePositive float64) *Bloom {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositive) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Bloom{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// positions derives the k bits of a digest by double hashing, the digest
+// is already uniformly distributed
+func (b *Bloom) positions(digest [sha1.Size]byte, fn func(uint64) bool) bool {
+	h1 := binary.BigEndian.Uint64(digest[0:8])
+	h2 := binary.BigEndian.Uint64(digest[8:16]) | 1
+	for i := uint64(0); i < uint64(b.k); i++ {
+		if !fn((h1 + i*h2) % b.m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bloom) AddDigest(digest [sha1.Size]byte) {
+	b.positions(digest, func(bit uint64) bool {
+		b.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+func (b *Bloom) Add(password string) {
+	b.AddDigest(sha1.Sum([]byte(password)))
+}
+
+func (b *Bloom) Contains(password string) bool {
+	return b.positions(sha1.Sum([]byte(password)), func(bit uint64) bool {
+		return b.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
+
+// WriteTo stores the filter as a small header followed by the bit set
+func (b *Bloom) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, 0, 20)
+	header = append(header, bloomMagic...)
+	header = binary.BigEndian.AppendUint32(header, bloomVersion)
+	header = binary.BigEndian.AppendUint64(header, b.m)
+	header = binary.BigEndian.AppendUint32(header, b.k)
+
+	written, err := w.Write(header)
+	if err != nil {
+		return int64(written), err
+	}
+	err = binary.Write(w, binary.BigEndian, b.bits)
+	if err != nil {
+		return int64(written), err
+	}
+	return int64(written + 8*len(b.bits)), nil
+}
+
+func ReadBloom(r io.Reader) (*Bloom, error) {
+	header := make([]byte, 20)
+	_, err := io.ReadFull(r, header)
+	if err != nil || string(header[0:4]) != bloomMagic || binary.BigEndian.Uint32(header[4:8]) != bloomVersion {
+		return nil, InvalidCorpus
+	}
+
+	b := &Bloom{
+		m: binary.BigEndian.Uint64(header[8:16]),
+		k: binary.BigEndian.Uint32(header[16:20]),
+	}
+	if b.m == 0 || b.k == 0 {
+		return nil, InvalidCorpus
+	}
+	b.bits = make([]uint64, (b.m+63)/64)
+	err = binary.Read(bufio.NewReader(r), binary.BigEndian, b.bits)
+	if err != nil {
+		return nil, InvalidCorpus
+	}
+	return b, nil
+}
+
+func LoadBloom(path string) (*Bloom, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadBloom(file)
+}
+
+// ParseCorpusLine reads one line of a breach list. Lines of the k-anonymity
+// downloads, a hex SHA-1 digest optionally followed by :count, are used as
+// they are, any other line is a password in clear.
+func ParseCorpusLine(line string) ([sha1.Size]byte, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return [sha1.Size]byte{}, false
+	}
+
+	candidate, _, _ := strings.Cut(line, ":")
+	if len(candidate) == 2*sha1.Size {
+		var digest [sha1.Size]byte
+		if _, err := hex.Decode(digest[:], []byte(candidate)); err == nil {
+			return digest, true
+		}
+	}
+	return sha1.Sum([]byte(line)), true
+}
@@ -0,0 +1,229 @@
+// Package password decides which passwords are accepted. A Policy lists the
+// requirements, a Checker applies it together with the strength estimate of
+// Score and the breached password corpus.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/validate"
+)
+
+// BcryptMaxLength is the number of bytes bcrypt reads, anything after it
+// is ignored
+const BcryptMaxLength = 72
+
+var (
+	Rejected = errs.New(errs.Unprocessable, "password_rejected", "password does not satisfy the password policy")
+)
+
+// Policy is the set of requirements of a password. The zero value accepts
+// everything, which makes it the neutral element of Merge.
+type Policy struct {
+	MinLength       int  `json:"min_length"`
+	MaxLength       int  `json:"max_length"`
+	RequireLower    bool `json:"require_lower"`
+	RequireUpper    bool `json:"require_upper"`
+	RequireDigit    bool `json:"require_digit"`
+	RequireSymbol   bool `json:"require_symbol"`
+	BanPersonalInfo bool `json:"ban_personal_info"`
+	// MinScore is the lowest accepted Score, from 0 to 4
+	MinScore       int  `json:"min_score"`
+	RejectBreached bool `json:"reject_breached"`
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength:       8,
+		MaxLength:       BcryptMaxLength,
+		BanPersonalInfo: true,
+		MinScore:        2,
+		RejectBreached:  true,
+	}
+}
+
+// Validate reports a policy no password can satisfy or one bcrypt cannot
+// enforce, the fields are named after the json keys
+func (p Policy) Validate() error {
+	var invalid validate.Errors
+	fail := func(field string, message string) {
+		invalid = append(invalid, validate.FieldError{Field: field, Code: "range", Message: message})
+	}
+
+	if p.MinLength < 0 {
+		fail("min_length", "must not be negative")
+	}
+	if p.MaxLength < 0 || p.MaxLength > BcryptMaxLength {
+		fail("max_length", fmt.Sprintf("must be between 0 and %d", BcryptMaxLength))
+	} else if p.MaxLength != 0 && p.MaxLength < p.MinLength {
+		fail("max_length", "must not be below min_length")
+	}
+	if p.MinScore < 0 || p.MinScore > 4 {
+		fail("min_score", "must be between 0 and 4")
+	}
+
+	if len(invalid) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// Merge returns the strictest combination of both policies, an
+// organization can tighten the server policy but never loosen it
+func (p Policy) Merge(other Policy) Policy {
+	merged := Policy{
+		MinLength:       maxInt(p.MinLength, other.MinLength),
+		MaxLength:       p.MaxLength,
+		RequireLower:    p.RequireLower || other.RequireLower,
+		RequireUpper:    p.RequireUpper || other.RequireUpper,
+		RequireDigit:    p.RequireDigit || other.RequireDigit,
+		RequireSymbol:   p.RequireSymbol || other.RequireSymbol,
+		BanPersonalInfo: p.BanPersonalInfo || other.BanPersonalInfo,
+		MinScore:        maxInt(p.MinScore, other.MinScore),
+		RejectBreached:  p.RejectBreached || other.RejectBreached,
+	}
+	if merged.MaxLength == 0 || (other.MaxLength != 0 && other.MaxLength < merged.MaxLength) {
+		merged.MaxLength = other.MaxLength
+	}
+	return merged
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Violation is one requirement a password fails
+type Violation struct {
+	Code    string
+	Message string
+}
+
+// Violations lists every failed requirement, it unwraps to Rejected
+type Violations []Violation
+
+func (v Violations) Error() string {
+	messages := make([]string, len(v))
+	for i, violation := range v {
+		messages[i] = violation.Message
+	}
+	return Rejected.Message + ": " + strings.Join(messages, "; ")
+}
+
+func (v Violations) Unwrap() error {
+	return Rejected
+}
+
+type Checker struct {
+	corpus *Bloom
+}
+
+// NewChecker checks breached passwords against corpus, a nil corpus
+// disables the breach check
+func NewChecker(corpus *Bloom) *Checker {
+	return &Checker{
+		corpus: corpus,
+	}
+}
+
+// Check returns Violations when password fails policy. Personal is what the
+// password must not contain when personal information is banned, such as
+// the names and the email address of the user.
+func (c *Checker) Check(policy Policy, password string, personal ...string) error {
+	var violations Violations
+	fail := func(code string, message string) {
+		violations = append(violations, Violation{Code: code, Message: message})
+	}
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		fail("too_short", fmt.Sprintf("must be at least %d characters", policy.MinLength))
+	}
+	if policy.MaxLength > 0 && len(password) > policy.MaxLength {
+		fail("too_long", fmt.Sprintf("must be at most %d bytes", policy.MaxLength))
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if policy.RequireLower && !lower {
+		fail("missing_lower", "must contain a lowercase letter")
+	}
+	if policy.RequireUpper && !upper {
+		fail("missing_upper", "must contain an uppercase letter")
+	}
+	if policy.RequireDigit && !digit {
+		fail("missing_digit", "must contain a digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		fail("missing_symbol", "must contain a symbol")
+	}
+
+	tokens := personalTokens(personal)
+	if policy.BanPersonalInfo {
+		lowered := strings.ToLower(password)
+		for _, token := range tokens {
+			if strings.Contains(lowered, token) {
+				fail("personal_info", "must not contain your name or email address")
+				break
+			}
+		}
+	}
+
+	if score := scoreTokens(password, tokens); score < policy.MinScore {
+		fail("too_weak", fmt.Sprintf("is too easy to guess, scored %d out of 4 and needs %d", score, policy.MinScore))
+	}
+
+	if policy.RejectBreached && c.corpus != nil && c.corpus.Contains(password) {
+		fail("breached", "appears in a list of breached passwords")
+	}
+
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// personalTokens splits names and email addresses into the lowercase words
+// worth looking for, short words match too much to be useful
+func personalTokens(personal []string) []string {
+	var tokens []string
+	for _, value := range personal {
+		value = strings.ToLower(value)
+		local, domain, isEmail := strings.Cut(value, "@")
+		if isEmail {
+			// the top level domain says nothing about the user
+			if i := strings.LastIndex(domain, "."); i >= 0 {
+				domain = domain[:i]
+			}
+			value = local + " " + domain
+		}
+		words := strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			if utf8.RuneCountInString(word) >= 3 {
+				tokens = append(tokens, word)
+			}
+		}
+		if isEmail && utf8.RuneCountInString(local) >= 3 {
+			tokens = append(tokens, local)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,122 @@
+package password
+
+import (
+	"errors"
+	"testing"
+
+	"microauth.io/core/internal/validate"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		fields []string
+	}{
+		{"default", DefaultPolicy(), nil},
+		{"zero", Policy{}, nil},
+		{"negative min length", Policy{MinLength: -1}, []string{"min_length"}},
+		{"past bcrypt", Policy{MaxLength: BcryptMaxLength + 1}, []string{"max_length"}},
+		{"max below min", Policy{MinLength: 12, MaxLength: 10}, []string{"max_length"}},
+		{"score", Policy{MinScore: 5}, []string{"min_score"}},
+		{"several", Policy{MinLength: -1, MinScore: -1}, []string{"min_length", "min_score"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.policy.Validate()
+			var invalid validate.Errors
+			if test.fields == nil {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &invalid) || len(invalid) != len(test.fields) {
+				t.Fatalf("Validate = %v, want errors on %v", err, test.fields)
+			}
+			for i, field := range test.fields {
+				if invalid[i].Field != field {
+					t.Errorf("error %d on %q, want %q", i, invalid[i].Field, field)
+				}
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name        string
+		server, org Policy
+		want        Policy
+	}{
+		{"zero is neutral", DefaultPolicy(), Policy{}, DefaultPolicy()},
+		{"tighter lengths", Policy{MinLength: 8, MaxLength: 72}, Policy{MinLength: 12, MaxLength: 64}, Policy{MinLength: 12, MaxLength: 64}},
+		{"looser lengths", Policy{MinLength: 12, MaxLength: 64}, Policy{MinLength: 8, MaxLength: 72}, Policy{MinLength: 12, MaxLength: 64}},
+		{"unlimited server length", Policy{}, Policy{MaxLength: 64}, Policy{MaxLength: 64}},
+		{"requirements add up", Policy{RequireLower: true, MinScore: 3}, Policy{RequireDigit: true, RejectBreached: true, MinScore: 1}, Policy{RequireLower: true, RequireDigit: true, RejectBreached: true, MinScore: 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.server.Merge(test.org); got != test.want {
+				t.Errorf("Merge = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	corpus := NewBloom(10, 0.001)
+	corpus.Add("Tr0ub4dor&3-horse")
+	checker := NewChecker(corpus)
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		personal []string
+		codes    []string
+	}{
+		{"accepted", DefaultPolicy(), "velvet-orbit-canyon-41", nil, nil},
+		{"too short", Policy{MinLength: 8}, "abc", nil, []string{"too_short"}},
+		{"runes not bytes", Policy{MinLength: 4}, "éèàù", nil, nil},
+		{"too long", Policy{MaxLength: 8}, "abcdefghij", nil, []string{"too_long"}},
+		{"classes", Policy{RequireLower: true, RequireUpper: true, RequireDigit: true, RequireSymbol: true}, "abc", nil, []string{"missing_upper", "missing_digit", "missing_symbol"}},
+		{"every class", Policy{RequireLower: true, RequireUpper: true, RequireDigit: true, RequireSymbol: true}, "aB3!", nil, nil},
+		{"name", Policy{BanPersonalInfo: true}, "xx-Gertrude-99", []string{"Gertrude", "Smith", "gertrude@example.com"}, []string{"personal_info"}},
+		{"email domain", Policy{BanPersonalInfo: true}, "acmecorp-2024!", []string{"al@acmecorp.io"}, []string{"personal_info"}},
+		{"short words are ignored", Policy{BanPersonalInfo: true}, "al-velvet-orbit", []string{"Al", "Bo"}, nil},
+		{"personal info allowed", Policy{}, "xx-Gertrude-99", []string{"Gertrude"}, nil},
+		{"weak", Policy{MinScore: 3}, "password1", nil, []string{"too_weak"}},
+		{"breached", Policy{RejectBreached: true}, "Tr0ub4dor&3-horse", nil, []string{"breached"}},
+		{"breach check off", Policy{}, "Tr0ub4dor&3-horse", nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checker.Check(test.policy, test.password, test.personal...)
+			if test.codes == nil {
+				if err != nil {
+					t.Errorf("Check: %v", err)
+				}
+				return
+			}
+			var violations Violations
+			if !errors.As(err, &violations) || !errors.Is(err, Rejected) {
+				t.Fatalf("Check = %v, want violations %v", err, test.codes)
+			}
+			if len(violations) != len(test.codes) {
+				t.Fatalf("Check = %+v, want violations %v", violations, test.codes)
+			}
+			for i, code := range test.codes {
+				if violations[i].Code != code {
+					t.Errorf("violation %d = %q, want %q", i, violations[i].Code, code)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckWithoutCorpus(t *testing.T) {
+	err := NewChecker(nil).Check(Policy{RejectBreached: true}, "Tr0ub4dor&3-horse")
+	if err != nil {
+		t.Errorf("Check without a corpus: %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+package password
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// common.txt lists frequent passwords and words, most frequent first
+//
+//go:embed common.txt
+var commonList string
+
+var commonRank = func() map[string]int {
+	ranks := make(map[string]int)
+	for _, word := range strings.Fields(commonList) {
+		if _, ok := ranks[word]; !ok {
+			ranks[word] = len(ranks) + 1
+		}
+	}
+	return ranks
+}()
+
+const (
+	// passwords longer than this are not cheaper to guess for being long,
+	// the estimate would only cost time
+	maxScoredLength = 64
+
+	bruteforceCardinality = 10
+	minSingleCharGuesses  = 10
+	minMultiCharGuesses   = 50
+	// growing a sequence of patterns costs the attacker this much per
+	// additional pattern
+	minGuessesPerPattern = 10000
+	referenceYear        = 2024
+	minYearSpace         = 20
+)
+
+var keyboardRows = []string{
+	"1234567890-=",
+	"qwertyuiop[]",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+var leet = strings.NewReplacer(
+	"4", "a", "@", "a", "8", "b", "3", "e", "6", "g", "1", "i", "!", "i",
+	"0", "o", "5", "s", "$", "s", "7", "t", "2", "z",
+)
+
+// match is a pattern found in password[i:j] and the guesses it costs
+type match struct {
+	i, j    int
+	guesses float64
+}
+
+// Score rates how hard password is to guess from 0, guessed within a
+// thousand tries, to 4, more than ten billion. Like zxcvbn it splits the
+// password into the cheapest sequence of patterns: common passwords and
+// words, the personal values, keyboard walks, sequences, repeats and years,
+// with brute force covering the rest.
+func Score(password string, personal ...string) int {
+	return scoreTokens(password, personalTokens(personal))
+}
+
+func scoreTokens(password string, personal []string) int {
+	guesses := Guesses(password, personal)
+	switch {
+	case guesses < 1e3+5:
+		return 0
+	case guesses < 1e6+5:
+		return 1
+	case guesses < 1e8+5:
+		return 2
+	case guesses < 1e10+5:
+		return 3
+	}
+	return 4
+}
+
+// Guesses estimates the number of guesses an attacker needs, personal are
+// lowercase words tried first
+func Guesses(password string, personal []string) float64 {
+	original := []rune(password)
+	if len(original) > maxScoredLength {
+		original = original[:maxScoredLength]
+	}
+	if len(original) == 0 {
+		return 1
+	}
+	lowered := []rune(strings.ToLower(string(original)))
+
+	matches := findMatches(original, lowered, personal)
+	return cheapestSequence(len(lowered), matches)
+}
+
+func findMatches(original []rune, lowered []rune, personal []string) []match {
+	n := len(lowered)
+	var matches []match
+
+	// brute force covers every substring so a sequence always exists
+	for i := 0; i < n; i++ {
+		for j := i + 1; j <= n; j++ {
+			guesses := math.Pow(bruteforceCardinality, float64(j-i))
+			matches = append(matches, match{i, j, bounded(guesses, j-i)})
+		}
+	}
+
+	personalRank := make(map[string]int, len(personal))
+	for _, word := range personal {
+		personalRank[word] = 1
+	}
+
+	for i := 0; i < n; i++ {
+		for j := i + 3; j <= n; j++ {
+			word := string(lowered[i:j])
+			variations := upperVariations(original[i:j])
+
+			if rank, ok := personalRank[word]; ok {
+				matches = append(matches, match{i, j, bounded(float64(rank)*variations, j-i)})
+			}
+			if rank, ok := commonRank[word]; ok {
+				matches = append(matches, match{i, j, bounded(float64(rank)*variations, j-i)})
+			}
+			if unleet := leet.Replace(word); unleet != word {
+				if rank, ok := commonRank[unleet]; ok {
+					matches = append(matches, match{i, j, bounded(float64(rank)*variations*2, j-i)})
+				}
+			}
+			if rank, ok := commonRank[reverse(word)]; ok {
+				matches = append(matches, match{i, j, bounded(float64(rank)*variations*2, j-i)})
+			}
+		}
+	}
+
+	matches = append(matches, sequenceMatches(lowered)...)
+	matches = append(matches, repeatMatches(lowered)...)
+	matches = append(matches, keyboardMatches(lowered)...)
+	matches = append(matches, yearMatches(lowered)...)
+	return matches
+}
+
+// cheapestSequence finds the sequence of matches covering the password
+// with the fewest guesses. Like zxcvbn a sequence of l patterns costs
+// l! * product(guesses) + minGuessesPerPattern^(l-1), the factorial counts
+// the orders an attacker tries the patterns in.
+func cheapestSequence(n int, matches []match) float64 {
+	byEnd := make([][]match, n+1)
+	for _, m := range matches {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	// best[l][k] is the smallest product of l matches covering [0, k)
+	best := make([][]float64, n+1)
+	for l := range best {
+		best[l] = make([]float64, n+1)
+		for k := range best[l] {
+			best[l][k] = math.Inf(1)
+		}
+	}
+	best[0][0] = 1
+
+	for k := 1; k <= n; k++ {
+		for _, m := range byEnd[k] {
+			for l := 1; l <= k; l++ {
+				if product := best[l-1][m.i] * m.guesses; product < best[l][k] {
+					best[l][k] = product
+				}
+			}
+		}
+	}
+
+	guesses := math.Inf(1)
+	factorial := 1.0
+	for l := 1; l <= n; l++ {
+		factorial *= float64(l)
+		total := factorial*best[l][n] + math.Pow(minGuessesPerPattern, float64(l-1))
+		if total < guesses {
+			guesses = total
+		}
+	}
+	return guesses
+}
+
+func bounded(guesses float64, length int) float64 {
+	minimum := float64(minMultiCharGuesses)
+	if length == 1 {
+		minimum = minSingleCharGuesses
+	}
+	return math.Max(guesses, minimum)
+}
+
+// upperVariations counts the capitalizations an attacker tries for a word,
+// a leading or an all caps word only doubles the guesses
+func upperVariations(word []rune) float64 {
+	var upper, lower int
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || (upper == 1 && unicode.IsUpper(word[0])) {
+		return 2
+	}
+
+	variations := 0.0
+	for i := 1; i <= upper && i <= lower; i++ {
+		variations += binomial(upper+lower, i)
+	}
+	return math.Max(variations, 1)
+}
+
+func binomial(n int, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+func reverse(word string) string {
+	runes := []rune(word)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// sequenceMatches finds runs of at least three characters with a constant
+// step of one, such as abc, 987 or xyz
+func sequenceMatches(password []rune) []match {
+	var matches []match
+	for i := 0; i < len(password); {
+		j := i + 1
+		var step rune
+		for j < len(password) {
+			delta := password[j] - password[j-1]
+			if delta != 1 && delta != -1 || (step != 0 && delta != step) {
+				break
+			}
+			step = delta
+			j++
+		}
+		if j-i >= 3 {
+			base := 26.0
+			if unicode.IsDigit(password[i]) {
+				base = 10
+			}
+			if strings.ContainsRune("a1z9", password[i]) {
+				base = 4
+			}
+			if step < 0 {
+				base *= 2
+			}
+			matches = append(matches, match{i, j, bounded(base*float64(j-i), j-i)})
+		}
+		if j-1 > i {
+			i = j - 1
+		} else {
+			i = j
+		}
+	}
+	return matches
+}
+
+// repeatMatches finds a block repeated back to back, such as aaa or
+// abcabc, it costs the guesses of the block times the repeats
+func repeatMatches(password []rune) []match {
+	var matches []match
+	n := len(password)
+	for i := 0; i < n; i++ {
+		for size := 1; i+2*size <= n; size++ {
+			block := string(password[i : i+size])
+			j := i + size
+			for j+size <= n && string(password[j:j+size]) == block {
+				j += size
+			}
+			repeats := (j - i) / size
+			if repeats < 2 || (size == 1 && repeats < 3) {
+				continue
+			}
+			blockGuesses := Guesses(block, nil)
+			matches = append(matches, match{i, j, bounded(blockGuesses*float64(repeats), j-i)})
+		}
+	}
+	return matches
+}
+
+// keyboardMatches finds walks of at least four adjacent keys on a qwerty
+// keyboard, every change of direction multiplies the guesses
+func keyboardMatches(password []rune) []match {
+	var matches []match
+	for i := 0; i < len(password); i++ {
+		j := i + 1
+		turns := 1
+		var direction int
+		for j < len(password) {
+			d, ok := keyDirection(password[j-1], password[j])
+			if !ok {
+				break
+			}
+			if direction != 0 && d != direction {
+				turns++
+			}
+			direction = d
+			j++
+		}
+		if j-i >= 4 {
+			guesses := 94 * float64(j-i) * math.Pow(4, float64(turns))
+			matches = append(matches, match{i, j, bounded(guesses, j-i)})
+		}
+	}
+	return matches
+}
+
+// keyDirection returns which neighbour of a on the keyboard b is, numbered
+// from 1 to 6
+func keyDirection(a rune, b rune) (int, bool) {
+	row, col, ok := keyPosition(a)
+	if !ok {
+		return 0, false
+	}
+	neighbours := [][2]int{{0, -1}, {0, 1}, {-1, 0}, {-1, 1}, {1, -1}, {1, 0}}
+	for d, offset := range neighbours {
+		r, c := row+offset[0], col+offset[1]
+		if r >= 0 && r < len(keyboardRows) && c >= 0 && c < len(keyboardRows[r]) && rune(keyboardRows[r][c]) == b {
+			return d + 1, true
+		}
+	}
+	return 0, false
+}
+
+func keyPosition(key rune) (int, int, bool) {
+	for row, keys := range keyboardRows {
+		if col := strings.IndexRune(keys, key); col >= 0 {
+			return row, col, true
+		}
+	}
+	return 0, 0, false
+}
+
+// yearMatches finds years from 1900 to 2099, recent ones are guessed first
+func yearMatches(password []rune) []match {
+	var matches []match
+	for i := 0; i+4 <= len(password); i++ {
+		year := 0
+		valid := true
+		for _, r := range password[i : i+4] {
+			if r < '0' || r > '9' {
+				valid = false
+				break
+			}
+			year = year*10 + int(r-'0')
+		}
+		if !valid || year < 1900 || year > 2099 {
+			continue
+		}
+		space := math.Max(math.Abs(float64(year-referenceYear)), minYearSpace)
+		matches = append(matches, match{i, i + 4, space})
+	}
+	return matches
+}
@@ -0,0 +1,680 @@
+// Package privacy carries out the rights of a user over their data: an
+// export of what is stored about them and the deletion of their account.
+//
+// A deletion is scheduled and happens once its grace period is over, until
+// then it can be cancelled. Deleting an account removes its memberships,
+// the organizations it was the only member of, the invites and emails sent
+// to its addresses, then redacts the audit events naming the user: their
+// ID and email addresses are replaced with a pseudonym and the client of
+// the events about them is dropped. The owner of an organization with
+// other members can't be deleted until they transfer the ownership.
+//
+// Users, organizations and members are first only marked deleted so they
+// can be restored. Once the retention window is over the purge deletes
+// them for good, a user is then erased like a deleted account.
+package privacy
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/organization"
+	"microauth.io/core/internal/user"
+)
+
+var (
+	DeletionNotFound      = errs.New(errs.NotFound, "account_deletion_not_found", "no account deletion is scheduled")
+	RequestDeletionFailed = errs.New(errs.Internal, "account_deletion_request_failed", "unable to schedule the account deletion")
+	CancelDeletionFailed  = errs.New(errs.Internal, "account_deletion_cancel_failed", "unable to cancel the account deletion")
+	FetchDeletionsFailed  = errs.New(errs.Internal, "fetch_account_deletions_failed", "unable to fetch account deletions")
+	DeletionFailed        = errs.New(errs.Internal, "account_deletion_failed", "unable to delete the account")
+	ExportFailed          = errs.New(errs.Internal, "account_export_failed", "unable to export the account")
+	DeletionCancelled     = "account deletion cancelled"
+	AccountDeleted        = "account deleted"
+)
+
+// Deletion is a scheduled account deletion
+type Deletion struct {
+	UserID string
+	// RequestedBy is the user themself or the operator who asked for it
+	RequestedBy string
+	RequestedAt int
+	// DeleteAfter ends the grace period, the account is deleted on the
+	// first run after it
+	DeleteAfter int
+}
+
+// Membership is a membership of the user with the name of its organization
+type Membership struct {
+	member.Member
+	OrganizationName string
+}
+
+// Session is a sign in of the user. Refresh tokens aren't stored, the
+// sessions are read back from the audit events.
+type Session struct {
+	IP        string
+	UserAgent string
+	CreatedAt int
+}
+
+// Archive is what is stored about a user
+type Archive struct {
+	Profile user.Profile
+	// Deletion is nil unless a deletion is scheduled
+	Deletion    *Deletion
+	Memberships []Membership
+	Sessions    []Session
+	// Events are the audit events naming the user, oldest first. The
+	// client of the events acted by someone else is left out.
+	Events     []audit.Event
+	ExportedAt int
+}
+
+type Store interface {
+	WithTx(context.Context, func(context.Context) error) error
+	GetUserByID(context.Context, string) (user.User, error)
+	// PurgeUser deletes the user for good with their email changes,
+	// provider identities and scheduled deletion
+	PurgeUser(context.Context, string) error
+	// FetchDeletedUsers returns the users deleted before the time
+	FetchDeletedUsers(context.Context, int) ([]user.User, error)
+	FetchMembersByUser(context.Context, string) ([]member.Member, error)
+	FetchAllMembers(context.Context, string) ([]member.Member, error)
+	DeleteMemberInvitesByEmail(context.Context, string) error
+	// DeleteOutboxMessagesTo deletes the emails queued or sent to the
+	// address alone
+	DeleteOutboxMessagesTo(context.Context, string) error
+	// PurgeUserMembers deletes for good the removed memberships of the
+	// user
+	PurgeUserMembers(context.Context, string) error
+	// PurgeMembers deletes for good the members removed before the time
+	PurgeMembers(context.Context, int) error
+	// FetchDeletedOrganizations returns the organizations deleted before
+	// the time
+	FetchDeletedOrganizations(context.Context, int) ([]organization.Organization, error)
+	// PurgeOrganization deletes the organization for good with its
+	// members, invites, teams, webhooks and provisioning
+	PurgeOrganization(context.Context, string) error
+	InsertAccountDeletion(context.Context, Deletion) error
+	GetAccountDeletion(context.Context, string) (Deletion, error)
+	// FetchAccountDeletions returns every scheduled deletion, the first
+	// due first
+	FetchAccountDeletions(context.Context) ([]Deletion, error)
+	DeleteAccountDeletion(context.Context, string) error
+	// FetchAuditEventsMentioning returns the events acted by one of the
+	// terms or naming one in their target or metadata, oldest first
+	FetchAuditEventsMentioning(context.Context, []string) ([]audit.Event, error)
+}
+
+type UserService interface {
+	GetProfile(context.Context, string) (user.Profile, error)
+	VerifyPassword(context.Context, string, string) error
+	DeleteUser(context.Context, string) (string, error)
+}
+
+// MemberService removes the memberships so they are audited and published
+// like any other removal
+type MemberService interface {
+	CheckRemoval(context.Context, member.Member) error
+	DeleteMember(context.Context, string, string) (string, error)
+}
+
+type OrganizationService interface {
+	GetOrganization(context.Context, string) (organization.Organization, error)
+	DeleteOrganization(context.Context, string) (string, error)
+}
+
+type Auditor interface {
+	Record(context.Context, audit.Event)
+	Redact(context.Context, audit.Event) error
+}
+
+type Options struct {
+	// GracePeriod is how long a scheduled deletion can be cancelled
+	GracePeriod time.Duration
+	// Interval is the interval between runs deleting the due accounts
+	Interval time.Duration
+	// Retention is how long what is deleted can be restored before it is
+	// purged
+	Retention time.Duration
+	// PurgeInterval is the interval between purges
+	PurgeInterval time.Duration
+}
+
+type Service struct {
+	store               Store
+	userService         UserService
+	memberService       MemberService
+	organizationService OrganizationService
+	auditor             Auditor
+	options             Options
+}
+
+func New(store Store, userService UserService, memberService MemberService, organizationService OrganizationService, auditor Auditor, options Options) *Service {
+	return &Service{
+		store:               store,
+		userService:         userService,
+		memberService:       memberService,
+		organizationService: organizationService,
+		auditor:             auditor,
+		options:             options,
+	}
+}
+
+func (s *Service) getUser(ctx context.Context, userID string) (user.User, error) {
+	u, err := s.store.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user.User{}, user.UnableToFindUser
+	}
+	if err != nil {
+		log.Println(err)
+		return user.User{}, err
+	}
+	return u, nil
+}
+
+// RequestDeletion schedules the deletion of the account of a signed in
+// user, who confirms it with their password
+func (s *Service) RequestDeletion(ctx context.Context, userID string, password string) (Deletion, error) {
+	err := s.userService.VerifyPassword(ctx, userID, password)
+	if err != nil {
+		return Deletion{}, err
+	}
+	return s.ScheduleDeletion(ctx, userID, userID)
+}
+
+// ScheduleDeletion schedules the deletion of the account on behalf of
+// requestedBy. A deletion already scheduled is returned unchanged.
+func (s *Service) ScheduleDeletion(ctx context.Context, userID string, requestedBy string) (Deletion, error) {
+	_, err := s.getUser(ctx, userID)
+	if errors.Is(err, user.UnableToFindUser) {
+		return Deletion{}, err
+	}
+	if err != nil {
+		return Deletion{}, RequestDeletionFailed
+	}
+
+	deletion, err := s.store.GetAccountDeletion(ctx, userID)
+	if err == nil {
+		return deletion, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		return Deletion{}, RequestDeletionFailed
+	}
+
+	// Refused now rather than once the grace period is over
+	memberships, err := s.store.FetchMembersByUser(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		return Deletion{}, RequestDeletionFailed
+	}
+	_, err = s.checkMemberships(ctx, memberships)
+	if err != nil {
+		return Deletion{}, err
+	}
+
+	now := time.Now()
+	deletion = Deletion{
+		UserID:      userID,
+		RequestedBy: requestedBy,
+		RequestedAt: int(now.Unix()),
+		DeleteAfter: int(now.Add(s.options.GracePeriod).Unix()),
+	}
+	err = s.store.InsertAccountDeletion(ctx, deletion)
+	if err != nil {
+		log.Println(err)
+		return Deletion{}, RequestDeletionFailed
+	}
+	s.auditor.Record(ctx, audit.Event{
+		Action:   audit.UserDeletionRequested,
+		Target:   audit.UserTarget(userID),
+		Metadata: map[string]string{"delete_after": strconv.Itoa(deletion.DeleteAfter)},
+	})
+	return deletion, nil
+}
+
+func (s *Service) GetDeletion(ctx context.Context, userID string) (Deletion, error) {
+	deletion, err := s.store.GetAccountDeletion(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Deletion{}, DeletionNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Deletion{}, FetchDeletionsFailed
+	}
+	return deletion, nil
+}
+
+// FetchDeletions returns every scheduled deletion, the first due first
+func (s *Service) FetchDeletions(ctx context.Context) ([]Deletion, error) {
+	deletions, err := s.store.FetchAccountDeletions(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, FetchDeletionsFailed
+	}
+	return deletions, nil
+}
+
+func (s *Service) CancelDeletion(ctx context.Context, userID string) (string, error) {
+	err := s.store.DeleteAccountDeletion(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", DeletionNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", CancelDeletionFailed
+	}
+	s.auditor.Record(ctx, audit.Event{
+		Action: audit.UserDeletionCancelled,
+		Target: audit.UserTarget(userID),
+	})
+	return DeletionCancelled, nil
+}
+
+// checkMemberships fails when the user can't leave one of the
+// organizations with other members, such as the one they own, see
+// member.Service.CheckRemoval. It returns the organizations the user is the
+// only member of.
+func (s *Service) checkMemberships(ctx context.Context, memberships []member.Member) ([]string, error) {
+	var sole []string
+	for _, membership := range memberships {
+		members, err := s.store.FetchAllMembers(ctx, membership.OrganizationID)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		if len(members) == 1 {
+			sole = append(sole, membership.OrganizationID)
+			continue
+		}
+
+		err = s.checkRemoval(ctx, membership)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sole, nil
+}
+
+// checkRemoval names the organization in the error when the member can't
+// leave it
+func (s *Service) checkRemoval(ctx context.Context, membership member.Member) error {
+	err := s.memberService.CheckRemoval(ctx, membership)
+	var refused *errs.Error
+	if errors.As(err, &refused) && refused.Kind == errs.Conflict {
+		org, err := s.organizationService.GetOrganization(ctx, membership.OrganizationID)
+		if err != nil {
+			return err
+		}
+		return errs.Detailed(refused, org.Name)
+	}
+	return err
+}
+
+// RemoveUser marks the account deleted after removing each of its
+// memberships like any other removal, so they are audited and published.
+// It is refused while the user owns an organization or is its last admin,
+// even as its only member. The memberships share the time of the account
+// so RestoreUser brings them back.
+func (s *Service) RemoveUser(ctx context.Context, userID string) (string, error) {
+	var result string
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		memberships, err := s.store.FetchMembersByUser(ctx, userID)
+		if err != nil {
+			log.Println(err)
+			return user.UserDeleteFailed
+		}
+		for _, membership := range memberships {
+			err = s.checkRemoval(ctx, membership)
+			if err != nil {
+				return err
+			}
+		}
+		for _, membership := range memberships {
+			_, err = s.memberService.DeleteMember(ctx, membership.OrganizationID, userID)
+			if err != nil {
+				return err
+			}
+		}
+		result, err = s.userService.DeleteUser(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// DeleteAccount deletes the account now, whether a deletion is scheduled
+// or not
+func (s *Service) DeleteAccount(ctx context.Context, userID string) (string, error) {
+	u, err := s.getUser(ctx, userID)
+	if errors.Is(err, user.UnableToFindUser) {
+		return "", err
+	}
+	if err != nil {
+		return "", DeletionFailed
+	}
+	pseudonym, err := newPseudonym()
+	if err != nil {
+		log.Println(err)
+		return "", DeletionFailed
+	}
+
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		memberships, err := s.store.FetchMembersByUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		sole, err := s.checkMemberships(ctx, memberships)
+		if err != nil {
+			return err
+		}
+		// the membership of a sole member goes with their organization
+		for _, membership := range memberships {
+			if includes(sole, membership.OrganizationID) {
+				continue
+			}
+			_, err = s.memberService.DeleteMember(ctx, membership.OrganizationID, userID)
+			if err != nil {
+				return err
+			}
+		}
+		for _, organizationID := range sole {
+			_, err = s.organizationService.DeleteOrganization(ctx, organizationID)
+			if err != nil {
+				return err
+			}
+		}
+		return s.erase(ctx, u, pseudonym)
+	})
+	if errors.Is(err, member.OwnerCannotBeRemoved) || errors.Is(err, member.LastAdmin) {
+		return "", err
+	}
+	if err != nil {
+		log.Println(err)
+		return "", DeletionFailed
+	}
+	s.auditor.Record(ctx, audit.Event{
+		Action: audit.UserDeleted,
+		Target: audit.UserTarget(pseudonym),
+	})
+	return AccountDeleted, nil
+}
+
+// erase deletes the user for good once they are a member of no
+// organization, with the invites and emails sent to their addresses, and
+// redacts the audit events naming them
+func (s *Service) erase(ctx context.Context, u user.User, pseudonym string) error {
+	err := s.store.PurgeUserMembers(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	terms, events, err := s.mentions(ctx, u)
+	if err != nil {
+		return err
+	}
+	for _, address := range terms[1:] {
+		err = s.store.DeleteMemberInvitesByEmail(ctx, address)
+		if err != nil {
+			return err
+		}
+		err = s.store.DeleteOutboxMessagesTo(ctx, address)
+		if err != nil {
+			return err
+		}
+	}
+	err = s.redact(ctx, u.ID, terms, events, pseudonym)
+	if err != nil {
+		return err
+	}
+	return s.store.PurgeUser(ctx, u.ID)
+}
+
+// newPseudonym names a deleted user in the redacted events, it is random
+// so the events of one user still read together without naming them
+func newPseudonym() (string, error) {
+	raw := make([]byte, 8)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	return "deleted-" + hex.EncodeToString(raw), nil
+}
+
+// mentions returns the ID and the email addresses of the user, current
+// and earlier ones read from their email changes, with the audit events
+// naming one of them
+func (s *Service) mentions(ctx context.Context, u user.User) ([]string, []audit.Event, error) {
+	terms := []string{u.ID, u.Email}
+	events, err := s.store.FetchAuditEventsMentioning(ctx, terms)
+	if err != nil {
+		return nil, nil, err
+	}
+	known := len(terms)
+	for _, event := range events {
+		if event.Target != audit.UserTarget(u.ID) {
+			continue
+		}
+		if event.Action != audit.UserEmailChanged && event.Action != audit.UserEmailChangeRequested {
+			continue
+		}
+		for _, key := range []string{"email", "previous_email"} {
+			if address := event.Metadata[key]; address != "" && !includes(terms, address) {
+				terms = append(terms, address)
+			}
+		}
+	}
+	if len(terms) > known {
+		events, err = s.store.FetchAuditEventsMentioning(ctx, terms)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return terms, events, nil
+}
+
+// redact replaces the terms naming the user with the pseudonym in the
+// events and drops the client of the events about them
+func (s *Service) redact(ctx context.Context, userID string, terms []string, events []audit.Event, pseudonym string) error {
+	pairs := make([]string, 0, 2*len(terms))
+	for _, term := range terms {
+		pairs = append(pairs, term, pseudonym)
+	}
+	replacer := strings.NewReplacer(pairs...)
+	for _, event := range events {
+		if event.ActorID == userID || event.Target == audit.UserTarget(userID) {
+			event.IP = ""
+			event.UserAgent = ""
+		}
+		event.ActorID = replacer.Replace(event.ActorID)
+		event.Target = replacer.Replace(event.Target)
+		metadata := make(map[string]string, len(event.Metadata))
+		for key, value := range event.Metadata {
+			metadata[key] = replacer.Replace(value)
+		}
+		event.Metadata = metadata
+		err := s.auditor.Redact(ctx, event)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func includes(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Run deletes the accounts whose grace period is over every Interval and
+// purges every PurgeInterval until the context is cancelled
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.options.Interval)
+	defer ticker.Stop()
+	purge := time.NewTicker(s.options.PurgeInterval)
+	defer purge.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.DeleteDue(ctx)
+		case <-purge.C:
+			s.Purge(ctx)
+		}
+	}
+}
+
+// DeleteDue deletes the accounts whose grace period is over. An account
+// that can't be deleted, such as the last administrator of an
+// organization, stays scheduled and is retried on the next run.
+func (s *Service) DeleteDue(ctx context.Context) {
+	deletions, err := s.store.FetchAccountDeletions(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	now := int(time.Now().Unix())
+	for _, deletion := range deletions {
+		if deletion.DeleteAfter > now {
+			break
+		}
+		_, err = s.DeleteAccount(ctx, deletion.UserID)
+		// a user an administrator deleted meanwhile is erased by the purge
+		if err != nil && !errors.Is(err, user.UnableToFindUser) {
+			log.Println("account", deletion.UserID, "was not deleted:", err)
+		}
+	}
+}
+
+// Purge deletes for good what was deleted before the retention window:
+// the organizations with their members, the removed members, then the
+// users, who are erased like a deleted account. What fails is retried on
+// the next purge.
+func (s *Service) Purge(ctx context.Context) {
+	before := int(time.Now().Add(-s.options.Retention).Unix())
+
+	organizations, err := s.store.FetchDeletedOrganizations(ctx, before)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, org := range organizations {
+		err = s.store.PurgeOrganization(ctx, org.ID)
+		if err != nil {
+			log.Println("organization", org.ID, "was not purged:", err)
+			continue
+		}
+		s.auditor.Record(ctx, audit.Event{
+			OrganizationID: org.ID,
+			Action:         audit.OrganizationPurged,
+			Target:         audit.OrganizationTarget(org.ID),
+		})
+	}
+
+	err = s.store.PurgeMembers(ctx, before)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	users, err := s.store.FetchDeletedUsers(ctx, before)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, u := range users {
+		pseudonym, err := newPseudonym()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		err = s.store.WithTx(ctx, func(ctx context.Context) error {
+			return s.erase(ctx, u, pseudonym)
+		})
+		if err != nil {
+			log.Println("user", u.ID, "was not purged:", err)
+			continue
+		}
+		s.auditor.Record(ctx, audit.Event{
+			Action: audit.UserDeleted,
+			Target: audit.UserTarget(pseudonym),
+		})
+	}
+}
+
+// Export gathers what is stored about the user
+func (s *Service) Export(ctx context.Context, userID string) (Archive, error) {
+	profile, err := s.userService.GetProfile(ctx, userID)
+	if err != nil {
+		return Archive{}, err
+	}
+	archive := Archive{
+		Profile:     profile,
+		Memberships: []Membership{},
+		Sessions:    []Session{},
+		ExportedAt:  int(time.Now().Unix()),
+	}
+
+	deletion, err := s.store.GetAccountDeletion(ctx, userID)
+	if err == nil {
+		archive.Deletion = &deletion
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		return Archive{}, ExportFailed
+	}
+
+	memberships, err := s.store.FetchMembersByUser(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		return Archive{}, ExportFailed
+	}
+	for _, membership := range memberships {
+		org, err := s.organizationService.GetOrganization(ctx, membership.OrganizationID)
+		if err != nil {
+			return Archive{}, ExportFailed
+		}
+		archive.Memberships = append(archive.Memberships, Membership{Member: membership, OrganizationName: org.Name})
+	}
+
+	events, err := s.store.FetchAuditEventsMentioning(ctx, []string{userID, profile.Email})
+	if err != nil {
+		log.Println(err)
+		return Archive{}, ExportFailed
+	}
+	for i, event := range events {
+		if event.ActorID != userID {
+			events[i].IP = ""
+			events[i].UserAgent = ""
+			continue
+		}
+		if event.Action == audit.UserLoggedIn {
+			archive.Sessions = append(archive.Sessions, Session{IP: event.IP, UserAgent: event.UserAgent, CreatedAt: event.CreatedAt})
+		}
+	}
+	archive.Events = events
+
+	s.auditor.Record(ctx, audit.Event{
+		Action: audit.UserDataExported,
+		Target: audit.UserTarget(userID),
+	})
+	return archive, nil
+}
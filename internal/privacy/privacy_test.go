@@ -0,0 +1,444 @@
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/organization"
+	"microauth.io/core/internal/user"
+)
+
+// privacyStore keeps the users, members and audit events, and records what
+// was deleted for good
+type privacyStore struct {
+	Store
+	users         map[string]user.User
+	members       []member.Member
+	events        []audit.Event
+	deletion      *Deletion
+	deletedUsers  []user.User
+	deletedOrgs   []organization.Organization
+	purgedUsers   []string
+	purgedOrgs    []string
+	purgedMembers bool
+	invites       []string
+	outbox        []string
+}
+
+func (s *privacyStore) WithTx(ctx context.Context, f func(context.Context) error) error {
+	return f(ctx)
+}
+
+func (s *privacyStore) GetUserByID(ctx context.Context, id string) (user.User, error) {
+	u, ok := s.users[id]
+	if !ok {
+		return user.User{}, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (s *privacyStore) PurgeUser(ctx context.Context, id string) error {
+	s.purgedUsers = append(s.purgedUsers, id)
+	delete(s.users, id)
+	return nil
+}
+
+func (s *privacyStore) FetchDeletedUsers(ctx context.Context, before int) ([]user.User, error) {
+	return s.deletedUsers, nil
+}
+
+func (s *privacyStore) FetchMembersByUser(ctx context.Context, userID string) ([]member.Member, error) {
+	members := []member.Member{}
+	for _, m := range s.members {
+		if m.UserID == userID {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+func (s *privacyStore) FetchAllMembers(ctx context.Context, organizationID string) ([]member.Member, error) {
+	members := []member.Member{}
+	for _, m := range s.members {
+		if m.OrganizationID == organizationID {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+func (s *privacyStore) DeleteMemberInvitesByEmail(ctx context.Context, email string) error {
+	s.invites = append(s.invites, email)
+	return nil
+}
+
+func (s *privacyStore) DeleteOutboxMessagesTo(ctx context.Context, address string) error {
+	s.outbox = append(s.outbox, address)
+	return nil
+}
+
+func (s *privacyStore) PurgeUserMembers(ctx context.Context, userID string) error {
+	return nil
+}
+
+func (s *privacyStore) PurgeMembers(ctx context.Context, before int) error {
+	s.purgedMembers = true
+	return nil
+}
+
+func (s *privacyStore) FetchDeletedOrganizations(ctx context.Context, before int) ([]organization.Organization, error) {
+	return s.deletedOrgs, nil
+}
+
+func (s *privacyStore) PurgeOrganization(ctx context.Context, id string) error {
+	s.purgedOrgs = append(s.purgedOrgs, id)
+	return nil
+}
+
+func (s *privacyStore) GetAccountDeletion(ctx context.Context, userID string) (Deletion, error) {
+	if s.deletion == nil || s.deletion.UserID != userID {
+		return Deletion{}, sql.ErrNoRows
+	}
+	return *s.deletion, nil
+}
+
+func (s *privacyStore) InsertAccountDeletion(ctx context.Context, deletion Deletion) error {
+	s.deletion = &deletion
+	return nil
+}
+
+func (s *privacyStore) FetchAuditEventsMentioning(ctx context.Context, terms []string) ([]audit.Event, error) {
+	events := []audit.Event{}
+	for _, event := range s.events {
+		if mentions(event, terms) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func mentions(event audit.Event, terms []string) bool {
+	values := []string{event.ActorID, event.Target}
+	for _, value := range event.Metadata {
+		values = append(values, value)
+	}
+	for _, value := range values {
+		for _, term := range terms {
+			if strings.Contains(value, term) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// memberService refuses the removals of its refused organizations and
+// otherwise removes the member from the store
+type memberService struct {
+	store   *privacyStore
+	refused map[string]error
+}
+
+func (s memberService) CheckRemoval(ctx context.Context, m member.Member) error {
+	return s.refused[m.OrganizationID]
+}
+
+func (s memberService) DeleteMember(ctx context.Context, organizationID string, userID string) (string, error) {
+	members := []member.Member{}
+	for _, m := range s.store.members {
+		if m.OrganizationID != organizationID || m.UserID != userID {
+			members = append(members, m)
+		}
+	}
+	s.store.members = members
+	return "", nil
+}
+
+// organizationService names the organizations by their ID and records the
+// deleted ones
+type organizationService struct {
+	deleted *[]string
+}
+
+func (s organizationService) GetOrganization(ctx context.Context, id string) (organization.Organization, error) {
+	return organization.Organization{ID: id, Name: strings.ToUpper(id[:1]) + id[1:]}, nil
+}
+
+func (s organizationService) DeleteOrganization(ctx context.Context, id string) (string, error) {
+	*s.deleted = append(*s.deleted, id)
+	return "", nil
+}
+
+// userService marks the users deleted in the store
+type userService struct {
+	store   *privacyStore
+	deleted *[]string
+}
+
+func (s userService) GetProfile(ctx context.Context, id string) (user.Profile, error) {
+	u, err := s.store.GetUserByID(ctx, id)
+	if err != nil {
+		return user.Profile{}, user.UnableToFindUser
+	}
+	return user.Profile{User: u}, nil
+}
+
+func (s userService) VerifyPassword(ctx context.Context, id string, password string) error {
+	return nil
+}
+
+func (s userService) DeleteUser(ctx context.Context, id string) (string, error) {
+	*s.deleted = append(*s.deleted, id)
+	return "", nil
+}
+
+// auditor records the events and the redacted ones
+type auditor struct {
+	recorded []audit.Event
+	redacted []audit.Event
+}
+
+func (a *auditor) Record(ctx context.Context, event audit.Event) {
+	a.recorded = append(a.recorded, event)
+}
+
+func (a *auditor) Redact(ctx context.Context, event audit.Event) error {
+	a.redacted = append(a.redacted, event)
+	return nil
+}
+
+// fixture is a service over a store with ada, who shares the shared
+// organization with bob and is alone in the solo one
+type fixture struct {
+	service              *Service
+	store                *privacyStore
+	auditor              *auditor
+	deletedOrganizations []string
+	deletedUsers         []string
+}
+
+const (
+	ada = "user-ada"
+	bob = "user-bob"
+)
+
+func newFixture(refused map[string]error) *fixture {
+	f := &fixture{
+		store: &privacyStore{
+			users: map[string]user.User{
+				ada: {ID: ada, Email: "ada@example.com"},
+				bob: {ID: bob, Email: "bob@example.com"},
+			},
+			members: []member.Member{
+				{OrganizationID: "shared", UserID: ada, Role: member.Admin},
+				{OrganizationID: "shared", UserID: bob, Role: member.Owner},
+				{OrganizationID: "solo", UserID: ada, Role: member.Owner},
+			},
+		},
+		auditor: &auditor{},
+	}
+	f.service = New(
+		f.store,
+		userService{store: f.store, deleted: &f.deletedUsers},
+		memberService{store: f.store, refused: refused},
+		organizationService{deleted: &f.deletedOrganizations},
+		f.auditor,
+		Options{GracePeriod: time.Hour, Retention: time.Hour},
+	)
+	return f
+}
+
+func TestDeleteAccount(t *testing.T) {
+	f := newFixture(nil)
+	f.store.events = []audit.Event{
+		{ID: 1, ActorID: ada, Action: audit.UserLoggedIn, Target: audit.UserTarget(ada), IP: "192.0.2.1", UserAgent: "curl"},
+		{ID: 2, ActorID: ada, Action: audit.UserEmailChanged, Target: audit.UserTarget(ada), Metadata: map[string]string{"email": "ada@example.com", "previous_email": "lovelace@example.com"}},
+		{ID: 3, ActorID: bob, OrganizationID: "shared", Action: audit.MemberInvited, Target: "invite:lovelace@example.com", IP: "192.0.2.2", UserAgent: "firefox"},
+		{ID: 4, ActorID: bob, Action: audit.UserLoggedIn, Target: audit.UserTarget(bob), IP: "192.0.2.2"},
+	}
+
+	result, err := f.service.DeleteAccount(context.Background(), ada)
+	if err != nil || result != AccountDeleted {
+		t.Fatalf("DeleteAccount = %q, %v", result, err)
+	}
+
+	if !reflect.DeepEqual(f.deletedOrganizations, []string{"solo"}) {
+		t.Errorf("deleted organizations = %v, want the solo one", f.deletedOrganizations)
+	}
+	if members, _ := f.store.FetchMembersByUser(context.Background(), ada); len(members) != 1 || members[0].OrganizationID != "solo" {
+		t.Errorf("memberships left = %+v, want the one of the solo organization", members)
+	}
+	if !reflect.DeepEqual(f.store.purgedUsers, []string{ada}) {
+		t.Errorf("purged users = %v, want ada", f.store.purgedUsers)
+	}
+	addresses := []string{"ada@example.com", "lovelace@example.com"}
+	if !reflect.DeepEqual(f.store.invites, addresses) || !reflect.DeepEqual(f.store.outbox, addresses) {
+		t.Errorf("invites deleted for %v and emails for %v, want %v", f.store.invites, f.store.outbox, addresses)
+	}
+
+	if len(f.auditor.redacted) != 3 {
+		t.Fatalf("redacted %d events, want the 3 naming ada", len(f.auditor.redacted))
+	}
+	pseudonym := strings.TrimPrefix(f.auditor.recorded[len(f.auditor.recorded)-1].Target, "user:")
+	for _, event := range f.auditor.redacted {
+		if mentions(event, append(addresses, ada)) {
+			t.Errorf("event %d still names ada: %+v", event.ID, event)
+		}
+		if !mentions(event, []string{pseudonym}) {
+			t.Errorf("event %d doesn't name the pseudonym %s: %+v", event.ID, pseudonym, event)
+		}
+		// bob invited ada's earlier address, his client stays
+		if cleared := event.IP == "" && event.UserAgent == ""; cleared != (event.ID != 3) {
+			t.Errorf("event %d client is %q %q", event.ID, event.IP, event.UserAgent)
+		}
+	}
+	if last := f.auditor.recorded[len(f.auditor.recorded)-1]; last.Action != audit.UserDeleted || !strings.HasPrefix(pseudonym, "deleted-") {
+		t.Errorf("last event = %+v, want the deletion of the pseudonym", last)
+	}
+}
+
+func TestDeletionRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		refused map[string]error
+		err     error
+	}{
+		{"owner", map[string]error{"shared": member.OwnerCannotBeRemoved}, member.OwnerCannotBeRemoved},
+		{"last admin", map[string]error{"shared": member.LastAdmin}, member.LastAdmin},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actions := map[string]func(*Service) error{
+				"DeleteAccount": func(s *Service) error {
+					_, err := s.DeleteAccount(context.Background(), ada)
+					return err
+				},
+				"RemoveUser": func(s *Service) error {
+					_, err := s.RemoveUser(context.Background(), ada)
+					return err
+				},
+				"ScheduleDeletion": func(s *Service) error {
+					_, err := s.ScheduleDeletion(context.Background(), ada, ada)
+					return err
+				},
+			}
+			for name, action := range actions {
+				f := newFixture(test.refused)
+				err := action(f.service)
+				if !errors.Is(err, test.err) {
+					t.Fatalf("%s: got %v, want %v", name, err, test.err)
+				}
+				if !strings.HasSuffix(err.Error(), ": Shared") {
+					t.Errorf("%s: %q doesn't name the organization", name, err)
+				}
+				if len(f.store.members) != 3 || len(f.deletedOrganizations) != 0 || len(f.deletedUsers) != 0 || len(f.store.purgedUsers) != 0 || f.store.deletion != nil {
+					t.Errorf("%s changed the store after refusing", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveUserSoleMember(t *testing.T) {
+	// RemoveUser checks every membership, even of a sole member
+	f := newFixture(map[string]error{"solo": member.OwnerCannotBeRemoved})
+	if _, err := f.service.RemoveUser(context.Background(), ada); !errors.Is(err, member.OwnerCannotBeRemoved) {
+		t.Fatalf("RemoveUser: got %v, want OwnerCannotBeRemoved", err)
+	}
+
+	// DeleteAccount deletes the organization instead
+	f = newFixture(map[string]error{"solo": member.OwnerCannotBeRemoved})
+	if _, err := f.service.DeleteAccount(context.Background(), ada); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	if !reflect.DeepEqual(f.deletedOrganizations, []string{"solo"}) {
+		t.Errorf("deleted organizations = %v, want the solo one", f.deletedOrganizations)
+	}
+}
+
+func TestRemoveUser(t *testing.T) {
+	f := newFixture(nil)
+	if _, err := f.service.RemoveUser(context.Background(), ada); err != nil {
+		t.Fatalf("RemoveUser: %v", err)
+	}
+	if members, _ := f.store.FetchMembersByUser(context.Background(), ada); len(members) != 0 {
+		t.Errorf("memberships left = %+v", members)
+	}
+	if !reflect.DeepEqual(f.deletedUsers, []string{ada}) || len(f.store.purgedUsers) != 0 {
+		t.Errorf("deleted users = %v and purged %v, want ada only marked deleted", f.deletedUsers, f.store.purgedUsers)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	f := newFixture(nil)
+	f.store.deletedOrgs = []organization.Organization{{ID: "gone"}}
+	f.store.deletedUsers = []user.User{f.store.users[bob]}
+	f.store.events = []audit.Event{
+		{ID: 1, ActorID: bob, Action: audit.UserLoggedIn, Target: audit.UserTarget(bob), IP: "192.0.2.2"},
+	}
+
+	f.service.Purge(context.Background())
+
+	if !reflect.DeepEqual(f.store.purgedOrgs, []string{"gone"}) || !f.store.purgedMembers {
+		t.Errorf("purged organizations %v and members %v, want gone and the members", f.store.purgedOrgs, f.store.purgedMembers)
+	}
+	if !reflect.DeepEqual(f.store.purgedUsers, []string{bob}) {
+		t.Errorf("purged users = %v, want bob", f.store.purgedUsers)
+	}
+	if len(f.auditor.redacted) != 1 || mentions(f.auditor.redacted[0], []string{bob}) || f.auditor.redacted[0].IP != "" {
+		t.Errorf("redacted = %+v, want the login of bob without naming him", f.auditor.redacted)
+	}
+	actions := []string{}
+	for _, event := range f.auditor.recorded {
+		actions = append(actions, event.Action)
+	}
+	if want := []string{audit.OrganizationPurged, audit.UserDeleted}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("recorded %v, want %v", actions, want)
+	}
+}
+
+func TestExport(t *testing.T) {
+	f := newFixture(nil)
+	f.store.deletion = &Deletion{UserID: ada, RequestedBy: ada}
+	f.store.events = []audit.Event{
+		{ID: 1, ActorID: ada, Action: audit.UserLoggedIn, Target: audit.UserTarget(ada), IP: "192.0.2.1", UserAgent: "curl", CreatedAt: 10},
+		{ID: 2, ActorID: bob, OrganizationID: "shared", Action: audit.MemberRoleChanged, Target: audit.UserTarget(ada), IP: "192.0.2.2", UserAgent: "firefox"},
+		{ID: 3, ActorID: bob, Action: audit.UserLoggedIn, Target: audit.UserTarget(bob), IP: "192.0.2.2"},
+	}
+
+	archive, err := f.service.Export(context.Background(), ada)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if archive.Profile.Email != "ada@example.com" || archive.Deletion == nil {
+		t.Errorf("archive = %+v, want the profile and deletion of ada", archive)
+	}
+	names := []string{}
+	for _, membership := range archive.Memberships {
+		names = append(names, membership.OrganizationName)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"Shared", "Solo"}) {
+		t.Errorf("memberships = %v, want Shared and Solo", names)
+	}
+	if want := []Session{{IP: "192.0.2.1", UserAgent: "curl", CreatedAt: 10}}; !reflect.DeepEqual(archive.Sessions, want) {
+		t.Errorf("sessions = %+v, want %+v", archive.Sessions, want)
+	}
+	if len(archive.Events) != 2 {
+		t.Fatalf("events = %+v, want the 2 naming ada", archive.Events)
+	}
+	if other := archive.Events[1]; other.IP != "" || other.UserAgent != "" {
+		t.Errorf("event acted by bob kept his client %s %s", other.IP, other.UserAgent)
+	}
+	if _, err := f.service.Export(context.Background(), "user-nobody"); !errors.Is(err, user.UnableToFindUser) {
+		t.Errorf("Export of an unknown user: got %v, want UnableToFindUser", err)
+	}
+}
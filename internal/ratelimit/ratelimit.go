@@ -0,0 +1,185 @@
+// Package ratelimit throttles requests with token buckets. A bucket holds
+// up to Limit tokens and regains them over Period, each request takes one.
+// A bucket is stored as the time it is full again, a single number an
+// upsert can advance atomically, so replicas sharing a database share their
+// limits.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"microauth.io/core/internal/errs"
+)
+
+var (
+	RateLimited = errs.New(errs.Throttled, "rate_limited", "too many requests, slow down")
+	InvalidRate = errors.New("invalid rate")
+)
+
+// Rate lets Limit requests through per Period, all at once when the bucket
+// is full. The zero Rate is unlimited.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParseRate reads a rate written limit/period, such as 10/1m. An empty
+// string or off is unlimited.
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return Rate{}, nil
+	}
+	limit, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("%w: %q is not limit/period", InvalidRate, s)
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 {
+		return Rate{}, fmt.Errorf("%w: %q needs a positive limit", InvalidRate, s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d < time.Millisecond*time.Duration(n) {
+		return Rate{}, fmt.Errorf("%w: %q needs a period of at least a millisecond per request", InvalidRate, s)
+	}
+	return Rate{Limit: n, Period: d}, nil
+}
+
+func (r Rate) String() string {
+	if r.Unlimited() {
+		return "off"
+	}
+	return strconv.Itoa(r.Limit) + "/" + r.Period.String()
+}
+
+func (r Rate) Unlimited() bool {
+	return r.Limit == 0
+}
+
+// interval is the time in milliseconds to regain one token
+func (r Rate) interval() int64 {
+	return r.Period.Milliseconds() / int64(r.Limit)
+}
+
+// Bucket is the time in unix milliseconds a bucket is full again, a bucket
+// full in the past is simply full
+type Bucket struct {
+	Key    string
+	FullAt int64
+}
+
+// Take takes a token if the bucket holds one. Stores without an atomic
+// upsert call it under their own lock.
+func (b Bucket) Take(now int64, interval int64, capacity int) (Bucket, bool) {
+	if b.FullAt < now {
+		b.FullAt = now
+	}
+	if b.FullAt+interval > now+int64(capacity)*interval {
+		return b, false
+	}
+	b.FullAt += interval
+	return b, true
+}
+
+type Store interface {
+	// TakeToken takes a token from the bucket of the key when it holds one,
+	// a missing bucket is full. The bucket is returned either way.
+	TakeToken(ctx context.Context, key string, now int64, interval int64, capacity int) (Bucket, bool, error)
+	// PurgeBuckets deletes the buckets full before the given time, they are
+	// no different from missing ones
+	PurgeBuckets(ctx context.Context, before int64) (int, error)
+}
+
+// Result describes a bucket after a request, for the RateLimit headers
+type Result struct {
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next token when none is left
+	RetryAfter time.Duration
+}
+
+// Limited is returned when a bucket is empty, the client may retry after
+// RetryAfter
+type Limited struct {
+	Result Result
+}
+
+func (l *Limited) Error() string {
+	return RateLimited.Message
+}
+
+func (l *Limited) Unwrap() error {
+	return RateLimited
+}
+
+func (l *Limited) RetryAfter() time.Duration {
+	return l.Result.RetryAfter
+}
+
+type Service struct {
+	store         Store
+	purgeInterval time.Duration
+}
+
+func New(store Store, purgeInterval time.Duration) *Service {
+	return &Service{
+		store:         store,
+		purgeInterval: purgeInterval,
+	}
+}
+
+// Take takes a token for key from the bucket of the policy and returns a
+// Limited error when there is none. A store failure lets the request
+// through, an outage of the limiter must not take the endpoints down.
+func (s *Service) Take(ctx context.Context, policy string, rate Rate, key string) (Result, error) {
+	now := time.Now().UnixMilli()
+	interval := rate.interval()
+	bucket, ok, err := s.store.TakeToken(ctx, policy+":"+key, now, interval, rate.Limit)
+	if err != nil {
+		log.Println(err)
+		return Result{Limit: rate.Limit, Remaining: rate.Limit}, nil
+	}
+
+	fullAt := bucket.FullAt
+	if fullAt < now {
+		fullAt = now
+	}
+	missing := (fullAt - now + interval - 1) / interval
+	result := Result{
+		Limit:     rate.Limit,
+		Remaining: rate.Limit - int(missing),
+		Reset:     time.Duration(fullAt-now) * time.Millisecond,
+	}
+	if !ok {
+		result.Remaining = 0
+		result.RetryAfter = time.Duration(fullAt-int64(rate.Limit-1)*interval-now) * time.Millisecond
+		return result, &Limited{Result: result}
+	}
+	return result, nil
+}
+
+// Run deletes the full buckets every purge interval until the context is
+// cancelled
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.purgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := s.store.PurgeBuckets(ctx, time.Now().UnixMilli())
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rate
+		err  error
+	}{
+		{"", Rate{}, nil},
+		{"off", Rate{}, nil},
+		{" 10/1m ", Rate{Limit: 10, Period: time.Minute}, nil},
+		{"5/1h", Rate{Limit: 5, Period: time.Hour}, nil},
+		{"10", Rate{}, InvalidRate},
+		{"0/1m", Rate{}, InvalidRate},
+		{"-1/1m", Rate{}, InvalidRate},
+		{"ten/1m", Rate{}, InvalidRate},
+		{"10/minute", Rate{}, InvalidRate},
+		{"10/5ms", Rate{}, InvalidRate},
+	}
+	for _, test := range tests {
+		got, err := ParseRate(test.in)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("ParseRate(%q) = %v, %v, want %v, %v", test.in, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestRateString(t *testing.T) {
+	for _, in := range []string{"off", "10/1m0s", "5/1h0m0s"} {
+		rate, err := ParseRate(in)
+		if err != nil {
+			t.Fatalf("ParseRate(%q): %v", in, err)
+		}
+		if rate.String() != in {
+			t.Errorf("ParseRate(%q).String() = %q", in, rate.String())
+		}
+	}
+}
+
+func TestBucketTake(t *testing.T) {
+	// 3 tokens, one every 10ms
+	const interval, capacity = 10, 3
+	tests := []struct {
+		name   string
+		fullAt int64
+		now    int64
+		want   int64
+		ok     bool
+	}{
+		{"missing bucket", 0, 1000, 1010, true},
+		{"full in the past", 500, 1000, 1010, true},
+		{"one token left", 1020, 1000, 1030, true},
+		{"empty", 1030, 1000, 1030, false},
+		{"refilled one token", 1030, 1010, 1040, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := Bucket{FullAt: test.fullAt}.Take(test.now, interval, capacity)
+			if got.FullAt != test.want || ok != test.ok {
+				t.Errorf("Take = %d, %v, want %d, %v", got.FullAt, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+// bucketStore keeps the buckets in a map, like the memory store
+type bucketStore struct {
+	buckets map[string]Bucket
+	err     error
+}
+
+func (s *bucketStore) TakeToken(ctx context.Context, key string, now int64, interval int64, capacity int) (Bucket, bool, error) {
+	if s.err != nil {
+		return Bucket{}, false, s.err
+	}
+	bucket, ok := s.buckets[key].Take(now, interval, capacity)
+	bucket.Key = key
+	s.buckets[key] = bucket
+	return bucket, ok, nil
+}
+
+func (s *bucketStore) PurgeBuckets(ctx context.Context, before int64) (int, error) {
+	purged := 0
+	for key, bucket := range s.buckets {
+		if bucket.FullAt < before {
+			delete(s.buckets, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func TestTake(t *testing.T) {
+	ctx := context.Background()
+	store := &bucketStore{buckets: map[string]Bucket{}}
+	service := New(store, time.Hour)
+	rate := Rate{Limit: 3, Period: time.Hour}
+
+	for i, remaining := range []int{2, 1, 0} {
+		result, err := service.Take(ctx, "login", rate, "192.0.2.1")
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		if result.Limit != 3 || result.Remaining != remaining {
+			t.Errorf("request %d = %+v, want %d remaining", i+1, result, remaining)
+		}
+	}
+
+	result, err := service.Take(ctx, "login", rate, "192.0.2.1")
+	var limited *Limited
+	if !errors.As(err, &limited) || !errors.Is(err, RateLimited) {
+		t.Fatalf("request 4: got %v, want Limited", err)
+	}
+	if result.Remaining != 0 || limited.RetryAfter() <= 0 || limited.RetryAfter() > 20*time.Minute {
+		t.Errorf("request 4 = %+v, want a retry within the 20 minutes of a token", result)
+	}
+
+	if _, err := service.Take(ctx, "login", rate, "192.0.2.2"); err != nil {
+		t.Errorf("another key: %v", err)
+	}
+	if _, err := service.Take(ctx, "signup", rate, "192.0.2.1"); err != nil {
+		t.Errorf("another policy: %v", err)
+	}
+}
+
+func TestTakeStoreFailure(t *testing.T) {
+	store := &bucketStore{err: errors.New("connection refused")}
+	service := New(store, time.Hour)
+
+	result, err := service.Take(context.Background(), "login", Rate{Limit: 3, Period: time.Minute}, "192.0.2.1")
+	if err != nil || result.Remaining != 3 {
+		t.Errorf("Take with a failing store = %+v, %v, want the request let through", result, err)
+	}
+}
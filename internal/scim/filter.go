@@ -0,0 +1,367 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"microauth.io/core/internal/errs"
+)
+
+// Filters follow RFC 7644 section 3.4.2.2: attribute expressions joined
+// with and, or, not and parentheses. Value paths in brackets are only
+// understood in PATCH paths, see patch.go. Strings compare without case.
+
+type expression interface {
+	match(map[string]interface{}) bool
+}
+
+type comparison struct {
+	path     []string
+	operator string
+	value    interface{}
+}
+
+type logical struct {
+	and         bool
+	left, right expression
+}
+
+type negation struct {
+	expression expression
+}
+
+var operators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// parseFilter returns nil for an empty filter, it matches everything
+func parseFilter(filter string) (expression, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.position < len(p.tokens) {
+		return nil, errs.Detailed(InvalidFilter, "unexpected "+p.tokens[p.position])
+	}
+	return e, nil
+}
+
+// matches reports whether the filter selects the attributes, a nil filter
+// selects everything
+func matches(e expression, values map[string]interface{}) bool {
+	return e == nil || e.match(values)
+}
+
+// tokenize splits the filter into words, parentheses and quoted strings,
+// which keep their quotes
+func tokenize(filter string) ([]string, error) {
+	tokens := []string{}
+	runes := []rune(filter)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '"':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(runes) {
+				return nil, errs.Detailed(InvalidFilter, "unterminated string")
+			}
+			tokens = append(tokens, string(runes[i:j+1]))
+			i = j + 1
+		case r == '[' || r == ']':
+			return nil, errs.Detailed(InvalidFilter, "value paths are not supported in filters")
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("()\"[]", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens   []string
+	position int
+}
+
+func (p *parser) peek() string {
+	if p.position < len(p.tokens) {
+		return p.tokens[p.position]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	p.position++
+	return token
+}
+
+func (p *parser) or() (expression, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (expression, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (expression, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, errs.Detailed(InvalidFilter, "unexpected end of filter")
+	case strings.EqualFold(token, "not"):
+		if p.next() != "(" {
+			return nil, errs.Detailed(InvalidFilter, "not must be followed by a parenthesis")
+		}
+		e, err := p.group()
+		if err != nil {
+			return nil, err
+		}
+		return negation{expression: e}, nil
+	case token == "(":
+		return p.group()
+	}
+	return p.comparison(token)
+}
+
+// group parses the rest of a parenthesized filter
+func (p *parser) group() (expression, error) {
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.next() != ")" {
+		return nil, errs.Detailed(InvalidFilter, "missing closing parenthesis")
+	}
+	return e, nil
+}
+
+func (p *parser) comparison(attribute string) (expression, error) {
+	if attribute == ")" || operators[strings.ToLower(attribute)] {
+		return nil, errs.Detailed(InvalidFilter, "unexpected "+attribute)
+	}
+	operator := strings.ToLower(p.next())
+	if !operators[operator] {
+		return nil, errs.Detailed(InvalidFilter, "unknown operator "+operator)
+	}
+	c := comparison{path: attributePath(attribute), operator: operator}
+	if operator == "pr" {
+		return c, nil
+	}
+
+	raw := p.next()
+	if raw == "" || raw == "(" || raw == ")" {
+		return nil, errs.Detailed(InvalidFilter, "missing value after "+operator)
+	}
+	if strings.HasPrefix(raw, "\"") {
+		var value string
+		err := json.Unmarshal([]byte(raw), &value)
+		if err != nil {
+			return nil, errs.Detailed(InvalidFilter, "invalid string "+raw)
+		}
+		c.value = value
+		return c, nil
+	}
+	var value interface{}
+	err := json.Unmarshal([]byte(strings.ToLower(raw)), &value)
+	if err != nil {
+		return nil, errs.Detailed(InvalidFilter, "invalid value "+raw)
+	}
+	c.value = value
+	return c, nil
+}
+
+// attributePath splits name.givenName, the schema of a fully qualified
+// attribute such as urn:ietf:params:scim:schemas:core:2.0:User:userName is
+// dropped
+func attributePath(attribute string) []string {
+	if i := strings.LastIndex(attribute, ":"); i >= 0 {
+		attribute = attribute[i+1:]
+	}
+	return strings.Split(attribute, ".")
+}
+
+func (l logical) match(values map[string]interface{}) bool {
+	if l.and {
+		return l.left.match(values) && l.right.match(values)
+	}
+	return l.left.match(values) || l.right.match(values)
+}
+
+func (n negation) match(values map[string]interface{}) bool {
+	return !n.expression.match(values)
+}
+
+func (c comparison) match(values map[string]interface{}) bool {
+	found := lookup(values, c.path)
+	switch c.operator {
+	case "pr":
+		for _, v := range found {
+			if present(v) {
+				return true
+			}
+		}
+		return false
+	case "ne":
+		if c.value == nil {
+			return len(found) > 0
+		}
+		for _, v := range found {
+			if compare(v, "eq", c.value) {
+				return false
+			}
+		}
+		return true
+	}
+	if c.value == nil {
+		return c.operator == "eq" && len(found) == 0
+	}
+	for _, v := range found {
+		if compare(v, c.operator, c.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns every value at the path, names are matched without case
+// and multi valued attributes contribute each of their values. A complex
+// multi valued attribute without a sub attribute, such as emails, stands
+// for its value sub attribute.
+func lookup(values map[string]interface{}, path []string) []interface{} {
+	current := []interface{}{values}
+	for _, name := range path {
+		next := []interface{}{}
+		for _, value := range current {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for key, v := range object {
+				if !strings.EqualFold(key, name) {
+					continue
+				}
+				if list, ok := v.([]interface{}); ok {
+					next = append(next, list...)
+				} else if v != nil {
+					next = append(next, v)
+				}
+			}
+		}
+		current = next
+	}
+
+	found := make([]interface{}, 0, len(current))
+	for _, value := range current {
+		if object, ok := value.(map[string]interface{}); ok {
+			if v, ok := object["value"]; ok && v != nil {
+				found = append(found, v)
+			}
+			continue
+		}
+		found = append(found, value)
+	}
+	return found
+}
+
+func present(value interface{}) bool {
+	s, ok := value.(string)
+	return !ok || s != ""
+}
+
+func compare(value interface{}, operator string, target interface{}) bool {
+	switch t := target.(type) {
+	case string:
+		v, ok := value.(string)
+		if !ok {
+			return false
+		}
+		v, t = strings.ToLower(v), strings.ToLower(t)
+		switch operator {
+		case "eq":
+			return v == t
+		case "co":
+			return strings.Contains(v, t)
+		case "sw":
+			return strings.HasPrefix(v, t)
+		case "ew":
+			return strings.HasSuffix(v, t)
+		case "gt":
+			return v > t
+		case "ge":
+			return v >= t
+		case "lt":
+			return v < t
+		case "le":
+			return v <= t
+		}
+	case bool:
+		v, ok := value.(bool)
+		return ok && operator == "eq" && v == t
+	case float64:
+		v, ok := value.(float64)
+		if !ok {
+			return false
+		}
+		switch operator {
+		case "eq":
+			return v == t
+		case "gt":
+			return v > t
+		case "ge":
+			return v >= t
+		case "lt":
+			return v < t
+		case "le":
+			return v <= t
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const filterUser = `{
+	"userName": "Ada@Example.com",
+	"name": {"givenName": "Ada", "familyName": "Lovelace"},
+	"emails": [{"value": "ada@example.com", "primary": true}, {"value": "ada@work.example"}],
+	"active": true,
+	"externalId": "",
+	"meta": {"lastModified": "2024-03-01T10:00:00Z"}
+}`
+
+func TestFilterMatches(t *testing.T) {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(filterUser), &values); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{``, true},
+		{`userName eq "ada@example.com"`, true},
+		{`USERNAME EQ "ADA@EXAMPLE.COM"`, true},
+		{`userName eq "bob@example.com"`, false},
+		{`userName ne "bob@example.com"`, true},
+		{`userName sw "ada"`, true},
+		{`userName ew ".com"`, true},
+		{`userName co "example"`, true},
+		{`name.familyName eq "Lovelace"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "ada@example.com"`, true},
+		{`emails eq "ada@work.example"`, true},
+		{`emails.value eq "ada@example.com"`, true},
+		{`emails.primary eq true`, true},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`externalId pr`, false},
+		{`name.givenName pr`, true},
+		{`title pr`, false},
+		{`title eq null`, true},
+		{`meta.lastModified gt "2024-01-01T00:00:00Z"`, true},
+		{`meta.lastModified lt "2024-01-01T00:00:00Z"`, false},
+		{`userName eq "bob@example.com" or active eq true`, true},
+		{`userName eq "bob@example.com" and active eq true`, false},
+		{`not (userName eq "bob@example.com")`, true},
+		{`active eq true and (name.givenName eq "Bob" or name.familyName eq "Lovelace")`, true},
+		{`userName eq "x" or userName eq "y" and active eq true`, false},
+	}
+	for _, test := range tests {
+		e, err := parseFilter(test.filter)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", test.filter, err)
+			continue
+		}
+		if got := matches(e, values); got != test.want {
+			t.Errorf("%q matches = %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestFilterInvalid(t *testing.T) {
+	for _, filter := range []string{
+		`userName`,
+		`userName zz "a"`,
+		`userName eq`,
+		`userName eq "unterminated`,
+		`(userName eq "a"`,
+		`userName eq "a")`,
+		`userName eq "a" and`,
+		`eq "a"`,
+		`userName eq bare`,
+	} {
+		if _, err := parseFilter(filter); !errors.Is(err, InvalidFilter) {
+			t.Errorf("parseFilter(%q): got %v, want InvalidFilter", filter, err)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package scim
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/member"
+)
+
+// mapping returns the role or the app role a display name stands for
+func mapping(displayName string) (GroupKind, string) {
+	switch role := member.Role(strings.ToLower(displayName)); role {
+	case member.Admin, member.Staff, member.User:
+		return RoleGroup, string(role)
+	}
+	return AppRoleGroup, displayName
+}
+
+// holds reports whether the member belongs to the group, the owner belongs
+// to the admin group
+func (g Group) holds(m member.Member) bool {
+	if g.Kind == RoleGroup {
+		return string(m.Role) == g.Value || (m.Role == member.Owner && g.Value == string(member.Admin))
+	}
+	return m.AppRole == g.Value
+}
+
+// groupState is what the provider controls of a group, members is nil
+// when the request keeps them
+type groupState struct {
+	displayName string
+	externalID  string
+	members     []string
+}
+
+func memberIDs(refs []Ref) []string {
+	if refs == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		ids = append(ids, ref.Value)
+	}
+	return ids
+}
+
+func requestedGroup(resource GroupResource) (groupState, error) {
+	state := groupState{
+		displayName: strings.TrimSpace(resource.DisplayName),
+		externalID:  strings.TrimSpace(resource.ExternalID),
+		members:     memberIDs(resource.Members),
+	}
+	if state.displayName == "" || len(state.displayName) > 255 {
+		return groupState{}, errs.Detailed(InvalidValue, "displayName is required and at most 255 characters")
+	}
+	return state, nil
+}
+
+func (s *Service) fetchMembers(ctx context.Context, organizationID string) ([]member.Member, error) {
+	members, err := s.store.FetchAllMembers(ctx, organizationID)
+	if err != nil {
+		log.Println(err)
+		return nil, FetchFailed
+	}
+	return members, nil
+}
+
+func holders(group Group, members []member.Member) []Ref {
+	refs := []Ref{}
+	for _, m := range members {
+		if group.holds(m) {
+			refs = append(refs, Ref{Value: m.UserID})
+		}
+	}
+	return refs
+}
+
+func (s *Service) FetchGroups(ctx context.Context, organizationID string, query Query) (ListResponse, error) {
+	filter, err := parseFilter(query.Filter)
+	if err != nil {
+		return ListResponse{}, err
+	}
+	groups, err := s.fetchGroups(ctx, organizationID)
+	if err != nil {
+		return ListResponse{}, err
+	}
+	members, err := s.fetchMembers(ctx, organizationID)
+	if err != nil {
+		return ListResponse{}, err
+	}
+
+	resources := []GroupResource{}
+	for _, group := range groups {
+		resource := groupResource(group, holders(group, members))
+		values, err := attributes(resource)
+		if err != nil {
+			log.Println(err)
+			return ListResponse{}, FetchFailed
+		}
+		if matches(filter, values) {
+			resources = append(resources, resource)
+		}
+	}
+
+	startIndex, first, last := query.page(len(resources))
+	return ListResponse{
+		Schemas:      []string{ListSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: last - first,
+		Resources:    resources[first:last],
+	}, nil
+}
+
+func (s *Service) loadGroup(ctx context.Context, organizationID string, id string) (Group, error) {
+	group, err := s.store.GetSCIMGroup(ctx, organizationID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Group{}, GroupNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Group{}, FetchFailed
+	}
+	return group, nil
+}
+
+func (s *Service) GetGroup(ctx context.Context, organizationID string, id string) (GroupResource, error) {
+	group, err := s.loadGroup(ctx, organizationID, id)
+	if err != nil {
+		return GroupResource{}, err
+	}
+	members, err := s.fetchMembers(ctx, organizationID)
+	if err != nil {
+		return GroupResource{}, err
+	}
+	return groupResource(group, holders(group, members)), nil
+}
+
+// CreateGroup maps a new group to its role or app role, the members given
+// are granted it
+func (s *Service) CreateGroup(ctx context.Context, organizationID string, resource GroupResource) (GroupResource, error) {
+	state, err := requestedGroup(resource)
+	if err != nil {
+		return GroupResource{}, err
+	}
+	if state.members == nil {
+		state.members = []string{}
+	}
+
+	now := int(time.Now().Unix())
+	group := Group{
+		ID:             uuid.New().String(),
+		OrganizationID: organizationID,
+		CreatedAt:      now,
+	}
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		return s.applyGroup(ctx, nil, group, state)
+	})
+	if err != nil {
+		return GroupResource{}, err
+	}
+	return s.GetGroup(ctx, organizationID, group.ID)
+}
+
+func (s *Service) ReplaceGroup(ctx context.Context, organizationID string, id string, resource GroupResource) (GroupResource, error) {
+	state, err := requestedGroup(resource)
+	if err != nil {
+		return GroupResource{}, err
+	}
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		group, err := s.loadGroup(ctx, organizationID, id)
+		if err != nil {
+			return err
+		}
+		return s.applyGroup(ctx, &group, group, state)
+	})
+	if err != nil {
+		return GroupResource{}, err
+	}
+	return s.GetGroup(ctx, organizationID, id)
+}
+
+func (s *Service) PatchGroup(ctx context.Context, organizationID string, id string, patch PatchRequest) (GroupResource, error) {
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		group, err := s.loadGroup(ctx, organizationID, id)
+		if err != nil {
+			return err
+		}
+		members, err := s.fetchMembers(ctx, organizationID)
+		if err != nil {
+			return err
+		}
+		state := groupState{
+			displayName: group.DisplayName,
+			externalID:  group.ExternalID,
+			members:     memberIDs(holders(group, members)),
+		}
+		for _, operation := range patch.Operations {
+			err = state.patch(operation)
+			if err != nil {
+				return err
+			}
+		}
+		return s.applyGroup(ctx, &group, group, state)
+	})
+	if err != nil {
+		return GroupResource{}, err
+	}
+	return s.GetGroup(ctx, organizationID, id)
+}
+
+// DeleteGroup takes the role or the app role away from the members
+// before forgetting the group
+func (s *Service) DeleteGroup(ctx context.Context, organizationID string, id string) error {
+	return s.store.WithTx(ctx, func(ctx context.Context) error {
+		group, err := s.loadGroup(ctx, organizationID, id)
+		if err != nil {
+			return err
+		}
+		err = s.applyGroup(ctx, &group, group, groupState{
+			displayName: group.DisplayName,
+			externalID:  group.ExternalID,
+			members:     []string{},
+		})
+		if err != nil {
+			return err
+		}
+		err = s.store.DeleteSCIMGroup(ctx, organizationID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return GroupNotFound
+		}
+		if err != nil {
+			log.Println(err)
+			return ProvisionFailed
+		}
+		return nil
+	})
+}
+
+// applyGroup stores the group in the state and updates the members whose
+// role or app role changes: members leaving lose it, members joining or
+// staying in a renamed group get the new one. previous is nil for a new
+// group.
+func (s *Service) applyGroup(ctx context.Context, previous *Group, group Group, state groupState) error {
+	groups, err := s.fetchGroups(ctx, group.OrganizationID)
+	if err != nil {
+		return err
+	}
+	for _, other := range groups {
+		if other.ID != group.ID && strings.EqualFold(other.DisplayName, state.displayName) {
+			return GroupExists
+		}
+	}
+	members, err := s.fetchMembers(ctx, group.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	group.DisplayName = state.displayName
+	group.ExternalID = state.externalID
+	group.Kind, group.Value = mapping(state.displayName)
+	group.UpdatedAt = int(time.Now().Unix())
+	changed := previous != nil && (previous.Kind != group.Kind || previous.Value != group.Value)
+
+	joining := map[string]bool{}
+	if state.members == nil {
+		for _, m := range members {
+			joining[m.UserID] = previous != nil && previous.holds(m)
+		}
+	} else {
+		known := make(map[string]bool, len(members))
+		for _, m := range members {
+			known[m.UserID] = true
+		}
+		for _, id := range state.members {
+			if !known[id] {
+				return errs.Detailed(NotAMember, id+" is not an active user of this organization")
+			}
+			joining[id] = true
+		}
+	}
+
+	if previous == nil {
+		_, err = s.store.InsertSCIMGroup(ctx, group)
+	} else {
+		err = s.store.UpdateSCIMGroup(ctx, group)
+	}
+	if err != nil {
+		log.Println(err)
+		return ProvisionFailed
+	}
+
+	for _, m := range members {
+		role, appRole := m.Role, m.AppRole
+		held := previous != nil && previous.holds(m)
+		if held && (!joining[m.UserID] || changed) {
+			if previous.Kind == RoleGroup {
+				role = member.User
+			} else {
+				appRole = ""
+			}
+		}
+		if joining[m.UserID] {
+			if group.Kind == RoleGroup {
+				role = member.Role(group.Value)
+			} else {
+				appRole = group.Value
+			}
+		}
+		// the owner outranks every role group and keeps their role
+		if m.Role == member.Owner {
+			role = member.Owner
+		}
+		if role == m.Role && appRole == m.AppRole {
+			continue
+		}
+		_, err = s.memberService.UpdateMember(ctx, group.OrganizationID, m.UserID, role, appRole)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
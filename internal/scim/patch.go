@@ -0,0 +1,302 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/user"
+)
+
+// PatchRequest is a SCIM PATCH body, RFC 7644 section 3.5.2
+type PatchRequest struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []Operation `json:"Operations"`
+}
+
+// Operation is one change, Value is decoded by the attribute it targets.
+// Identity providers differ in the case of op and in sending values as
+// strings, "False" for active, both are accepted.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (o Operation) verb() (string, error) {
+	verb := strings.ToLower(o.Op)
+	switch verb {
+	case "add", "replace", "remove":
+		return verb, nil
+	}
+	return "", errs.Detailed(InvalidSyntax, "unknown op "+o.Op)
+}
+
+// attributeName lowercases a path and drops the core schema of a fully
+// qualified one. Paths of other schemas, such as the enterprise extension,
+// come back empty, their attributes are not kept.
+func attributeName(path string, schema string) string {
+	name := strings.ToLower(strings.TrimSpace(path))
+	prefix := strings.ToLower(schema) + ":"
+	if strings.HasPrefix(name, prefix) {
+		return name[len(prefix):]
+	}
+	if strings.HasPrefix(name, "urn:") {
+		return ""
+	}
+	return name
+}
+
+// valueObject is the value of an operation without a path, its keys are
+// paths themselves
+func valueObject(o Operation) (map[string]json.RawMessage, error) {
+	values := map[string]json.RawMessage{}
+	err := json.Unmarshal(o.Value, &values)
+	if err != nil {
+		return nil, errs.Detailed(InvalidValue, "an operation without path needs an object value")
+	}
+	return values, nil
+}
+
+func decodeString(raw json.RawMessage, attribute string) (string, error) {
+	var value string
+	err := json.Unmarshal(raw, &value)
+	if err != nil {
+		return "", errs.Detailed(InvalidValue, attribute+" must be a string")
+	}
+	return strings.TrimSpace(value), nil
+}
+
+func decodeBool(raw json.RawMessage, attribute string) (bool, error) {
+	var value interface{}
+	_ = json.Unmarshal(raw, &value)
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, errs.Detailed(InvalidValue, attribute+" must be a boolean")
+}
+
+// decodeEmail reads an email value sent as a string, an email object or a
+// list of them, the primary one wins
+func decodeEmail(raw json.RawMessage) (string, error) {
+	var value string
+	if json.Unmarshal(raw, &value) == nil {
+		return user.NormalizeEmail(value), nil
+	}
+	var email Email
+	if json.Unmarshal(raw, &email) == nil && email.Value != "" {
+		return user.NormalizeEmail(email.Value), nil
+	}
+	var emails []Email
+	if json.Unmarshal(raw, &emails) == nil && len(emails) > 0 {
+		chosen := emails[0]
+		for _, e := range emails {
+			if e.Primary {
+				chosen = e
+			}
+		}
+		return user.NormalizeEmail(chosen.Value), nil
+	}
+	return "", errs.Detailed(InvalidValue, "emails must hold an email address")
+}
+
+func (state *userState) patch(o Operation) error {
+	verb, err := o.verb()
+	if err != nil {
+		return err
+	}
+	if o.Path == "" {
+		if verb == "remove" {
+			return errs.Detailed(NoTarget, "remove needs a path")
+		}
+		values, err := valueObject(o)
+		if err != nil {
+			return err
+		}
+		for path, value := range values {
+			err = state.set(path, value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if verb == "remove" {
+		if attributeName(o.Path, UserSchema) == "externalid" {
+			state.externalID = ""
+		}
+		return nil
+	}
+	return state.set(o.Path, o.Value)
+}
+
+// set replaces the attribute at path, attributes this server does not keep
+// are ignored
+func (state *userState) set(path string, raw json.RawMessage) error {
+	name := attributeName(path, UserSchema)
+	if strings.HasPrefix(name, "emails") {
+		email, err := decodeEmail(raw)
+		if err != nil {
+			return err
+		}
+		state.email = email
+		return nil
+	}
+
+	var err error
+	switch name {
+	case "active":
+		state.active, err = decodeBool(raw, "active")
+	case "username":
+		state.email, err = decodeString(raw, "userName")
+		state.email = user.NormalizeEmail(state.email)
+	case "externalid":
+		state.externalID, err = decodeString(raw, "externalId")
+	case "name.givenname":
+		state.givenName, err = decodeString(raw, "name.givenName")
+	case "name.familyname":
+		state.familyName, err = decodeString(raw, "name.familyName")
+	case "name":
+		var name Name
+		if json.Unmarshal(raw, &name) != nil {
+			return errs.Detailed(InvalidValue, "name must be an object")
+		}
+		if name.GivenName != "" {
+			state.givenName = strings.TrimSpace(name.GivenName)
+		}
+		if name.FamilyName != "" {
+			state.familyName = strings.TrimSpace(name.FamilyName)
+		}
+	}
+	return err
+}
+
+func (state *groupState) patch(o Operation) error {
+	verb, err := o.verb()
+	if err != nil {
+		return err
+	}
+	if o.Path == "" {
+		if verb == "remove" {
+			return errs.Detailed(NoTarget, "remove needs a path")
+		}
+		values, err := valueObject(o)
+		if err != nil {
+			return err
+		}
+		for path, value := range values {
+			err = state.apply(verb, path, value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return state.apply(verb, o.Path, o.Value)
+}
+
+func (state *groupState) apply(verb string, path string, raw json.RawMessage) error {
+	name := attributeName(path, GroupSchema)
+	if strings.HasPrefix(name, "members[") {
+		return state.removeMatching(verb, path)
+	}
+
+	var err error
+	switch name {
+	case "displayname":
+		if verb == "remove" {
+			return errs.Detailed(InvalidValue, "displayName is required")
+		}
+		state.displayName, err = decodeString(raw, "displayName")
+	case "externalid":
+		if verb == "remove" {
+			state.externalID = ""
+			return nil
+		}
+		state.externalID, err = decodeString(raw, "externalId")
+	case "members":
+		err = state.patchMembers(verb, raw)
+	case "id", "":
+		// the id can't change and other schemas are not kept
+	default:
+		return errs.Detailed(InvalidPath, path)
+	}
+	return err
+}
+
+func (state *groupState) patchMembers(verb string, raw json.RawMessage) error {
+	var refs []Ref
+	if len(raw) > 0 && string(raw) != "null" {
+		err := json.Unmarshal(raw, &refs)
+		if err != nil {
+			return errs.Detailed(InvalidValue, "members must be a list of references")
+		}
+	}
+
+	switch verb {
+	case "replace":
+		state.members = memberIDs(refs)
+		if state.members == nil {
+			state.members = []string{}
+		}
+	case "add":
+		for _, ref := range refs {
+			if !contains(state.members, ref.Value) {
+				state.members = append(state.members, ref.Value)
+			}
+		}
+	case "remove":
+		// without a value every member is removed
+		if refs == nil {
+			state.members = []string{}
+			return nil
+		}
+		removed := memberIDs(refs)
+		kept := []string{}
+		for _, id := range state.members {
+			if !contains(removed, id) {
+				kept = append(kept, id)
+			}
+		}
+		state.members = kept
+	}
+	return nil
+}
+
+// removeMatching handles members[value eq "..."], the only value path
+// providers send
+func (state *groupState) removeMatching(verb string, path string) error {
+	end := strings.LastIndex(path, "]")
+	if verb != "remove" || end < 0 || end != len(path)-1 {
+		return errs.Detailed(InvalidPath, path)
+	}
+	filter, err := parseFilter(path[strings.Index(path, "[")+1 : end])
+	if err != nil || filter == nil {
+		return errs.Detailed(InvalidPath, path)
+	}
+	kept := []string{}
+	for _, id := range state.members {
+		if !filter.match(map[string]interface{}{"value": id}) {
+			kept = append(kept, id)
+		}
+	}
+	state.members = kept
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func operation(t *testing.T, body string) Operation {
+	t.Helper()
+	var o Operation
+	if err := json.Unmarshal([]byte(body), &o); err != nil {
+		t.Fatalf("operation %s: %v", body, err)
+	}
+	return o
+}
+
+func TestUserPatch(t *testing.T) {
+	initial := userState{email: "ada@example.com", givenName: "Ada", familyName: "Lovelace", externalID: "okta-1", active: true}
+	tests := []struct {
+		name      string
+		operation string
+		want      userState
+		err       error
+	}{
+		{"deactivate", `{"op": "replace", "path": "active", "value": false}`, userState{email: "ada@example.com", givenName: "Ada", familyName: "Lovelace", externalID: "okta-1"}, nil},
+		{"azure op case and string bool", `{"op": "Replace", "path": "active", "value": "False"}`, userState{email: "ada@example.com", givenName: "Ada", familyName: "Lovelace", externalID: "okta-1"}, nil},
+		{"no path", `{"op": "replace", "value": {"active": false, "name.givenName": "Augusta"}}`, userState{email: "ada@example.com", givenName: "Augusta", familyName: "Lovelace", externalID: "okta-1"}, nil},
+		{"user name", `{"op": "replace", "path": "userName", "value": " Ada@Work.Example "}`, userState{email: "ada@work.example", givenName: "Ada", familyName: "Lovelace", externalID: "okta-1", active: true}, nil},
+		{"primary email", `{"op": "replace", "path": "emails", "value": [{"value": "a@other.example"}, {"value": "Ada@Work.Example", "primary": true}]}`, userState{email: "ada@work.example", givenName: "Ada", familyName: "Lovelace", externalID: "okta-1", active: true}, nil},
+		{"email value path", `{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "ada@work.example"}`, userState{email: "ada@work.example", givenName: "Ada", familyName: "Lovelace", externalID: "okta-1", active: true}, nil},
+		{"name object", `{"op": "add", "path": "name", "value": {"familyName": "King"}}`, userState{email: "ada@example.com", givenName: "Ada", familyName: "King", externalID: "okta-1", active: true}, nil},
+		{"qualified path", `{"op": "replace", "path": "urn:ietf:params:scim:schemas:core:2.0:User:name.givenName", "value": "Augusta"}`, userState{email: "ada@example.com", givenName: "Augusta", familyName: "Lovelace", externalID: "okta-1", active: true}, nil},
+		{"extension is ignored", `{"op": "add", "path": "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department", "value": "R&D"}`, initial, nil},
+		{"remove external id", `{"op": "remove", "path": "externalId"}`, userState{email: "ada@example.com", givenName: "Ada", familyName: "Lovelace", active: true}, nil},
+		{"unknown op", `{"op": "move", "path": "active", "value": true}`, initial, InvalidSyntax},
+		{"remove without path", `{"op": "remove"}`, initial, NoTarget},
+		{"no path without object", `{"op": "replace", "value": "x"}`, initial, InvalidValue},
+		{"not a bool", `{"op": "replace", "path": "active", "value": "maybe"}`, initial, InvalidValue},
+		{"not a string", `{"op": "replace", "path": "name.familyName", "value": 3}`, initial, InvalidValue},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := initial
+			err := state.patch(operation(t, test.operation))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("patch: got %v, want %v", err, test.err)
+			}
+			if err == nil && state != test.want {
+				t.Errorf("patch = %+v, want %+v", state, test.want)
+			}
+		})
+	}
+}
+
+func TestGroupPatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		members   []string
+		err       error
+	}{
+		{"add members", `{"op": "add", "path": "members", "value": [{"value": "c"}, {"value": "a"}]}`, []string{"a", "b", "c"}, nil},
+		{"replace members", `{"op": "replace", "path": "members", "value": [{"value": "c"}]}`, []string{"c"}, nil},
+		{"replace with none", `{"op": "replace", "path": "members", "value": []}`, []string{}, nil},
+		{"remove listed", `{"op": "remove", "path": "members", "value": [{"value": "a"}]}`, []string{"b"}, nil},
+		{"remove all", `{"op": "remove", "path": "members"}`, []string{}, nil},
+		{"remove by filter", `{"op": "remove", "path": "members[value eq \"b\"]"}`, []string{"a"}, nil},
+		{"no path", `{"op": "add", "value": {"members": [{"value": "d"}]}}`, []string{"a", "b", "d"}, nil},
+		{"filter outside remove", `{"op": "add", "path": "members[value eq \"b\"]"}`, nil, InvalidPath},
+		{"broken filter", `{"op": "remove", "path": "members[value eq]"}`, nil, InvalidPath},
+		{"unknown attribute", `{"op": "replace", "path": "owner", "value": "x"}`, nil, InvalidPath},
+		{"remove display name", `{"op": "remove", "path": "displayName"}`, nil, InvalidValue},
+		{"members not a list", `{"op": "add", "path": "members", "value": {"value": "c"}}`, nil, InvalidValue},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := groupState{displayName: "Engineering", members: []string{"a", "b"}}
+			err := state.patch(operation(t, test.operation))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("patch: got %v, want %v", err, test.err)
+			}
+			if err == nil && !reflect.DeepEqual(state.members, test.members) {
+				t.Errorf("members = %v, want %v", state.members, test.members)
+			}
+		})
+	}
+}
+
+func TestGroupPatchDisplayName(t *testing.T) {
+	state := groupState{displayName: "Engineering"}
+	err := state.patch(operation(t, `{"op": "replace", "value": {"displayName": " Platform ", "externalId": "okta-7", "id": "ignored"}}`))
+	if err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	if state.displayName != "Platform" || state.externalID != "okta-7" {
+		t.Errorf("patch = %+v, want the name and the external id replaced", state)
+	}
+}
@@ -0,0 +1,234 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/user"
+)
+
+type Name struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	Formatted  string `json:"formatted,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Ref points to another resource, the members of a group or the groups of
+// a user
+type Ref struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// Meta.Location is left to the transport, it knows the address the
+// resources are served at
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+	Version      string `json:"version,omitempty"`
+}
+
+// UserResource is the SCIM User, userName is the email address of the
+// account. Password is only read, it is never returned.
+type UserResource struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        Name     `json:"name"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	// Active is nil when a request leaves it out, it then defaults to true
+	Active   *bool  `json:"active,omitempty"`
+	Password string `json:"password,omitempty"`
+	Groups   []Ref  `json:"groups,omitempty"`
+	Meta     *Meta  `json:"meta,omitempty"`
+}
+
+// GroupResource is the SCIM Group. Members is nil when a request leaves
+// it out, a replace then keeps the members.
+type GroupResource struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Ref    `json:"members"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// Query selects a page of a list, StartIndex counts from 1. A request
+// without count asks for MaxResults, a count of 0 only for totalResults.
+type Query struct {
+	Filter     string
+	StartIndex int
+	Count      int
+}
+
+// page bounds the query and returns the range of n results it selects
+func (q Query) page(n int) (int, int, int) {
+	startIndex := q.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count := q.Count
+	if count < 0 {
+		count = 0
+	}
+	if count > MaxResults {
+		count = MaxResults
+	}
+	first := startIndex - 1
+	if first > n {
+		first = n
+	}
+	last := first + count
+	if last > n {
+		last = n
+	}
+	return startIndex, first, last
+}
+
+func timestamp(unix int) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(int64(unix), 0).UTC().Format(time.RFC3339)
+}
+
+func fullName(u user.User) string {
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+// userResource is the user seen by the organization, membership is nil
+// once it was removed
+func userResource(u user.User, identity Identity, membership *member.Member, groups []Group) UserResource {
+	active := membership != nil
+	resource := UserResource{
+		Schemas:    []string{UserSchema},
+		ID:         u.ID,
+		ExternalID: identity.ExternalID,
+		UserName:   u.Email,
+		Name: Name{
+			GivenName:  u.FirstName,
+			FamilyName: u.LastName,
+			Formatted:  fullName(u),
+		},
+		DisplayName: fullName(u),
+		Emails:      []Email{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      timestamp(u.CreatedAt),
+			LastModified: timestamp(latest(u.UpdatedAt, identity.UpdatedAt)),
+		},
+	}
+	if membership != nil {
+		for _, group := range groups {
+			if group.holds(*membership) {
+				resource.Groups = append(resource.Groups, Ref{Value: group.ID, Display: group.DisplayName})
+			}
+		}
+	}
+	return resource
+}
+
+func groupResource(group Group, members []Ref) GroupResource {
+	if members == nil {
+		members = []Ref{}
+	}
+	return GroupResource{
+		Schemas:     []string{GroupSchema},
+		ID:          group.ID,
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     members,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      timestamp(group.CreatedAt),
+			LastModified: timestamp(group.UpdatedAt),
+		},
+	}
+}
+
+func latest(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// attributes is the resource as the generic JSON value filters are
+// evaluated against
+func attributes(resource interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	return values, json.Unmarshal(raw, &values)
+}
+
+// ServiceProviderConfig describes what this server supports, identity
+// providers read it before provisioning
+func ServiceProviderConfig() map[string]interface{} {
+	unsupported := map[string]bool{"supported": false}
+	return map[string]interface{}{
+		"schemas":          []string{ConfigSchema},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            map[string]bool{"supported": true},
+		"bulk":             map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]interface{}{"supported": true, "maxResults": MaxResults},
+		"changePassword":   unsupported,
+		"sort":             unsupported,
+		"etag":             unsupported,
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "A scim token of the organization in the Authorization header",
+			"primary":     true,
+		}},
+		"meta": map[string]string{"resourceType": "ServiceProviderConfig"},
+	}
+}
+
+// ResourceTypes lists the resources served under the base of an
+// organization
+func ResourceTypes() []map[string]interface{} {
+	return []map[string]interface{}{
+		{
+			"schemas":  []string{ResourceSchema},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   UserSchema,
+			"meta":     map[string]string{"resourceType": "ResourceType"},
+		},
+		{
+			"schemas":  []string{ResourceSchema},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   GroupSchema,
+			"meta":     map[string]string{"resourceType": "ResourceType"},
+		},
+	}
+}
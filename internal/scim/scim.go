@@ -0,0 +1,273 @@
+// Package scim lets the identity provider of an organization, such as Okta
+// or Azure AD, provision its members over SCIM 2.0 (RFC 7643 and 7644).
+//
+// A SCIM User is a global user seen through the organization: it is active
+// while the user is a member. Deactivating or deleting it removes the
+// membership, the account itself and its other memberships are kept. The
+// users the provider created or linked are remembered as identities with
+// their externalId, so a deactivated user can still be read back and
+// reactivated.
+//
+// A SCIM Group maps to a member role when its display name is one, admin,
+// staff or user, and to the app role of the same name otherwise. Group
+// membership is the role itself, a member holds one role and one app
+// role so joining a second group of the same kind leaves the first.
+//
+// Every organization authenticates its provider with its own bearer
+// tokens, only their hash is stored.
+package scim
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/password"
+	"microauth.io/core/internal/user"
+)
+
+const (
+	UserSchema        = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema       = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListSchema        = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchSchema       = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema       = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ConfigSchema      = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceSchema    = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	tokenPrefix       = "scim_"
+	lastUsedPrecision = 60
+
+	// MaxResults bounds a page of a list, it is also the default count
+	MaxResults = 200
+)
+
+var (
+	InvalidToken      = errs.New(errs.Unauthenticated, "invalid_scim_token", "invalid scim token")
+	TokenNotFound     = errs.New(errs.NotFound, "scim_token_not_found", "scim token not found")
+	FetchTokensFailed = errs.New(errs.Internal, "fetch_scim_tokens_failed", "unable to fetch scim tokens")
+	TokenCreateFailed = errs.New(errs.Internal, "scim_token_creation_failed", "unable to create scim token")
+	TokenDeleteFailed = errs.New(errs.Internal, "scim_token_delete_failed", "unable to revoke scim token")
+	UserNotFound      = errs.New(errs.NotFound, "scim_user_not_found", "user not found")
+	GroupNotFound     = errs.New(errs.NotFound, "scim_group_not_found", "group not found")
+	UserExists        = errs.New(errs.Conflict, "scim_user_exists", "the user is already provisioned in this organization")
+	AccountExists     = errs.New(errs.Conflict, "scim_account_exists", "an account with this email exists outside the organization, invite it to join")
+	GroupExists       = errs.New(errs.Conflict, "scim_group_exists", "a group with this display name already exists")
+	InvalidValue      = errs.New(errs.Invalid, "invalid_value", "invalid attribute value")
+	InvalidSyntax     = errs.New(errs.Invalid, "invalid_syntax", "the request body is not a valid scim request")
+	InvalidFilter     = errs.New(errs.Invalid, "invalid_filter", "invalid filter")
+	InvalidPath       = errs.New(errs.Invalid, "invalid_path", "invalid patch path")
+	NoTarget          = errs.New(errs.Invalid, "no_target", "the patch path matches nothing")
+	Immutable         = errs.New(errs.Invalid, "mutability", "userName is the email address of the account, it can't be changed through scim")
+	NotAMember        = errs.New(errs.Invalid, "scim_not_a_member", "only active users can join a group")
+	ProvisionFailed   = errs.New(errs.Internal, "scim_provisioning_failed", "unable to provision the resource")
+	FetchFailed       = errs.New(errs.Internal, "scim_fetch_failed", "unable to fetch the resource")
+	TokenRevoked      = "scim token revoked"
+)
+
+// Token authenticates the provider of an organization. The secret is only
+// returned when the token is created, Hash is its SHA-256.
+type Token struct {
+	ID             string
+	OrganizationID string
+	Name           string
+	Hash           string
+	CreatedAt      int
+	// LastUsedAt is updated at most once a minute, 0 until first use
+	LastUsedAt int
+}
+
+// Identity is a user the provider of the organization created or linked
+type Identity struct {
+	OrganizationID string
+	UserID         string
+	ExternalID     string
+	CreatedAt      int
+	UpdatedAt      int
+}
+
+type GroupKind string
+
+const (
+	RoleGroup    GroupKind = "role"
+	AppRoleGroup GroupKind = "app_role"
+)
+
+// Group is a group of the provider and the role or the app role, Value,
+// its members hold
+type Group struct {
+	ID             string
+	OrganizationID string
+	DisplayName    string
+	ExternalID     string
+	Kind           GroupKind
+	Value          string
+	CreatedAt      int
+	UpdatedAt      int
+}
+
+type Store interface {
+	WithTx(context.Context, func(context.Context) error) error
+	GetUserByID(context.Context, string) (user.User, error)
+	UpdateUserName(context.Context, string, string, string) error
+	FetchMemberByID(context.Context, string, string) (member.Member, error)
+	FetchAllMembers(context.Context, string) ([]member.Member, error)
+	InsertSCIMToken(context.Context, Token) (string, error)
+	GetSCIMTokenByHash(context.Context, string) (Token, error)
+	FetchSCIMTokens(context.Context, string) ([]Token, error)
+	UpdateSCIMTokenLastUsed(context.Context, string, int) error
+	DeleteSCIMToken(context.Context, string, string) error
+	// UpsertSCIMIdentity inserts the identity or replaces its external ID
+	UpsertSCIMIdentity(context.Context, Identity) error
+	GetSCIMIdentity(context.Context, string, string) (Identity, error)
+	FetchSCIMIdentities(context.Context, string) ([]Identity, error)
+	DeleteSCIMIdentity(context.Context, string, string) error
+	InsertSCIMGroup(context.Context, Group) (string, error)
+	GetSCIMGroup(context.Context, string, string) (Group, error)
+	FetchSCIMGroups(context.Context, string) ([]Group, error)
+	UpdateSCIMGroup(context.Context, Group) error
+	DeleteSCIMGroup(context.Context, string, string) error
+}
+
+type UserService interface {
+	GetUserByEmail(context.Context, string) (user.User, error)
+	ProvisionUser(context.Context, string, string, string, string, string, ...password.Policy) (string, error)
+}
+
+// MemberService changes the memberships so they are audited and published
+// like any other change
+type MemberService interface {
+	AddMember(context.Context, string, string, member.Role, string) (string, error)
+	UpdateMember(context.Context, string, string, member.Role, string) (string, error)
+	DeleteMember(context.Context, string, string) (string, error)
+}
+
+type OrganizationService interface {
+	GetPasswordPolicy(context.Context, string) (password.Policy, error)
+}
+
+type Auditor interface {
+	Record(context.Context, audit.Event)
+}
+
+type Publisher interface {
+	Publish(context.Context, string, string, interface{})
+}
+
+type Service struct {
+	store               Store
+	userService         UserService
+	memberService       MemberService
+	organizationService OrganizationService
+	auditor             Auditor
+	publisher           Publisher
+}
+
+func New(store Store, userService UserService, memberService MemberService, organizationService OrganizationService, auditor Auditor, publisher Publisher) *Service {
+	return &Service{
+		store:               store,
+		userService:         userService,
+		memberService:       memberService,
+		organizationService: organizationService,
+		auditor:             auditor,
+		publisher:           publisher,
+	}
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken returns the token with its secret, the secret is not stored
+// and can't be shown again
+func (s *Service) CreateToken(ctx context.Context, organizationID string, name string) (Token, string, error) {
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	if err != nil {
+		log.Println(err)
+		return Token{}, "", TokenCreateFailed
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	token := Token{
+		ID:             uuid.New().String(),
+		OrganizationID: organizationID,
+		Name:           name,
+		Hash:           hashToken(secret),
+		CreatedAt:      int(time.Now().Unix()),
+	}
+	_, err = s.store.InsertSCIMToken(ctx, token)
+	if err != nil {
+		log.Println(err)
+		return Token{}, "", TokenCreateFailed
+	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: organizationID,
+		Action:         audit.SCIMTokenCreated,
+		Target:         audit.OrganizationTarget(organizationID),
+		Metadata:       map[string]string{"token_id": token.ID, "name": name},
+	})
+	return token, secret, nil
+}
+
+func (s *Service) FetchTokens(ctx context.Context, organizationID string) ([]Token, error) {
+	tokens, err := s.store.FetchSCIMTokens(ctx, organizationID)
+	if err != nil {
+		log.Println(err)
+		return []Token{}, FetchTokensFailed
+	}
+	return tokens, nil
+}
+
+func (s *Service) RevokeToken(ctx context.Context, organizationID string, id string) (string, error) {
+	err := s.store.DeleteSCIMToken(ctx, organizationID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", TokenNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", TokenDeleteFailed
+	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: organizationID,
+		Action:         audit.SCIMTokenRevoked,
+		Target:         audit.OrganizationTarget(organizationID),
+		Metadata:       map[string]string{"token_id": id},
+	})
+	return TokenRevoked, nil
+}
+
+// Authenticate accepts a token of the organization only, a token of
+// another organization is as invalid as an unknown one
+func (s *Service) Authenticate(ctx context.Context, organizationID string, secret string) error {
+	token, err := s.store.GetSCIMTokenByHash(ctx, hashToken(secret))
+	if errors.Is(err, sql.ErrNoRows) {
+		return InvalidToken
+	}
+	if err != nil {
+		log.Println(err)
+		return FetchTokensFailed
+	}
+	if token.OrganizationID != organizationID {
+		return InvalidToken
+	}
+
+	now := int(time.Now().Unix())
+	if now-token.LastUsedAt >= lastUsedPrecision {
+		err = s.store.UpdateSCIMTokenLastUsed(ctx, token.ID, now)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package scim
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/user"
+	"microauth.io/core/internal/validate"
+	"microauth.io/core/internal/webhook"
+)
+
+// userState is what the provider controls of a user, requests are turned
+// into the state they want and applied at once
+type userState struct {
+	email      string
+	givenName  string
+	familyName string
+	externalID string
+	active     bool
+}
+
+func requestedState(resource UserResource) (userState, error) {
+	state := userState{
+		email:      user.NormalizeEmail(resource.UserName),
+		givenName:  strings.TrimSpace(resource.Name.GivenName),
+		familyName: strings.TrimSpace(resource.Name.FamilyName),
+		externalID: strings.TrimSpace(resource.ExternalID),
+		active:     resource.Active == nil || *resource.Active,
+	}
+	if !validate.Email(state.email, "") {
+		return userState{}, errs.Detailed(InvalidValue, "userName must be an email address")
+	}
+	return state, nil
+}
+
+// loadUser returns the user with what the organization knows of it, a user
+// the organization never had is not found
+func (s *Service) loadUser(ctx context.Context, organizationID string, id string) (user.User, Identity, *member.Member, error) {
+	identity, err := s.store.GetSCIMIdentity(ctx, organizationID, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		return user.User{}, Identity{}, nil, FetchFailed
+	}
+	linked := err == nil
+
+	var membership *member.Member
+	m, err := s.store.FetchMemberByID(ctx, organizationID, id)
+	if err == nil {
+		membership = &m
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		return user.User{}, Identity{}, nil, FetchFailed
+	}
+	if !linked && membership == nil {
+		return user.User{}, Identity{}, nil, UserNotFound
+	}
+
+	u, err := s.store.GetUserByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user.User{}, Identity{}, nil, UserNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return user.User{}, Identity{}, nil, FetchFailed
+	}
+	return u, identity, membership, nil
+}
+
+func (s *Service) fetchGroups(ctx context.Context, organizationID string) ([]Group, error) {
+	groups, err := s.store.FetchSCIMGroups(ctx, organizationID)
+	if err != nil {
+		log.Println(err)
+		return nil, FetchFailed
+	}
+	return groups, nil
+}
+
+// FetchUsers lists the members of the organization and the users its
+// provider deactivated, ordered by ID
+func (s *Service) FetchUsers(ctx context.Context, organizationID string, query Query) (ListResponse, error) {
+	filter, err := parseFilter(query.Filter)
+	if err != nil {
+		return ListResponse{}, err
+	}
+
+	members, err := s.store.FetchAllMembers(ctx, organizationID)
+	if err != nil {
+		log.Println(err)
+		return ListResponse{}, FetchFailed
+	}
+	identities, err := s.store.FetchSCIMIdentities(ctx, organizationID)
+	if err != nil {
+		log.Println(err)
+		return ListResponse{}, FetchFailed
+	}
+	groups, err := s.fetchGroups(ctx, organizationID)
+	if err != nil {
+		return ListResponse{}, err
+	}
+
+	memberships := make(map[string]*member.Member, len(members))
+	for i := range members {
+		memberships[members[i].UserID] = &members[i]
+	}
+	linked := make(map[string]Identity, len(identities))
+	for _, identity := range identities {
+		linked[identity.UserID] = identity
+	}
+	ids := make([]string, 0, len(memberships)+len(linked))
+	for id := range memberships {
+		ids = append(ids, id)
+	}
+	for id := range linked {
+		if memberships[id] == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	resources := []UserResource{}
+	for _, id := range ids {
+		u, err := s.store.GetUserByID(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			return ListResponse{}, FetchFailed
+		}
+		resource := userResource(u, linked[id], memberships[id], groups)
+		values, err := attributes(resource)
+		if err != nil {
+			log.Println(err)
+			return ListResponse{}, FetchFailed
+		}
+		if matches(filter, values) {
+			resources = append(resources, resource)
+		}
+	}
+
+	startIndex, first, last := query.page(len(resources))
+	return ListResponse{
+		Schemas:      []string{ListSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: last - first,
+		Resources:    resources[first:last],
+	}, nil
+}
+
+func (s *Service) GetUser(ctx context.Context, organizationID string, id string) (UserResource, error) {
+	u, identity, membership, err := s.loadUser(ctx, organizationID, id)
+	if err != nil {
+		return UserResource{}, err
+	}
+	groups, err := s.fetchGroups(ctx, organizationID)
+	if err != nil {
+		return UserResource{}, err
+	}
+	return userResource(u, identity, membership, groups), nil
+}
+
+// CreateUser provisions the user in the organization. An account with the
+// email address is only linked when the organization provisioned it, any
+// other joins through an invite it accepts and is then listed as a member.
+// A new account has no password unless the provider sends one.
+func (s *Service) CreateUser(ctx context.Context, organizationID string, resource UserResource) (UserResource, error) {
+	state, err := requestedState(resource)
+	if err != nil {
+		return UserResource{}, err
+	}
+
+	var userID string
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		existing, err := s.userService.GetUserByEmail(ctx, state.email)
+		if errors.Is(err, user.UnableToFindUser) {
+			policy, err := s.organizationService.GetPasswordPolicy(ctx, organizationID)
+			if err != nil {
+				return err
+			}
+			userID, err = s.userService.ProvisionUser(ctx, organizationID, state.givenName, state.familyName, state.email, resource.Password, policy)
+			if err != nil {
+				return err
+			}
+			s.publisher.Publish(ctx, organizationID, webhook.UserCreated, webhook.UserData{
+				ID:        userID,
+				Email:     state.email,
+				FirstName: state.givenName,
+				LastName:  state.familyName,
+			})
+			provisioned := user.User{ID: userID, Email: state.email, FirstName: state.givenName, LastName: state.familyName, ProvisionedBy: organizationID}
+			return s.apply(ctx, organizationID, provisioned, nil, nil, state)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, _, _, err = s.loadUser(ctx, organizationID, existing.ID)
+		if err == nil {
+			return UserExists
+		}
+		if !errors.Is(err, UserNotFound) {
+			return err
+		}
+		if existing.ProvisionedBy != organizationID {
+			return AccountExists
+		}
+		userID = existing.ID
+		return s.apply(ctx, organizationID, existing, nil, nil, state)
+	})
+	if err != nil {
+		return UserResource{}, err
+	}
+	return s.GetUser(ctx, organizationID, userID)
+}
+
+// ReplaceUser applies a whole user
+func (s *Service) ReplaceUser(ctx context.Context, organizationID string, id string, resource UserResource) (UserResource, error) {
+	state, err := requestedState(resource)
+	if err != nil {
+		return UserResource{}, err
+	}
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		u, identity, membership, err := s.loadUser(ctx, organizationID, id)
+		if err != nil {
+			return err
+		}
+		return s.apply(ctx, organizationID, u, &identity, membership, state)
+	})
+	if err != nil {
+		return UserResource{}, err
+	}
+	return s.GetUser(ctx, organizationID, id)
+}
+
+func (s *Service) PatchUser(ctx context.Context, organizationID string, id string, patch PatchRequest) (UserResource, error) {
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		u, identity, membership, err := s.loadUser(ctx, organizationID, id)
+		if err != nil {
+			return err
+		}
+		state := userState{
+			email:      u.Email,
+			givenName:  u.FirstName,
+			familyName: u.LastName,
+			externalID: identity.ExternalID,
+			active:     membership != nil,
+		}
+		for _, operation := range patch.Operations {
+			err = state.patch(operation)
+			if err != nil {
+				return err
+			}
+		}
+		return s.apply(ctx, organizationID, u, &identity, membership, state)
+	})
+	if err != nil {
+		return UserResource{}, err
+	}
+	return s.GetUser(ctx, organizationID, id)
+}
+
+// DeleteUser removes the membership and forgets the identity, the account
+// stays
+func (s *Service) DeleteUser(ctx context.Context, organizationID string, id string) error {
+	return s.store.WithTx(ctx, func(ctx context.Context) error {
+		_, _, membership, err := s.loadUser(ctx, organizationID, id)
+		if err != nil {
+			return err
+		}
+		if membership != nil {
+			_, err = s.memberService.DeleteMember(ctx, organizationID, id)
+			if err != nil {
+				return err
+			}
+		}
+		err = s.store.DeleteSCIMIdentity(ctx, organizationID, id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+			return ProvisionFailed
+		}
+		return nil
+	})
+}
+
+// apply moves the user to the state, identity is nil for a user the
+// provider did not know yet and membership nil for a user outside of the
+// organization. A state without a name keeps the one of the account, as
+// does an account the organization didn't provision: its name is shared
+// with the other organizations of the user.
+func (s *Service) apply(ctx context.Context, organizationID string, u user.User, identity *Identity, membership *member.Member, state userState) error {
+	if !strings.EqualFold(state.email, u.Email) {
+		return Immutable
+	}
+	if (state.givenName == "" && state.familyName == "") || u.ProvisionedBy != organizationID {
+		state.givenName, state.familyName = u.FirstName, u.LastName
+	}
+
+	if state.givenName != u.FirstName || state.familyName != u.LastName {
+		err := s.store.UpdateUserName(ctx, u.ID, state.givenName, state.familyName)
+		if err != nil {
+			log.Println(err)
+			return ProvisionFailed
+		}
+	}
+
+	if identity == nil || identity.CreatedAt == 0 || identity.ExternalID != state.externalID {
+		now := int(time.Now().Unix())
+		next := Identity{
+			OrganizationID: organizationID,
+			UserID:         u.ID,
+			ExternalID:     state.externalID,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		err := s.store.UpsertSCIMIdentity(ctx, next)
+		if err != nil {
+			log.Println(err)
+			return ProvisionFailed
+		}
+	}
+
+	switch {
+	case state.active && membership == nil:
+		_, err := s.memberService.AddMember(ctx, organizationID, u.ID, member.User, "")
+		return err
+	case !state.active && membership != nil:
+		_, err := s.memberService.DeleteMember(ctx, organizationID, u.ID)
+		return err
+	}
+	return nil
+}
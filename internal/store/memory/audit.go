@@ -0,0 +1,173 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+
+	"microauth.io/core/internal/audit"
+)
+
+func (s *Store) InsertAuditEvent(ctx context.Context, event audit.Event) (int64, error) {
+	defer s.lock(ctx)()
+
+	event.ID = int64(len(s.data.auditEvents) + 1)
+	s.data.auditEvents = append(s.data.auditEvents, event)
+	return event.ID, nil
+}
+
+func (s *Store) FetchAuditEvents(ctx context.Context, filter audit.Filter) ([]audit.Event, error) {
+	defer s.lock(ctx)()
+
+	events := []audit.Event{}
+	for _, event := range s.data.auditEvents {
+		switch {
+		case filter.OrganizationID != "" && event.OrganizationID != filter.OrganizationID,
+			filter.ActorID != "" && event.ActorID != filter.ActorID,
+			filter.Action != "" && event.Action != filter.Action,
+			filter.Target != "" && event.Target != filter.Target,
+			filter.Since > 0 && event.CreatedAt < filter.Since,
+			filter.Until > 0 && event.CreatedAt > filter.Until,
+			filter.Before > 0 && event.ID >= filter.Before:
+			continue
+		}
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID > events[j].ID
+	})
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+func (s *Store) AdvanceAuditChain(ctx context.Context, organizationID string) (int64, string, error) {
+	defer s.lock(ctx)()
+
+	head := s.data.auditChains[organizationID]
+	head.OrganizationID = organizationID
+	head.Sequence++
+	s.data.auditChains[organizationID] = head
+	return head.Sequence, head.Hash, nil
+}
+
+func (s *Store) UpdateAuditChainHead(ctx context.Context, organizationID string, hash string) error {
+	defer s.lock(ctx)()
+
+	head, ok := s.data.auditChains[organizationID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	head.Hash = hash
+	s.data.auditChains[organizationID] = head
+	return nil
+}
+
+func (s *Store) FetchAuditChainHeads(ctx context.Context) ([]audit.Head, error) {
+	defer s.lock(ctx)()
+
+	heads := []audit.Head{}
+	for _, head := range s.data.auditChains {
+		for _, checkpoint := range s.data.auditCheckpoints {
+			if checkpoint.OrganizationID == head.OrganizationID && checkpoint.Sequence > head.Checkpointed {
+				head.Checkpointed = checkpoint.Sequence
+			}
+		}
+		heads = append(heads, head)
+	}
+	sort.Slice(heads, func(i, j int) bool {
+		return heads[i].OrganizationID < heads[j].OrganizationID
+	})
+	return heads, nil
+}
+
+func (s *Store) FetchAuditChain(ctx context.Context, organizationID string, from int64, limit int) ([]audit.Event, error) {
+	defer s.lock(ctx)()
+
+	events := []audit.Event{}
+	for _, event := range s.data.auditEvents {
+		if event.OrganizationID == organizationID && event.Sequence >= from {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Sequence < events[j].Sequence
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (s *Store) InsertAuditCheckpoint(ctx context.Context, checkpoint audit.Checkpoint) error {
+	defer s.lock(ctx)()
+
+	for _, existing := range s.data.auditCheckpoints {
+		if existing.OrganizationID == checkpoint.OrganizationID && existing.Sequence == checkpoint.Sequence {
+			return UniqueViolation
+		}
+	}
+	s.data.auditCheckpoints = append(s.data.auditCheckpoints, checkpoint)
+	return nil
+}
+
+func (s *Store) FetchAuditCheckpoints(ctx context.Context, organizationID string) ([]audit.Checkpoint, error) {
+	defer s.lock(ctx)()
+
+	checkpoints := []audit.Checkpoint{}
+	for _, checkpoint := range s.data.auditCheckpoints {
+		if checkpoint.OrganizationID == organizationID {
+			checkpoints = append(checkpoints, checkpoint)
+		}
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Sequence < checkpoints[j].Sequence
+	})
+	return checkpoints, nil
+}
+
+func (s *Store) FetchAuditEventsMentioning(ctx context.Context, terms []string) ([]audit.Event, error) {
+	defer s.lock(ctx)()
+
+	mentions := func(event audit.Event, term string) bool {
+		term = strings.ToLower(term)
+		if event.ActorID == term || strings.Contains(strings.ToLower(event.Target), term) {
+			return true
+		}
+		for key, value := range event.Metadata {
+			if strings.Contains(strings.ToLower(key), term) || strings.Contains(strings.ToLower(value), term) {
+				return true
+			}
+		}
+		return false
+	}
+
+	events := []audit.Event{}
+	for _, event := range s.data.auditEvents {
+		for _, term := range terms {
+			if mentions(event, term) {
+				events = append(events, event)
+				break
+			}
+		}
+	}
+	return events, nil
+}
+
+func (s *Store) RedactAuditEvent(ctx context.Context, event audit.Event) error {
+	defer s.lock(ctx)()
+
+	if event.ID < 1 || event.ID > int64(len(s.data.auditEvents)) {
+		return sql.ErrNoRows
+	}
+	stored := &s.data.auditEvents[event.ID-1]
+	stored.ActorID = event.ActorID
+	stored.Target = event.Target
+	stored.IP = event.IP
+	stored.UserAgent = event.UserAgent
+	stored.Metadata = event.Metadata
+	stored.Redacted = true
+	return nil
+}
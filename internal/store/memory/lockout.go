@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+
+	"microauth.io/core/internal/lockout"
+)
+
+func (s *Store) GetLoginAttempts(ctx context.Context, subject string) (lockout.Attempts, error) {
+	defer s.lock(ctx)()
+
+	attempts, ok := s.data.loginAttempts[subject]
+	if !ok {
+		return lockout.Attempts{}, sql.ErrNoRows
+	}
+	return attempts, nil
+}
+
+func (s *Store) RecordLoginFailure(ctx context.Context, subject string, at int, since int) (lockout.Attempts, error) {
+	defer s.lock(ctx)()
+
+	attempts, ok := s.data.loginAttempts[subject]
+	if !ok || attempts.LastFailure < since {
+		attempts = lockout.Attempts{Subject: subject}
+	}
+	attempts.Failures++
+	attempts.LastFailure = at
+	s.data.loginAttempts[subject] = attempts
+	return attempts, nil
+}
+
+func (s *Store) DeleteLoginAttempts(ctx context.Context, subject string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.loginAttempts[subject]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.data.loginAttempts, subject)
+	return nil
+}
+
+func (s *Store) PurgeLoginAttempts(ctx context.Context, before int) (int, error) {
+	defer s.lock(ctx)()
+
+	purged := 0
+	for subject, attempts := range s.data.loginAttempts {
+		if attempts.LastFailure < before {
+			delete(s.data.loginAttempts, subject)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,298 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"microauth.io/core/internal/member"
+)
+
+func (s *Store) findMember(organizationID string, userID string) (member.Member, bool) {
+	for _, mem := range s.data.members {
+		if mem.OrganizationID == organizationID && mem.UserID == userID {
+			return mem, true
+		}
+	}
+	return member.Member{}, false
+}
+
+// activeMember finds the member unless they were removed
+func (s *Store) activeMember(organizationID string, userID string) (member.Member, bool) {
+	mem, ok := s.findMember(organizationID, userID)
+	return mem, ok && mem.DeletedAt == 0
+}
+
+// purgeMember mirrors the cascade of the schema to the team memberships
+// and the ownership transfer
+func (s *Store) purgeMember(mem member.Member) {
+	delete(s.data.members, mem.ID)
+	for key, membership := range s.data.teamMembers {
+		if membership.OrganizationID == mem.OrganizationID && membership.UserID == mem.UserID {
+			delete(s.data.teamMembers, key)
+		}
+	}
+	transfer, ok := s.data.ownershipTransfers[mem.OrganizationID]
+	if ok && (transfer.FromUserID == mem.UserID || transfer.ToUserID == mem.UserID) {
+		delete(s.data.ownershipTransfers, mem.OrganizationID)
+	}
+}
+
+func (s *Store) findInvite(email string, organizationID string) (member.MemberInvite, bool) {
+	for _, id := range sortedKeys(s.data.invites) {
+		invite := s.data.invites[id]
+		if invite.Email == email && invite.OrganizationID == organizationID {
+			return invite, true
+		}
+	}
+	return member.MemberInvite{}, false
+}
+
+func (s *Store) GetMemberInvite(ctx context.Context, email string, organizationID string) (member.MemberInvite, error) {
+	defer s.lock(ctx)()
+
+	invite, ok := s.findInvite(email, organizationID)
+	if !ok || s.data.organizations[organizationID].DeletedAt > 0 {
+		return member.MemberInvite{}, sql.ErrNoRows
+	}
+	return invite, nil
+}
+
+func (s *Store) InsertMemberInvite(ctx context.Context, email string, organizationID string, code string, expiresAt int) (string, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.organizations[organizationID]; !ok {
+		return "", ForeignKeyViolation
+	}
+	// like the unique index on (email, organization_id)
+	if _, ok := s.findInvite(email, organizationID); ok {
+		return "", UniqueViolation
+	}
+
+	now := int(time.Now().Unix())
+	invite := member.MemberInvite{
+		ID:             uuid.New().String(),
+		Email:          email,
+		Code:           code,
+		OrganizationID: organizationID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		ExpiresAt:      expiresAt,
+	}
+	s.data.invites[invite.ID] = invite
+	return invite.ID, nil
+}
+
+func (s *Store) DeleteMemberInvite(ctx context.Context, email string, organizationID string) (string, error) {
+	defer s.lock(ctx)()
+
+	for id, invite := range s.data.invites {
+		if invite.Email == email && invite.OrganizationID == organizationID {
+			delete(s.data.invites, id)
+		}
+	}
+	return "member invite deleted", nil
+}
+
+func (s *Store) FetchAllMembers(ctx context.Context, organizationID string) ([]member.Member, error) {
+	defer s.lock(ctx)()
+
+	members := make([]member.Member, 0)
+	for _, id := range sortedKeys(s.data.members) {
+		if mem := s.data.members[id]; mem.OrganizationID == organizationID && mem.DeletedAt == 0 {
+			members = append(members, mem)
+		}
+	}
+	return members, nil
+}
+
+func (s *Store) FetchMembers(ctx context.Context, filter member.Filter) ([]member.Listing, error) {
+	defer s.lock(ctx)()
+
+	search := strings.ToLower(filter.Search)
+	listings := make([]member.Listing, 0)
+	for _, mem := range s.data.members {
+		if mem.OrganizationID != filter.OrganizationID || (filter.UserID != "" && mem.UserID != filter.UserID) {
+			continue
+		}
+		if (mem.DeletedAt > 0) != filter.Deleted {
+			continue
+		}
+		if (filter.Role != "" && mem.Role != filter.Role) || (filter.AppRole != "" && mem.AppRole != filter.AppRole) {
+			continue
+		}
+		u := s.data.users[mem.UserID]
+		if search != "" && !strings.Contains(strings.ToLower(u.Email), search) &&
+			!strings.Contains(strings.ToLower(u.FirstName+" "+u.LastName), search) {
+			continue
+		}
+		listings = append(listings, member.Listing{
+			Member:    mem,
+			FirstName: u.FirstName,
+			LastName:  u.LastName,
+			Email:     u.Email,
+		})
+	}
+	keys := func(l member.Listing) []string { return l.Keys(filter.Sort) }
+	return paginate(listings, keys, false, filter.Descending, filter.After, filter.Limit), nil
+}
+
+func (s *Store) FetchMemberByID(ctx context.Context, organizationID string, userID string) (member.Member, error) {
+	defer s.lock(ctx)()
+
+	mem, ok := s.activeMember(organizationID, userID)
+	if !ok {
+		return member.Member{}, sql.ErrNoRows
+	}
+	return mem, nil
+}
+
+func (s *Store) InsertMember(ctx context.Context, organizationID string, userID string, role member.Role, appRole string) (string, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.organizations[organizationID]; !ok {
+		return "", ForeignKeyViolation
+	}
+	if _, ok := s.data.users[userID]; !ok {
+		return "", ForeignKeyViolation
+	}
+	if mem, ok := s.findMember(organizationID, userID); ok {
+		if mem.DeletedAt == 0 {
+			return "", UniqueViolation
+		}
+		s.purgeMember(mem)
+	}
+
+	mem := member.Member{
+		ID:             uuid.New().String(),
+		OrganizationID: organizationID,
+		UserID:         userID,
+		Role:           role,
+		AppRole:        appRole,
+	}
+	s.data.members[mem.ID] = mem
+	return mem.ID, nil
+}
+
+func (s *Store) UpdateMember(ctx context.Context, organizationID string, userID string, role member.Role, appRole string) (string, error) {
+	defer s.lock(ctx)()
+
+	mem, ok := s.activeMember(organizationID, userID)
+	if ok {
+		mem.Role = role
+		mem.AppRole = appRole
+		s.data.members[mem.ID] = mem
+	}
+	return member.MemberUpdated, nil
+}
+
+func (s *Store) DeleteMember(ctx context.Context, organizationID string, userID string) (string, error) {
+	defer s.lock(ctx)()
+
+	mem, ok := s.activeMember(organizationID, userID)
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	mem.DeletedAt = now(ctx)
+	s.data.members[mem.ID] = mem
+	return member.MemberDeleted, nil
+}
+
+func (s *Store) RestoreMember(ctx context.Context, organizationID string, userID string) error {
+	defer s.lock(ctx)()
+
+	mem, ok := s.findMember(organizationID, userID)
+	if !ok || mem.DeletedAt == 0 {
+		return sql.ErrNoRows
+	}
+	if s.data.organizations[organizationID].DeletedAt > 0 || s.data.users[userID].DeletedAt > 0 {
+		return sql.ErrNoRows
+	}
+	mem.DeletedAt = 0
+	s.data.members[mem.ID] = mem
+	return nil
+}
+
+func (s *Store) PurgeMembers(ctx context.Context, before int) error {
+	defer s.lock(ctx)()
+
+	for _, mem := range s.data.members {
+		if mem.DeletedAt > 0 && mem.DeletedAt < before {
+			s.purgeMember(mem)
+		}
+	}
+	return nil
+}
+
+func (s *Store) PurgeUserMembers(ctx context.Context, userID string) error {
+	defer s.lock(ctx)()
+
+	for _, mem := range s.data.members {
+		if mem.UserID == userID && mem.DeletedAt > 0 {
+			s.purgeMember(mem)
+		}
+	}
+	return nil
+}
+
+func (s *Store) FetchMembersByUser(ctx context.Context, userID string) ([]member.Member, error) {
+	defer s.lock(ctx)()
+
+	members := make([]member.Member, 0)
+	for _, id := range sortedKeys(s.data.members) {
+		if mem := s.data.members[id]; mem.UserID == userID && mem.DeletedAt == 0 {
+			members = append(members, mem)
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return members[i].OrganizationID < members[j].OrganizationID
+	})
+	return members, nil
+}
+
+func (s *Store) DeleteMemberInvitesByEmail(ctx context.Context, email string) error {
+	defer s.lock(ctx)()
+
+	for id, invite := range s.data.invites {
+		if invite.Email == email {
+			delete(s.data.invites, id)
+		}
+	}
+	return nil
+}
+
+func (s *Store) SaveOwnershipTransfer(ctx context.Context, transfer member.OwnershipTransfer) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.findMember(transfer.OrganizationID, transfer.FromUserID); !ok {
+		return ForeignKeyViolation
+	}
+	if _, ok := s.findMember(transfer.OrganizationID, transfer.ToUserID); !ok {
+		return ForeignKeyViolation
+	}
+	s.data.ownershipTransfers[transfer.OrganizationID] = transfer
+	return nil
+}
+
+func (s *Store) GetOwnershipTransfer(ctx context.Context, organizationID string) (member.OwnershipTransfer, error) {
+	defer s.lock(ctx)()
+
+	transfer, ok := s.data.ownershipTransfers[organizationID]
+	if !ok {
+		return member.OwnershipTransfer{}, sql.ErrNoRows
+	}
+	return transfer, nil
+}
+
+func (s *Store) DeleteOwnershipTransfer(ctx context.Context, organizationID string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.ownershipTransfers[organizationID]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.data.ownershipTransfers, organizationID)
+	return nil
+}
@@ -0,0 +1,229 @@
+// Package memory implements the stores of every service in process. It
+// enforces the same uniqueness and foreign key rules as the sql schema so
+// services can be exercised without a database.
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/lockout"
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/organization"
+	"microauth.io/core/internal/outbox"
+	"microauth.io/core/internal/privacy"
+	"microauth.io/core/internal/ratelimit"
+	"microauth.io/core/internal/scim"
+	"microauth.io/core/internal/team"
+	"microauth.io/core/internal/user"
+	"microauth.io/core/internal/webhook"
+)
+
+var (
+	UniqueViolation     = errors.New("unique constraint violated")
+	ForeignKeyViolation = errors.New("foreign key constraint violated")
+)
+
+type state struct {
+	users         map[string]user.User
+	organizations map[string]organization.Organization
+	members       map[string]member.Member
+	invites       map[string]member.MemberInvite
+	outbox        map[string]outbox.Message
+	loginAttempts map[string]lockout.Attempts
+	buckets       map[string]ratelimit.Bucket
+	// auditEvents is append only, an event ID is its position plus one
+	auditEvents      []audit.Event
+	auditChains      map[string]audit.Head
+	auditCheckpoints []audit.Checkpoint
+	webhooks         map[string]webhook.Endpoint
+	deliveries       map[string]webhook.Delivery
+	scimTokens       map[string]scim.Token
+	// scimIdentities are keyed by organization and user, see identityKey
+	scimIdentities map[string]scim.Identity
+	scimGroups     map[string]scim.Group
+	teams          map[string]team.Team
+	// teamMembers are keyed by team and user, see teamMemberKey
+	teamMembers map[string]team.Member
+	// emailChanges are keyed by user
+	emailChanges map[string]user.EmailChange
+	// accountDeletions are keyed by user
+	accountDeletions map[string]privacy.Deletion
+	// ownershipTransfers are keyed by organization
+	ownershipTransfers map[string]member.OwnershipTransfer
+	// organizationPreferences are keyed by user
+	organizationPreferences map[string]user.OrganizationPreference
+}
+
+func newState() *state {
+	return &state{
+		users:                   make(map[string]user.User),
+		organizations:           make(map[string]organization.Organization),
+		members:                 make(map[string]member.Member),
+		invites:                 make(map[string]member.MemberInvite),
+		outbox:                  make(map[string]outbox.Message),
+		loginAttempts:           make(map[string]lockout.Attempts),
+		buckets:                 make(map[string]ratelimit.Bucket),
+		auditChains:             make(map[string]audit.Head),
+		webhooks:                make(map[string]webhook.Endpoint),
+		deliveries:              make(map[string]webhook.Delivery),
+		scimTokens:              make(map[string]scim.Token),
+		scimIdentities:          make(map[string]scim.Identity),
+		scimGroups:              make(map[string]scim.Group),
+		teams:                   make(map[string]team.Team),
+		teamMembers:             make(map[string]team.Member),
+		emailChanges:            make(map[string]user.EmailChange),
+		accountDeletions:        make(map[string]privacy.Deletion),
+		ownershipTransfers:      make(map[string]member.OwnershipTransfer),
+		organizationPreferences: make(map[string]user.OrganizationPreference),
+	}
+}
+
+func (s *state) clone() *state {
+	c := newState()
+	for k, v := range s.users {
+		c.users[k] = v
+	}
+	for k, v := range s.organizations {
+		c.organizations[k] = v
+	}
+	for k, v := range s.members {
+		c.members[k] = v
+	}
+	for k, v := range s.invites {
+		c.invites[k] = v
+	}
+	for k, v := range s.outbox {
+		c.outbox[k] = v
+	}
+	for k, v := range s.loginAttempts {
+		c.loginAttempts[k] = v
+	}
+	for k, v := range s.buckets {
+		c.buckets[k] = v
+	}
+	for k, v := range s.auditChains {
+		c.auditChains[k] = v
+	}
+	for k, v := range s.webhooks {
+		c.webhooks[k] = v
+	}
+	for k, v := range s.deliveries {
+		c.deliveries[k] = v
+	}
+	for k, v := range s.scimTokens {
+		c.scimTokens[k] = v
+	}
+	for k, v := range s.scimIdentities {
+		c.scimIdentities[k] = v
+	}
+	for k, v := range s.scimGroups {
+		c.scimGroups[k] = v
+	}
+	for k, v := range s.teams {
+		c.teams[k] = v
+	}
+	for k, v := range s.teamMembers {
+		c.teamMembers[k] = v
+	}
+	for k, v := range s.emailChanges {
+		c.emailChanges[k] = v
+	}
+	for k, v := range s.accountDeletions {
+		c.accountDeletions[k] = v
+	}
+	for k, v := range s.ownershipTransfers {
+		c.ownershipTransfers[k] = v
+	}
+	for k, v := range s.organizationPreferences {
+		c.organizationPreferences[k] = v
+	}
+	c.auditEvents = append(c.auditEvents, s.auditEvents...)
+	c.auditCheckpoints = append(c.auditCheckpoints, s.auditCheckpoints...)
+	return c
+}
+
+type txKey struct{}
+
+// txTimeKey holds the time the transaction of the context started
+type txTimeKey struct{}
+
+type Store struct {
+	// txMu serializes transactions with every other operation, mu guards
+	// the state itself
+	txMu sync.Mutex
+	mu   sync.Mutex
+	data *state
+}
+
+func New() *Store {
+	return &Store{
+		data: newState(),
+	}
+}
+
+func (s *Store) inTx(ctx context.Context) bool {
+	return ctx.Value(txKey{}) == s
+}
+
+// lock acquires the store for one operation. Operations inside a
+// transaction already own txMu.
+func (s *Store) lock(ctx context.Context) func() {
+	if s.inTx(ctx) {
+		s.mu.Lock()
+		return s.mu.Unlock
+	}
+	s.txMu.Lock()
+	s.mu.Lock()
+	return func() {
+		s.mu.Unlock()
+		s.txMu.Unlock()
+	}
+}
+
+// WithTx runs fn with exclusive access to the store and restores the state
+// from before the call when fn fails
+func (s *Store) WithTx(ctx context.Context, fn func(context.Context) error) error {
+	if s.inTx(ctx) {
+		return fn(ctx)
+	}
+
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.Lock()
+	snapshot := s.data.clone()
+	s.mu.Unlock()
+
+	ctx = context.WithValue(ctx, txTimeKey{}, time.Now().Unix())
+	err := fn(context.WithValue(ctx, txKey{}, s))
+	if err != nil {
+		s.mu.Lock()
+		s.data = snapshot
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// now is the time the transaction of the context started, or the current
+// time outside of one, see database.Database.now
+func now(ctx context.Context) int {
+	if now, ok := ctx.Value(txTimeKey{}).(int64); ok {
+		return int(now)
+	}
+	return int(time.Now().Unix())
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
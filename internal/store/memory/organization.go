@@ -0,0 +1,216 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"microauth.io/core/internal/organization"
+	"microauth.io/core/internal/password"
+)
+
+func (s *Store) domainTaken(domain string, except string) bool {
+	for _, org := range s.data.organizations {
+		if org.Domain == domain && org.ID != except {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) FetchUserOrganizations(ctx context.Context, filter organization.Filter) ([]organization.Organization, error) {
+	defer s.lock(ctx)()
+
+	search := strings.ToLower(filter.Search)
+	organizations := make([]organization.Organization, 0)
+	for _, mem := range s.data.members {
+		if mem.UserID != filter.UserID || mem.DeletedAt > 0 || (filter.Role != "" && mem.Role != filter.Role) {
+			continue
+		}
+		org, ok := s.data.organizations[mem.OrganizationID]
+		if !ok || org.DeletedAt > 0 {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(org.Name), search) &&
+			!strings.Contains(strings.ToLower(org.Domain), search) {
+			continue
+		}
+		org.Role = mem.Role
+		organizations = append(organizations, org)
+	}
+	keys := func(o organization.Organization) []string { return o.Keys(filter.Sort) }
+	numeric := filter.Sort == organization.SortCreated
+	return paginate(organizations, keys, numeric, filter.Descending, filter.After, filter.Limit), nil
+}
+
+func (s *Store) InsertOrganization(ctx context.Context, name string, domain string) (string, error) {
+	defer s.lock(ctx)()
+
+	if s.domainTaken(domain, "") {
+		return "", organization.DomainTaken
+	}
+
+	now := int(time.Now().Unix())
+	org := organization.Organization{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Domain:    domain,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.data.organizations[org.ID] = org
+	return org.ID, nil
+}
+
+func (s *Store) GetOrganizationByID(ctx context.Context, id string) (organization.Organization, error) {
+	defer s.lock(ctx)()
+
+	org, ok := s.data.organizations[id]
+	if !ok || org.DeletedAt > 0 {
+		return organization.Organization{}, sql.ErrNoRows
+	}
+	return org, nil
+}
+
+func (s *Store) DeleteOrganizationByID(ctx context.Context, id string) (string, error) {
+	defer s.lock(ctx)()
+
+	org, ok := s.data.organizations[id]
+	if !ok || org.DeletedAt > 0 {
+		return "", sql.ErrNoRows
+	}
+	now := int(time.Now().Unix())
+	org.DeletedAt = now
+	org.UpdatedAt = now
+	s.data.organizations[id] = org
+	for memberID, mem := range s.data.members {
+		if mem.OrganizationID == id && mem.DeletedAt == 0 {
+			mem.DeletedAt = now
+			s.data.members[memberID] = mem
+		}
+	}
+	return organization.OrganizationDeleted, nil
+}
+
+func (s *Store) RestoreOrganization(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	org, ok := s.data.organizations[id]
+	if !ok || org.DeletedAt == 0 {
+		return sql.ErrNoRows
+	}
+	for memberID, mem := range s.data.members {
+		if mem.OrganizationID == id && mem.DeletedAt == org.DeletedAt && s.data.users[mem.UserID].DeletedAt == 0 {
+			mem.DeletedAt = 0
+			s.data.members[memberID] = mem
+		}
+	}
+	org.DeletedAt = 0
+	org.UpdatedAt = int(time.Now().Unix())
+	s.data.organizations[id] = org
+	return nil
+}
+
+func (s *Store) FetchDeletedOrganizations(ctx context.Context, before int) ([]organization.Organization, error) {
+	defer s.lock(ctx)()
+
+	organizations := make([]organization.Organization, 0)
+	for _, id := range sortedKeys(s.data.organizations) {
+		if org := s.data.organizations[id]; org.DeletedAt > 0 && org.DeletedAt < before {
+			organizations = append(organizations, org)
+		}
+	}
+	sort.SliceStable(organizations, func(i, j int) bool {
+		return organizations[i].DeletedAt < organizations[j].DeletedAt
+	})
+	return organizations, nil
+}
+
+// PurgeOrganization mirrors the cascades of the schema
+func (s *Store) PurgeOrganization(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.organizations[id]; !ok {
+		return sql.ErrNoRows
+	}
+	for inviteID, invite := range s.data.invites {
+		if invite.OrganizationID == id {
+			delete(s.data.invites, inviteID)
+		}
+	}
+	for _, mem := range s.data.members {
+		if mem.OrganizationID == id {
+			s.purgeMember(mem)
+		}
+	}
+
+	delete(s.data.organizations, id)
+	for endpointID, endpoint := range s.data.webhooks {
+		if endpoint.OrganizationID == id {
+			s.deleteWebhook(endpointID)
+		}
+	}
+	s.deleteSCIM(id)
+	s.deleteTeams(id)
+	for userID, preference := range s.data.organizationPreferences {
+		if preference.DefaultOrganizationID == id {
+			preference.DefaultOrganizationID = ""
+		}
+		if preference.LastOrganizationID == id {
+			preference.LastOrganizationID = ""
+		}
+		s.data.organizationPreferences[userID] = preference
+	}
+	return nil
+}
+
+func (s *Store) UpdateOrganization(ctx context.Context, id string, name string, domain string) (organization.Organization, error) {
+	defer s.lock(ctx)()
+
+	org, ok := s.data.organizations[id]
+	if !ok || org.DeletedAt > 0 {
+		return organization.Organization{}, sql.ErrNoRows
+	}
+	if s.domainTaken(domain, id) {
+		return organization.Organization{}, organization.DomainTaken
+	}
+
+	org.Name = name
+	org.Domain = domain
+	org.UpdatedAt = int(time.Now().Unix())
+	s.data.organizations[id] = org
+	return org, nil
+}
+
+func (s *Store) UpdateOrganizationBranding(ctx context.Context, id string, logoURL string, primaryColor string, senderName string) (organization.Organization, error) {
+	defer s.lock(ctx)()
+
+	org, ok := s.data.organizations[id]
+	if !ok || org.DeletedAt > 0 {
+		return organization.Organization{}, sql.ErrNoRows
+	}
+
+	org.LogoURL = logoURL
+	org.PrimaryColor = primaryColor
+	org.SenderName = senderName
+	org.UpdatedAt = int(time.Now().Unix())
+	s.data.organizations[id] = org
+	return org, nil
+}
+
+func (s *Store) UpdateOrganizationPasswordPolicy(ctx context.Context, id string, policy password.Policy) (organization.Organization, error) {
+	defer s.lock(ctx)()
+
+	org, ok := s.data.organizations[id]
+	if !ok || org.DeletedAt > 0 {
+		return organization.Organization{}, sql.ErrNoRows
+	}
+
+	org.PasswordPolicy = policy
+	org.UpdatedAt = int(time.Now().Unix())
+	s.data.organizations[id] = org
+	return org, nil
+}
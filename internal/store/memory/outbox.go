@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"microauth.io/core/internal/outbox"
+)
+
+func (s *Store) InsertOutboxMessage(ctx context.Context, message outbox.Message) (string, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.outbox[message.ID]; ok {
+		return "", UniqueViolation
+	}
+	s.data.outbox[message.ID] = message
+	return message.ID, nil
+}
+
+func (s *Store) ClaimOutboxMessages(ctx context.Context, limit int, leaseUntil int) ([]outbox.Message, error) {
+	defer s.lock(ctx)()
+
+	now := int(time.Now().Unix())
+	due := make([]outbox.Message, 0)
+	for _, message := range s.data.outbox {
+		if (message.Status == outbox.Pending || message.Status == outbox.Sending) && message.NextAttemptAt <= now {
+			due = append(due, message)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt < due[j].NextAttemptAt
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for i := range due {
+		due[i].Status = outbox.Sending
+		due[i].Attempts++
+		due[i].NextAttemptAt = leaseUntil
+		due[i].UpdatedAt = now
+		s.data.outbox[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+func (s *Store) UpdateOutboxMessageStatus(ctx context.Context, id string, status outbox.Status, lastError string, nextAttemptAt int) (string, error) {
+	defer s.lock(ctx)()
+
+	message, ok := s.data.outbox[id]
+	if ok {
+		message.Status = status
+		message.LastError = lastError
+		message.NextAttemptAt = nextAttemptAt
+		message.UpdatedAt = int(time.Now().Unix())
+		s.data.outbox[id] = message
+	}
+	return "outbox message updated", nil
+}
+
+func (s *Store) FetchOutboxMessages(ctx context.Context, status outbox.Status) ([]outbox.Message, error) {
+	defer s.lock(ctx)()
+
+	messages := make([]outbox.Message, 0)
+	for _, message := range s.data.outbox {
+		if message.Status == status {
+			messages = append(messages, message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].UpdatedAt > messages[j].UpdatedAt
+	})
+	return messages, nil
+}
+
+func (s *Store) RetryOutboxMessage(ctx context.Context, id string) (string, error) {
+	defer s.lock(ctx)()
+
+	message, ok := s.data.outbox[id]
+	if !ok || (message.Status != outbox.Pending && message.Status != outbox.Dead) {
+		return "", sql.ErrNoRows
+	}
+
+	now := int(time.Now().Unix())
+	message.Status = outbox.Pending
+	message.Attempts = 0
+	message.NextAttemptAt = now
+	message.UpdatedAt = now
+	s.data.outbox[id] = message
+	return "outbox message updated", nil
+}
+
+func (s *Store) DeleteOutboxMessagesTo(ctx context.Context, address string) error {
+	defer s.lock(ctx)()
+
+	for id, message := range s.data.outbox {
+		if len(message.Recipients) == 1 && message.Recipients[0] == address {
+			delete(s.data.outbox, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// compareKeys compares sort keys like the row values of the sql stores,
+// the first key is compared as a number when numeric is set
+func compareKeys(a []string, b []string, numeric bool) int {
+	for i := range a {
+		if i == 0 && numeric {
+			x, _ := strconv.Atoi(a[i])
+			y, _ := strconv.Atoi(b[i])
+			if x != y {
+				if x < y {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// paginate sorts the items by their keys and returns up to limit of them
+// after the keys of after, like the keyset queries of the sql stores
+func paginate[T any](items []T, keys func(T) []string, numeric bool, descending bool, after []string, limit int) []T {
+	direction := 1
+	if descending {
+		direction = -1
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return direction*compareKeys(keys(items[i]), keys(items[j]), numeric) < 0
+	})
+	page := make([]T, 0)
+	for _, item := range items {
+		if len(page) == limit {
+			break
+		}
+		if len(after) > 0 && direction*compareKeys(keys(item), after, numeric) <= 0 {
+			continue
+		}
+		page = append(page, item)
+	}
+	return page
+}
@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"microauth.io/core/internal/privacy"
+)
+
+func (s *Store) InsertAccountDeletion(ctx context.Context, deletion privacy.Deletion) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.users[deletion.UserID]; !ok {
+		return ForeignKeyViolation
+	}
+	if _, ok := s.data.accountDeletions[deletion.UserID]; ok {
+		return UniqueViolation
+	}
+	s.data.accountDeletions[deletion.UserID] = deletion
+	return nil
+}
+
+func (s *Store) GetAccountDeletion(ctx context.Context, userID string) (privacy.Deletion, error) {
+	defer s.lock(ctx)()
+
+	deletion, ok := s.data.accountDeletions[userID]
+	if !ok {
+		return privacy.Deletion{}, sql.ErrNoRows
+	}
+	return deletion, nil
+}
+
+func (s *Store) FetchAccountDeletions(ctx context.Context) ([]privacy.Deletion, error) {
+	defer s.lock(ctx)()
+
+	deletions := make([]privacy.Deletion, 0, len(s.data.accountDeletions))
+	for _, userID := range sortedKeys(s.data.accountDeletions) {
+		deletions = append(deletions, s.data.accountDeletions[userID])
+	}
+	sort.SliceStable(deletions, func(i, j int) bool {
+		return deletions[i].DeleteAfter < deletions[j].DeleteAfter
+	})
+	return deletions, nil
+}
+
+func (s *Store) DeleteAccountDeletion(ctx context.Context, userID string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.accountDeletions[userID]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.data.accountDeletions, userID)
+	return nil
+}
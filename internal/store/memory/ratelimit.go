@@ -0,0 +1,34 @@
+package memory
+
+import (
+	"context"
+
+	"microauth.io/core/internal/ratelimit"
+)
+
+func (s *Store) TakeToken(ctx context.Context, key string, now int64, interval int64, capacity int) (ratelimit.Bucket, bool, error) {
+	defer s.lock(ctx)()
+
+	bucket, ok := s.data.buckets[key]
+	if !ok {
+		bucket = ratelimit.Bucket{Key: key}
+	}
+	bucket, taken := bucket.Take(now, interval, capacity)
+	if taken {
+		s.data.buckets[key] = bucket
+	}
+	return bucket, taken, nil
+}
+
+func (s *Store) PurgeBuckets(ctx context.Context, before int64) (int, error) {
+	defer s.lock(ctx)()
+
+	purged := 0
+	for key, bucket := range s.data.buckets {
+		if bucket.FullAt < before {
+			delete(s.data.buckets, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
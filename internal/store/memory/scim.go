@@ -0,0 +1,240 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"microauth.io/core/internal/scim"
+)
+
+func identityKey(organizationID string, userID string) string {
+	return organizationID + "/" + userID
+}
+
+func (s *Store) InsertSCIMToken(ctx context.Context, token scim.Token) (string, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.organizations[token.OrganizationID]; !ok {
+		return "", ForeignKeyViolation
+	}
+	if _, ok := s.data.scimTokens[token.ID]; ok {
+		return "", UniqueViolation
+	}
+	for _, existing := range s.data.scimTokens {
+		if existing.Hash == token.Hash {
+			return "", UniqueViolation
+		}
+	}
+	s.data.scimTokens[token.ID] = token
+	return token.ID, nil
+}
+
+func (s *Store) GetSCIMTokenByHash(ctx context.Context, hash string) (scim.Token, error) {
+	defer s.lock(ctx)()
+
+	for _, token := range s.data.scimTokens {
+		if token.Hash == hash && s.data.organizations[token.OrganizationID].DeletedAt == 0 {
+			return token, nil
+		}
+	}
+	return scim.Token{}, sql.ErrNoRows
+}
+
+func (s *Store) FetchSCIMTokens(ctx context.Context, organizationID string) ([]scim.Token, error) {
+	defer s.lock(ctx)()
+
+	tokens := []scim.Token{}
+	for _, token := range s.data.scimTokens {
+		if token.OrganizationID == organizationID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].CreatedAt != tokens[j].CreatedAt {
+			return tokens[i].CreatedAt < tokens[j].CreatedAt
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (s *Store) UpdateSCIMTokenLastUsed(ctx context.Context, id string, usedAt int) error {
+	defer s.lock(ctx)()
+
+	token, ok := s.data.scimTokens[id]
+	if !ok {
+		return nil
+	}
+	token.LastUsedAt = usedAt
+	s.data.scimTokens[id] = token
+	return nil
+}
+
+func (s *Store) DeleteSCIMToken(ctx context.Context, organizationID string, id string) error {
+	defer s.lock(ctx)()
+
+	token, ok := s.data.scimTokens[id]
+	if !ok || token.OrganizationID != organizationID {
+		return sql.ErrNoRows
+	}
+	delete(s.data.scimTokens, id)
+	return nil
+}
+
+func (s *Store) UpsertSCIMIdentity(ctx context.Context, identity scim.Identity) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.organizations[identity.OrganizationID]; !ok {
+		return ForeignKeyViolation
+	}
+	if _, ok := s.data.users[identity.UserID]; !ok {
+		return ForeignKeyViolation
+	}
+	key := identityKey(identity.OrganizationID, identity.UserID)
+	if existing, ok := s.data.scimIdentities[key]; ok {
+		identity.CreatedAt = existing.CreatedAt
+	}
+	s.data.scimIdentities[key] = identity
+	return nil
+}
+
+func (s *Store) GetSCIMIdentity(ctx context.Context, organizationID string, userID string) (scim.Identity, error) {
+	defer s.lock(ctx)()
+
+	identity, ok := s.data.scimIdentities[identityKey(organizationID, userID)]
+	if !ok {
+		return scim.Identity{}, sql.ErrNoRows
+	}
+	return identity, nil
+}
+
+func (s *Store) FetchSCIMIdentities(ctx context.Context, organizationID string) ([]scim.Identity, error) {
+	defer s.lock(ctx)()
+
+	identities := []scim.Identity{}
+	for _, identity := range s.data.scimIdentities {
+		if identity.OrganizationID == organizationID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].UserID < identities[j].UserID
+	})
+	return identities, nil
+}
+
+func (s *Store) DeleteSCIMIdentity(ctx context.Context, organizationID string, userID string) error {
+	defer s.lock(ctx)()
+
+	key := identityKey(organizationID, userID)
+	if _, ok := s.data.scimIdentities[key]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.data.scimIdentities, key)
+	return nil
+}
+
+func (s *Store) InsertSCIMGroup(ctx context.Context, group scim.Group) (string, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.organizations[group.OrganizationID]; !ok {
+		return "", ForeignKeyViolation
+	}
+	if _, ok := s.data.scimGroups[group.ID]; ok {
+		return "", UniqueViolation
+	}
+	if s.scimGroupNameTaken(group) {
+		return "", UniqueViolation
+	}
+	s.data.scimGroups[group.ID] = group
+	return group.ID, nil
+}
+
+// scimGroupNameTaken enforces the unique display name per organization
+func (s *Store) scimGroupNameTaken(group scim.Group) bool {
+	for _, existing := range s.data.scimGroups {
+		if existing.ID != group.ID && existing.OrganizationID == group.OrganizationID && existing.DisplayName == group.DisplayName {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) GetSCIMGroup(ctx context.Context, organizationID string, id string) (scim.Group, error) {
+	defer s.lock(ctx)()
+
+	group, ok := s.data.scimGroups[id]
+	if !ok || group.OrganizationID != organizationID {
+		return scim.Group{}, sql.ErrNoRows
+	}
+	return group, nil
+}
+
+func (s *Store) FetchSCIMGroups(ctx context.Context, organizationID string) ([]scim.Group, error) {
+	defer s.lock(ctx)()
+
+	groups := []scim.Group{}
+	for _, group := range s.data.scimGroups {
+		if group.OrganizationID == organizationID {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].CreatedAt != groups[j].CreatedAt {
+			return groups[i].CreatedAt < groups[j].CreatedAt
+		}
+		return groups[i].ID < groups[j].ID
+	})
+	return groups, nil
+}
+
+func (s *Store) UpdateSCIMGroup(ctx context.Context, group scim.Group) error {
+	defer s.lock(ctx)()
+
+	existing, ok := s.data.scimGroups[group.ID]
+	if !ok || existing.OrganizationID != group.OrganizationID {
+		return sql.ErrNoRows
+	}
+	if s.scimGroupNameTaken(group) {
+		return UniqueViolation
+	}
+	existing.DisplayName = group.DisplayName
+	existing.ExternalID = group.ExternalID
+	existing.Kind = group.Kind
+	existing.Value = group.Value
+	existing.UpdatedAt = group.UpdatedAt
+	s.data.scimGroups[group.ID] = existing
+	return nil
+}
+
+func (s *Store) DeleteSCIMGroup(ctx context.Context, organizationID string, id string) error {
+	defer s.lock(ctx)()
+
+	group, ok := s.data.scimGroups[id]
+	if !ok || group.OrganizationID != organizationID {
+		return sql.ErrNoRows
+	}
+	delete(s.data.scimGroups, id)
+	return nil
+}
+
+// deleteSCIM deletes the scim tokens, identities and groups of the
+// organization, like the cascade of the sql schema
+func (s *Store) deleteSCIM(organizationID string) {
+	for id, token := range s.data.scimTokens {
+		if token.OrganizationID == organizationID {
+			delete(s.data.scimTokens, id)
+		}
+	}
+	for key, identity := range s.data.scimIdentities {
+		if identity.OrganizationID == organizationID {
+			delete(s.data.scimIdentities, key)
+		}
+	}
+	for id, group := range s.data.scimGroups {
+		if group.OrganizationID == organizationID {
+			delete(s.data.scimGroups, id)
+		}
+	}
+}
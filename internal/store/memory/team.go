@@ -0,0 +1,199 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"microauth.io/core/internal/team"
+)
+
+func teamMemberKey(teamID string, userID string) string {
+	return teamID + "/" + userID
+}
+
+func copyTeam(t team.Team) team.Team {
+	t.AppRoles = append([]string{}, t.AppRoles...)
+	return t
+}
+
+// checkTeam enforces the foreign keys and the unique name of the team
+func (s *Store) checkTeam(t team.Team) error {
+	if _, ok := s.data.organizations[t.OrganizationID]; !ok {
+		return ForeignKeyViolation
+	}
+	if _, ok := s.data.teams[t.ParentID]; t.ParentID != "" && !ok {
+		return ForeignKeyViolation
+	}
+	for _, existing := range s.data.teams {
+		if existing.ID != t.ID && existing.OrganizationID == t.OrganizationID && existing.Name == t.Name {
+			return UniqueViolation
+		}
+	}
+	return nil
+}
+
+func (s *Store) InsertTeam(ctx context.Context, t team.Team) (string, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.teams[t.ID]; ok {
+		return "", UniqueViolation
+	}
+	if err := s.checkTeam(t); err != nil {
+		return "", err
+	}
+	s.data.teams[t.ID] = copyTeam(t)
+	return t.ID, nil
+}
+
+func (s *Store) GetTeam(ctx context.Context, organizationID string, id string) (team.Team, error) {
+	defer s.lock(ctx)()
+
+	t, ok := s.data.teams[id]
+	if !ok || t.OrganizationID != organizationID {
+		return team.Team{}, sql.ErrNoRows
+	}
+	return copyTeam(t), nil
+}
+
+func (s *Store) FetchTeams(ctx context.Context, organizationID string) ([]team.Team, error) {
+	defer s.lock(ctx)()
+
+	teams := []team.Team{}
+	for _, t := range s.data.teams {
+		if t.OrganizationID == organizationID {
+			teams = append(teams, copyTeam(t))
+		}
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		if teams[i].Name != teams[j].Name {
+			return teams[i].Name < teams[j].Name
+		}
+		return teams[i].ID < teams[j].ID
+	})
+	return teams, nil
+}
+
+func (s *Store) UpdateTeam(ctx context.Context, t team.Team) error {
+	defer s.lock(ctx)()
+
+	existing, ok := s.data.teams[t.ID]
+	if !ok || existing.OrganizationID != t.OrganizationID {
+		return sql.ErrNoRows
+	}
+	if err := s.checkTeam(t); err != nil {
+		return err
+	}
+	existing.ParentID = t.ParentID
+	existing.Name = t.Name
+	existing.Description = t.Description
+	existing.Role = t.Role
+	existing.AppRoles = append([]string{}, t.AppRoles...)
+	existing.UpdatedAt = t.UpdatedAt
+	s.data.teams[t.ID] = existing
+	return nil
+}
+
+func (s *Store) DeleteTeam(ctx context.Context, organizationID string, id string) error {
+	defer s.lock(ctx)()
+
+	t, ok := s.data.teams[id]
+	if !ok || t.OrganizationID != organizationID {
+		return sql.ErrNoRows
+	}
+	for _, child := range s.data.teams {
+		if child.ParentID == id {
+			return ForeignKeyViolation
+		}
+	}
+	delete(s.data.teams, id)
+	for key, membership := range s.data.teamMembers {
+		if membership.TeamID == id {
+			delete(s.data.teamMembers, key)
+		}
+	}
+	return nil
+}
+
+func (s *Store) InsertTeamMember(ctx context.Context, m team.Member) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.teams[m.TeamID]; !ok {
+		return ForeignKeyViolation
+	}
+	if _, ok := s.findMember(m.OrganizationID, m.UserID); !ok {
+		return ForeignKeyViolation
+	}
+	key := teamMemberKey(m.TeamID, m.UserID)
+	if _, ok := s.data.teamMembers[key]; ok {
+		return UniqueViolation
+	}
+	s.data.teamMembers[key] = m
+	return nil
+}
+
+func (s *Store) FetchTeamMembers(ctx context.Context, organizationID string, teamID string) ([]team.Member, error) {
+	defer s.lock(ctx)()
+
+	members := []team.Member{}
+	for _, m := range s.data.teamMembers {
+		if _, ok := s.activeMember(m.OrganizationID, m.UserID); !ok {
+			continue
+		}
+		if m.OrganizationID == organizationID && m.TeamID == teamID {
+			members = append(members, m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].CreatedAt != members[j].CreatedAt {
+			return members[i].CreatedAt < members[j].CreatedAt
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members, nil
+}
+
+func (s *Store) FetchUserTeams(ctx context.Context, organizationID string, userID string) ([]team.Member, error) {
+	defer s.lock(ctx)()
+
+	members := []team.Member{}
+	for _, m := range s.data.teamMembers {
+		if _, ok := s.activeMember(m.OrganizationID, m.UserID); !ok {
+			continue
+		}
+		if m.OrganizationID == organizationID && m.UserID == userID {
+			members = append(members, m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].TeamID < members[j].TeamID
+	})
+	return members, nil
+}
+
+func (s *Store) DeleteTeamMember(ctx context.Context, organizationID string, teamID string, userID string) error {
+	defer s.lock(ctx)()
+
+	key := teamMemberKey(teamID, userID)
+	m, ok := s.data.teamMembers[key]
+	if !ok || m.OrganizationID != organizationID {
+		return sql.ErrNoRows
+	}
+	delete(s.data.teamMembers, key)
+	return nil
+}
+
+// deleteTeams deletes the teams of the organization and their members,
+// like the cascade of the sql schema
+func (s *Store) deleteTeams(organizationID string) {
+	for id, t := range s.data.teams {
+		if t.OrganizationID == organizationID {
+			delete(s.data.teams, id)
+		}
+	}
+	for key, m := range s.data.teamMembers {
+		if m.OrganizationID == organizationID {
+			delete(s.data.teamMembers, key)
+		}
+	}
+}
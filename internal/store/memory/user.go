@@ -0,0 +1,307 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"microauth.io/core/internal/password"
+	"microauth.io/core/internal/user"
+)
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (user.User, error) {
+	defer s.lock(ctx)()
+
+	for _, u := range s.data.users {
+		if u.Email == email && u.DeletedAt == 0 {
+			return u, nil
+		}
+	}
+	return user.User{}, sql.ErrNoRows
+}
+
+func (s *Store) EmailExists(ctx context.Context, email string) (bool, error) {
+	defer s.lock(ctx)()
+
+	for _, u := range s.data.users {
+		if u.Email == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Store) InsertUser(ctx context.Context, firstName string, lastName string, email string, password string, isEmailVerified bool) (string, error) {
+	defer s.lock(ctx)()
+
+	for _, u := range s.data.users {
+		// like the unique index on LOWER(email)
+		if strings.EqualFold(u.Email, email) {
+			return "", UniqueViolation
+		}
+	}
+
+	now := int(time.Now().Unix())
+	u := user.User{
+		ID:              uuid.New().String(),
+		FirstName:       firstName,
+		LastName:        lastName,
+		Email:           email,
+		IsEmailVerified: isEmailVerified,
+		Password:        password,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	s.data.users[u.ID] = u
+	return u.ID, nil
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id string) (user.User, error) {
+	defer s.lock(ctx)()
+
+	u, ok := s.data.users[id]
+	if !ok || u.DeletedAt > 0 {
+		return user.User{}, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (s *Store) UpdateUserPassword(ctx context.Context, id string, hashedPassword string) error {
+	defer s.lock(ctx)()
+
+	u, ok := s.data.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	u.Password = hashedPassword
+	u.UpdatedAt = int(time.Now().Unix())
+	s.data.users[id] = u
+	return nil
+}
+
+func (s *Store) UpdateUserName(ctx context.Context, id string, firstName string, lastName string) error {
+	defer s.lock(ctx)()
+
+	u, ok := s.data.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	u.FirstName = firstName
+	u.LastName = lastName
+	u.UpdatedAt = int(time.Now().Unix())
+	s.data.users[id] = u
+	return nil
+}
+
+func (s *Store) UpdateUserProvisioner(ctx context.Context, id string, organizationID string) error {
+	defer s.lock(ctx)()
+
+	u, ok := s.data.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	u.ProvisionedBy = organizationID
+	s.data.users[id] = u
+	return nil
+}
+
+func (s *Store) UpdateUserEmail(ctx context.Context, id string, email string) error {
+	defer s.lock(ctx)()
+
+	u, ok := s.data.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	for _, other := range s.data.users {
+		if strings.EqualFold(other.Email, email) && other.ID != id {
+			return UniqueViolation
+		}
+	}
+	u.Email = email
+	u.IsEmailVerified = true
+	u.UpdatedAt = int(time.Now().Unix())
+	s.data.users[id] = u
+	return nil
+}
+
+func (s *Store) RevokeUserSessions(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	u, ok := s.data.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	u.SessionVersion++
+	u.UpdatedAt = int(time.Now().Unix())
+	s.data.users[id] = u
+	return nil
+}
+
+func (s *Store) SaveEmailChange(ctx context.Context, change user.EmailChange) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.users[change.UserID]; !ok {
+		return ForeignKeyViolation
+	}
+	s.data.emailChanges[change.UserID] = change
+	return nil
+}
+
+func (s *Store) GetEmailChange(ctx context.Context, userID string) (user.EmailChange, error) {
+	defer s.lock(ctx)()
+
+	change, ok := s.data.emailChanges[userID]
+	if !ok {
+		return user.EmailChange{}, sql.ErrNoRows
+	}
+	return change, nil
+}
+
+func (s *Store) DeleteEmailChange(ctx context.Context, userID string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.emailChanges[userID]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.data.emailChanges, userID)
+	return nil
+}
+
+func (s *Store) GetOrganizationPreference(ctx context.Context, userID string) (user.OrganizationPreference, error) {
+	defer s.lock(ctx)()
+
+	preference, ok := s.data.organizationPreferences[userID]
+	if !ok {
+		return user.OrganizationPreference{}, sql.ErrNoRows
+	}
+	return preference, nil
+}
+
+func (s *Store) SetDefaultOrganization(ctx context.Context, userID string, organizationID string) error {
+	return s.savePreference(ctx, userID, organizationID, func(preference *user.OrganizationPreference) {
+		preference.DefaultOrganizationID = organizationID
+	})
+}
+
+func (s *Store) SetLastOrganization(ctx context.Context, userID string, organizationID string) error {
+	return s.savePreference(ctx, userID, organizationID, func(preference *user.OrganizationPreference) {
+		preference.LastOrganizationID = organizationID
+	})
+}
+
+func (s *Store) savePreference(ctx context.Context, userID string, organizationID string, set func(*user.OrganizationPreference)) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.users[userID]; !ok {
+		return ForeignKeyViolation
+	}
+	if _, ok := s.data.organizations[organizationID]; organizationID != "" && !ok {
+		return ForeignKeyViolation
+	}
+	preference := s.data.organizationPreferences[userID]
+	preference.UserID = userID
+	set(&preference)
+	preference.UpdatedAt = int(time.Now().Unix())
+	s.data.organizationPreferences[userID] = preference
+	return nil
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	u, ok := s.data.users[id]
+	if !ok || u.DeletedAt > 0 {
+		return sql.ErrNoRows
+	}
+	deletedAt := now(ctx)
+	u.DeletedAt = deletedAt
+	u.UpdatedAt = deletedAt
+	s.data.users[id] = u
+	for memberID, mem := range s.data.members {
+		if mem.UserID == id && mem.DeletedAt == 0 {
+			mem.DeletedAt = deletedAt
+			s.data.members[memberID] = mem
+		}
+	}
+	return nil
+}
+
+func (s *Store) RestoreUser(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	u, ok := s.data.users[id]
+	if !ok || u.DeletedAt == 0 {
+		return sql.ErrNoRows
+	}
+	for memberID, mem := range s.data.members {
+		if mem.UserID == id && mem.DeletedAt == u.DeletedAt && s.data.organizations[mem.OrganizationID].DeletedAt == 0 {
+			mem.DeletedAt = 0
+			s.data.members[memberID] = mem
+		}
+	}
+	u.DeletedAt = 0
+	u.UpdatedAt = int(time.Now().Unix())
+	s.data.users[id] = u
+	return nil
+}
+
+func (s *Store) FetchDeletedUsers(ctx context.Context, before int) ([]user.User, error) {
+	defer s.lock(ctx)()
+
+	users := make([]user.User, 0)
+	for _, id := range sortedKeys(s.data.users) {
+		if u := s.data.users[id]; u.DeletedAt > 0 && u.DeletedAt < before {
+			users = append(users, u)
+		}
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].DeletedAt < users[j].DeletedAt
+	})
+	return users, nil
+}
+
+// PurgeUser mirrors the cascades of the schema, a user who still has a
+// member row, even a removed one, can't be purged
+func (s *Store) PurgeUser(ctx context.Context, id string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.users[id]; !ok {
+		return sql.ErrNoRows
+	}
+	for _, mem := range s.data.members {
+		if mem.UserID == id {
+			return ForeignKeyViolation
+		}
+	}
+
+	delete(s.data.users, id)
+	delete(s.data.emailChanges, id)
+	delete(s.data.accountDeletions, id)
+	delete(s.data.organizationPreferences, id)
+	for key, identity := range s.data.scimIdentities {
+		if identity.UserID == id {
+			delete(s.data.scimIdentities, key)
+		}
+	}
+	return nil
+}
+
+func (s *Store) FetchUserPasswordPolicies(ctx context.Context, userID string) ([]password.Policy, error) {
+	defer s.lock(ctx)()
+
+	policies := make([]password.Policy, 0)
+	for _, id := range sortedKeys(s.data.members) {
+		mem := s.data.members[id]
+		if mem.UserID != userID || mem.DeletedAt > 0 {
+			continue
+		}
+		org, ok := s.data.organizations[mem.OrganizationID]
+		if ok && org.DeletedAt == 0 && org.PasswordPolicy != (password.Policy{}) {
+			policies = append(policies, org.PasswordPolicy)
+		}
+	}
+	return policies, nil
+}
@@ -0,0 +1,177 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"microauth.io/core/internal/webhook"
+)
+
+func (s *Store) InsertWebhookEndpoint(ctx context.Context, endpoint webhook.Endpoint) (string, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.organizations[endpoint.OrganizationID]; !ok {
+		return "", ForeignKeyViolation
+	}
+	if _, ok := s.data.webhooks[endpoint.ID]; ok {
+		return "", UniqueViolation
+	}
+	endpoint.Events = append([]string(nil), endpoint.Events...)
+	s.data.webhooks[endpoint.ID] = endpoint
+	return endpoint.ID, nil
+}
+
+func (s *Store) GetWebhookEndpoint(ctx context.Context, organizationID string, id string) (webhook.Endpoint, error) {
+	defer s.lock(ctx)()
+
+	endpoint, ok := s.data.webhooks[id]
+	if !ok || endpoint.OrganizationID != organizationID {
+		return webhook.Endpoint{}, sql.ErrNoRows
+	}
+	return endpoint, nil
+}
+
+func (s *Store) FetchWebhookEndpoints(ctx context.Context, organizationID string) ([]webhook.Endpoint, error) {
+	defer s.lock(ctx)()
+
+	endpoints := []webhook.Endpoint{}
+	for _, endpoint := range s.data.webhooks {
+		if endpoint.OrganizationID == organizationID {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].CreatedAt != endpoints[j].CreatedAt {
+			return endpoints[i].CreatedAt < endpoints[j].CreatedAt
+		}
+		return endpoints[i].ID < endpoints[j].ID
+	})
+	return endpoints, nil
+}
+
+func (s *Store) UpdateWebhookEndpoint(ctx context.Context, endpoint webhook.Endpoint) error {
+	defer s.lock(ctx)()
+
+	existing, ok := s.data.webhooks[endpoint.ID]
+	if !ok || existing.OrganizationID != endpoint.OrganizationID {
+		return sql.ErrNoRows
+	}
+	existing.URL = endpoint.URL
+	existing.Events = append([]string(nil), endpoint.Events...)
+	existing.Active = endpoint.Active
+	existing.UpdatedAt = endpoint.UpdatedAt
+	s.data.webhooks[endpoint.ID] = existing
+	return nil
+}
+
+func (s *Store) DeleteWebhookEndpoint(ctx context.Context, organizationID string, id string) error {
+	defer s.lock(ctx)()
+
+	endpoint, ok := s.data.webhooks[id]
+	if !ok || endpoint.OrganizationID != organizationID {
+		return sql.ErrNoRows
+	}
+	s.deleteWebhook(id)
+	return nil
+}
+
+// deleteWebhook deletes the endpoint with its deliveries, like the cascade
+// of the sql schema
+func (s *Store) deleteWebhook(id string) {
+	delete(s.data.webhooks, id)
+	for deliveryID, delivery := range s.data.deliveries {
+		if delivery.EndpointID == id {
+			delete(s.data.deliveries, deliveryID)
+		}
+	}
+}
+
+func (s *Store) InsertWebhookDelivery(ctx context.Context, delivery webhook.Delivery) (string, error) {
+	defer s.lock(ctx)()
+
+	if _, ok := s.data.webhooks[delivery.EndpointID]; !ok {
+		return "", ForeignKeyViolation
+	}
+	if _, ok := s.data.deliveries[delivery.ID]; ok {
+		return "", UniqueViolation
+	}
+	s.data.deliveries[delivery.ID] = delivery
+	return delivery.ID, nil
+}
+
+func (s *Store) GetWebhookDelivery(ctx context.Context, endpointID string, id string) (webhook.Delivery, error) {
+	defer s.lock(ctx)()
+
+	delivery, ok := s.data.deliveries[id]
+	if !ok || delivery.EndpointID != endpointID {
+		return webhook.Delivery{}, sql.ErrNoRows
+	}
+	return delivery, nil
+}
+
+func (s *Store) FetchWebhookDeliveries(ctx context.Context, endpointID string, status webhook.Status, limit int) ([]webhook.Delivery, error) {
+	defer s.lock(ctx)()
+
+	deliveries := []webhook.Delivery{}
+	for _, delivery := range s.data.deliveries {
+		if delivery.EndpointID == endpointID && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].CreatedAt != deliveries[j].CreatedAt {
+			return deliveries[i].CreatedAt > deliveries[j].CreatedAt
+		}
+		return deliveries[i].ID < deliveries[j].ID
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, leaseUntil int) ([]webhook.Delivery, error) {
+	defer s.lock(ctx)()
+
+	now := int(time.Now().Unix())
+	due := []webhook.Delivery{}
+	for _, delivery := range s.data.deliveries {
+		if (delivery.Status == webhook.Pending || delivery.Status == webhook.Sending) && delivery.NextAttemptAt <= now {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt < due[j].NextAttemptAt
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for i, delivery := range due {
+		delivery.Status = webhook.Sending
+		delivery.Attempts++
+		delivery.NextAttemptAt = leaseUntil
+		delivery.UpdatedAt = now
+		s.data.deliveries[delivery.ID] = delivery
+		due[i] = delivery
+	}
+	return due, nil
+}
+
+func (s *Store) UpdateWebhookDeliveryStatus(ctx context.Context, id string, status webhook.Status, responseStatus int, lastError string, nextAttemptAt int) error {
+	defer s.lock(ctx)()
+
+	delivery, ok := s.data.deliveries[id]
+	if !ok {
+		return nil
+	}
+	delivery.Status = status
+	delivery.ResponseStatus = responseStatus
+	delivery.LastError = lastError
+	delivery.NextAttemptAt = nextAttemptAt
+	delivery.UpdatedAt = int(time.Now().Unix())
+	s.data.deliveries[id] = delivery
+	return nil
+}
@@ -0,0 +1,62 @@
+package storetest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"microauth.io/core/internal/database"
+	"microauth.io/core/internal/migrate"
+	"microauth.io/core/internal/store/memory"
+	"microauth.io/core/migrations"
+)
+
+// PostgresURLEnv names the variable holding the dsn of a scratch postgres
+// database, the postgres backend is skipped when it is not set
+const PostgresURLEnv = "MICROAUTH_TEST_POSTGRES_URL"
+
+// Memory opens an empty in process store
+func Memory(t *testing.T) Stores {
+	return memory.New()
+}
+
+// SQLite opens a fresh, migrated in memory database
+func SQLite(t *testing.T) Stores {
+	return open(t, database.SQLite, ":memory:")
+}
+
+// Postgres opens the database named by PostgresURLEnv and migrates it
+func Postgres(t *testing.T) Stores {
+	url := os.Getenv(PostgresURLEnv)
+	if url == "" {
+		t.Skip(PostgresURLEnv + " is not set")
+	}
+	return open(t, database.Postgres, url)
+}
+
+func open(t *testing.T, driver string, url string) Stores {
+	t.Helper()
+
+	db := database.New()
+	if err := db.Connect(driver, url); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() {
+		db.DB().Close()
+	})
+
+	source, err := migrations.Source(driver)
+	if err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	migrator, err := migrate.New(db.DB(), driver, source)
+	if err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	err = migrator.Up(context.Background())
+	if err != nil && err != migrate.NoChange {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return db
+}
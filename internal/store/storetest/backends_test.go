@@ -0,0 +1,15 @@
+package storetest
+
+import "testing"
+
+func TestMemory(t *testing.T) {
+	Run(t, Memory)
+}
+
+func TestSQLite(t *testing.T) {
+	Run(t, SQLite)
+}
+
+func TestPostgres(t *testing.T) {
+	Run(t, Postgres)
+}
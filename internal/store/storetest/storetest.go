@@ -0,0 +1,2200 @@
+// Package storetest is the conformance suite every store backend must pass.
+// A backend test calls Run with a function opening an empty, migrated
+// store:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, storetest.Memory)
+//		storetest.Run(t, storetest.SQLite)
+//		storetest.Run(t, storetest.Postgres)
+//	}
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/lockout"
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/organization"
+	"microauth.io/core/internal/outbox"
+	"microauth.io/core/internal/password"
+	"microauth.io/core/internal/privacy"
+	"microauth.io/core/internal/ratelimit"
+	"microauth.io/core/internal/scim"
+	"microauth.io/core/internal/team"
+	"microauth.io/core/internal/user"
+	"microauth.io/core/internal/webhook"
+)
+
+// Stores is everything a backend has to implement
+type Stores interface {
+	user.UserStore
+	organization.OrganizationStore
+	member.MemberStore
+	outbox.OutboxStore
+	lockout.AttemptStore
+	ratelimit.Store
+	audit.Store
+	webhook.Store
+	scim.Store
+	team.Store
+	privacy.Store
+}
+
+var errRollback = errors.New("rollback")
+
+// Run runs the whole suite, open is called once per test
+func Run(t *testing.T, open func(*testing.T) Stores) {
+	tests := []struct {
+		name string
+		test func(*testing.T, Stores)
+	}{
+		{"InsertAndGetUser", testInsertAndGetUser},
+		{"UniqueEmail", testUniqueEmail},
+		{"MissingUser", testMissingUser},
+		{"UpdateUserPassword", testUpdateUserPassword},
+		{"UpdateUserName", testUpdateUserName},
+		{"UpdateUserProvisioner", testUpdateUserProvisioner},
+		{"UpdateUserEmail", testUpdateUserEmail},
+		{"RevokeUserSessions", testRevokeUserSessions},
+		{"EmailChange", testEmailChange},
+		{"OrganizationPreference", testOrganizationPreference},
+		{"InsertAndGetOrganization", testInsertAndGetOrganization},
+		{"UniqueDomain", testUniqueDomain},
+		{"UpdateOrganization", testUpdateOrganization},
+		{"UpdateBranding", testUpdateBranding},
+		{"PasswordPolicy", testPasswordPolicy},
+		{"OrganizationsByUser", testOrganizationsByUser},
+		{"DeleteOrganization", testDeleteOrganization},
+		{"InsertAndFetchMember", testInsertAndFetchMember},
+		{"ListMembers", testListMembers},
+		{"UniqueMember", testUniqueMember},
+		{"UpdateMember", testUpdateMember},
+		{"DeleteMember", testDeleteMember},
+		{"MemberInvite", testMemberInvite},
+		{"OwnershipTransfer", testOwnershipTransfer},
+		{"OutboxClaim", testOutboxClaim},
+		{"OutboxRetry", testOutboxRetry},
+		{"LoginAttempts", testLoginAttempts},
+		{"RateLimitBuckets", testRateLimitBuckets},
+		{"AuditEvents", testAuditEvents},
+		{"AuditChain", testAuditChain},
+		{"WebhookEndpoints", testWebhookEndpoints},
+		{"WebhookDeliveries", testWebhookDeliveries},
+		{"SCIMTokens", testSCIMTokens},
+		{"SCIMIdentities", testSCIMIdentities},
+		{"SCIMGroups", testSCIMGroups},
+		{"Teams", testTeams},
+		{"TeamMembers", testTeamMembers},
+		{"SoftDeleteMember", testSoftDeleteMember},
+		{"SoftDeleteOrganization", testSoftDeleteOrganization},
+		{"SoftDeleteUser", testSoftDeleteUser},
+		{"Purge", testPurge},
+		{"PurgeUser", testPurgeUser},
+		{"AccountDeletions", testAccountDeletions},
+		{"AuditRedaction", testAuditRedaction},
+		{"TransactionCommit", testTransactionCommit},
+		{"TransactionRollback", testTransactionRollback},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, open(t))
+		})
+	}
+}
+
+// unique values keep the suite independent of what a shared database
+// already contains
+func uniqueEmail() string {
+	return uuid.New().String() + "@example.com"
+}
+
+func uniqueDomain() string {
+	return uuid.New().String() + ".example.com"
+}
+
+func newUser(t *testing.T, stores Stores) string {
+	t.Helper()
+	id, err := stores.InsertUser(context.Background(), "Ada", "Lovelace", uniqueEmail(), "hash", false)
+	if err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	return id
+}
+
+func newOrganization(t *testing.T, stores Stores) string {
+	t.Helper()
+	id, err := stores.InsertOrganization(context.Background(), "Acme", uniqueDomain())
+	if err != nil {
+		t.Fatalf("InsertOrganization: %v", err)
+	}
+	return id
+}
+
+func testInsertAndGetUser(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	email := uniqueEmail()
+
+	id, err := stores.InsertUser(ctx, "Ada", "Lovelace", email, "hash", true)
+	if err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+
+	got, err := stores.GetUserByEmail(ctx, email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if got.ID != id || got.FirstName != "Ada" || got.LastName != "Lovelace" || got.Email != email || got.Password != "hash" || !got.IsEmailVerified {
+		t.Errorf("GetUserByEmail = %+v", got)
+	}
+	if got.IsAdmin {
+		t.Errorf("new users must not be admins")
+	}
+	if got.CreatedAt == 0 || got.UpdatedAt == 0 {
+		t.Errorf("timestamps not set: %+v", got)
+	}
+}
+
+func testUniqueEmail(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	email := uniqueEmail()
+
+	if _, err := stores.InsertUser(ctx, "Ada", "Lovelace", email, "hash", false); err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	if _, err := stores.InsertUser(ctx, "Other", "User", email, "hash", false); err == nil {
+		t.Errorf("InsertUser with a taken email succeeded")
+	}
+	if _, err := stores.InsertUser(ctx, "Other", "User", strings.ToUpper(email), "hash", false); err == nil {
+		t.Errorf("InsertUser with a taken email in another case succeeded")
+	}
+}
+
+func testMissingUser(t *testing.T, stores Stores) {
+	if _, err := stores.GetUserByEmail(context.Background(), uniqueEmail()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByEmail of an unknown email: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testUpdateUserPassword(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newUser(t, stores)
+
+	if err := stores.UpdateUserPassword(ctx, id, "new hash"); err != nil {
+		t.Fatalf("UpdateUserPassword: %v", err)
+	}
+	got, err := stores.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.ID != id || got.Password != "new hash" {
+		t.Errorf("GetUserByID = %+v", got)
+	}
+
+	missing := uuid.New().String()
+	if _, err := stores.GetUserByID(ctx, missing); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByID of an unknown id: got %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.UpdateUserPassword(ctx, missing, "hash"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UpdateUserPassword of an unknown id: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testUpdateUserName(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newUser(t, stores)
+
+	if err := stores.UpdateUserName(ctx, id, "Grace", "Hopper"); err != nil {
+		t.Fatalf("UpdateUserName: %v", err)
+	}
+	got, err := stores.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.FirstName != "Grace" || got.LastName != "Hopper" || got.Password != "hash" {
+		t.Errorf("GetUserByID after UpdateUserName = %+v", got)
+	}
+	if err := stores.UpdateUserName(ctx, uuid.New().String(), "Grace", "Hopper"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UpdateUserName of an unknown id: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testUpdateUserProvisioner(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newUser(t, stores)
+	orgID := newOrganization(t, stores)
+
+	if err := stores.UpdateUserProvisioner(ctx, id, orgID); err != nil {
+		t.Fatalf("UpdateUserProvisioner: %v", err)
+	}
+	got, err := stores.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.ProvisionedBy != orgID {
+		t.Errorf("GetUserByID after UpdateUserProvisioner = %+v, want provisioned by %s", got, orgID)
+	}
+	if err := stores.UpdateUserProvisioner(ctx, uuid.New().String(), orgID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UpdateUserProvisioner of an unknown id: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testUpdateUserEmail(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newUser(t, stores)
+	email := uniqueEmail()
+
+	if err := stores.UpdateUserEmail(ctx, id, email); err != nil {
+		t.Fatalf("UpdateUserEmail: %v", err)
+	}
+	got, err := stores.GetUserByEmail(ctx, email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if got.ID != id || !got.IsEmailVerified {
+		t.Errorf("GetUserByEmail after UpdateUserEmail = %+v", got)
+	}
+
+	other := newUser(t, stores)
+	if err := stores.UpdateUserEmail(ctx, other, email); err == nil {
+		t.Errorf("UpdateUserEmail to a taken address succeeded")
+	}
+	if err := stores.UpdateUserEmail(ctx, uuid.New().String(), uniqueEmail()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UpdateUserEmail of an unknown id: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testRevokeUserSessions(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newUser(t, stores)
+
+	for i := 0; i < 2; i++ {
+		if err := stores.RevokeUserSessions(ctx, id); err != nil {
+			t.Fatalf("RevokeUserSessions: %v", err)
+		}
+	}
+	got, err := stores.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.SessionVersion != 2 {
+		t.Errorf("SessionVersion after two revocations = %d, want 2", got.SessionVersion)
+	}
+	if err := stores.RevokeUserSessions(ctx, uuid.New().String()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RevokeUserSessions of an unknown id: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testEmailChange(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newUser(t, stores)
+
+	if _, err := stores.GetEmailChange(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetEmailChange without a change: got %v, want sql.ErrNoRows", err)
+	}
+
+	first := user.EmailChange{UserID: id, Email: uniqueEmail(), CodeHash: "first", ExpiresAt: 200, CreatedAt: 100}
+	if err := stores.SaveEmailChange(ctx, first); err != nil {
+		t.Fatalf("SaveEmailChange: %v", err)
+	}
+	second := user.EmailChange{UserID: id, Email: uniqueEmail(), CodeHash: "second", ExpiresAt: 300, CreatedAt: 150}
+	if err := stores.SaveEmailChange(ctx, second); err != nil {
+		t.Fatalf("SaveEmailChange replacing a change: %v", err)
+	}
+	got, err := stores.GetEmailChange(ctx, id)
+	if err != nil {
+		t.Fatalf("GetEmailChange: %v", err)
+	}
+	if got != second {
+		t.Errorf("GetEmailChange = %+v, want %+v", got, second)
+	}
+
+	if err := stores.DeleteEmailChange(ctx, id); err != nil {
+		t.Fatalf("DeleteEmailChange: %v", err)
+	}
+	if err := stores.DeleteEmailChange(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteEmailChange twice: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testOrganizationPreference(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newUser(t, stores)
+	first := newOrganization(t, stores)
+	second := newOrganization(t, stores)
+
+	if _, err := stores.GetOrganizationPreference(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetOrganizationPreference without a preference: got %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.SetLastOrganization(ctx, id, first); err != nil {
+		t.Fatalf("SetLastOrganization: %v", err)
+	}
+	if err := stores.SetDefaultOrganization(ctx, id, second); err != nil {
+		t.Fatalf("SetDefaultOrganization: %v", err)
+	}
+	got, err := stores.GetOrganizationPreference(ctx, id)
+	if err != nil {
+		t.Fatalf("GetOrganizationPreference: %v", err)
+	}
+	if got.UserID != id || got.DefaultOrganizationID != second || got.LastOrganizationID != first {
+		t.Errorf("GetOrganizationPreference = %+v, want default %s and last %s", got, second, first)
+	}
+	if err := stores.SetDefaultOrganization(ctx, id, uuid.New().String()); err == nil {
+		t.Errorf("SetDefaultOrganization of an unknown organization succeeded")
+	}
+
+	if err := stores.SetDefaultOrganization(ctx, id, ""); err != nil {
+		t.Fatalf("SetDefaultOrganization clearing it: %v", err)
+	}
+	if err := stores.PurgeOrganization(ctx, first); err != nil {
+		t.Fatalf("PurgeOrganization: %v", err)
+	}
+	got, err = stores.GetOrganizationPreference(ctx, id)
+	if err != nil {
+		t.Fatalf("GetOrganizationPreference: %v", err)
+	}
+	if got.DefaultOrganizationID != "" || got.LastOrganizationID != "" {
+		t.Errorf("GetOrganizationPreference after clearing and purging = %+v, want neither", got)
+	}
+}
+
+func testInsertAndGetOrganization(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	domain := uniqueDomain()
+
+	id, err := stores.InsertOrganization(ctx, "Acme", domain)
+	if err != nil {
+		t.Fatalf("InsertOrganization: %v", err)
+	}
+
+	got, err := stores.GetOrganizationByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetOrganizationByID: %v", err)
+	}
+	if got.ID != id || got.Name != "Acme" || got.Domain != domain {
+		t.Errorf("GetOrganizationByID = %+v", got)
+	}
+
+	if _, err := stores.GetOrganizationByID(ctx, uuid.New().String()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetOrganizationByID of an unknown id: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testUniqueDomain(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	domain := uniqueDomain()
+
+	if _, err := stores.InsertOrganization(ctx, "Acme", domain); err != nil {
+		t.Fatalf("InsertOrganization: %v", err)
+	}
+	if _, err := stores.InsertOrganization(ctx, "Other", domain); !errors.Is(err, organization.DomainTaken) {
+		t.Errorf("InsertOrganization with a taken domain: got %v, want DomainTaken", err)
+	}
+	other, err := stores.InsertOrganization(ctx, "Other", uniqueDomain())
+	if err != nil {
+		t.Fatalf("InsertOrganization: %v", err)
+	}
+	if _, err := stores.UpdateOrganization(ctx, other, "Other", domain); !errors.Is(err, organization.DomainTaken) {
+		t.Errorf("UpdateOrganization to a taken domain: got %v, want DomainTaken", err)
+	}
+}
+
+func testUpdateOrganization(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newOrganization(t, stores)
+	domain := uniqueDomain()
+
+	got, err := stores.UpdateOrganization(ctx, id, "Renamed", domain)
+	if err != nil {
+		t.Fatalf("UpdateOrganization: %v", err)
+	}
+	if got.Name != "Renamed" || got.Domain != domain {
+		t.Errorf("UpdateOrganization = %+v", got)
+	}
+
+	taken := newOrganization(t, stores)
+	takenOrg, err := stores.GetOrganizationByID(ctx, taken)
+	if err != nil {
+		t.Fatalf("GetOrganizationByID: %v", err)
+	}
+	if _, err := stores.UpdateOrganization(ctx, id, "Renamed", takenOrg.Domain); err == nil {
+		t.Errorf("UpdateOrganization to a taken domain succeeded")
+	}
+}
+
+func testUpdateBranding(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newOrganization(t, stores)
+
+	got, err := stores.UpdateOrganizationBranding(ctx, id, "https://example.com/logo.png", "#ff0000", "Acme Team")
+	if err != nil {
+		t.Fatalf("UpdateOrganizationBranding: %v", err)
+	}
+	if got.LogoURL != "https://example.com/logo.png" || got.PrimaryColor != "#ff0000" || got.SenderName != "Acme Team" {
+		t.Errorf("UpdateOrganizationBranding = %+v", got)
+	}
+}
+
+func testPasswordPolicy(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	strict := newOrganization(t, stores)
+	lax := newOrganization(t, stores)
+	foreign := newOrganization(t, stores)
+
+	for _, orgID := range []string{strict, lax} {
+		if _, err := stores.InsertMember(ctx, orgID, userID, member.User, ""); err != nil {
+			t.Fatalf("InsertMember: %v", err)
+		}
+	}
+
+	policy := password.Policy{MinLength: 12, RequireSymbol: true, MinScore: 3}
+	got, err := stores.UpdateOrganizationPasswordPolicy(ctx, strict, policy)
+	if err != nil {
+		t.Fatalf("UpdateOrganizationPasswordPolicy: %v", err)
+	}
+	if got.PasswordPolicy != policy {
+		t.Errorf("UpdateOrganizationPasswordPolicy = %+v", got.PasswordPolicy)
+	}
+	if _, err := stores.UpdateOrganizationPasswordPolicy(ctx, foreign, password.Policy{MinLength: 20}); err != nil {
+		t.Fatalf("UpdateOrganizationPasswordPolicy: %v", err)
+	}
+
+	org, err := stores.GetOrganizationByID(ctx, lax)
+	if err != nil {
+		t.Fatalf("GetOrganizationByID: %v", err)
+	}
+	if org.PasswordPolicy != (password.Policy{}) {
+		t.Errorf("organization without a policy has %+v", org.PasswordPolicy)
+	}
+
+	policies, err := stores.FetchUserPasswordPolicies(ctx, userID)
+	if err != nil {
+		t.Fatalf("FetchUserPasswordPolicies: %v", err)
+	}
+	if len(policies) != 1 || policies[0] != policy {
+		t.Errorf("FetchUserPasswordPolicies = %+v, want only %+v", policies, policy)
+	}
+
+	if _, err := stores.UpdateOrganizationPasswordPolicy(ctx, strict, password.Policy{}); err != nil {
+		t.Fatalf("UpdateOrganizationPasswordPolicy: %v", err)
+	}
+	policies, err = stores.FetchUserPasswordPolicies(ctx, userID)
+	if err != nil {
+		t.Fatalf("FetchUserPasswordPolicies: %v", err)
+	}
+	if len(policies) != 0 {
+		t.Errorf("FetchUserPasswordPolicies after removing the policy = %+v", policies)
+	}
+}
+
+func testOrganizationsByUser(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	newOrganization(t, stores)
+
+	ids := map[string]string{}
+	for _, name := range []string{"Gamma", "Alpha", "Beta"} {
+		id, err := stores.InsertOrganization(ctx, name, uniqueDomain())
+		if err != nil {
+			t.Fatalf("InsertOrganization: %v", err)
+		}
+		role := member.User
+		if name == "Beta" {
+			role = member.Admin
+		}
+		if _, err := stores.InsertMember(ctx, id, userID, role, ""); err != nil {
+			t.Fatalf("InsertMember: %v", err)
+		}
+		ids[name] = id
+	}
+
+	fetch := func(filter organization.Filter) []string {
+		t.Helper()
+		filter.UserID = userID
+		if filter.Sort == "" {
+			filter.Sort = organization.SortName
+		}
+		got, err := stores.FetchUserOrganizations(ctx, filter)
+		if err != nil {
+			t.Fatalf("FetchUserOrganizations: %v", err)
+		}
+		names := make([]string, len(got))
+		for i, org := range got {
+			names[i] = org.Name
+		}
+		return names
+	}
+
+	if got := fetch(organization.Filter{Limit: 10}); strings.Join(got, ",") != "Alpha,Beta,Gamma" {
+		t.Errorf("FetchUserOrganizations = %v, want the organizations of the user by name", got)
+	}
+	if got := fetch(organization.Filter{Limit: 10, Descending: true}); strings.Join(got, ",") != "Gamma,Beta,Alpha" {
+		t.Errorf("FetchUserOrganizations descending = %v", got)
+	}
+	after := []string{"Alpha", ids["Alpha"]}
+	if got := fetch(organization.Filter{Limit: 1, After: after}); strings.Join(got, ",") != "Beta" {
+		t.Errorf("FetchUserOrganizations after Alpha = %v, want Beta", got)
+	}
+	if got := fetch(organization.Filter{Limit: 10, Role: member.Admin}); strings.Join(got, ",") != "Beta" {
+		t.Errorf("FetchUserOrganizations of admins = %v, want Beta", got)
+	}
+	if got := fetch(organization.Filter{Limit: 10, Search: "AMM"}); strings.Join(got, ",") != "Gamma" {
+		t.Errorf("FetchUserOrganizations searching amm = %v, want Gamma", got)
+	}
+	if got := fetch(organization.Filter{Limit: 10, Search: "%"}); len(got) != 0 {
+		t.Errorf("FetchUserOrganizations searching %% = %v, want none", got)
+	}
+	organizations, err := stores.FetchUserOrganizations(ctx, organization.Filter{UserID: userID, Sort: organization.SortName, Limit: 10})
+	if err != nil {
+		t.Fatalf("FetchUserOrganizations: %v", err)
+	}
+	for _, org := range organizations {
+		want := member.User
+		if org.Name == "Beta" {
+			want = member.Admin
+		}
+		if org.Role != want {
+			t.Errorf("FetchUserOrganizations role in %s = %q, want %q", org.Name, org.Role, want)
+		}
+	}
+
+	beta, err := stores.GetOrganizationByID(ctx, ids["Beta"])
+	if err != nil {
+		t.Fatalf("GetOrganizationByID: %v", err)
+	}
+	filter := organization.Filter{Sort: organization.SortCreated, Limit: 10, After: beta.Keys(organization.SortCreated)}
+	for _, name := range fetch(filter) {
+		if name == "Beta" {
+			t.Errorf("FetchUserOrganizations by creation after Beta returned Beta")
+		}
+	}
+}
+
+func testListMembers(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+
+	people := []struct {
+		first string
+		last  string
+		email string
+		role  member.Role
+	}{
+		{"Grace", "Hopper", "c@example.com", member.User},
+		{"Alan", "Turing", "a@example.com", member.Admin},
+		{"Ada", "Lovelace", "b@example.com", member.User},
+	}
+	for _, p := range people {
+		email := p.email[:1] + uuid.New().String() + "@example.com"
+		userID, err := stores.InsertUser(ctx, p.first, p.last, email, "hash", false)
+		if err != nil {
+			t.Fatalf("InsertUser: %v", err)
+		}
+		if _, err := stores.InsertMember(ctx, orgID, userID, p.role, "billing"); err != nil {
+			t.Fatalf("InsertMember: %v", err)
+		}
+	}
+
+	fetch := func(filter member.Filter) []member.Listing {
+		t.Helper()
+		filter.OrganizationID = orgID
+		filter.Limit = 10
+		got, err := stores.FetchMembers(ctx, filter)
+		if err != nil {
+			t.Fatalf("FetchMembers: %v", err)
+		}
+		return got
+	}
+	names := func(listings []member.Listing) string {
+		last := make([]string, len(listings))
+		for i, l := range listings {
+			last[i] = l.LastName
+		}
+		return strings.Join(last, ",")
+	}
+
+	got := fetch(member.Filter{Sort: member.SortEmail})
+	if names(got) != "Turing,Lovelace,Hopper" {
+		t.Fatalf("FetchMembers by email = %v", names(got))
+	}
+	if got[0].FirstName != "Alan" || got[0].Role != member.Admin || got[0].AppRole != "billing" || got[0].OrganizationID != orgID {
+		t.Errorf("FetchMembers listing = %+v", got[0])
+	}
+	if got := fetch(member.Filter{Sort: member.SortName}); names(got) != "Hopper,Lovelace,Turing" {
+		t.Errorf("FetchMembers by name = %v", names(got))
+	}
+	if got := fetch(member.Filter{Sort: member.SortName, Descending: true}); names(got) != "Turing,Lovelace,Hopper" {
+		t.Errorf("FetchMembers by name descending = %v", names(got))
+	}
+	if got := fetch(member.Filter{Sort: member.SortRole}); names(got) != "Turing,Lovelace,Hopper" {
+		t.Errorf("FetchMembers by role = %v", names(got))
+	}
+	after := got[0].Keys(member.SortEmail)
+	if got := fetch(member.Filter{Sort: member.SortEmail, After: after}); names(got) != "Lovelace,Hopper" {
+		t.Errorf("FetchMembers after the first = %v", names(got))
+	}
+	if got := fetch(member.Filter{Sort: member.SortEmail, Role: member.User}); names(got) != "Lovelace,Hopper" {
+		t.Errorf("FetchMembers of users = %v", names(got))
+	}
+	if got := fetch(member.Filter{Sort: member.SortEmail, AppRole: "support"}); len(got) != 0 {
+		t.Errorf("FetchMembers of another app role = %v", names(got))
+	}
+	if got := fetch(member.Filter{Sort: member.SortEmail, Search: "ada love"}); names(got) != "Lovelace" {
+		t.Errorf("FetchMembers searching the full name = %v", names(got))
+	}
+	if got := fetch(member.Filter{Sort: member.SortEmail, Search: "TURING"}); names(got) != "Turing" {
+		t.Errorf("FetchMembers searching the last name = %v", names(got))
+	}
+	if got := fetch(member.Filter{Sort: member.SortEmail, Search: "_"}); len(got) != 0 {
+		t.Errorf("FetchMembers searching _ = %v, want none", names(got))
+	}
+}
+
+func testDeleteOrganization(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newOrganization(t, stores)
+
+	if _, err := stores.DeleteOrganizationByID(ctx, id); err != nil {
+		t.Fatalf("DeleteOrganizationByID: %v", err)
+	}
+	if _, err := stores.GetOrganizationByID(ctx, id); err == nil {
+		t.Errorf("deleted organization is still returned")
+	}
+	if _, err := stores.DeleteOrganizationByID(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting a missing organization: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testInsertAndFetchMember(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	orgID := newOrganization(t, stores)
+
+	id, err := stores.InsertMember(ctx, orgID, userID, member.Admin, "billing")
+	if err != nil {
+		t.Fatalf("InsertMember: %v", err)
+	}
+
+	got, err := stores.FetchMemberByID(ctx, orgID, userID)
+	if err != nil {
+		t.Fatalf("FetchMemberByID: %v", err)
+	}
+	want := member.Member{ID: id, OrganizationID: orgID, UserID: userID, Role: member.Admin, AppRole: "billing"}
+	if got != want {
+		t.Errorf("FetchMemberByID = %+v, want %+v", got, want)
+	}
+
+	members, err := stores.FetchAllMembers(ctx, orgID)
+	if err != nil {
+		t.Fatalf("FetchAllMembers: %v", err)
+	}
+	if len(members) != 1 || members[0] != want {
+		t.Errorf("FetchAllMembers = %+v", members)
+	}
+
+	if _, err := stores.FetchMemberByID(ctx, orgID, uuid.New().String()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FetchMemberByID of an unknown user: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testUniqueMember(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	orgID := newOrganization(t, stores)
+
+	if _, err := stores.InsertMember(ctx, orgID, userID, member.User, ""); err != nil {
+		t.Fatalf("InsertMember: %v", err)
+	}
+	if _, err := stores.InsertMember(ctx, orgID, userID, member.Admin, ""); err == nil {
+		t.Errorf("InsertMember of an existing member succeeded")
+	}
+}
+
+func testUpdateMember(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	orgID := newOrganization(t, stores)
+
+	if _, err := stores.InsertMember(ctx, orgID, userID, member.User, ""); err != nil {
+		t.Fatalf("InsertMember: %v", err)
+	}
+	if _, err := stores.UpdateMember(ctx, orgID, userID, member.Staff, "support"); err != nil {
+		t.Fatalf("UpdateMember: %v", err)
+	}
+
+	got, err := stores.FetchMemberByID(ctx, orgID, userID)
+	if err != nil {
+		t.Fatalf("FetchMemberByID: %v", err)
+	}
+	if got.Role != member.Staff || got.AppRole != "support" {
+		t.Errorf("UpdateMember did not apply: %+v", got)
+	}
+}
+
+func testDeleteMember(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	orgID := newOrganization(t, stores)
+
+	if _, err := stores.InsertMember(ctx, orgID, userID, member.User, ""); err != nil {
+		t.Fatalf("InsertMember: %v", err)
+	}
+	if _, err := stores.DeleteMember(ctx, orgID, userID); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+	if _, err := stores.FetchMemberByID(ctx, orgID, userID); err == nil {
+		t.Errorf("deleted member is still returned")
+	}
+	if _, err := stores.DeleteMember(ctx, orgID, userID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting a missing member: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testMemberInvite(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	email := uniqueEmail()
+	expiresAt := int(time.Now().Add(time.Hour).Unix())
+
+	if _, err := stores.InsertMemberInvite(ctx, email, orgID, "ABC123", expiresAt); err != nil {
+		t.Fatalf("InsertMemberInvite: %v", err)
+	}
+
+	got, err := stores.GetMemberInvite(ctx, email, orgID)
+	if err != nil {
+		t.Fatalf("GetMemberInvite: %v", err)
+	}
+	if got.Email != email || got.OrganizationID != orgID || got.Code != "ABC123" || got.ExpiresAt != expiresAt {
+		t.Errorf("GetMemberInvite = %+v", got)
+	}
+	// one pending invite per email and organization
+	if _, err := stores.InsertMemberInvite(ctx, email, orgID, "DEF456", expiresAt); err == nil {
+		t.Errorf("second invite of the email to the organization was inserted")
+	}
+
+	if _, err := stores.DeleteMemberInvite(ctx, email, orgID); err != nil {
+		t.Fatalf("DeleteMemberInvite: %v", err)
+	}
+	if _, err := stores.GetMemberInvite(ctx, email, orgID); err == nil {
+		t.Errorf("deleted invite is still returned")
+	}
+}
+
+// claimed returns the message with the id if the claim returned it
+func claimed(messages []outbox.Message, id string) (outbox.Message, bool) {
+	for _, message := range messages {
+		if message.ID == id {
+			return message, true
+		}
+	}
+	return outbox.Message{}, false
+}
+
+func testOwnershipTransfer(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	ownerID := newUser(t, stores)
+	firstID := newUser(t, stores)
+	secondID := newUser(t, stores)
+	for _, m := range []struct {
+		userID string
+		role   member.Role
+	}{{ownerID, member.Owner}, {firstID, member.Admin}, {secondID, member.User}} {
+		if _, err := stores.InsertMember(ctx, orgID, m.userID, m.role, ""); err != nil {
+			t.Fatalf("InsertMember: %v", err)
+		}
+	}
+
+	if _, err := stores.GetOwnershipTransfer(ctx, orgID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetOwnershipTransfer without a transfer: got %v, want sql.ErrNoRows", err)
+	}
+	outsider := member.OwnershipTransfer{OrganizationID: orgID, FromUserID: ownerID, ToUserID: newUser(t, stores), CreatedAt: 100, ExpiresAt: 200}
+	if err := stores.SaveOwnershipTransfer(ctx, outsider); err == nil {
+		t.Error("SaveOwnershipTransfer to a user outside of the organization succeeded")
+	}
+
+	first := member.OwnershipTransfer{OrganizationID: orgID, FromUserID: ownerID, ToUserID: firstID, CreatedAt: 100, ExpiresAt: 200}
+	if err := stores.SaveOwnershipTransfer(ctx, first); err != nil {
+		t.Fatalf("SaveOwnershipTransfer: %v", err)
+	}
+	second := member.OwnershipTransfer{OrganizationID: orgID, FromUserID: ownerID, ToUserID: secondID, CreatedAt: 150, ExpiresAt: 300}
+	if err := stores.SaveOwnershipTransfer(ctx, second); err != nil {
+		t.Fatalf("SaveOwnershipTransfer replacing a transfer: %v", err)
+	}
+	got, err := stores.GetOwnershipTransfer(ctx, orgID)
+	if err != nil {
+		t.Fatalf("GetOwnershipTransfer: %v", err)
+	}
+	if got != second {
+		t.Errorf("GetOwnershipTransfer = %+v, want %+v", got, second)
+	}
+
+	if err := stores.DeleteOwnershipTransfer(ctx, orgID); err != nil {
+		t.Fatalf("DeleteOwnershipTransfer: %v", err)
+	}
+	if err := stores.DeleteOwnershipTransfer(ctx, orgID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteOwnershipTransfer twice: got %v, want sql.ErrNoRows", err)
+	}
+
+	// the transfer goes with the membership of its recipient once it is
+	// purged, a removed member keeps it until then
+	if err := stores.SaveOwnershipTransfer(ctx, first); err != nil {
+		t.Fatalf("SaveOwnershipTransfer: %v", err)
+	}
+	if _, err := stores.DeleteMember(ctx, orgID, firstID); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+	if _, err := stores.GetOwnershipTransfer(ctx, orgID); err != nil {
+		t.Errorf("GetOwnershipTransfer to a removed member: %v", err)
+	}
+	if err := stores.PurgeUserMembers(ctx, firstID); err != nil {
+		t.Fatalf("PurgeUserMembers: %v", err)
+	}
+	if _, err := stores.GetOwnershipTransfer(ctx, orgID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetOwnershipTransfer to a purged member: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testOutboxClaim(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	message := outbox.NewMessage("from@example.com", []string{uniqueEmail(), uniqueEmail()}, "Hello", []byte("body"))
+
+	if _, err := stores.InsertOutboxMessage(ctx, message); err != nil {
+		t.Fatalf("InsertOutboxMessage: %v", err)
+	}
+
+	leaseUntil := int(time.Now().Add(time.Hour).Unix())
+	messages, err := stores.ClaimOutboxMessages(ctx, 1000, leaseUntil)
+	if err != nil {
+		t.Fatalf("ClaimOutboxMessages: %v", err)
+	}
+	got, ok := claimed(messages, message.ID)
+	if !ok {
+		t.Fatalf("due message was not claimed")
+	}
+	if got.Status != outbox.Sending || got.Attempts != 1 || got.NextAttemptAt != leaseUntil {
+		t.Errorf("claimed message = %+v", got)
+	}
+	if len(got.Recipients) != 2 || got.Recipients[0] != message.Recipients[0] || got.Body != "body" {
+		t.Errorf("claimed message lost its content: %+v", got)
+	}
+
+	// a leased message is not handed out twice
+	messages, err = stores.ClaimOutboxMessages(ctx, 1000, leaseUntil)
+	if err != nil {
+		t.Fatalf("ClaimOutboxMessages: %v", err)
+	}
+	if _, ok := claimed(messages, message.ID); ok {
+		t.Errorf("leased message was claimed again")
+	}
+
+	if _, err := stores.UpdateOutboxMessageStatus(ctx, message.ID, outbox.Sent, "", 0); err != nil {
+		t.Fatalf("UpdateOutboxMessageStatus: %v", err)
+	}
+	messages, err = stores.ClaimOutboxMessages(ctx, 1000, leaseUntil)
+	if err != nil {
+		t.Fatalf("ClaimOutboxMessages: %v", err)
+	}
+	if _, ok := claimed(messages, message.ID); ok {
+		t.Errorf("sent message was claimed again")
+	}
+}
+
+func testOutboxRetry(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	message := outbox.NewMessage("from@example.com", []string{uniqueEmail()}, "Hello", []byte("body"))
+
+	if _, err := stores.InsertOutboxMessage(ctx, message); err != nil {
+		t.Fatalf("InsertOutboxMessage: %v", err)
+	}
+	if _, err := stores.UpdateOutboxMessageStatus(ctx, message.ID, outbox.Dead, "connection refused", 0); err != nil {
+		t.Fatalf("UpdateOutboxMessageStatus: %v", err)
+	}
+
+	dead, err := stores.FetchOutboxMessages(ctx, outbox.Dead)
+	if err != nil {
+		t.Fatalf("FetchOutboxMessages: %v", err)
+	}
+	got, ok := claimed(dead, message.ID)
+	if !ok {
+		t.Fatalf("dead message is not listed")
+	}
+	if got.LastError != "connection refused" {
+		t.Errorf("dead message = %+v", got)
+	}
+
+	if _, err := stores.RetryOutboxMessage(ctx, message.ID); err != nil {
+		t.Fatalf("RetryOutboxMessage: %v", err)
+	}
+	messages, err := stores.ClaimOutboxMessages(ctx, 1000, int(time.Now().Add(time.Hour).Unix()))
+	if err != nil {
+		t.Fatalf("ClaimOutboxMessages: %v", err)
+	}
+	if got, ok := claimed(messages, message.ID); !ok || got.Attempts != 1 {
+		t.Errorf("retried message was not claimed with fresh attempts: %+v", got)
+	}
+
+	if _, err := stores.RetryOutboxMessage(ctx, uuid.New().String()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("retrying an unknown message: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testLoginAttempts(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	subject := "account:" + uniqueEmail()
+	stale := "ip:" + uuid.New().String()
+
+	if _, err := stores.GetLoginAttempts(ctx, subject); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetLoginAttempts of an unknown subject: got %v, want sql.ErrNoRows", err)
+	}
+
+	for i, at := range []int{1000, 1010, 1020} {
+		got, err := stores.RecordLoginFailure(ctx, subject, at, 900)
+		if err != nil {
+			t.Fatalf("RecordLoginFailure: %v", err)
+		}
+		if got.Failures != i+1 || got.LastFailure != at {
+			t.Errorf("RecordLoginFailure = %+v, want %d failures at %d", got, i+1, at)
+		}
+	}
+
+	// failures before since are forgotten
+	got, err := stores.RecordLoginFailure(ctx, subject, 5000, 4000)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+	if got.Failures != 1 || got.LastFailure != 5000 {
+		t.Errorf("RecordLoginFailure after the window = %+v, want 1 failure at 5000", got)
+	}
+
+	if _, err := stores.RecordLoginFailure(ctx, stale, 100, 0); err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+	purged, err := stores.PurgeLoginAttempts(ctx, 200)
+	if err != nil {
+		t.Fatalf("PurgeLoginAttempts: %v", err)
+	}
+	if purged < 1 {
+		t.Errorf("PurgeLoginAttempts purged %d subjects, want at least 1", purged)
+	}
+	if _, err := stores.GetLoginAttempts(ctx, stale); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("purged subject is still returned: %v", err)
+	}
+	if _, err := stores.GetLoginAttempts(ctx, subject); err != nil {
+		t.Errorf("recent subject was purged: %v", err)
+	}
+
+	if err := stores.DeleteLoginAttempts(ctx, subject); err != nil {
+		t.Fatalf("DeleteLoginAttempts: %v", err)
+	}
+	if err := stores.DeleteLoginAttempts(ctx, subject); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting missing attempts: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testRateLimitBuckets(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	key := "login:ip:" + uuid.New().String()
+	stale := "signup:ip:" + uuid.New().String()
+
+	// a bucket of 3 tokens regaining one every 100ms
+	for i, want := range []int64{1100, 1200, 1300} {
+		got, ok, err := stores.TakeToken(ctx, key, 1000, 100, 3)
+		if err != nil {
+			t.Fatalf("TakeToken: %v", err)
+		}
+		if !ok || got.FullAt != want {
+			t.Errorf("TakeToken %d = %+v, %v, want a token and full at %d", i, got, ok, want)
+		}
+	}
+	got, ok, err := stores.TakeToken(ctx, key, 1050, 100, 3)
+	if err != nil {
+		t.Fatalf("TakeToken: %v", err)
+	}
+	if ok || got.FullAt != 1300 {
+		t.Errorf("TakeToken from an empty bucket = %+v, %v, want no token and full at 1300", got, ok)
+	}
+	got, ok, err = stores.TakeToken(ctx, key, 1200, 100, 3)
+	if err != nil {
+		t.Fatalf("TakeToken: %v", err)
+	}
+	if !ok || got.FullAt != 1400 {
+		t.Errorf("TakeToken after a refill = %+v, %v, want a token and full at 1400", got, ok)
+	}
+	// a bucket full in the past starts over from now
+	got, ok, err = stores.TakeToken(ctx, key, 5000, 100, 3)
+	if err != nil {
+		t.Fatalf("TakeToken: %v", err)
+	}
+	if !ok || got.FullAt != 5100 {
+		t.Errorf("TakeToken from a full bucket = %+v, %v, want a token and full at 5100", got, ok)
+	}
+
+	if _, _, err := stores.TakeToken(ctx, stale, 100, 100, 3); err != nil {
+		t.Fatalf("TakeToken: %v", err)
+	}
+	purged, err := stores.PurgeBuckets(ctx, 1000)
+	if err != nil {
+		t.Fatalf("PurgeBuckets: %v", err)
+	}
+	if purged < 1 {
+		t.Errorf("PurgeBuckets purged %d buckets, want at least 1", purged)
+	}
+	// the purged bucket is full again, the recent one is not
+	if got, _, _ := stores.TakeToken(ctx, stale, 1000, 100, 3); got.FullAt != 1100 {
+		t.Errorf("purged bucket = %+v, want full at 1100", got)
+	}
+	if got, _, _ := stores.TakeToken(ctx, key, 5000, 100, 3); got.FullAt != 5200 {
+		t.Errorf("recent bucket = %+v, want full at 5200", got)
+	}
+}
+
+func testAuditEvents(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	organizationID := uuid.New().String()
+	actorID := uuid.New().String()
+
+	var ids []int64
+	for i, action := range []string{audit.OrganizationCreated, audit.MemberInvited, audit.MemberInvited} {
+		id, err := stores.InsertAuditEvent(ctx, audit.Event{
+			ActorID:        actorID,
+			OrganizationID: organizationID,
+			Action:         action,
+			Target:         audit.InviteTarget("a@example.com"),
+			IP:             "192.0.2.1",
+			UserAgent:      "storetest",
+			Metadata:       map[string]string{"n": string(rune('0' + i))},
+			CreatedAt:      1000 + i,
+		})
+		if err != nil {
+			t.Fatalf("InsertAuditEvent: %v", err)
+		}
+		if len(ids) > 0 && id <= ids[len(ids)-1] {
+			t.Errorf("InsertAuditEvent returned %d after %d, ids must grow", id, ids[len(ids)-1])
+		}
+		ids = append(ids, id)
+	}
+	if _, err := stores.InsertAuditEvent(ctx, audit.Event{ActorID: actorID, Action: audit.UserLoggedIn, CreatedAt: 1000}); err != nil {
+		t.Fatalf("InsertAuditEvent: %v", err)
+	}
+
+	events, err := stores.FetchAuditEvents(ctx, audit.Filter{OrganizationID: organizationID, Limit: 10})
+	if err != nil {
+		t.Fatalf("FetchAuditEvents: %v", err)
+	}
+	if len(events) != 3 || events[0].ID != ids[2] || events[2].ID != ids[0] {
+		t.Fatalf("FetchAuditEvents = %+v, want the 3 organization events newest first", events)
+	}
+	if got := events[2]; got.Action != audit.OrganizationCreated || got.ActorID != actorID || got.IP != "192.0.2.1" || got.UserAgent != "storetest" || got.Metadata["n"] != "0" || got.CreatedAt != 1000 {
+		t.Errorf("FetchAuditEvents round trip = %+v", got)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		filter audit.Filter
+		want   []int64
+	}{
+		{"action", audit.Filter{Action: audit.MemberInvited}, []int64{ids[2], ids[1]}},
+		{"since", audit.Filter{Since: 1001}, []int64{ids[2], ids[1]}},
+		{"until", audit.Filter{Until: 1000}, []int64{ids[0]}},
+		{"before", audit.Filter{Before: ids[2]}, []int64{ids[1], ids[0]}},
+		{"limit", audit.Filter{Limit: 1}, []int64{ids[2]}},
+	} {
+		tt.filter.OrganizationID = organizationID
+		if tt.filter.Limit == 0 {
+			tt.filter.Limit = 10
+		}
+		events, err := stores.FetchAuditEvents(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("FetchAuditEvents by %s: %v", tt.name, err)
+		}
+		var got []int64
+		for _, event := range events {
+			got = append(got, event.ID)
+		}
+		if len(got) != len(tt.want) || (len(got) > 0 && (got[0] != tt.want[0] || got[len(got)-1] != tt.want[len(tt.want)-1])) {
+			t.Errorf("FetchAuditEvents by %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func testAuditChain(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	organizationID := uuid.New().String()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := audit.New(stores, audit.Options{SigningKey: key})
+
+	err = stores.WithTx(ctx, func(ctx context.Context) error {
+		if _, _, err := stores.AdvanceAuditChain(ctx, organizationID); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx = %v, want the rollback error", err)
+	}
+	for _, action := range []string{audit.OrganizationCreated, audit.MemberInvited, audit.MemberAdded} {
+		service.Record(ctx, audit.Event{OrganizationID: organizationID, Action: action})
+	}
+	service.Record(ctx, audit.Event{Action: audit.UserLoggedIn})
+
+	heads, err := stores.FetchAuditChainHeads(ctx)
+	if err != nil {
+		t.Fatalf("FetchAuditChainHeads: %v", err)
+	}
+	if len(heads) != 2 || heads[1].OrganizationID != organizationID || heads[1].Sequence != 3 || heads[1].Checkpointed != 0 {
+		t.Fatalf("FetchAuditChainHeads = %+v, want the organization chain at 3, a rolled back advance is not counted", heads)
+	}
+	events, err := stores.FetchAuditChain(ctx, organizationID, 2, 10)
+	if err != nil {
+		t.Fatalf("FetchAuditChain: %v", err)
+	}
+	if len(events) != 2 || events[0].Sequence != 2 || events[1].PreviousHash != events[0].Hash || events[1].Hash != heads[1].Hash {
+		t.Fatalf("FetchAuditChain = %+v, want sequences 2 and 3 chained to the head", events)
+	}
+
+	if err := service.Checkpoint(ctx); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	checkpoints, err := stores.FetchAuditCheckpoints(ctx, organizationID)
+	if err != nil {
+		t.Fatalf("FetchAuditCheckpoints: %v", err)
+	}
+	if len(checkpoints) != 1 || checkpoints[0].Sequence != 3 || checkpoints[0].Hash != heads[1].Hash {
+		t.Fatalf("FetchAuditCheckpoints = %+v, want the head", checkpoints)
+	}
+	if checked, err := service.Verify(ctx, key.Public().(ed25519.PublicKey)); err != nil || checked != 4 {
+		t.Fatalf("Verify = %d, %v, want 4 intact events", checked, err)
+	}
+
+	var export bytes.Buffer
+	if _, err := service.Export(ctx, &export, organizationID, 2, 0); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	exported, err := audit.VerifyExport(bytes.NewReader(export.Bytes()), key.Public().(ed25519.PublicKey))
+	if err != nil || exported.FirstSequence != 2 || exported.LastSequence != 3 {
+		t.Fatalf("VerifyExport = %+v, %v, want sequences 2 to 3", exported, err)
+	}
+	tampered := bytes.Replace(export.Bytes(), []byte(audit.MemberAdded), []byte(audit.MemberRemoved), 1)
+	if _, err := audit.VerifyExport(bytes.NewReader(tampered), key.Public().(ed25519.PublicKey)); err == nil {
+		t.Error("VerifyExport accepted an edited event")
+	}
+
+	// An event inserted behind the service does not advance the head
+	forged := audit.Event{OrganizationID: organizationID, Action: audit.MemberRemoved, Sequence: 4, PreviousHash: heads[1].Hash}
+	forged.Hash = forged.Digest()
+	if _, err := stores.InsertAuditEvent(ctx, forged); err != nil {
+		t.Fatalf("InsertAuditEvent: %v", err)
+	}
+	var broken *audit.Break
+	if _, err := service.Verify(ctx, nil); !errors.As(err, &broken) || broken.OrganizationID != organizationID {
+		t.Fatalf("Verify = %v, want a break of the organization chain", err)
+	}
+}
+
+func newWebhookEndpoint(t *testing.T, stores Stores, organizationID string) webhook.Endpoint {
+	t.Helper()
+	endpoint := webhook.Endpoint{
+		ID:             uuid.New().String(),
+		OrganizationID: organizationID,
+		URL:            "https://hooks.example.com/microauth",
+		Secret:         "whsec_test",
+		Events:         []string{webhook.MemberAdded, webhook.MemberRemoved},
+		Active:         true,
+		CreatedAt:      1000,
+		UpdatedAt:      1000,
+	}
+	if _, err := stores.InsertWebhookEndpoint(context.Background(), endpoint); err != nil {
+		t.Fatalf("InsertWebhookEndpoint: %v", err)
+	}
+	return endpoint
+}
+
+func testWebhookEndpoints(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	endpoint := newWebhookEndpoint(t, stores, orgID)
+
+	got, err := stores.GetWebhookEndpoint(ctx, orgID, endpoint.ID)
+	if err != nil {
+		t.Fatalf("GetWebhookEndpoint: %v", err)
+	}
+	if got.URL != endpoint.URL || got.Secret != endpoint.Secret || !got.Active || len(got.Events) != 2 || got.Events[1] != webhook.MemberRemoved {
+		t.Errorf("GetWebhookEndpoint round trip = %+v", got)
+	}
+	if _, err := stores.GetWebhookEndpoint(ctx, newOrganization(t, stores), endpoint.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetWebhookEndpoint of another organization = %v, want sql.ErrNoRows", err)
+	}
+
+	endpoint.URL = "https://hooks.example.com/v2"
+	endpoint.Events = []string{webhook.OrganizationUpdated}
+	endpoint.Active = false
+	if err := stores.UpdateWebhookEndpoint(ctx, endpoint); err != nil {
+		t.Fatalf("UpdateWebhookEndpoint: %v", err)
+	}
+	endpoints, err := stores.FetchWebhookEndpoints(ctx, orgID)
+	if err != nil {
+		t.Fatalf("FetchWebhookEndpoints: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].URL != endpoint.URL || endpoints[0].Active || len(endpoints[0].Events) != 1 {
+		t.Errorf("FetchWebhookEndpoints after update = %+v", endpoints)
+	}
+
+	if err := stores.DeleteWebhookEndpoint(ctx, orgID, endpoint.ID); err != nil {
+		t.Fatalf("DeleteWebhookEndpoint: %v", err)
+	}
+	if err := stores.DeleteWebhookEndpoint(ctx, orgID, endpoint.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("second DeleteWebhookEndpoint = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testWebhookDeliveries(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	endpoint := newWebhookEndpoint(t, stores, orgID)
+	now := int(time.Now().Unix())
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		delivery := webhook.Delivery{
+			ID:             uuid.New().String(),
+			EndpointID:     endpoint.ID,
+			OrganizationID: orgID,
+			EventID:        uuid.New().String(),
+			EventType:      webhook.MemberAdded,
+			Payload:        `{"type":"member.added"}`,
+			Status:         webhook.Pending,
+			NextAttemptAt:  now,
+			CreatedAt:      now + i,
+			UpdatedAt:      now + i,
+		}
+		if _, err := stores.InsertWebhookDelivery(ctx, delivery); err != nil {
+			t.Fatalf("InsertWebhookDelivery: %v", err)
+		}
+		ids = append(ids, delivery.ID)
+	}
+
+	leaseUntil := int(time.Now().Add(time.Hour).Unix())
+	deliveries, err := stores.ClaimWebhookDeliveries(ctx, 1000, leaseUntil)
+	if err != nil {
+		t.Fatalf("ClaimWebhookDeliveries: %v", err)
+	}
+	if len(deliveries) != 2 || deliveries[0].Status != webhook.Sending || deliveries[0].Attempts != 1 || deliveries[0].NextAttemptAt != leaseUntil || deliveries[0].Payload == "" {
+		t.Fatalf("ClaimWebhookDeliveries = %+v, want both deliveries leased", deliveries)
+	}
+	if deliveries, _ := stores.ClaimWebhookDeliveries(ctx, 1000, leaseUntil); len(deliveries) != 0 {
+		t.Errorf("leased deliveries were claimed again: %+v", deliveries)
+	}
+
+	if err := stores.UpdateWebhookDeliveryStatus(ctx, ids[0], webhook.Delivered, 204, "", now); err != nil {
+		t.Fatalf("UpdateWebhookDeliveryStatus: %v", err)
+	}
+	got, err := stores.GetWebhookDelivery(ctx, endpoint.ID, ids[0])
+	if err != nil {
+		t.Fatalf("GetWebhookDelivery: %v", err)
+	}
+	if got.Status != webhook.Delivered || got.ResponseStatus != 204 || got.EventType != webhook.MemberAdded {
+		t.Errorf("GetWebhookDelivery = %+v", got)
+	}
+
+	deliveries, err = stores.FetchWebhookDeliveries(ctx, endpoint.ID, "", 10)
+	if err != nil {
+		t.Fatalf("FetchWebhookDeliveries: %v", err)
+	}
+	if len(deliveries) != 2 || deliveries[0].ID != ids[1] {
+		t.Errorf("FetchWebhookDeliveries = %+v, want both newest first", deliveries)
+	}
+	deliveries, err = stores.FetchWebhookDeliveries(ctx, endpoint.ID, webhook.Delivered, 10)
+	if err != nil || len(deliveries) != 1 || deliveries[0].ID != ids[0] {
+		t.Errorf("FetchWebhookDeliveries of delivered = %+v, %v", deliveries, err)
+	}
+
+	// deleting the endpoint deletes its delivery log
+	if err := stores.DeleteWebhookEndpoint(ctx, orgID, endpoint.ID); err != nil {
+		t.Fatalf("DeleteWebhookEndpoint: %v", err)
+	}
+	if _, err := stores.GetWebhookDelivery(ctx, endpoint.ID, ids[0]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetWebhookDelivery after delete = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testSCIMTokens(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	token := scim.Token{
+		ID:             uuid.New().String(),
+		OrganizationID: orgID,
+		Name:           "okta",
+		Hash:           uuid.New().String(),
+		CreatedAt:      int(time.Now().Unix()),
+	}
+	if _, err := stores.InsertSCIMToken(ctx, token); err != nil {
+		t.Fatalf("InsertSCIMToken: %v", err)
+	}
+	duplicate := token
+	duplicate.ID = uuid.New().String()
+	if _, err := stores.InsertSCIMToken(ctx, duplicate); err == nil {
+		t.Error("InsertSCIMToken accepted a hash twice")
+	}
+
+	if err := stores.UpdateSCIMTokenLastUsed(ctx, token.ID, token.CreatedAt+60); err != nil {
+		t.Fatalf("UpdateSCIMTokenLastUsed: %v", err)
+	}
+	got, err := stores.GetSCIMTokenByHash(ctx, token.Hash)
+	if err != nil {
+		t.Fatalf("GetSCIMTokenByHash: %v", err)
+	}
+	if got.ID != token.ID || got.OrganizationID != orgID || got.Name != "okta" || got.LastUsedAt != token.CreatedAt+60 {
+		t.Errorf("GetSCIMTokenByHash = %+v", got)
+	}
+	if _, err := stores.GetSCIMTokenByHash(ctx, "unknown"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSCIMTokenByHash of an unknown hash = %v, want sql.ErrNoRows", err)
+	}
+	tokens, err := stores.FetchSCIMTokens(ctx, orgID)
+	if err != nil || len(tokens) != 1 || tokens[0].ID != token.ID {
+		t.Errorf("FetchSCIMTokens = %+v, %v", tokens, err)
+	}
+
+	if err := stores.DeleteSCIMToken(ctx, newOrganization(t, stores), token.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteSCIMToken of another organization = %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.DeleteSCIMToken(ctx, orgID, token.ID); err != nil {
+		t.Fatalf("DeleteSCIMToken: %v", err)
+	}
+	if _, err := stores.GetSCIMTokenByHash(ctx, token.Hash); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSCIMTokenByHash after delete = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testSCIMIdentities(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	userID := newUser(t, stores)
+	now := int(time.Now().Unix())
+
+	identity := scim.Identity{OrganizationID: orgID, UserID: userID, ExternalID: "00u1", CreatedAt: now, UpdatedAt: now}
+	if err := stores.UpsertSCIMIdentity(ctx, identity); err != nil {
+		t.Fatalf("UpsertSCIMIdentity: %v", err)
+	}
+	identity.ExternalID = "00u2"
+	identity.CreatedAt = now + 10
+	identity.UpdatedAt = now + 10
+	if err := stores.UpsertSCIMIdentity(ctx, identity); err != nil {
+		t.Fatalf("second UpsertSCIMIdentity: %v", err)
+	}
+	got, err := stores.GetSCIMIdentity(ctx, orgID, userID)
+	if err != nil {
+		t.Fatalf("GetSCIMIdentity: %v", err)
+	}
+	if got.ExternalID != "00u2" || got.CreatedAt != now || got.UpdatedAt != now+10 {
+		t.Errorf("GetSCIMIdentity after upsert = %+v, want the new external ID and the first creation time", got)
+	}
+	if err := stores.UpsertSCIMIdentity(ctx, scim.Identity{OrganizationID: orgID, UserID: uuid.New().String(), CreatedAt: now, UpdatedAt: now}); err == nil {
+		t.Error("UpsertSCIMIdentity accepted an unknown user")
+	}
+
+	identities, err := stores.FetchSCIMIdentities(ctx, orgID)
+	if err != nil || len(identities) != 1 || identities[0].UserID != userID {
+		t.Errorf("FetchSCIMIdentities = %+v, %v", identities, err)
+	}
+
+	if err := stores.DeleteSCIMIdentity(ctx, orgID, userID); err != nil {
+		t.Fatalf("DeleteSCIMIdentity: %v", err)
+	}
+	if _, err := stores.GetSCIMIdentity(ctx, orgID, userID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSCIMIdentity after delete = %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.DeleteSCIMIdentity(ctx, orgID, userID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("second DeleteSCIMIdentity = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testSCIMGroups(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	now := int(time.Now().Unix())
+
+	group := scim.Group{
+		ID:             uuid.New().String(),
+		OrganizationID: orgID,
+		DisplayName:    "Admins",
+		ExternalID:     "00g1",
+		Kind:           scim.AppRoleGroup,
+		Value:          "Admins",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if _, err := stores.InsertSCIMGroup(ctx, group); err != nil {
+		t.Fatalf("InsertSCIMGroup: %v", err)
+	}
+	other := group
+	other.ID = uuid.New().String()
+	if _, err := stores.InsertSCIMGroup(ctx, other); err == nil {
+		t.Error("InsertSCIMGroup accepted a display name twice")
+	}
+
+	group.DisplayName = "admin"
+	group.Kind = scim.RoleGroup
+	group.Value = "admin"
+	group.UpdatedAt = now + 1
+	if err := stores.UpdateSCIMGroup(ctx, group); err != nil {
+		t.Fatalf("UpdateSCIMGroup: %v", err)
+	}
+	got, err := stores.GetSCIMGroup(ctx, orgID, group.ID)
+	if err != nil {
+		t.Fatalf("GetSCIMGroup: %v", err)
+	}
+	if got != group {
+		t.Errorf("GetSCIMGroup = %+v, want %+v", got, group)
+	}
+	if _, err := stores.GetSCIMGroup(ctx, newOrganization(t, stores), group.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSCIMGroup of another organization = %v, want sql.ErrNoRows", err)
+	}
+	groups, err := stores.FetchSCIMGroups(ctx, orgID)
+	if err != nil || len(groups) != 1 || groups[0].ID != group.ID {
+		t.Errorf("FetchSCIMGroups = %+v, %v", groups, err)
+	}
+
+	if err := stores.DeleteSCIMGroup(ctx, orgID, group.ID); err != nil {
+		t.Fatalf("DeleteSCIMGroup: %v", err)
+	}
+	if err := stores.DeleteSCIMGroup(ctx, orgID, group.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("second DeleteSCIMGroup = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func sameTeam(a team.Team, b team.Team) bool {
+	if strings.Join(a.AppRoles, ",") != strings.Join(b.AppRoles, ",") {
+		return false
+	}
+	return a.ID == b.ID && a.OrganizationID == b.OrganizationID && a.ParentID == b.ParentID &&
+		a.Name == b.Name && a.Description == b.Description && a.Role == b.Role &&
+		a.CreatedAt == b.CreatedAt && a.UpdatedAt == b.UpdatedAt
+}
+
+func testTeams(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	now := int(time.Now().Unix())
+
+	parent := team.Team{
+		ID:             uuid.New().String(),
+		OrganizationID: orgID,
+		Name:           "Engineering",
+		Role:           member.Staff,
+		AppRoles:       []string{"github", "jira"},
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if _, err := stores.InsertTeam(ctx, parent); err != nil {
+		t.Fatalf("InsertTeam: %v", err)
+	}
+	child := team.Team{
+		ID:             uuid.New().String(),
+		OrganizationID: orgID,
+		ParentID:       parent.ID,
+		Name:           "Backend",
+		Description:    "APIs",
+		AppRoles:       []string{},
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if _, err := stores.InsertTeam(ctx, child); err != nil {
+		t.Fatalf("InsertTeam with a parent: %v", err)
+	}
+	duplicate := parent
+	duplicate.ID = uuid.New().String()
+	if _, err := stores.InsertTeam(ctx, duplicate); err == nil {
+		t.Error("InsertTeam accepted a name twice")
+	}
+	orphan := child
+	orphan.ID = uuid.New().String()
+	orphan.Name = "Orphan"
+	orphan.ParentID = uuid.New().String()
+	if _, err := stores.InsertTeam(ctx, orphan); err == nil {
+		t.Error("InsertTeam accepted an unknown parent")
+	}
+
+	parent.Name = "R&D"
+	parent.Role = member.Admin
+	parent.AppRoles = []string{"github"}
+	parent.UpdatedAt = now + 1
+	if err := stores.UpdateTeam(ctx, parent); err != nil {
+		t.Fatalf("UpdateTeam: %v", err)
+	}
+	got, err := stores.GetTeam(ctx, orgID, parent.ID)
+	if err != nil || !sameTeam(got, parent) {
+		t.Errorf("GetTeam = %+v, %v, want %+v", got, err, parent)
+	}
+	got, err = stores.GetTeam(ctx, orgID, child.ID)
+	if err != nil || !sameTeam(got, child) {
+		t.Errorf("GetTeam of the child = %+v, %v, want %+v", got, err, child)
+	}
+	if _, err := stores.GetTeam(ctx, newOrganization(t, stores), parent.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetTeam of another organization = %v, want sql.ErrNoRows", err)
+	}
+	teams, err := stores.FetchTeams(ctx, orgID)
+	if err != nil || len(teams) != 2 || teams[0].ID != child.ID || teams[1].ID != parent.ID {
+		t.Errorf("FetchTeams = %+v, %v, want ordered by name", teams, err)
+	}
+
+	if err := stores.DeleteTeam(ctx, orgID, parent.ID); err == nil {
+		t.Error("DeleteTeam deleted a team with a subteam")
+	}
+	if err := stores.DeleteTeam(ctx, orgID, child.ID); err != nil {
+		t.Fatalf("DeleteTeam: %v", err)
+	}
+	if err := stores.DeleteTeam(ctx, orgID, child.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("second DeleteTeam = %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.UpdateTeam(ctx, child); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UpdateTeam of a deleted team = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testTeamMembers(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	userID := newUser(t, stores)
+	now := int(time.Now().Unix())
+	if _, err := stores.InsertMember(ctx, orgID, userID, member.User, ""); err != nil {
+		t.Fatalf("InsertMember: %v", err)
+	}
+	teamIDs := []string{}
+	for _, name := range []string{"Support", "Sales"} {
+		id, err := stores.InsertTeam(ctx, team.Team{
+			ID:             uuid.New().String(),
+			OrganizationID: orgID,
+			Name:           name,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		})
+		if err != nil {
+			t.Fatalf("InsertTeam: %v", err)
+		}
+		teamIDs = append(teamIDs, id)
+	}
+
+	for _, teamID := range teamIDs {
+		membership := team.Member{TeamID: teamID, OrganizationID: orgID, UserID: userID, CreatedAt: now}
+		if err := stores.InsertTeamMember(ctx, membership); err != nil {
+			t.Fatalf("InsertTeamMember: %v", err)
+		}
+	}
+	membership := team.Member{TeamID: teamIDs[0], OrganizationID: orgID, UserID: userID, CreatedAt: now}
+	if err := stores.InsertTeamMember(ctx, membership); err == nil {
+		t.Error("InsertTeamMember accepted a member twice")
+	}
+	outsider := team.Member{TeamID: teamIDs[0], OrganizationID: orgID, UserID: newUser(t, stores), CreatedAt: now}
+	if err := stores.InsertTeamMember(ctx, outsider); err == nil {
+		t.Error("InsertTeamMember accepted a user outside of the organization")
+	}
+
+	members, err := stores.FetchTeamMembers(ctx, orgID, teamIDs[0])
+	if err != nil || len(members) != 1 || members[0] != membership {
+		t.Errorf("FetchTeamMembers = %+v, %v", members, err)
+	}
+	teams, err := stores.FetchUserTeams(ctx, orgID, userID)
+	if err != nil || len(teams) != 2 {
+		t.Errorf("FetchUserTeams = %+v, %v", teams, err)
+	}
+
+	if err := stores.DeleteTeamMember(ctx, orgID, teamIDs[0], userID); err != nil {
+		t.Fatalf("DeleteTeamMember: %v", err)
+	}
+	if err := stores.DeleteTeamMember(ctx, orgID, teamIDs[0], userID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("second DeleteTeamMember = %v, want sql.ErrNoRows", err)
+	}
+
+	// removing the member removes them from their teams
+	if _, err := stores.DeleteMember(ctx, orgID, userID); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+	teams, err = stores.FetchUserTeams(ctx, orgID, userID)
+	if err != nil || len(teams) != 0 {
+		t.Errorf("FetchUserTeams after DeleteMember = %+v, %v", teams, err)
+	}
+
+	// deleting a team removes its members
+	if _, err := stores.InsertMember(ctx, orgID, userID, member.User, ""); err != nil {
+		t.Fatalf("InsertMember: %v", err)
+	}
+	membership.TeamID = teamIDs[1]
+	if err := stores.InsertTeamMember(ctx, membership); err != nil {
+		t.Fatalf("InsertTeamMember: %v", err)
+	}
+	if err := stores.DeleteTeam(ctx, orgID, teamIDs[1]); err != nil {
+		t.Fatalf("DeleteTeam: %v", err)
+	}
+	members, err = stores.FetchTeamMembers(ctx, orgID, teamIDs[1])
+	if err != nil || len(members) != 0 {
+		t.Errorf("FetchTeamMembers of a deleted team = %+v, %v", members, err)
+	}
+}
+
+func testSoftDeleteMember(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	orgID := newOrganization(t, stores)
+	now := int(time.Now().Unix())
+
+	if _, err := stores.InsertMember(ctx, orgID, userID, member.Staff, "support"); err != nil {
+		t.Fatalf("InsertMember: %v", err)
+	}
+	teamID, err := stores.InsertTeam(ctx, team.Team{ID: uuid.New().String(), OrganizationID: orgID, Name: "Support", CreatedAt: now, UpdatedAt: now})
+	if err != nil {
+		t.Fatalf("InsertTeam: %v", err)
+	}
+	if err := stores.InsertTeamMember(ctx, team.Member{TeamID: teamID, OrganizationID: orgID, UserID: userID, CreatedAt: now}); err != nil {
+		t.Fatalf("InsertTeamMember: %v", err)
+	}
+
+	if _, err := stores.DeleteMember(ctx, orgID, userID); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+	if _, err := stores.UpdateMember(ctx, orgID, userID, member.Admin, ""); err != nil {
+		t.Fatalf("UpdateMember: %v", err)
+	}
+	listings, err := stores.FetchMembers(ctx, member.Filter{OrganizationID: orgID, Sort: member.SortEmail, Limit: 10})
+	if err != nil || len(listings) != 0 {
+		t.Errorf("FetchMembers after DeleteMember = %+v, %v, want none", listings, err)
+	}
+	listings, err = stores.FetchMembers(ctx, member.Filter{OrganizationID: orgID, Deleted: true, Sort: member.SortEmail, Limit: 10})
+	if err != nil || len(listings) != 1 || listings[0].UserID != userID || listings[0].DeletedAt == 0 {
+		t.Errorf("FetchMembers of the removed members = %+v, %v", listings, err)
+	}
+	if memberships, err := stores.FetchMembersByUser(ctx, userID); err != nil || len(memberships) != 0 {
+		t.Errorf("FetchMembersByUser after DeleteMember = %+v, %v, want none", memberships, err)
+	}
+	if members, err := stores.FetchTeamMembers(ctx, orgID, teamID); err != nil || len(members) != 0 {
+		t.Errorf("FetchTeamMembers of a removed member = %+v, %v, want none", members, err)
+	}
+
+	// the member comes back with their role, app role and teams
+	if err := stores.RestoreMember(ctx, orgID, userID); err != nil {
+		t.Fatalf("RestoreMember: %v", err)
+	}
+	got, err := stores.FetchMemberByID(ctx, orgID, userID)
+	if err != nil || got.Role != member.Staff || got.AppRole != "support" || got.DeletedAt != 0 {
+		t.Errorf("FetchMemberByID after RestoreMember = %+v, %v", got, err)
+	}
+	if members, err := stores.FetchTeamMembers(ctx, orgID, teamID); err != nil || len(members) != 1 {
+		t.Errorf("FetchTeamMembers after RestoreMember = %+v, %v", members, err)
+	}
+	if err := stores.RestoreMember(ctx, orgID, userID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RestoreMember of a member: got %v, want sql.ErrNoRows", err)
+	}
+
+	// adding a removed user again starts a new membership
+	if _, err := stores.DeleteMember(ctx, orgID, userID); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+	if _, err := stores.InsertMember(ctx, orgID, userID, member.User, ""); err != nil {
+		t.Fatalf("InsertMember of a removed member: %v", err)
+	}
+	got, err = stores.FetchMemberByID(ctx, orgID, userID)
+	if err != nil || got.Role != member.User || got.AppRole != "" {
+		t.Errorf("FetchMemberByID after InsertMember = %+v, %v", got, err)
+	}
+	if members, err := stores.FetchTeamMembers(ctx, orgID, teamID); err != nil || len(members) != 0 {
+		t.Errorf("FetchTeamMembers of a new membership = %+v, %v, want none", members, err)
+	}
+}
+
+func testSoftDeleteOrganization(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	admin := newUser(t, stores)
+	removed := newUser(t, stores)
+	for _, userID := range []string{admin, removed} {
+		if _, err := stores.InsertMember(ctx, orgID, userID, member.Admin, ""); err != nil {
+			t.Fatalf("InsertMember: %v", err)
+		}
+	}
+	email := uniqueEmail()
+	if _, err := stores.InsertMemberInvite(ctx, email, orgID, "ABC123", int(time.Now().Add(time.Hour).Unix())); err != nil {
+		t.Fatalf("InsertMemberInvite: %v", err)
+	}
+	token := scim.Token{ID: uuid.New().String(), OrganizationID: orgID, Name: "okta", Hash: uuid.New().String(), CreatedAt: int(time.Now().Unix())}
+	if _, err := stores.InsertSCIMToken(ctx, token); err != nil {
+		t.Fatalf("InsertSCIMToken: %v", err)
+	}
+	// a deleted user stays deleted when the organization is restored
+	if err := stores.DeleteUser(ctx, removed); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	if _, err := stores.DeleteOrganizationByID(ctx, orgID); err != nil {
+		t.Fatalf("DeleteOrganizationByID: %v", err)
+	}
+	if _, err := stores.UpdateOrganization(ctx, orgID, "Renamed", uniqueDomain()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UpdateOrganization of a deleted organization: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.FetchMemberByID(ctx, orgID, admin); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FetchMemberByID in a deleted organization: got %v, want sql.ErrNoRows", err)
+	}
+	organizations, err := stores.FetchUserOrganizations(ctx, organization.Filter{UserID: admin, Sort: organization.SortName, Limit: 10})
+	if err != nil || len(organizations) != 0 {
+		t.Errorf("FetchUserOrganizations with a deleted organization = %+v, %v, want none", organizations, err)
+	}
+	if _, err := stores.GetMemberInvite(ctx, email, orgID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetMemberInvite of a deleted organization: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.GetSCIMTokenByHash(ctx, token.Hash); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSCIMTokenByHash of a deleted organization: got %v, want sql.ErrNoRows", err)
+	}
+	deleted, err := stores.FetchDeletedOrganizations(ctx, int(time.Now().Unix())+1)
+	if err != nil || !includesOrganization(deleted, orgID) {
+		t.Errorf("FetchDeletedOrganizations = %+v, %v, want the deleted organization", deleted, err)
+	}
+	if deleted, err := stores.FetchDeletedOrganizations(ctx, 1); err != nil || len(deleted) != 0 {
+		t.Errorf("FetchDeletedOrganizations before the deletion = %+v, %v, want none", deleted, err)
+	}
+
+	if err := stores.RestoreOrganization(ctx, orgID); err != nil {
+		t.Fatalf("RestoreOrganization: %v", err)
+	}
+	if org, err := stores.GetOrganizationByID(ctx, orgID); err != nil || org.DeletedAt != 0 {
+		t.Errorf("GetOrganizationByID after RestoreOrganization = %+v, %v", org, err)
+	}
+	if _, err := stores.FetchMemberByID(ctx, orgID, admin); err != nil {
+		t.Errorf("FetchMemberByID after RestoreOrganization: %v", err)
+	}
+	if _, err := stores.FetchMemberByID(ctx, orgID, removed); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FetchMemberByID of a deleted user after RestoreOrganization: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.GetMemberInvite(ctx, email, orgID); err != nil {
+		t.Errorf("GetMemberInvite after RestoreOrganization: %v", err)
+	}
+	if err := stores.RestoreOrganization(ctx, orgID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RestoreOrganization twice: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testSoftDeleteUser(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newUser(t, stores)
+	orgID := newOrganization(t, stores)
+	u, err := stores.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if _, err := stores.InsertMember(ctx, orgID, id, member.Admin, ""); err != nil {
+		t.Fatalf("InsertMember: %v", err)
+	}
+
+	if err := stores.DeleteUser(ctx, id); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := stores.GetUserByID(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByID of a deleted user: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.GetUserByEmail(ctx, u.Email); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByEmail of a deleted user: got %v, want sql.ErrNoRows", err)
+	}
+	if exists, err := stores.EmailExists(ctx, u.Email); err != nil || !exists {
+		t.Errorf("EmailExists of a deleted user = %v, %v, want true", exists, err)
+	}
+	if _, err := stores.InsertUser(ctx, "Ada", "Lovelace", u.Email, "hash", false); err == nil {
+		t.Error("InsertUser took the address of a deleted user")
+	}
+	if _, err := stores.FetchMemberByID(ctx, orgID, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FetchMemberByID of a deleted user: got %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.RestoreMember(ctx, orgID, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RestoreMember of a deleted user: got %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.DeleteUser(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteUser twice: got %v, want sql.ErrNoRows", err)
+	}
+	users, err := stores.FetchDeletedUsers(ctx, int(time.Now().Unix())+1)
+	if err != nil || len(users) == 0 {
+		t.Errorf("FetchDeletedUsers = %+v, %v, want the deleted user", users, err)
+	}
+	found := false
+	for _, deleted := range users {
+		found = found || (deleted.ID == id && deleted.Email == u.Email && deleted.DeletedAt > 0)
+	}
+	if !found {
+		t.Errorf("FetchDeletedUsers = %+v, want the deleted user", users)
+	}
+
+	if err := stores.RestoreUser(ctx, id); err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if _, err := stores.GetUserByEmail(ctx, u.Email); err != nil {
+		t.Errorf("GetUserByEmail after RestoreUser: %v", err)
+	}
+	if _, err := stores.FetchMemberByID(ctx, orgID, id); err != nil {
+		t.Errorf("FetchMemberByID after RestoreUser: %v", err)
+	}
+	if err := stores.RestoreUser(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RestoreUser twice: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testPurge(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	orgID := newOrganization(t, stores)
+	kept := newOrganization(t, stores)
+	userID := newUser(t, stores)
+	other := newUser(t, stores)
+	for _, membership := range [][2]string{{orgID, userID}, {kept, userID}, {kept, other}} {
+		if _, err := stores.InsertMember(ctx, membership[0], membership[1], member.User, ""); err != nil {
+			t.Fatalf("InsertMember: %v", err)
+		}
+	}
+	email := uniqueEmail()
+	if _, err := stores.InsertMemberInvite(ctx, email, orgID, "ABC123", int(time.Now().Add(time.Hour).Unix())); err != nil {
+		t.Fatalf("InsertMemberInvite: %v", err)
+	}
+	if _, err := stores.DeleteOrganizationByID(ctx, orgID); err != nil {
+		t.Fatalf("DeleteOrganizationByID: %v", err)
+	}
+	if _, err := stores.DeleteMember(ctx, kept, other); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+
+	// nothing was deleted before the first second
+	if err := stores.PurgeMembers(ctx, 1); err != nil {
+		t.Fatalf("PurgeMembers: %v", err)
+	}
+	if err := stores.RestoreMember(ctx, kept, other); err != nil {
+		t.Fatalf("RestoreMember: %v", err)
+	}
+	if _, err := stores.DeleteMember(ctx, kept, other); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+
+	if err := stores.PurgeOrganization(ctx, orgID); err != nil {
+		t.Fatalf("PurgeOrganization: %v", err)
+	}
+	if err := stores.RestoreOrganization(ctx, orgID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RestoreOrganization of a purged organization: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.InsertMemberInvite(ctx, email, orgID, "DEF456", 0); err == nil {
+		t.Error("InsertMemberInvite to a purged organization succeeded")
+	}
+	if err := stores.PurgeOrganization(ctx, orgID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("PurgeOrganization twice: got %v, want sql.ErrNoRows", err)
+	}
+
+	if err := stores.PurgeMembers(ctx, int(time.Now().Unix())+1); err != nil {
+		t.Fatalf("PurgeMembers: %v", err)
+	}
+	if err := stores.RestoreMember(ctx, kept, other); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RestoreMember of a purged member: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.FetchMemberByID(ctx, kept, userID); err != nil {
+		t.Errorf("FetchMemberByID of a member PurgeMembers kept: %v", err)
+	}
+}
+
+func includesOrganization(organizations []organization.Organization, id string) bool {
+	for _, org := range organizations {
+		if org.ID == id && org.DeletedAt > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func testPurgeUser(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	id := newUser(t, stores)
+	orgID := newOrganization(t, stores)
+	u, err := stores.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+
+	if _, err := stores.InsertMember(ctx, orgID, id, member.Admin, ""); err != nil {
+		t.Fatalf("InsertMember: %v", err)
+	}
+	memberships, err := stores.FetchMembersByUser(ctx, id)
+	if err != nil {
+		t.Fatalf("FetchMembersByUser: %v", err)
+	}
+	if len(memberships) != 1 || memberships[0].OrganizationID != orgID || memberships[0].Role != member.Admin {
+		t.Fatalf("FetchMembersByUser = %+v, want the admin membership", memberships)
+	}
+	if err := stores.PurgeUser(ctx, id); err == nil {
+		t.Fatal("PurgeUser deleted a user who is still a member")
+	}
+	if _, err := stores.DeleteMember(ctx, orgID, id); err != nil {
+		t.Fatalf("DeleteMember: %v", err)
+	}
+	if err := stores.PurgeUser(ctx, id); err == nil {
+		t.Fatal("PurgeUser deleted a user with a removed membership")
+	}
+	if err := stores.PurgeUserMembers(ctx, id); err != nil {
+		t.Fatalf("PurgeUserMembers: %v", err)
+	}
+
+	change := user.EmailChange{UserID: id, Email: uniqueEmail(), CodeHash: "hash", ExpiresAt: 200, CreatedAt: 100}
+	if err := stores.SaveEmailChange(ctx, change); err != nil {
+		t.Fatalf("SaveEmailChange: %v", err)
+	}
+	if err := stores.UpsertSCIMIdentity(ctx, scim.Identity{OrganizationID: orgID, UserID: id, ExternalID: "ext", CreatedAt: 100, UpdatedAt: 100}); err != nil {
+		t.Fatalf("UpsertSCIMIdentity: %v", err)
+	}
+	if err := stores.InsertAccountDeletion(ctx, privacy.Deletion{UserID: id, RequestedBy: id, RequestedAt: 100, DeleteAfter: 200}); err != nil {
+		t.Fatalf("InsertAccountDeletion: %v", err)
+	}
+
+	expiresAt := int(time.Now().Add(time.Hour).Unix())
+	if _, err := stores.InsertMemberInvite(ctx, u.Email, orgID, "ABC123", expiresAt); err != nil {
+		t.Fatalf("InsertMemberInvite: %v", err)
+	}
+	other := uniqueEmail()
+	if _, err := stores.InsertMemberInvite(ctx, other, orgID, "DEF456", expiresAt); err != nil {
+		t.Fatalf("InsertMemberInvite: %v", err)
+	}
+	if err := stores.DeleteMemberInvitesByEmail(ctx, u.Email); err != nil {
+		t.Fatalf("DeleteMemberInvitesByEmail: %v", err)
+	}
+	if _, err := stores.GetMemberInvite(ctx, u.Email, orgID); err == nil {
+		t.Error("the invite to the deleted address is still returned")
+	}
+	if _, err := stores.GetMemberInvite(ctx, other, orgID); err != nil {
+		t.Errorf("GetMemberInvite of another address: %v", err)
+	}
+
+	sent := outbox.NewMessage("from@example.com", []string{u.Email}, "Hello", []byte("body"))
+	shared := outbox.NewMessage("from@example.com", []string{u.Email, other}, "Hello", []byte("body"))
+	for _, message := range []outbox.Message{sent, shared} {
+		if _, err := stores.InsertOutboxMessage(ctx, message); err != nil {
+			t.Fatalf("InsertOutboxMessage: %v", err)
+		}
+	}
+	if err := stores.DeleteOutboxMessagesTo(ctx, u.Email); err != nil {
+		t.Fatalf("DeleteOutboxMessagesTo: %v", err)
+	}
+	messages, err := stores.ClaimOutboxMessages(ctx, 1000, int(time.Now().Add(time.Hour).Unix()))
+	if err != nil {
+		t.Fatalf("ClaimOutboxMessages: %v", err)
+	}
+	if _, ok := claimed(messages, sent.ID); ok {
+		t.Error("the message to the deleted address is still queued")
+	}
+	if _, ok := claimed(messages, shared.ID); !ok {
+		t.Error("a message with other recipients was deleted")
+	}
+
+	if err := stores.PurgeUser(ctx, id); err != nil {
+		t.Fatalf("PurgeUser: %v", err)
+	}
+	if _, err := stores.GetUserByID(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByID of a deleted user: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.GetEmailChange(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetEmailChange of a deleted user: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.GetSCIMIdentity(ctx, orgID, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSCIMIdentity of a deleted user: got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.GetAccountDeletion(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetAccountDeletion of a deleted user: got %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.PurgeUser(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("PurgeUser twice: got %v, want sql.ErrNoRows", err)
+	}
+	if exists, err := stores.EmailExists(ctx, u.Email); err != nil || exists {
+		t.Errorf("EmailExists of a purged user = %v, %v, want false", exists, err)
+	}
+}
+
+func testAccountDeletions(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	late := privacy.Deletion{UserID: newUser(t, stores), RequestedAt: 100, DeleteAfter: 300}
+	late.RequestedBy = late.UserID
+	early := privacy.Deletion{UserID: newUser(t, stores), RequestedBy: uuid.New().String(), RequestedAt: 150, DeleteAfter: 200}
+
+	if _, err := stores.GetAccountDeletion(ctx, late.UserID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetAccountDeletion without a deletion: got %v, want sql.ErrNoRows", err)
+	}
+	for _, deletion := range []privacy.Deletion{late, early} {
+		if err := stores.InsertAccountDeletion(ctx, deletion); err != nil {
+			t.Fatalf("InsertAccountDeletion: %v", err)
+		}
+	}
+	if err := stores.InsertAccountDeletion(ctx, late); err == nil {
+		t.Error("InsertAccountDeletion scheduled a user twice")
+	}
+	if err := stores.InsertAccountDeletion(ctx, privacy.Deletion{UserID: uuid.New().String(), DeleteAfter: 200}); err == nil {
+		t.Error("InsertAccountDeletion accepted an unknown user")
+	}
+
+	got, err := stores.GetAccountDeletion(ctx, late.UserID)
+	if err != nil {
+		t.Fatalf("GetAccountDeletion: %v", err)
+	}
+	if got != late {
+		t.Errorf("GetAccountDeletion = %+v, want %+v", got, late)
+	}
+	deletions, err := stores.FetchAccountDeletions(ctx)
+	if err != nil {
+		t.Fatalf("FetchAccountDeletions: %v", err)
+	}
+	var order []string
+	for _, deletion := range deletions {
+		if deletion.UserID == late.UserID || deletion.UserID == early.UserID {
+			order = append(order, deletion.UserID)
+		}
+	}
+	if len(order) != 2 || order[0] != early.UserID {
+		t.Errorf("FetchAccountDeletions = %+v, want the first due first", deletions)
+	}
+
+	if err := stores.DeleteAccountDeletion(ctx, early.UserID); err != nil {
+		t.Fatalf("DeleteAccountDeletion: %v", err)
+	}
+	if err := stores.DeleteAccountDeletion(ctx, early.UserID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteAccountDeletion twice: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testAuditRedaction(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	userID := uuid.New().String()
+	email := uniqueEmail()
+	organizationID := uuid.New().String()
+	service := audit.New(stores, audit.Options{})
+
+	service.Record(ctx, audit.Event{ActorID: userID, Action: audit.UserLoggedIn, Target: audit.UserTarget(userID), IP: "192.0.2.1"})
+	service.Record(ctx, audit.Event{OrganizationID: organizationID, Action: audit.MemberInvited, Target: audit.InviteTarget(email)})
+	service.Record(ctx, audit.Event{OrganizationID: organizationID, Action: audit.TeamMemberAdded, Target: audit.TeamTarget(uuid.New().String()), Metadata: map[string]string{"user_id": userID}})
+	service.Record(ctx, audit.Event{OrganizationID: organizationID, Action: audit.OrganizationUpdated})
+
+	events, err := stores.FetchAuditEventsMentioning(ctx, []string{userID, email})
+	if err != nil {
+		t.Fatalf("FetchAuditEventsMentioning: %v", err)
+	}
+	if len(events) != 3 || events[0].Action != audit.UserLoggedIn || events[1].Action != audit.MemberInvited || events[2].Action != audit.TeamMemberAdded {
+		t.Fatalf("FetchAuditEventsMentioning = %+v, want the three events naming the user, oldest first", events)
+	}
+
+	for _, event := range events {
+		event.ActorID = ""
+		event.Target = "user:deleted"
+		event.IP = ""
+		event.Metadata = map[string]string{"user_id": "deleted"}
+		if err := service.Redact(ctx, event); err != nil {
+			t.Fatalf("Redact: %v", err)
+		}
+	}
+	if err := stores.RedactAuditEvent(ctx, audit.Event{ID: 1 << 40}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RedactAuditEvent of an unknown event: got %v, want sql.ErrNoRows", err)
+	}
+	events, err = stores.FetchAuditEventsMentioning(ctx, []string{userID, email})
+	if err != nil {
+		t.Fatalf("FetchAuditEventsMentioning: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("FetchAuditEventsMentioning after redaction = %+v, want none", events)
+	}
+	chain, err := stores.FetchAuditChain(ctx, organizationID, 1, 10)
+	if err != nil {
+		t.Fatalf("FetchAuditChain: %v", err)
+	}
+	if len(chain) != 5 || !chain[0].Redacted || chain[0].Target != "user:deleted" || chain[2].Redacted || chain[3].Action != audit.EventRedacted || chain[4].Target != audit.EventTarget(chain[1].ID) {
+		t.Fatalf("FetchAuditChain = %+v, want the first two events redacted and their redactions appended", chain)
+	}
+	if _, err := service.Verify(ctx, nil); err != nil {
+		t.Errorf("Verify after redaction: %v", err)
+	}
+
+	// an export of a redacted event runs up to its redaction, and so up to
+	// the one of the second redacted event on the way
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export bytes.Buffer
+	signed := audit.New(stores, audit.Options{SigningKey: key})
+	if _, err := signed.Export(ctx, &export, organizationID, 1, 1); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	exported, err := audit.VerifyExport(&export, key.Public().(ed25519.PublicKey))
+	if err != nil || exported.FirstSequence != 1 || exported.LastSequence != 5 {
+		t.Errorf("VerifyExport = %+v, %v, want sequences 1 to 5", exported, err)
+	}
+
+	// a redacted event edited afterwards no longer matches its redaction
+	chain[0].Target = "user:someone"
+	if err := stores.RedactAuditEvent(ctx, chain[0]); err != nil {
+		t.Fatalf("RedactAuditEvent: %v", err)
+	}
+	var broken *audit.Break
+	if _, err := service.Verify(ctx, nil); !errors.As(err, &broken) || broken.OrganizationID != organizationID || broken.Sequence != 1 {
+		t.Errorf("Verify after editing a redacted event: got %v, want a break at sequence 1", err)
+	}
+}
+
+func testTransactionCommit(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	var orgID string
+
+	err := stores.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		orgID, err = stores.InsertOrganization(ctx, "Acme", uniqueDomain())
+		if err != nil {
+			return err
+		}
+		_, err = stores.InsertMember(ctx, orgID, userID, member.Admin, "")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	if _, err := stores.GetOrganizationByID(ctx, orgID); err != nil {
+		t.Errorf("committed organization is missing: %v", err)
+	}
+	if _, err := stores.FetchMemberByID(ctx, orgID, userID); err != nil {
+		t.Errorf("committed member is missing: %v", err)
+	}
+}
+
+func testTransactionRollback(t *testing.T, stores Stores) {
+	ctx := context.Background()
+	userID := newUser(t, stores)
+	var orgID string
+
+	err := stores.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		orgID, err = stores.InsertOrganization(ctx, "Acme", uniqueDomain())
+		if err != nil {
+			return err
+		}
+		if _, err := stores.InsertMember(ctx, orgID, userID, member.Admin, ""); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx = %v, want the error of fn", err)
+	}
+
+	if _, err := stores.GetOrganizationByID(ctx, orgID); err == nil {
+		t.Errorf("rolled back organization exists")
+	}
+	if _, err := stores.FetchMemberByID(ctx, orgID, userID); err == nil {
+		t.Errorf("rolled back member exists")
+	}
+}
@@ -0,0 +1,473 @@
+// Package team organizes the members of an organization into teams. Teams
+// nest under a parent team, and a team grants a role and app roles to its
+// members and to the members of its subteams. The permissions of a member
+// merge their own role and app role with the grants of every team they are
+// in, directly or through a subteam.
+package team
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/member"
+)
+
+const (
+	// MaxAppRoles bounds the app roles a team grants
+	MaxAppRoles      = 20
+	maxAppRoleLength = 255
+)
+
+var (
+	TeamNotFound       = errs.New(errs.NotFound, "team_not_found", "team not found")
+	TeamExists         = errs.New(errs.Conflict, "team_exists", "a team with this name already exists")
+	FetchTeamsFailed   = errs.New(errs.Internal, "fetch_teams_failed", "unable to fetch teams")
+	TeamCreateFailed   = errs.New(errs.Internal, "team_creation_failed", "unable to create team")
+	TeamUpdateFailed   = errs.New(errs.Internal, "team_update_failed", "unable to update team")
+	TeamDeleteFailed   = errs.New(errs.Internal, "team_delete_failed", "unable to delete team")
+	HasSubteams        = errs.New(errs.Conflict, "team_has_subteams", "move or delete the subteams of the team first")
+	ParentNotFound     = errs.New(errs.Unprocessable, "parent_team_not_found", "parent team not found")
+	InvalidParent      = errs.New(errs.Unprocessable, "invalid_parent_team", "a team can't be nested under itself or one of its subteams")
+	InvalidRole        = errs.New(errs.Invalid, "invalid_team_role", "a team grants the admin, staff or user role")
+	InvalidAppRole     = errs.New(errs.Invalid, "invalid_app_role", "app roles are 1 to 255 characters without commas, up to 20 per team")
+	NotAMember         = errs.New(errs.Unprocessable, "organization_member_required", "only members of the organization can join a team")
+	AlreadyTeamMember  = errs.New(errs.Conflict, "already_team_member", "user is already a member of this team")
+	TeamMemberNotFound = errs.New(errs.NotFound, "team_member_not_found", "user is not a member of this team")
+	FetchMembersFailed = errs.New(errs.Internal, "fetch_team_members_failed", "unable to fetch team members")
+	MemberAddFailed    = errs.New(errs.Internal, "team_member_add_failed", "unable to add team member")
+	MemberRemoveFailed = errs.New(errs.Internal, "team_member_remove_failed", "unable to remove team member")
+	TeamDeleted        = "team deleted"
+	TeamMemberAdded    = "team member added"
+	TeamMemberRemoved  = "team member removed"
+)
+
+type Team struct {
+	ID             string
+	OrganizationID string
+	// ParentID is empty for a top level team
+	ParentID    string
+	Name        string
+	Description string
+	// Role and AppRoles are granted to the members of the team and of its
+	// subteams, an empty role grants none
+	Role      member.Role
+	AppRoles  []string
+	CreatedAt int
+	UpdatedAt int
+}
+
+type Member struct {
+	TeamID         string
+	OrganizationID string
+	UserID         string
+	CreatedAt      int
+}
+
+// Permissions are what a member holds in the organization
+type Permissions struct {
+	// Role is the highest of the role of the member and the roles their
+	// teams grant
+	Role     member.Role
+	AppRoles []string
+	// Teams are the teams of the member and the teams above them
+	Teams []string
+}
+
+type Store interface {
+	WithTx(context.Context, func(context.Context) error) error
+	FetchMemberByID(context.Context, string, string) (member.Member, error)
+	InsertTeam(context.Context, Team) (string, error)
+	GetTeam(context.Context, string, string) (Team, error)
+	FetchTeams(context.Context, string) ([]Team, error)
+	UpdateTeam(context.Context, Team) error
+	DeleteTeam(context.Context, string, string) error
+	InsertTeamMember(context.Context, Member) error
+	FetchTeamMembers(context.Context, string, string) ([]Member, error)
+	// FetchUserTeams returns the teams of the organization the user is
+	// directly a member of
+	FetchUserTeams(context.Context, string, string) ([]Member, error)
+	DeleteTeamMember(context.Context, string, string, string) error
+}
+
+type Auditor interface {
+	Record(context.Context, audit.Event)
+}
+
+type Service struct {
+	store   Store
+	auditor Auditor
+}
+
+func New(store Store, auditor Auditor) *Service {
+	return &Service{
+		store:   store,
+		auditor: auditor,
+	}
+}
+
+// appRoles trims and dedupes the app roles, they are stored comma separated
+func appRoles(values []string) ([]string, error) {
+	roles := []string{}
+	seen := map[string]bool{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" || len(value) > maxAppRoleLength || strings.Contains(value, ",") {
+			return nil, InvalidAppRole
+		}
+		if !seen[value] {
+			seen[value] = true
+			roles = append(roles, value)
+		}
+	}
+	if len(roles) > MaxAppRoles {
+		return nil, InvalidAppRole
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+// check validates the grants of the team and its place among the other
+// teams of the organization
+func check(team Team, teams []Team) (Team, error) {
+	// the owner role is held by one member, a team can't grant it
+	if team.Role != "" && (!team.Role.Valid() || team.Role == member.Owner) {
+		return Team{}, InvalidRole
+	}
+	roles, err := appRoles(team.AppRoles)
+	if err != nil {
+		return Team{}, err
+	}
+	team.AppRoles = roles
+
+	byID := map[string]Team{}
+	for _, existing := range teams {
+		if existing.ID != team.ID && strings.EqualFold(existing.Name, team.Name) {
+			return Team{}, TeamExists
+		}
+		byID[existing.ID] = existing
+	}
+	// walking up from the parent must not come back to the team
+	for parentID := team.ParentID; parentID != ""; parentID = byID[parentID].ParentID {
+		if parentID == team.ID {
+			return Team{}, InvalidParent
+		}
+		if _, ok := byID[parentID]; !ok {
+			return Team{}, ParentNotFound
+		}
+	}
+	return team, nil
+}
+
+func (s *Service) FetchTeams(ctx context.Context, organizationID string) ([]Team, error) {
+	teams, err := s.store.FetchTeams(ctx, organizationID)
+	if err != nil {
+		log.Println(err)
+		return []Team{}, FetchTeamsFailed
+	}
+	return teams, nil
+}
+
+func (s *Service) GetTeam(ctx context.Context, organizationID string, id string) (Team, error) {
+	team, err := s.store.GetTeam(ctx, organizationID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Team{}, TeamNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Team{}, FetchTeamsFailed
+	}
+	return team, nil
+}
+
+func (s *Service) CreateTeam(ctx context.Context, team Team) (Team, error) {
+	now := int(time.Now().Unix())
+	team.ID = uuid.New().String()
+	team.CreatedAt = now
+	team.UpdatedAt = now
+
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		teams, err := s.FetchTeams(ctx, team.OrganizationID)
+		if err != nil {
+			return err
+		}
+		team, err = check(team, teams)
+		if err != nil {
+			return err
+		}
+		_, err = s.store.InsertTeam(ctx, team)
+		if err != nil {
+			log.Println(err)
+			return TeamCreateFailed
+		}
+		return nil
+	})
+	if err != nil {
+		return Team{}, err
+	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: team.OrganizationID,
+		Action:         audit.TeamCreated,
+		Target:         audit.TeamTarget(team.ID),
+		Metadata:       teamMetadata(team),
+	})
+	return team, nil
+}
+
+// UpdateTeam replaces the name, the description, the parent and the grants
+// of the team
+func (s *Service) UpdateTeam(ctx context.Context, team Team) (Team, error) {
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		previous, err := s.GetTeam(ctx, team.OrganizationID, team.ID)
+		if err != nil {
+			return err
+		}
+		teams, err := s.FetchTeams(ctx, team.OrganizationID)
+		if err != nil {
+			return err
+		}
+		team, err = check(team, teams)
+		if err != nil {
+			return err
+		}
+		team.CreatedAt = previous.CreatedAt
+		team.UpdatedAt = int(time.Now().Unix())
+		err = s.store.UpdateTeam(ctx, team)
+		if err != nil {
+			log.Println(err)
+			return TeamUpdateFailed
+		}
+		return nil
+	})
+	if err != nil {
+		return Team{}, err
+	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: team.OrganizationID,
+		Action:         audit.TeamUpdated,
+		Target:         audit.TeamTarget(team.ID),
+		Metadata:       teamMetadata(team),
+	})
+	return team, nil
+}
+
+// DeleteTeam deletes a team without subteams along with its memberships
+func (s *Service) DeleteTeam(ctx context.Context, organizationID string, id string) (string, error) {
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		teams, err := s.FetchTeams(ctx, organizationID)
+		if err != nil {
+			return err
+		}
+		for _, team := range teams {
+			if team.ParentID == id {
+				return HasSubteams
+			}
+		}
+		err = s.store.DeleteTeam(ctx, organizationID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return TeamNotFound
+		}
+		if err != nil {
+			log.Println(err)
+			return TeamDeleteFailed
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: organizationID,
+		Action:         audit.TeamDeleted,
+		Target:         audit.TeamTarget(id),
+	})
+	return TeamDeleted, nil
+}
+
+func (s *Service) FetchTeamMembers(ctx context.Context, organizationID string, id string) ([]Member, error) {
+	_, err := s.GetTeam(ctx, organizationID, id)
+	if err != nil {
+		return []Member{}, err
+	}
+	members, err := s.store.FetchTeamMembers(ctx, organizationID, id)
+	if err != nil {
+		log.Println(err)
+		return []Member{}, FetchMembersFailed
+	}
+	return members, nil
+}
+
+// AddTeamMember adds a member of the organization to the team
+func (s *Service) AddTeamMember(ctx context.Context, organizationID string, id string, userID string) (string, error) {
+	err := s.store.WithTx(ctx, func(ctx context.Context) error {
+		_, err := s.GetTeam(ctx, organizationID, id)
+		if err != nil {
+			return err
+		}
+		_, err = s.store.FetchMemberByID(ctx, organizationID, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return NotAMember
+		}
+		if err != nil {
+			log.Println(err)
+			return MemberAddFailed
+		}
+		teams, err := s.store.FetchUserTeams(ctx, organizationID, userID)
+		if err != nil {
+			log.Println(err)
+			return MemberAddFailed
+		}
+		for _, membership := range teams {
+			if membership.TeamID == id {
+				return AlreadyTeamMember
+			}
+		}
+		err = s.store.InsertTeamMember(ctx, Member{
+			TeamID:         id,
+			OrganizationID: organizationID,
+			UserID:         userID,
+			CreatedAt:      int(time.Now().Unix()),
+		})
+		if err != nil {
+			log.Println(err)
+			return MemberAddFailed
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: organizationID,
+		Action:         audit.TeamMemberAdded,
+		Target:         audit.TeamTarget(id),
+		Metadata:       map[string]string{"user_id": userID},
+	})
+	return TeamMemberAdded, nil
+}
+
+func (s *Service) RemoveTeamMember(ctx context.Context, organizationID string, id string, userID string) (string, error) {
+	err := s.store.DeleteTeamMember(ctx, organizationID, id, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", TeamMemberNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", MemberRemoveFailed
+	}
+	s.auditor.Record(ctx, audit.Event{
+		OrganizationID: organizationID,
+		Action:         audit.TeamMemberRemoved,
+		Target:         audit.TeamTarget(id),
+		Metadata:       map[string]string{"user_id": userID},
+	})
+	return TeamMemberRemoved, nil
+}
+
+// grants returns the teams of the user, the teams above them included, in
+// the order of their IDs
+func (s *Service) grants(ctx context.Context, organizationID string, userID string) ([]Team, error) {
+	memberships, err := s.store.FetchUserTeams(ctx, organizationID, userID)
+	if err != nil {
+		log.Println(err)
+		return nil, FetchTeamsFailed
+	}
+	if len(memberships) == 0 {
+		return []Team{}, nil
+	}
+	teams, err := s.FetchTeams(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]Team{}
+	for _, team := range teams {
+		byID[team.ID] = team
+	}
+
+	held := map[string]bool{}
+	for _, membership := range memberships {
+		for id := membership.TeamID; id != "" && !held[id]; id = byID[id].ParentID {
+			if _, ok := byID[id]; !ok {
+				break
+			}
+			held[id] = true
+		}
+	}
+	result := []Team{}
+	for id := range held {
+		result = append(result, byID[id])
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// GrantedRole returns the highest role the teams of the user grant, empty
+// when they grant none
+func (s *Service) GrantedRole(ctx context.Context, organizationID string, userID string) (member.Role, error) {
+	teams, err := s.grants(ctx, organizationID, userID)
+	if err != nil {
+		return "", err
+	}
+	var role member.Role
+	for _, team := range teams {
+		if team.Role.Outranks(role) {
+			role = team.Role
+		}
+	}
+	return role, nil
+}
+
+// Permissions resolves what the user holds in the organization, they must
+// be a member of it
+func (s *Service) Permissions(ctx context.Context, organizationID string, userID string) (Permissions, error) {
+	mem, err := s.store.FetchMemberByID(ctx, organizationID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Permissions{}, member.NotAMember
+	}
+	if err != nil {
+		log.Println(err)
+		return Permissions{}, member.FetchMemberFailed
+	}
+	teams, err := s.grants(ctx, organizationID, userID)
+	if err != nil {
+		return Permissions{}, err
+	}
+
+	permissions := Permissions{Role: mem.Role, AppRoles: []string{}, Teams: []string{}}
+	roles := map[string]bool{}
+	if mem.AppRole != "" {
+		roles[mem.AppRole] = true
+	}
+	for _, team := range teams {
+		permissions.Teams = append(permissions.Teams, team.ID)
+		if team.Role.Outranks(permissions.Role) {
+			permissions.Role = team.Role
+		}
+		for _, role := range team.AppRoles {
+			roles[role] = true
+		}
+	}
+	for role := range roles {
+		permissions.AppRoles = append(permissions.AppRoles, role)
+	}
+	sort.Strings(permissions.AppRoles)
+	return permissions, nil
+}
+
+func teamMetadata(team Team) map[string]string {
+	return map[string]string{
+		"name":      team.Name,
+		"parent_id": team.ParentID,
+		"role":      string(team.Role),
+		"app_roles": strings.Join(team.AppRoles, ","),
+	}
+}
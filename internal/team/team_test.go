@@ -0,0 +1,234 @@
+package team
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/member"
+)
+
+const organizationID = "organization-1"
+
+// teamStore keeps the teams and memberships of one organization
+type teamStore struct {
+	members     map[string]member.Member
+	teams       map[string]Team
+	memberships []Member
+}
+
+func newTeamStore(teams ...Team) *teamStore {
+	s := &teamStore{members: map[string]member.Member{}, teams: map[string]Team{}}
+	for _, team := range teams {
+		team.OrganizationID = organizationID
+		s.teams[team.ID] = team
+	}
+	return s
+}
+
+func (s *teamStore) WithTx(ctx context.Context, f func(context.Context) error) error {
+	return f(ctx)
+}
+
+func (s *teamStore) FetchMemberByID(ctx context.Context, organizationID string, userID string) (member.Member, error) {
+	m, ok := s.members[userID]
+	if !ok {
+		return member.Member{}, sql.ErrNoRows
+	}
+	return m, nil
+}
+
+func (s *teamStore) InsertTeam(ctx context.Context, team Team) (string, error) {
+	s.teams[team.ID] = team
+	return team.ID, nil
+}
+
+func (s *teamStore) GetTeam(ctx context.Context, organizationID string, id string) (Team, error) {
+	team, ok := s.teams[id]
+	if !ok {
+		return Team{}, sql.ErrNoRows
+	}
+	return team, nil
+}
+
+func (s *teamStore) FetchTeams(ctx context.Context, organizationID string) ([]Team, error) {
+	teams := []Team{}
+	for _, team := range s.teams {
+		teams = append(teams, team)
+	}
+	return teams, nil
+}
+
+func (s *teamStore) UpdateTeam(ctx context.Context, team Team) error {
+	s.teams[team.ID] = team
+	return nil
+}
+
+func (s *teamStore) DeleteTeam(ctx context.Context, organizationID string, id string) error {
+	delete(s.teams, id)
+	return nil
+}
+
+func (s *teamStore) InsertTeamMember(ctx context.Context, m Member) error {
+	s.memberships = append(s.memberships, m)
+	return nil
+}
+
+func (s *teamStore) FetchTeamMembers(ctx context.Context, organizationID string, id string) ([]Member, error) {
+	members := []Member{}
+	for _, m := range s.memberships {
+		if m.TeamID == id {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+func (s *teamStore) FetchUserTeams(ctx context.Context, organizationID string, userID string) ([]Member, error) {
+	members := []Member{}
+	for _, m := range s.memberships {
+		if m.UserID == userID {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+func (s *teamStore) DeleteTeamMember(ctx context.Context, organizationID string, id string, userID string) error {
+	return nil
+}
+
+type auditor struct{}
+
+func (auditor) Record(context.Context, audit.Event) {}
+
+// hierarchy is a platform team with a backend team under it and an api team
+// under that one
+var hierarchy = []Team{
+	{ID: "a-platform", Name: "Platform", Role: member.Admin, AppRoles: []string{"deploy"}},
+	{ID: "b-backend", ParentID: "a-platform", Name: "Backend", Role: member.Staff, AppRoles: []string{"billing"}},
+	{ID: "c-api", ParentID: "b-backend", Name: "API", AppRoles: []string{"deploy", "oncall"}},
+	{ID: "d-sales", Name: "Sales", Role: member.User, AppRoles: []string{"crm"}},
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		team Team
+		want Team
+		err  error
+	}{
+		{"top level", Team{ID: "new", Name: "Design"}, Team{ID: "new", Name: "Design", AppRoles: []string{}}, nil},
+		{"nested", Team{ID: "new", Name: "Design", ParentID: "c-api", Role: member.Admin}, Team{ID: "new", Name: "Design", ParentID: "c-api", Role: member.Admin, AppRoles: []string{}}, nil},
+		{"app roles deduped and sorted", Team{ID: "new", Name: "Design", AppRoles: []string{" ux ", "research", "ux"}}, Team{ID: "new", Name: "Design", AppRoles: []string{"research", "ux"}}, nil},
+		{"owner role", Team{ID: "new", Name: "Design", Role: member.Owner}, Team{}, InvalidRole},
+		{"unknown role", Team{ID: "new", Name: "Design", Role: "root"}, Team{}, InvalidRole},
+		{"app role with a comma", Team{ID: "new", Name: "Design", AppRoles: []string{"a,b"}}, Team{}, InvalidAppRole},
+		{"empty app role", Team{ID: "new", Name: "Design", AppRoles: []string{" "}}, Team{}, InvalidAppRole},
+		{"duplicate name", Team{ID: "new", Name: "backend"}, Team{}, TeamExists},
+		{"own name", Team{ID: "b-backend", Name: "BACKEND", ParentID: "a-platform"}, Team{ID: "b-backend", Name: "BACKEND", ParentID: "a-platform", AppRoles: []string{}}, nil},
+		{"missing parent", Team{ID: "new", Name: "Design", ParentID: "z-missing"}, Team{}, ParentNotFound},
+		{"own parent", Team{ID: "b-backend", Name: "Backend", ParentID: "b-backend"}, Team{}, InvalidParent},
+		{"under its subteam", Team{ID: "a-platform", Name: "Platform", ParentID: "c-api"}, Team{}, InvalidParent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := check(test.team, hierarchy)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("check: got %v, want %v", err, test.err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("check = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCreateTeamOwner(t *testing.T) {
+	store := newTeamStore(hierarchy...)
+	service := New(store, auditor{})
+	_, err := service.CreateTeam(context.Background(), Team{OrganizationID: organizationID, Name: "Founders", Role: member.Owner})
+	if !errors.Is(err, InvalidRole) {
+		t.Fatalf("CreateTeam granting owner: got %v, want InvalidRole", err)
+	}
+	if len(store.teams) != len(hierarchy) {
+		t.Errorf("CreateTeam stored the team")
+	}
+}
+
+func TestUpdateTeamCycle(t *testing.T) {
+	store := newTeamStore(hierarchy...)
+	service := New(store, auditor{})
+	platform := hierarchy[0]
+	platform.OrganizationID = organizationID
+	platform.ParentID = "c-api"
+
+	_, err := service.UpdateTeam(context.Background(), platform)
+	if !errors.Is(err, InvalidParent) {
+		t.Fatalf("UpdateTeam under its subteam: got %v, want InvalidParent", err)
+	}
+	if store.teams["a-platform"].ParentID != "" {
+		t.Errorf("UpdateTeam stored the cycle")
+	}
+
+	// the api team moves up to the platform team
+	api := hierarchy[2]
+	api.OrganizationID = organizationID
+	api.ParentID = "a-platform"
+	if _, err := service.UpdateTeam(context.Background(), api); err != nil {
+		t.Fatalf("UpdateTeam: %v", err)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	store := newTeamStore(hierarchy...)
+	store.members = map[string]member.Member{
+		"api":      {UserID: "api", Role: member.User, AppRole: "oncall"},
+		"backend":  {UserID: "backend", Role: member.User},
+		"sales":    {UserID: "sales", Role: member.Staff},
+		"outsider": {UserID: "outsider", Role: member.User, AppRole: "viewer"},
+		"owner":    {UserID: "owner", Role: member.Owner},
+	}
+	store.memberships = []Member{
+		{TeamID: "c-api", UserID: "api"},
+		{TeamID: "b-backend", UserID: "backend"},
+		{TeamID: "d-sales", UserID: "sales"},
+		{TeamID: "c-api", UserID: "owner"},
+	}
+	service := New(store, auditor{})
+
+	tests := []struct {
+		user    string
+		want    Permissions
+		granted member.Role
+		err     error
+	}{
+		{"api", Permissions{Role: member.Admin, AppRoles: []string{"billing", "deploy", "oncall"}, Teams: []string{"a-platform", "b-backend", "c-api"}}, member.Admin, nil},
+		{"backend", Permissions{Role: member.Admin, AppRoles: []string{"billing", "deploy"}, Teams: []string{"a-platform", "b-backend"}}, member.Admin, nil},
+		{"sales", Permissions{Role: member.Staff, AppRoles: []string{"crm"}, Teams: []string{"d-sales"}}, member.User, nil},
+		{"outsider", Permissions{Role: member.User, AppRoles: []string{"viewer"}, Teams: []string{}}, "", nil},
+		{"owner", Permissions{Role: member.Owner, AppRoles: []string{"billing", "deploy", "oncall"}, Teams: []string{"a-platform", "b-backend", "c-api"}}, member.Admin, nil},
+		{"stranger", Permissions{}, "", member.NotAMember},
+	}
+	for _, test := range tests {
+		t.Run(test.user, func(t *testing.T) {
+			got, err := service.Permissions(context.Background(), organizationID, test.user)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Permissions: got %v, want %v", err, test.err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Permissions = %+v, want %+v", got, test.want)
+			}
+			if test.err != nil {
+				return
+			}
+			granted, err := service.GrantedRole(context.Background(), organizationID, test.user)
+			if err != nil || granted != test.granted {
+				t.Errorf("GrantedRole = %q, %v, want %q", granted, err, test.granted)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/audit"
+)
+
+type AuditService interface {
+	FetchEvents(context.Context, audit.Filter, string) (audit.Page, error)
+	Export(context.Context, io.Writer, string, int64, int64) (audit.Range, error)
+}
+
+// AuditEventsQuery filters the audit events of an organization, since and
+// until are unix timestamps
+type AuditEventsQuery struct {
+	Action  string `query:"action" json:"action" validate:"trim,max=100"`
+	ActorID string `query:"actor_id" json:"actor_id" validate:"trim,uuid"`
+	Target  string `query:"target" json:"target" validate:"trim,max=400"`
+	Since   string `query:"since" json:"since" validate:"trim,numeric"`
+	Until   string `query:"until" json:"until" validate:"trim,numeric"`
+	Limit   string `query:"limit" json:"limit" validate:"trim,numeric"`
+	Cursor  string `query:"cursor" json:"cursor" validate:"trim,max=100"`
+}
+
+// AuditExportQuery is the range of sequences to export, the whole chain by
+// default
+type AuditExportQuery struct {
+	From string `query:"from" json:"from" validate:"trim,numeric"`
+	To   string `query:"to" json:"to" validate:"trim,numeric"`
+}
+
+type AuditEventResponse struct {
+	ID             int64             `json:"id"`
+	ActorID        string            `json:"actor_id"`
+	OrganizationID string            `json:"organization_id"`
+	Action         string            `json:"action"`
+	Target         string            `json:"target"`
+	IP             string            `json:"ip"`
+	UserAgent      string            `json:"user_agent"`
+	Metadata       map[string]string `json:"metadata"`
+	CreatedAt      int               `json:"created_at"`
+	Sequence       int64             `json:"sequence"`
+	PreviousHash   string            `json:"previous_hash"`
+	Hash           string            `json:"hash"`
+	Redacted       bool              `json:"redacted"`
+}
+
+type AuditEventsResponse struct {
+	Events []AuditEventResponse `json:"events"`
+	// NextCursor is empty on the last page
+	NextCursor string `json:"next_cursor"`
+}
+
+// AuditContextMiddleware hands the client of every request to the services
+// recording audit events, JWTMiddleware adds the actor
+func (h *Http) AuditContextMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := audit.WithClient(c.Request().Context(), c.RealIP(), c.Request().UserAgent())
+		c.SetRequest(c.Request().WithContext(ctx))
+		return next(c)
+	}
+}
+
+func (h *Http) FetchAuditEventsHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	query := AuditEventsQuery{}
+	err = h.bind(ctx, &query)
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	// numeric values were validated, they parse
+	filter := audit.Filter{
+		OrganizationID: organizationID,
+		ActorID:        query.ActorID,
+		Action:         query.Action,
+		Target:         query.Target,
+	}
+	filter.Since, _ = strconv.Atoi(query.Since)
+	filter.Until, _ = strconv.Atoi(query.Until)
+	filter.Limit, _ = strconv.Atoi(query.Limit)
+
+	page, err := h.auditService.FetchEvents(ctx.Request().Context(), filter, query.Cursor)
+	if err != nil {
+		return err
+	}
+
+	response := AuditEventsResponse{
+		Events:     make([]AuditEventResponse, len(page.Events)),
+		NextCursor: page.NextCursor,
+	}
+	for i, event := range page.Events {
+		response.Events[i] = AuditEventResponse(event)
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// exportWriter sends the headers of an export with its first line, an error
+// raised before is still answered with a problem
+type exportWriter struct {
+	ctx            echo.Context
+	organizationID string
+}
+
+func (w exportWriter) Write(p []byte) (int, error) {
+	response := w.ctx.Response()
+	if !response.Committed {
+		response.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+		response.Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit-`+w.organizationID+`.jsonl"`)
+		response.WriteHeader(http.StatusOK)
+	}
+	return response.Write(p)
+}
+
+func (h *Http) ExportAuditEventsHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	query := AuditExportQuery{}
+	err = h.bind(ctx, &query)
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	from, _ := strconv.ParseInt(query.From, 10, 64)
+	to, _ := strconv.ParseInt(query.To, 10, 64)
+	_, err = h.auditService.Export(ctx.Request().Context(), exportWriter{ctx: ctx, organizationID: organizationID}, organizationID, from, to)
+	return err
+}
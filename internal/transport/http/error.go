@@ -0,0 +1,159 @@
+package http
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/password"
+	"microauth.io/core/internal/validate"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem is the RFC 7807 body of every error response, Code is stable
+// and meant for clients to match on
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	// Errors lists the invalid fields of a request that failed validation
+	Errors validate.Errors `json:"errors,omitempty"`
+}
+
+var (
+	InvalidRequestBody = errs.New(errs.Invalid, "invalid_request_body", "invalid request body")
+	MissingToken       = errs.New(errs.Unauthenticated, "missing_token", "missing bearer token")
+	InvalidToken       = errs.New(errs.Unauthenticated, "invalid_token", "invalid or expired bearer token")
+	AdminRequired      = errs.New(errs.Forbidden, "platform_admin_required", "admin privileges required")
+	InternalError      = errs.New(errs.Internal, "internal_error", "some error happened")
+	// organization scoped tokens
+	StaleToken           = errs.New(errs.Unauthenticated, "stale_token", "the permissions of the token changed, request a new one")
+	OrganizationMismatch = errs.New(errs.Forbidden, "organization_mismatch", "the token is scoped to another organization")
+	NotOrganizationToken = errs.New(errs.Invalid, "not_organization_token", "the current organization needs a token scoped to an organization")
+)
+
+var statusByKind = map[errs.Kind]int{
+	errs.Internal:        http.StatusInternalServerError,
+	errs.Invalid:         http.StatusBadRequest,
+	errs.Unauthenticated: http.StatusUnauthorized,
+	errs.Forbidden:       http.StatusForbidden,
+	errs.NotFound:        http.StatusNotFound,
+	errs.Conflict:        http.StatusConflict,
+	errs.Gone:            http.StatusGone,
+	errs.Unprocessable:   http.StatusUnprocessableEntity,
+	errs.Throttled:       http.StatusTooManyRequests,
+}
+
+// ErrorHandler writes every error returned by a handler as a problem
+// document. Internal errors are logged and their cause is not disclosed.
+func (h *Http) ErrorHandler(err error, ctx echo.Context) {
+	if ctx.Response().Committed {
+		return
+	}
+
+	problem := h.problem(err)
+	problem.Instance = ctx.Request().URL.Path
+	if retry, ok := retryAfter(err); ok {
+		ctx.Response().Header().Set("Retry-After", strconv.Itoa(retry))
+	}
+	if problem.Status >= http.StatusInternalServerError {
+		log.Println(ctx.Request().Method, problem.Instance, err)
+	}
+
+	ctx.Response().Header().Set(echo.HeaderContentType, problemContentType)
+	if ctx.Request().Method == http.MethodHead {
+		err = ctx.NoContent(problem.Status)
+	} else {
+		err = ctx.JSON(problem.Status, problem)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (h *Http) problem(err error) Problem {
+	// errors raised by echo itself, such as unknown routes
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		detail, _ := httpErr.Message.(string)
+		return newProblem(httpErr.Code, httpCode(httpErr.Code), detail)
+	}
+
+	e, ok := errs.As(err)
+	if !ok {
+		e = InternalError
+	}
+	status, ok := statusByKind[e.Kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	problem := newProblem(status, e.Code, e.Message)
+
+	var invalid validate.Errors
+	if errors.As(err, &invalid) {
+		problem.Errors = invalid
+	}
+	// a rejected password lists every requirement it fails
+	var violations password.Violations
+	if errors.As(err, &violations) {
+		for _, violation := range violations {
+			problem.Errors = append(problem.Errors, validate.FieldError{
+				Field:   "password",
+				Code:    violation.Code,
+				Message: violation.Message,
+			})
+		}
+	}
+	return problem
+}
+
+// retryAfter is the whole number of seconds a throttled client has to wait,
+// errors announce it with a RetryAfter method
+func retryAfter(err error) (int, bool) {
+	var throttled interface{ RetryAfter() time.Duration }
+	if !errors.As(err, &throttled) {
+		return 0, false
+	}
+	return seconds(throttled.RetryAfter()), true
+}
+
+func newProblem(status int, code string, detail string) Problem {
+	return Problem{
+		Type:   "urn:microauth:problem:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// httpCode derives a code for statuses that do not come from a domain
+// error, 404 becomes not_found
+func httpCode(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusUnsupportedMediaType:
+		return "unsupported_media_type"
+	case http.StatusTooManyRequests:
+		return "too_many_requests"
+	case http.StatusServiceUnavailable:
+		return "service_unavailable"
+	}
+	if status >= http.StatusInternalServerError {
+		return "internal_error"
+	}
+	return "bad_request"
+}
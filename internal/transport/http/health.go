@@ -0,0 +1,61 @@
+package http
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ReadinessCheck reports whether a dependency can serve requests
+type ReadinessCheck func(context.Context) error
+
+const readinessTimeout = 2 * time.Second
+
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+var (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// AddReadinessCheck registers a dependency checked by /readyz
+func (h *Http) AddReadinessCheck(name string, check ReadinessCheck) {
+	h.readinessChecks[name] = check
+}
+
+// HealthHandler answers as long as the process serves requests
+func (h *Http) HealthHandler(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, HealthResponse{Status: StatusOK})
+}
+
+// ReadyHandler runs every readiness check and fails when one of them does
+func (h *Http) ReadyHandler(ctx echo.Context) error {
+	response := HealthResponse{
+		Status: StatusOK,
+		Checks: make(map[string]string, len(h.readinessChecks)),
+	}
+	checkCtx, cancel := context.WithTimeout(ctx.Request().Context(), readinessTimeout)
+	defer cancel()
+
+	for name, check := range h.readinessChecks {
+		err := check(checkCtx)
+		if err != nil {
+			log.Println("readiness check failed:", name, err)
+			response.Status = StatusUnavailable
+			response.Checks[name] = err.Error()
+			continue
+		}
+		response.Checks[name] = StatusOK
+	}
+
+	if response.Status != StatusOK {
+		return ctx.JSON(http.StatusServiceUnavailable, response)
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
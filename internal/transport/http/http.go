@@ -1,7 +1,15 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
 	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/ratelimit"
+	"microauth.io/core/internal/validate"
 )
 
 type Http struct {
@@ -9,40 +17,200 @@ type Http struct {
 	userService         UserService
 	organizationService OrganizationService
 	memberService       MemberService
+	outboxService       OutboxService
+	lockoutService      LockoutService
+	rateLimiter         RateLimiter
+	auditService        AuditService
+	webhookService      WebhookService
+	scimService         SCIMService
+	teamService         TeamService
+	privacyService      PrivacyService
+	rateLimits          map[string]ratelimit.Rate
+	accessTokenSecret   []byte
+	validator           *validate.Validator
+	readinessChecks     map[string]ReadinessCheck
 }
 
-var (
-	InvalidRequestBody  = "invalid request body"
-	InternalServerError = "some error happened"
-)
+type Options struct {
+	AccessTokenSecret string
+	// Validator checks the request bodies, it holds the password rule
+	Validator *validate.Validator
+	// TrustedProxies are the networks whose X-Forwarded-For header names
+	// the client, without any the client is the peer of the connection
+	TrustedProxies []*net.IPNet
+	// RateLimits are the rates of the policies by name, a missing policy
+	// is not limited
+	RateLimits map[string]ratelimit.Rate
+}
 
-func New(userService UserService, organizationService OrganizationService, memberService MemberService) *Http {
-	return &Http{
+func New(userService UserService, organizationService OrganizationService, memberService MemberService, outboxService OutboxService, lockoutService LockoutService, rateLimiter RateLimiter, auditService AuditService, webhookService WebhookService, scimService SCIMService, teamService TeamService, privacyService PrivacyService, options Options) *Http {
+	h := &Http{
 		userService:         userService,
 		organizationService: organizationService,
 		memberService:       memberService,
+		outboxService:       outboxService,
+		lockoutService:      lockoutService,
+		rateLimiter:         rateLimiter,
+		auditService:        auditService,
+		webhookService:      webhookService,
+		scimService:         scimService,
+		teamService:         teamService,
+		privacyService:      privacyService,
+		rateLimits:          options.RateLimits,
+		accessTokenSecret:   []byte(options.AccessTokenSecret),
+		validator:           options.Validator,
+		readinessChecks:     make(map[string]ReadinessCheck),
 		server:              echo.New(),
 	}
+	h.server.HTTPErrorHandler = h.ErrorHandler
+	h.server.Validator = options.Validator
+	h.server.IPExtractor = ipExtractor(options.TrustedProxies)
+	h.server.Use(h.AuditContextMiddleware)
+	return h
+}
+
+// start the echo server, it returns nil once Shutdown is called
+func (h *Http) Start(port string) error {
+	err := h.server.Start(":" + port)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
 
-// start the echo server
-func (h *Http) Start(port string) {
-	h.server.Logger.Fatal(h.server.Start(":" + port))
+// Shutdown stops accepting connections and waits for in flight requests
+// until the context expires
+func (h *Http) Shutdown(ctx context.Context) error {
+	return h.server.Shutdown(ctx)
+}
+
+// ipExtractor never trusts forwarding headers sent by clients themselves,
+// they would pick the address failed logins are counted against
+func ipExtractor(trustedProxies []*net.IPNet) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, network := range trustedProxies {
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// bind decodes the request body into body and validates it
+func (h *Http) bind(ctx echo.Context, body interface{}) error {
+	err := ctx.Bind(body)
+	if err != nil {
+		log.Println(err)
+		return InvalidRequestBody
+	}
+	return ctx.Validate(body)
+}
+
+// uuidParam returns the named path parameter once it is known to be a uuid
+func (h *Http) uuidParam(ctx echo.Context, name string) (string, error) {
+	value := ctx.Param(name)
+	return value, h.validator.Var(name, value, "required,uuid")
 }
 
 // register all the routes here
 func (h *Http) RegisterHandlers() {
-	h.server.POST("/api/v1/users/signup", h.SignupHandler)
-	h.server.POST("/api/v1/users/login", h.LoginHandler)
-	h.server.POST("/api/v1/users/refresh", h.RefreshTokenHandler)
-	h.server.POST("/api/v1/organizations/accept-invite", h.AcceptInviteHandler)
+	// probes
+	h.server.GET("/healthz", h.HealthHandler)
+	h.server.GET("/readyz", h.ReadyHandler)
+
+	h.server.POST("/api/v1/users/signup", h.SignupHandler, h.rateLimit(SignupPolicy, byIP))
+	h.server.POST("/api/v1/users/login", h.LoginHandler, h.rateLimit(LoginPolicy, byIP))
+	h.server.POST("/api/v1/users/refresh", h.RefreshTokenHandler, h.rateLimit(RefreshPolicy, byIP))
+	h.server.POST("/api/v1/organizations/accept-invite", h.AcceptInviteHandler, h.rateLimit(AcceptInvitePolicy, byIP))
 
 	// authenticated requests
 	authenticated := h.server.Group("/api/v1")
-	authenticated.Use(h.JWTMiddleware)
+	authenticated.Use(h.JWTMiddleware, h.OrganizationMiddleware)
+	authenticated.GET("/users/me", h.FetchProfileHandler)
+	authenticated.PATCH("/users/me", h.UpdateProfileHandler)
+	authenticated.POST("/users/me/password", h.ChangePasswordHandler, h.rateLimit(ChangePasswordPolicy, byUser))
+	authenticated.POST("/users/me/email", h.ChangeEmailHandler, h.rateLimit(ChangeEmailPolicy, byUser))
+	authenticated.POST("/users/me/email/confirm", h.ConfirmEmailHandler, h.rateLimit(ChangeEmailPolicy, byUser))
+	authenticated.GET("/users/me/deletion", h.FetchDeletionHandler)
+	authenticated.POST("/users/me/deletion", h.RequestDeletionHandler, h.rateLimit(DeleteAccountPolicy, byUser))
+	authenticated.DELETE("/users/me/deletion", h.CancelDeletionHandler)
+	authenticated.GET("/users/me/export", h.ExportAccountHandler)
+	authenticated.POST("/users/me/switch-organization", h.SwitchOrganizationHandler)
+	authenticated.PUT("/users/me/default-organization", h.SetDefaultOrganizationHandler)
+	authenticated.DELETE("/users/me/default-organization", h.ClearDefaultOrganizationHandler)
 	authenticated.GET("/organizations", h.FetchOrganizationsHandler)
 	authenticated.POST("/organizations", h.CreateOrganizationHandler)
+	authenticated.DELETE("/organizations/:organizationID", h.DeleteOrganizationHandler)
+	authenticated.PUT("/organizations/:organizationID/branding", h.UpdateBrandingHandler)
+	authenticated.PUT("/organizations/:organizationID/password-policy", h.UpdatePasswordPolicyHandler)
+	authenticated.DELETE("/organizations/:organizationID/password-policy", h.DeletePasswordPolicyHandler)
+	authenticated.POST("/organizations/:organizationID/token", h.OrganizationTokenHandler)
 	authenticated.GET("/organizations/:organizationID/members/me", h.FetchMemberHandler)
-	authenticated.GET("/organizations/:organizationID/members", h.FetchAllMembersHandler)
-	authenticated.POST("/organizations/:organizationID/members", h.InviteMemberHandler)
+	authenticated.GET("/organizations/:organizationID/members/me/permissions", h.FetchPermissionsHandler)
+	authenticated.GET("/organizations/:organizationID/members", h.FetchMembersHandler)
+	authenticated.POST("/organizations/:organizationID/members", h.InviteMemberHandler, h.rateLimit(InvitePolicy, byMember))
+	authenticated.DELETE("/organizations/:organizationID/members/:userID", h.RemoveMemberHandler)
+	authenticated.POST("/organizations/:organizationID/members/:userID/restore", h.RestoreMemberHandler)
+	authenticated.GET("/organizations/:organizationID/ownership-transfer", h.FetchOwnershipTransferHandler)
+	authenticated.POST("/organizations/:organizationID/ownership-transfer", h.TransferOwnershipHandler)
+	authenticated.DELETE("/organizations/:organizationID/ownership-transfer", h.CancelOwnershipTransferHandler)
+	authenticated.POST("/organizations/:organizationID/ownership-transfer/accept", h.AcceptOwnershipTransferHandler)
+	authenticated.GET("/organizations/:organizationID/audit-events", h.FetchAuditEventsHandler)
+	authenticated.GET("/organizations/:organizationID/audit-events/export", h.ExportAuditEventsHandler)
+	authenticated.GET("/organizations/:organizationID/webhooks", h.FetchWebhooksHandler)
+	authenticated.POST("/organizations/:organizationID/webhooks", h.CreateWebhookHandler)
+	authenticated.GET("/organizations/:organizationID/webhooks/:webhookID", h.FetchWebhookHandler)
+	authenticated.PUT("/organizations/:organizationID/webhooks/:webhookID", h.UpdateWebhookHandler)
+	authenticated.DELETE("/organizations/:organizationID/webhooks/:webhookID", h.DeleteWebhookHandler)
+	authenticated.GET("/organizations/:organizationID/webhooks/:webhookID/deliveries", h.FetchWebhookDeliveriesHandler)
+	authenticated.POST("/organizations/:organizationID/webhooks/:webhookID/deliveries/:deliveryID/redeliver", h.RedeliverWebhookHandler)
+	authenticated.GET("/organizations/:organizationID/scim-tokens", h.FetchSCIMTokensHandler)
+	authenticated.POST("/organizations/:organizationID/scim-tokens", h.CreateSCIMTokenHandler)
+	authenticated.DELETE("/organizations/:organizationID/scim-tokens/:tokenID", h.RevokeSCIMTokenHandler)
+	authenticated.GET("/organizations/:organizationID/teams", h.FetchTeamsHandler)
+	authenticated.POST("/organizations/:organizationID/teams", h.CreateTeamHandler)
+	authenticated.GET("/organizations/:organizationID/teams/:teamID", h.FetchTeamHandler)
+	authenticated.PUT("/organizations/:organizationID/teams/:teamID", h.UpdateTeamHandler)
+	authenticated.DELETE("/organizations/:organizationID/teams/:teamID", h.DeleteTeamHandler)
+	authenticated.GET("/organizations/:organizationID/teams/:teamID/members", h.FetchTeamMembersHandler)
+	authenticated.PUT("/organizations/:organizationID/teams/:teamID/members/:userID", h.AddTeamMemberHandler)
+	authenticated.DELETE("/organizations/:organizationID/teams/:teamID/members/:userID", h.RemoveTeamMemberHandler)
+
+	// identity provider requests, authenticated with a scim token of the
+	// organization
+	provisioning := h.server.Group("/scim/v2/:organizationID", h.SCIMErrorMiddleware, h.SCIMAuthMiddleware)
+	provisioning.GET("/ServiceProviderConfig", h.SCIMServiceProviderConfigHandler)
+	provisioning.GET("/ResourceTypes", h.SCIMResourceTypesHandler)
+	provisioning.GET("/Users", h.FetchSCIMUsersHandler)
+	provisioning.POST("/Users", h.CreateSCIMUserHandler)
+	provisioning.GET("/Users/:userID", h.FetchSCIMUserHandler)
+	provisioning.PUT("/Users/:userID", h.ReplaceSCIMUserHandler)
+	provisioning.PATCH("/Users/:userID", h.PatchSCIMUserHandler)
+	provisioning.DELETE("/Users/:userID", h.DeleteSCIMUserHandler)
+	provisioning.GET("/Groups", h.FetchSCIMGroupsHandler)
+	provisioning.POST("/Groups", h.CreateSCIMGroupHandler)
+	provisioning.GET("/Groups/:groupID", h.FetchSCIMGroupHandler)
+	provisioning.PUT("/Groups/:groupID", h.ReplaceSCIMGroupHandler)
+	provisioning.PATCH("/Groups/:groupID", h.PatchSCIMGroupHandler)
+	provisioning.DELETE("/Groups/:groupID", h.DeleteSCIMGroupHandler)
+
+	// operator requests
+	admin := authenticated.Group("/admin")
+	admin.Use(h.AdminMiddleware)
+	admin.GET("/outbox", h.FetchOutboxMessagesHandler)
+	admin.POST("/outbox/:messageID/retry", h.RetryOutboxMessageHandler)
+	admin.DELETE("/lockouts/accounts/:email", h.UnlockAccountHandler)
+	admin.DELETE("/lockouts/addresses/:ip", h.UnlockAddressHandler)
+	admin.GET("/account-deletions", h.FetchDeletionsHandler)
+	admin.POST("/users/:userID/deletion", h.AdminDeleteAccountHandler)
+	admin.DELETE("/users/:userID/deletion", h.AdminCancelDeletionHandler)
+	admin.GET("/users/:userID/export", h.AdminExportAccountHandler)
+	admin.DELETE("/users/:userID", h.AdminDeleteUserHandler)
+	admin.POST("/users/:userID/restore", h.AdminRestoreUserHandler)
+	admin.POST("/organizations/:organizationID/restore", h.AdminRestoreOrganizationHandler)
 }
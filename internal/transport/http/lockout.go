@@ -0,0 +1,46 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type LockoutService interface {
+	UnlockAccount(context.Context, string) (string, error)
+	UnlockAddress(context.Context, string) (string, error)
+}
+
+// UnlockAccountHandler forgets the failed logins of an account, known or
+// not
+func (h *Http) UnlockAccountHandler(ctx echo.Context) error {
+	email := ctx.Param("email")
+	err := h.validator.Var("email", email, "required,email")
+	if err != nil {
+		return err
+	}
+
+	result, err := h.lockoutService.UnlockAccount(ctx.Request().Context(), email)
+	if err != nil {
+		return err
+	}
+
+	return ctx.String(http.StatusOK, result)
+}
+
+// UnlockAddressHandler forgets the failed logins from a client address
+func (h *Http) UnlockAddressHandler(ctx echo.Context) error {
+	ip := ctx.Param("ip")
+	err := h.validator.Var("ip", ip, "required,ip")
+	if err != nil {
+		return err
+	}
+
+	result, err := h.lockoutService.UnlockAddress(ctx.Request().Context(), ip)
+	if err != nil {
+		return err
+	}
+
+	return ctx.String(http.StatusOK, result)
+}
@@ -2,21 +2,29 @@ package http
 
 import (
 	"context"
-	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/page"
 )
 
 type MemberService interface {
-	InviteMember(context.Context, string, string, string) (string, error)
-	FetchAllMembers(context.Context, string, string) ([]member.Member, error)
-	FetchMember(context.Context, string, string) (member.Member, error)
+	InviteMember(context.Context, string, string, string, string) (string, error)
+	FetchMembers(context.Context, string, member.Filter, string) (member.Page, error)
+	FetchListing(context.Context, string, string) (member.Listing, error)
+	RequireAdmin(context.Context, string, string) error
+	RequireOwner(context.Context, string, string) error
 	AddMember(context.Context, string, string, member.Role, string) (string, error)
 	UpdateMember(context.Context, string, string, member.Role, string) (string, error)
 	DeleteMember(context.Context, string, string) (string, error)
+	RestoreMember(context.Context, string, string) (string, error)
 	AcceptInvite(ctx context.Context, email string, code string, organizationID string, firstName string, lastName string, password string) (string, error)
+	TransferOwnership(context.Context, string, string, string, string) (member.OwnershipTransfer, error)
+	GetOwnershipTransfer(context.Context, string, string) (member.OwnershipTransfer, error)
+	AcceptOwnershipTransfer(context.Context, string, string) (string, error)
+	CancelOwnershipTransfer(context.Context, string, string) (string, error)
 }
 
 type MemberResponse struct {
@@ -25,26 +33,81 @@ type MemberResponse struct {
 	UserID         string      `json:"user_id"`
 	Role           member.Role `json:"role"`
 	AppRole        string      `json:"app_role"`
+	FirstName      string      `json:"first_name"`
+	LastName       string      `json:"last_name"`
+	Email          string      `json:"email"`
+	// DeletedAt is when a removed member was removed, 0 otherwise
+	DeletedAt int `json:"deleted_at"`
+}
+
+type MembersResponse struct {
+	Members []MemberResponse `json:"members"`
+	// NextCursor is empty on the last page
+	NextCursor string `json:"next_cursor"`
+}
+
+// MembersQuery filters and sorts the members, q searches the email and
+// the name and deleted lists the removed members instead
+type MembersQuery struct {
+	Role    member.Role `query:"role" json:"role" validate:"trim,lower,max=32"`
+	AppRole string      `query:"app_role" json:"app_role" validate:"trim,max=100"`
+	Search  string      `query:"q" json:"q" validate:"trim,max=254"`
+	Deleted bool        `query:"deleted" json:"deleted"`
+	Sort    member.Sort `query:"sort" json:"sort" validate:"trim,lower,max=32"`
+	Order   string      `query:"order" json:"order" validate:"trim,lower,max=4"`
+	Limit   string      `query:"limit" json:"limit" validate:"trim,numeric"`
+	Cursor  string      `query:"cursor" json:"cursor" validate:"trim,max=2048"`
+}
+
+func memberResponse(l member.Listing) MemberResponse {
+	return MemberResponse{
+		ID:             l.ID,
+		OrganizationID: l.OrganizationID,
+		UserID:         l.UserID,
+		Role:           l.Role,
+		AppRole:        l.AppRole,
+		FirstName:      l.FirstName,
+		LastName:       l.LastName,
+		Email:          l.Email,
+		DeletedAt:      l.DeletedAt,
+	}
+}
+
+type OwnershipTransferResponse struct {
+	OrganizationID string `json:"organization_id"`
+	FromUserID     string `json:"from_user_id"`
+	ToUserID       string `json:"to_user_id"`
+	CreatedAt      int    `json:"created_at"`
+	ExpiresAt      int    `json:"expires_at"`
+}
+
+// The email telling the recipient is written in locale
+type TransferOwnershipRequest struct {
+	UserID string `json:"user_id" validate:"trim,required,uuid"`
+	Locale string `json:"locale" validate:"trim,locale"`
 }
 
 type InviteMemberRequest struct {
-	Email string `json:"email"`
+	Email  string `json:"email" validate:"trim,lower,required,email,max=254"`
+	Locale string `json:"locale" validate:"trim,locale"`
 }
 
+// The names and the password are only used when the invite creates the
+// user
 type AcceptInviteRequest struct {
-	Email          string `json:"email"`
-	Code           string `json:"code"`
-	OrganizationID string `json:"organization_id"`
-	FirstName      string `json:"first_name"`
-	LastName       string `json:"last_name"`
-	Password       string `json:"password"`
+	Email          string `json:"email" validate:"trim,lower,required,email,max=254"`
+	Code           string `json:"code" validate:"trim,upper,required,alphanum,max=16"`
+	OrganizationID string `json:"organization_id" validate:"trim,required,uuid"`
+	FirstName      string `json:"first_name" validate:"trim,max=100"`
+	LastName       string `json:"last_name" validate:"trim,max=100"`
+	Password       string `json:"password" validate:"password"`
 }
 
 func (h *Http) AcceptInviteHandler(ctx echo.Context) error {
 	// Parse the request body
 	var request AcceptInviteRequest
-	if err := ctx.Bind(&request); err != nil {
-		return ctx.String(http.StatusBadRequest, InvalidRequestBody)
+	if err := h.bind(ctx, &request); err != nil {
+		return err
 	}
 
 	// Invoke the service to accept the invitation
@@ -58,8 +121,7 @@ func (h *Http) AcceptInviteHandler(ctx echo.Context) error {
 		request.Password,
 	)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusInternalServerError, InternalServerError)
+		return err
 	}
 
 	return ctx.String(http.StatusOK, response)
@@ -67,60 +129,185 @@ func (h *Http) AcceptInviteHandler(ctx echo.Context) error {
 
 func (h *Http) InviteMemberHandler(ctx echo.Context) error {
 	// Get the organization ID from path parameter
-	organizationID := ctx.Param("organizationID")
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
 
 	// Get the user ID from path parameter
 	userID := ctx.Get("UserID").(string)
 
 	// Parse the request body
 	var request InviteMemberRequest
-	if err := ctx.Bind(&request); err != nil {
-		return ctx.String(http.StatusBadRequest, InvalidRequestBody)
+	if err := h.bind(ctx, &request); err != nil {
+		return err
 	}
 
 	// Invoke the service to invite a member
-	result, err := h.memberService.InviteMember(ctx.Request().Context(), request.Email, userID, organizationID)
+	result, err := h.memberService.InviteMember(ctx.Request().Context(), request.Email, userID, organizationID, request.Locale)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusInternalServerError, InternalServerError)
+		return err
 	}
 
 	return ctx.String(http.StatusOK, result)
 }
 
-func (h *Http) FetchAllMembersHandler(ctx echo.Context) error {
-	members, err := h.memberService.FetchAllMembers(ctx.Request().Context(), ctx.Param("organizationID"), ctx.Get("UserID").(string))
+func (h *Http) FetchMembersHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	query := MembersQuery{}
+	err = h.bind(ctx, &query)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusBadRequest, InternalServerError)
+		return err
 	}
-	result := make([]MemberResponse, len(members))
-	for i, mem := range members {
-		result[i] = MemberResponse{
-			ID:             mem.ID,
-			OrganizationID: mem.OrganizationID,
-			UserID:         mem.UserID,
-			Role:           mem.Role,
-			AppRole:        mem.AppRole,
-		}
+	descending, err := page.Descending(query.Order)
+	if err != nil {
+		return err
 	}
 
-	return ctx.JSON(http.StatusOK, result)
+	// limit was validated, it parses
+	filter := member.Filter{
+		OrganizationID: organizationID,
+		Role:           query.Role,
+		AppRole:        query.AppRole,
+		Search:         query.Search,
+		Deleted:        query.Deleted,
+		Sort:           query.Sort,
+		Descending:     descending,
+	}
+	filter.Limit, _ = strconv.Atoi(query.Limit)
 
+	result, err := h.memberService.FetchMembers(ctx.Request().Context(), ctx.Get("UserID").(string), filter, query.Cursor)
+	if err != nil {
+		return err
+	}
+	response := MembersResponse{
+		Members:    make([]MemberResponse, len(result.Members)),
+		NextCursor: result.NextCursor,
+	}
+	for i, l := range result.Members {
+		response.Members[i] = memberResponse(l)
+	}
+	return ctx.JSON(http.StatusOK, response)
 }
 
 func (h *Http) FetchMemberHandler(ctx echo.Context) error {
-	member, err := h.memberService.FetchMember(ctx.Request().Context(), ctx.Param("organizationID"), ctx.Get("UserID").(string))
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	listing, err := h.memberService.FetchListing(ctx.Request().Context(), organizationID, ctx.Get("UserID").(string))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, memberResponse(listing))
+}
+
+// RemoveMemberHandler removes a member, they can be restored with their
+// teams until the retention window is over
+func (h *Http) RemoveMemberHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+	userID, err := h.uuidParam(ctx, "userID")
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.memberService.DeleteMember(ctx.Request().Context(), organizationID, userID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) RestoreMemberHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+	userID, err := h.uuidParam(ctx, "userID")
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.memberService.RestoreMember(ctx.Request().Context(), organizationID, userID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+// TransferOwnershipHandler lets the owner offer the organization to another
+// member, it changes hands once they accept it
+func (h *Http) TransferOwnershipHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	var request TransferOwnershipRequest
+	if err := h.bind(ctx, &request); err != nil {
+		return err
+	}
+
+	transfer, err := h.memberService.TransferOwnership(ctx.Request().Context(), organizationID, ctx.Get("UserID").(string), request.UserID, request.Locale)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusAccepted, OwnershipTransferResponse(transfer))
+}
+
+func (h *Http) FetchOwnershipTransferHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusBadRequest, InternalServerError)
+		return err
 	}
-	result := MemberResponse{
-		ID:             member.ID,
-		OrganizationID: member.OrganizationID,
-		UserID:         member.UserID,
-		Role:           member.Role,
-		AppRole:        member.AppRole,
+
+	transfer, err := h.memberService.GetOwnershipTransfer(ctx.Request().Context(), organizationID, ctx.Get("UserID").(string))
+	if err != nil {
+		return err
 	}
-	return ctx.JSON(http.StatusOK, result)
+	return ctx.JSON(http.StatusOK, OwnershipTransferResponse(transfer))
+}
+
+func (h *Http) AcceptOwnershipTransferHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	result, err := h.memberService.AcceptOwnershipTransfer(ctx.Request().Context(), organizationID, ctx.Get("UserID").(string))
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) CancelOwnershipTransferHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	result, err := h.memberService.CancelOwnershipTransfer(ctx.Request().Context(), organizationID, ctx.Get("UserID").(string))
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
 }
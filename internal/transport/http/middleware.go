@@ -1,44 +1,147 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"sort"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/audit"
+	"microauth.io/core/internal/member"
 )
 
+// CurrentOrganization in place of an organization ID in a path is the
+// organization of the token
+const CurrentOrganization = "current"
+
 func (http *Http) JWTMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		// Get the JWT token from the Authorization header
 		authHeader := c.Request().Header.Get("Authorization")
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == "" {
-			return echo.NewHTTPError(403, "Missing JWT token")
+			return MissingToken
 		}
 
 		// Parse the JWT token and extract the ID
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 			// Provide the JWT secret key for token verification
-			return []byte("accesstokensecret"), nil
+			return http.accessTokenSecret, nil
 		})
 		if err != nil || !token.Valid {
-			return echo.NewHTTPError(403, "Invalid JWT token")
+			return InvalidToken
 		}
 
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
-			return echo.NewHTTPError(403, "Invalid JWT claims")
+			return InvalidToken
 		}
 
 		userID, ok := claims["id"].(string)
 		if !ok {
-			return echo.NewHTTPError(403, "Invalid user ID in JWT claims")
+			return InvalidToken
+		}
+
+		// Tokens issued before the admin claim existed are not admin tokens
+		isAdmin, _ := claims["admin"].(bool)
+
+		// Organization scoped tokens hold the permissions of the member when
+		// they were issued
+		organizationID, _ := claims["org"].(string)
+		if organizationID != "" {
+			err = http.checkPermissions(c.Request().Context(), claims, organizationID, userID)
+			if err != nil {
+				return err
+			}
 		}
 
 		// Set the ID as a request context value
 		c.Set("UserID", userID)
+		c.Set("IsAdmin", isAdmin)
+		c.Set("OrganizationID", organizationID)
+		c.SetRequest(c.Request().WithContext(audit.WithActor(c.Request().Context(), userID)))
 
 		// Call the next handler
 		return next(c)
 	}
 }
+
+// checkPermissions refuses an organization scoped token once the member
+// left the organization or a role or team change altered their permissions
+func (http *Http) checkPermissions(ctx context.Context, claims jwt.MapClaims, organizationID string, userID string) error {
+	permissions, err := http.teamService.Permissions(ctx, organizationID, userID)
+	if errors.Is(err, member.NotAMember) {
+		return StaleToken
+	}
+	if err != nil {
+		return err
+	}
+	role, _ := claims["org_role"].(string)
+	if role != string(permissions.Role) || !sameStrings(claims["app_roles"], permissions.AppRoles) || !sameStrings(claims["teams"], permissions.Teams) {
+		return StaleToken
+	}
+	return nil
+}
+
+// sameStrings compares a list claim with values regardless of their order
+func sameStrings(claim interface{}, values []string) bool {
+	list, _ := claim.([]interface{})
+	if len(list) != len(values) {
+		return false
+	}
+	claimed := make([]string, 0, len(list))
+	for _, value := range list {
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		claimed = append(claimed, s)
+	}
+	expected := append([]string{}, values...)
+	sort.Strings(claimed)
+	sort.Strings(expected)
+	for i := range claimed {
+		if claimed[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// OrganizationMiddleware must run after JWTMiddleware. With an organization
+// scoped token the path names its organization or CurrentOrganization,
+// which stands for it.
+func (http *Http) OrganizationMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		organizationID, _ := c.Get("OrganizationID").(string)
+		values := c.ParamValues()
+		for i, name := range c.ParamNames() {
+			if name != "organizationID" {
+				continue
+			}
+			if values[i] == CurrentOrganization {
+				if organizationID == "" {
+					return NotOrganizationToken
+				}
+				values[i] = organizationID
+				c.SetParamValues(values...)
+			} else if organizationID != "" && values[i] != organizationID {
+				return OrganizationMismatch
+			}
+		}
+		return next(c)
+	}
+}
+
+// AdminMiddleware must run after JWTMiddleware
+func (http *Http) AdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		isAdmin, ok := c.Get("IsAdmin").(bool)
+		if !ok || !isAdmin {
+			return AdminRequired
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/team"
+)
+
+const (
+	testSecret        = "access"
+	testOrganization  = "0b3c1c53-6a6e-4a8e-8d0a-5f1f5cbf1a01"
+	otherOrganization = "7d4f1b0e-2f8c-4d52-9a36-1c2e3f4a5b6c"
+)
+
+// teamService knows the permissions of user-1 in the test organization
+type teamService struct {
+	TeamService
+	permissions map[string]team.Permissions
+}
+
+func (s teamService) Permissions(ctx context.Context, organizationID string, userID string) (team.Permissions, error) {
+	permissions, ok := s.permissions[organizationID+"/"+userID]
+	if !ok {
+		return team.Permissions{}, member.NotAMember
+	}
+	return permissions, nil
+}
+
+func signed(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func organizationClaims(role string, appRoles []string, teams []string) jwt.MapClaims {
+	return jwt.MapClaims{"id": "user-1", "org": testOrganization, "org_role": role, "app_roles": appRoles, "teams": teams}
+}
+
+func TestOrganizationToken(t *testing.T) {
+	h := &Http{
+		accessTokenSecret: []byte(testSecret),
+		teamService: teamService{permissions: map[string]team.Permissions{
+			testOrganization + "/user-1": {Role: member.Admin, AppRoles: []string{"billing", "support"}, Teams: []string{"team-1", "team-2"}},
+		}},
+	}
+	server := echo.New()
+	var seen string
+	route := func(ctx echo.Context) error {
+		seen = ctx.Param("organizationID")
+		return ctx.NoContent(http.StatusOK)
+	}
+	server.GET("/organizations/:organizationID/members", route, h.JWTMiddleware, h.OrganizationMiddleware)
+
+	current := organizationClaims("admin", []string{"support", "billing"}, []string{"team-2", "team-1"})
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		path   string
+		// organization is the one the handler sees
+		organization string
+		err          error
+	}{
+		{"current organization", current, CurrentOrganization, testOrganization, nil},
+		{"same organization", current, testOrganization, testOrganization, nil},
+		{"other organization", current, otherOrganization, "", OrganizationMismatch},
+		{"user token", jwt.MapClaims{"id": "user-1"}, otherOrganization, otherOrganization, nil},
+		{"current without an organization token", jwt.MapClaims{"id": "user-1"}, CurrentOrganization, "", NotOrganizationToken},
+		{"role changed", organizationClaims("user", []string{"billing", "support"}, []string{"team-1", "team-2"}), CurrentOrganization, "", StaleToken},
+		{"app role removed", organizationClaims("admin", []string{"billing"}, []string{"team-1", "team-2"}), CurrentOrganization, "", StaleToken},
+		{"team left", organizationClaims("admin", []string{"billing", "support"}, []string{"team-1", "team-3"}), CurrentOrganization, "", StaleToken},
+		{"member removed", jwt.MapClaims{"id": "user-2", "org": testOrganization, "org_role": "user"}, CurrentOrganization, "", StaleToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seen = ""
+			request := httptest.NewRequest(http.MethodGet, "/organizations/"+test.path+"/members", nil)
+			request.Header.Set("Authorization", "Bearer "+signed(t, test.claims))
+			recorder := httptest.NewRecorder()
+			ctx := server.NewContext(request, recorder)
+			server.Router().Find(http.MethodGet, request.URL.Path, ctx)
+			if err := h.JWTMiddleware(h.OrganizationMiddleware(route))(ctx); !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			if seen != test.organization {
+				t.Errorf("handler saw organization %q, want %q", seen, test.organization)
+			}
+		})
+	}
+}
@@ -2,56 +2,131 @@ package http
 
 import (
 	"context"
-	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"microauth.io/core/internal/member"
 	"microauth.io/core/internal/organization"
+	"microauth.io/core/internal/page"
+	"microauth.io/core/internal/password"
 )
 
 type OrganizationService interface {
-	FetchUserOrganizations(context.Context, string) ([]organization.Organization, error)
+	FetchUserOrganizations(context.Context, organization.Filter, string) (organization.Page, error)
 	GetOrganization(context.Context, string) (organization.Organization, error)
-	CreateOrganization(context.Context, string, string) (string, error)
+	CreateOrganization(context.Context, string, string, string, string) (string, error)
 	DeleteOrganization(context.Context, string) (string, error)
+	RestoreOrganization(context.Context, string) (string, error)
 	EditOrganization(context.Context, string, string, string) (string, error)
+	EditBranding(context.Context, string, string, string, string) (string, error)
+	EditPasswordPolicy(context.Context, string, password.Policy) (string, error)
 }
 
 type CreateOrganizationRequest struct {
-	Name    string `json:"name"`
-	Domain  string `json:"domain"`
-	AppRole string `json:"app_role"`
+	Name    string `json:"name" validate:"trim,required,max=100"`
+	Domain  string `json:"domain" validate:"trim,lower,required,hostname"`
+	AppRole string `json:"app_role" validate:"trim,max=100"`
+}
+
+type UpdateBrandingRequest struct {
+	LogoURL      string `json:"logo_url" validate:"trim,url,max=2048"`
+	PrimaryColor string `json:"primary_color" validate:"trim,hexcolor"`
+	SenderName   string `json:"sender_name" validate:"trim,max=100"`
+}
+
+// PasswordPolicy is what an organization adds to the server password
+// policy, the policy a password must satisfy is the strictest of both
+type PasswordPolicy struct {
+	MinLength       int  `json:"min_length"`
+	MaxLength       int  `json:"max_length"`
+	RequireLower    bool `json:"require_lower"`
+	RequireUpper    bool `json:"require_upper"`
+	RequireDigit    bool `json:"require_digit"`
+	RequireSymbol   bool `json:"require_symbol"`
+	BanPersonalInfo bool `json:"ban_personal_info"`
+	MinScore        int  `json:"min_score"`
+	RejectBreached  bool `json:"reject_breached"`
 }
 
 type OrganizationsResponse struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Domain    string `json:"domain"`
-	CreatedAt int    `json:"created_at"`
-	UpdatedAt int    `json:"updated_at"`
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Domain       string `json:"domain"`
+	LogoURL      string `json:"logo_url"`
+	PrimaryColor string `json:"primary_color"`
+	SenderName   string `json:"sender_name"`
+	// PasswordPolicy is null when the organization has none
+	PasswordPolicy *PasswordPolicy `json:"password_policy"`
+	// Role is the role of the signed in user in the organization
+	Role      member.Role `json:"role"`
+	CreatedAt int         `json:"created_at"`
+	UpdatedAt int         `json:"updated_at"`
 }
 
-var (
-	CreateOrganizationFailed   = "create organization failed"
-	UnableToFetchOrganizations = "unable to fetch organizations"
-)
+type OrganizationsPageResponse struct {
+	Organizations []OrganizationsResponse `json:"organizations"`
+	// NextCursor is empty on the last page
+	NextCursor string `json:"next_cursor"`
+}
+
+// OrganizationsQuery filters and sorts the organizations of the signed in
+// user, role is their role in the organization and q searches the name and
+// the domain
+type OrganizationsQuery struct {
+	Role   member.Role       `query:"role" json:"role" validate:"trim,lower,max=32"`
+	Search string            `query:"q" json:"q" validate:"trim,max=254"`
+	Sort   organization.Sort `query:"sort" json:"sort" validate:"trim,lower,max=32"`
+	Order  string            `query:"order" json:"order" validate:"trim,lower,max=4"`
+	Limit  string            `query:"limit" json:"limit" validate:"trim,numeric"`
+	Cursor string            `query:"cursor" json:"cursor" validate:"trim,max=2048"`
+}
 
 func (h *Http) FetchOrganizationsHandler(ctx echo.Context) error {
-	organizations, err := h.organizationService.FetchUserOrganizations(ctx.Request().Context(), ctx.Get("UserID").(string))
+	query := OrganizationsQuery{}
+	err := h.bind(ctx, &query)
+	if err != nil {
+		return err
+	}
+	descending, err := page.Descending(query.Order)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusInternalServerError, UnableToFetchOrganizations)
+		return err
 	}
-	response := make([]OrganizationsResponse, len(organizations))
 
-	for i, org := range organizations {
-		response[i] = OrganizationsResponse{
-			ID:        org.ID,
-			Name:      org.Name,
-			Domain:    org.Domain,
-			CreatedAt: org.CreatedAt,
-			UpdatedAt: org.UpdatedAt,
+	// limit was validated, it parses
+	filter := organization.Filter{
+		UserID:     ctx.Get("UserID").(string),
+		Role:       query.Role,
+		Search:     query.Search,
+		Sort:       query.Sort,
+		Descending: descending,
+	}
+	filter.Limit, _ = strconv.Atoi(query.Limit)
+
+	result, err := h.organizationService.FetchUserOrganizations(ctx.Request().Context(), filter, query.Cursor)
+	if err != nil {
+		return err
+	}
+	response := OrganizationsPageResponse{
+		Organizations: make([]OrganizationsResponse, len(result.Organizations)),
+		NextCursor:    result.NextCursor,
+	}
+
+	for i, org := range result.Organizations {
+		response.Organizations[i] = OrganizationsResponse{
+			ID:           org.ID,
+			Name:         org.Name,
+			Domain:       org.Domain,
+			LogoURL:      org.LogoURL,
+			PrimaryColor: org.PrimaryColor,
+			SenderName:   org.SenderName,
+			Role:         org.Role,
+			CreatedAt:    org.CreatedAt,
+			UpdatedAt:    org.UpdatedAt,
+		}
+		if org.PasswordPolicy != (password.Policy{}) {
+			policy := PasswordPolicy(org.PasswordPolicy)
+			response.Organizations[i].PasswordPolicy = &policy
 		}
 	}
 
@@ -60,25 +135,129 @@ func (h *Http) FetchOrganizationsHandler(ctx echo.Context) error {
 
 func (h *Http) CreateOrganizationHandler(ctx echo.Context) error {
 	body := CreateOrganizationRequest{}
-	err := ctx.Bind(&body)
+	err := h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.organizationService.CreateOrganization(ctx.Request().Context(), body.Name, body.Domain, ctx.Get("UserID").(string), body.AppRole)
+
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusBadRequest, InvalidRequestBody)
+		return err
 	}
 
-	orgID, err := h.organizationService.CreateOrganization(ctx.Request().Context(), body.Name, body.Domain)
+	return ctx.String(http.StatusOK, "create organization success")
+}
+
+func (h *Http) UpdateBrandingHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
 
+	body := UpdateBrandingRequest{}
+	err = h.bind(ctx, &body)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusInternalServerError, CreateOrganizationFailed)
+		return err
 	}
 
-	_, err = h.memberService.AddMember(ctx.Request().Context(), orgID, ctx.Get("UserID").(string), member.Admin, body.AppRole)
+	// Only admins can change how the organization emails look
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
 
+	result, err := h.organizationService.EditBranding(ctx.Request().Context(), organizationID, body.LogoURL, body.PrimaryColor, body.SenderName)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusInternalServerError, CreateOrganizationFailed)
+		return err
 	}
 
-	return ctx.String(http.StatusOK, "create organization success")
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) UpdatePasswordPolicyHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	body := PasswordPolicy{}
+	err = h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.organizationService.EditPasswordPolicy(ctx.Request().Context(), organizationID, password.Policy(body))
+	if err != nil {
+		return err
+	}
+
+	return ctx.String(http.StatusOK, result)
+}
+
+// DeletePasswordPolicyHandler leaves the members with the server policy
+func (h *Http) DeletePasswordPolicyHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.organizationService.EditPasswordPolicy(ctx.Request().Context(), organizationID, password.Policy{})
+	if err != nil {
+		return err
+	}
+
+	return ctx.String(http.StatusOK, result)
+}
+
+// DeleteOrganizationHandler lets the owner remove the organization and its
+// members, a system administrator can restore them until the retention
+// window is over
+func (h *Http) DeleteOrganizationHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	err = h.memberService.RequireOwner(ctx.Request().Context(), organizationID, ctx.Get("UserID").(string))
+	if err != nil {
+		return err
+	}
+
+	result, err := h.organizationService.DeleteOrganization(ctx.Request().Context(), organizationID)
+	if err != nil {
+		return err
+	}
+
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) AdminRestoreOrganizationHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	result, err := h.organizationService.RestoreOrganization(ctx.Request().Context(), organizationID)
+	if err != nil {
+		return err
+	}
+
+	return ctx.String(http.StatusOK, result)
+}
+
+// requireOrganizationAdmin fails unless the signed in user is an admin of
+// the organization, on their own or through a team
+func (h *Http) requireOrganizationAdmin(ctx echo.Context, organizationID string) error {
+	return h.memberService.RequireAdmin(ctx.Request().Context(), organizationID, ctx.Get("UserID").(string))
 }
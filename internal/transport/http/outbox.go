@@ -0,0 +1,70 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/outbox"
+)
+
+type OutboxService interface {
+	FetchMessages(context.Context, outbox.Status) ([]outbox.Message, error)
+	RetryMessage(context.Context, string) (string, error)
+}
+
+type OutboxMessageResponse struct {
+	ID            string        `json:"id"`
+	Recipients    []string      `json:"recipients"`
+	Subject       string        `json:"subject"`
+	Status        outbox.Status `json:"status"`
+	Attempts      int           `json:"attempts"`
+	LastError     string        `json:"last_error"`
+	NextAttemptAt int           `json:"next_attempt_at"`
+	CreatedAt     int           `json:"created_at"`
+	UpdatedAt     int           `json:"updated_at"`
+}
+
+func (h *Http) FetchOutboxMessagesHandler(ctx echo.Context) error {
+	// Failed messages are the dead ones unless another status is asked for
+	status := outbox.Status(ctx.QueryParam("status"))
+	if status == "" {
+		status = outbox.Dead
+	}
+
+	messages, err := h.outboxService.FetchMessages(ctx.Request().Context(), status)
+	if err != nil {
+		return err
+	}
+
+	response := make([]OutboxMessageResponse, len(messages))
+	for i, message := range messages {
+		response[i] = OutboxMessageResponse{
+			ID:            message.ID,
+			Recipients:    message.Recipients,
+			Subject:       message.Subject,
+			Status:        message.Status,
+			Attempts:      message.Attempts,
+			LastError:     message.LastError,
+			NextAttemptAt: message.NextAttemptAt,
+			CreatedAt:     message.CreatedAt,
+			UpdatedAt:     message.UpdatedAt,
+		}
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+func (h *Http) RetryOutboxMessageHandler(ctx echo.Context) error {
+	messageID, err := h.uuidParam(ctx, "messageID")
+	if err != nil {
+		return err
+	}
+
+	result, err := h.outboxService.RetryMessage(ctx.Request().Context(), messageID)
+	if err != nil {
+		return err
+	}
+
+	return ctx.String(http.StatusOK, result)
+}
@@ -0,0 +1,200 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/privacy"
+)
+
+type PrivacyService interface {
+	RequestDeletion(context.Context, string, string) (privacy.Deletion, error)
+	ScheduleDeletion(context.Context, string, string) (privacy.Deletion, error)
+	GetDeletion(context.Context, string) (privacy.Deletion, error)
+	FetchDeletions(context.Context) ([]privacy.Deletion, error)
+	CancelDeletion(context.Context, string) (string, error)
+	DeleteAccount(context.Context, string) (string, error)
+	RemoveUser(context.Context, string) (string, error)
+	Export(context.Context, string) (privacy.Archive, error)
+}
+
+// DeleteAccountRequest schedules the deletion of the account of the signed
+// in user
+type DeleteAccountRequest struct {
+	Password string `json:"password" validate:"required,max=1024"`
+}
+
+// AdminDeleteAccountRequest schedules the deletion of an account, or
+// deletes it right away when Immediate is set
+type AdminDeleteAccountRequest struct {
+	Immediate bool `json:"immediate"`
+}
+
+type DeletionResponse struct {
+	UserID      string `json:"user_id"`
+	RequestedBy string `json:"requested_by"`
+	RequestedAt int    `json:"requested_at"`
+	DeleteAfter int    `json:"delete_after"`
+}
+
+type DeletionsResponse struct {
+	Deletions []DeletionResponse `json:"deletions"`
+}
+
+type MembershipResponse struct {
+	OrganizationID   string `json:"organization_id"`
+	OrganizationName string `json:"organization_name"`
+	Role             string `json:"role"`
+	AppRole          string `json:"app_role"`
+}
+
+type SessionResponse struct {
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt int    `json:"created_at"`
+}
+
+// ExportResponse is the archive of what is stored about a user
+type ExportResponse struct {
+	Profile ProfileResponse `json:"profile"`
+	// Deletion is null unless a deletion is scheduled
+	Deletion    *DeletionResponse    `json:"deletion"`
+	Memberships []MembershipResponse `json:"memberships"`
+	Sessions    []SessionResponse    `json:"sessions"`
+	AuditEvents []AuditEventResponse `json:"audit_events"`
+	ExportedAt  int                  `json:"exported_at"`
+}
+
+func exportResponse(archive privacy.Archive) ExportResponse {
+	response := ExportResponse{
+		Profile:     profileResponse(archive.Profile),
+		Memberships: make([]MembershipResponse, len(archive.Memberships)),
+		Sessions:    make([]SessionResponse, len(archive.Sessions)),
+		AuditEvents: make([]AuditEventResponse, len(archive.Events)),
+		ExportedAt:  archive.ExportedAt,
+	}
+	if archive.Deletion != nil {
+		deletion := DeletionResponse(*archive.Deletion)
+		response.Deletion = &deletion
+	}
+	for i, membership := range archive.Memberships {
+		response.Memberships[i] = MembershipResponse{
+			OrganizationID:   membership.OrganizationID,
+			OrganizationName: membership.OrganizationName,
+			Role:             string(membership.Role),
+			AppRole:          membership.AppRole,
+		}
+	}
+	for i, session := range archive.Sessions {
+		response.Sessions[i] = SessionResponse(session)
+	}
+	for i, event := range archive.Events {
+		response.AuditEvents[i] = AuditEventResponse(event)
+	}
+	return response
+}
+
+// sendExport answers with the archive as a file to download
+func (h *Http) sendExport(ctx echo.Context, userID string) error {
+	archive, err := h.privacyService.Export(ctx.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	ctx.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="account-`+userID+`.json"`)
+	return ctx.JSON(http.StatusOK, exportResponse(archive))
+}
+
+func (h *Http) FetchDeletionHandler(ctx echo.Context) error {
+	deletion, err := h.privacyService.GetDeletion(ctx.Request().Context(), ctx.Get("UserID").(string))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, DeletionResponse(deletion))
+}
+
+// RequestDeletionHandler answers with the scheduled deletion, the account
+// is deleted once its grace period is over
+func (h *Http) RequestDeletionHandler(ctx echo.Context) error {
+	body := DeleteAccountRequest{}
+	err := h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+	deletion, err := h.privacyService.RequestDeletion(ctx.Request().Context(), ctx.Get("UserID").(string), body.Password)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusAccepted, DeletionResponse(deletion))
+}
+
+func (h *Http) CancelDeletionHandler(ctx echo.Context) error {
+	result, err := h.privacyService.CancelDeletion(ctx.Request().Context(), ctx.Get("UserID").(string))
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) ExportAccountHandler(ctx echo.Context) error {
+	return h.sendExport(ctx, ctx.Get("UserID").(string))
+}
+
+func (h *Http) FetchDeletionsHandler(ctx echo.Context) error {
+	deletions, err := h.privacyService.FetchDeletions(ctx.Request().Context())
+	if err != nil {
+		return err
+	}
+	response := DeletionsResponse{Deletions: make([]DeletionResponse, len(deletions))}
+	for i, deletion := range deletions {
+		response.Deletions[i] = DeletionResponse(deletion)
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// AdminDeleteAccountHandler schedules the deletion of an account on the
+// behalf of its user, or deletes it right away
+func (h *Http) AdminDeleteAccountHandler(ctx echo.Context) error {
+	userID, err := h.uuidParam(ctx, "userID")
+	if err != nil {
+		return err
+	}
+	body := AdminDeleteAccountRequest{}
+	err = h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+
+	if body.Immediate {
+		result, err := h.privacyService.DeleteAccount(ctx.Request().Context(), userID)
+		if err != nil {
+			return err
+		}
+		return ctx.String(http.StatusOK, result)
+	}
+	deletion, err := h.privacyService.ScheduleDeletion(ctx.Request().Context(), userID, ctx.Get("UserID").(string))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusAccepted, DeletionResponse(deletion))
+}
+
+func (h *Http) AdminCancelDeletionHandler(ctx echo.Context) error {
+	userID, err := h.uuidParam(ctx, "userID")
+	if err != nil {
+		return err
+	}
+	result, err := h.privacyService.CancelDeletion(ctx.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) AdminExportAccountHandler(ctx echo.Context) error {
+	userID, err := h.uuidParam(ctx, "userID")
+	if err != nil {
+		return err
+	}
+	return h.sendExport(ctx, userID)
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/ratelimit"
+)
+
+// rate limit policies, the keys of Options.RateLimits
+const (
+	SignupPolicy         = "signup"
+	LoginPolicy          = "login"
+	RefreshPolicy        = "refresh"
+	AcceptInvitePolicy   = "accept_invite"
+	InvitePolicy         = "invite"
+	ChangePasswordPolicy = "change_password"
+	ChangeEmailPolicy    = "change_email"
+	DeleteAccountPolicy  = "delete_account"
+)
+
+type RateLimiter interface {
+	Take(ctx context.Context, policy string, rate ratelimit.Rate, key string) (ratelimit.Result, error)
+}
+
+// rateKey picks the bucket of a request within a policy
+type rateKey func(echo.Context) string
+
+func byIP(ctx echo.Context) string {
+	return "ip:" + ctx.RealIP()
+}
+
+// byUser must run after JWTMiddleware
+func byUser(ctx echo.Context) string {
+	userID, _ := ctx.Get("UserID").(string)
+	return "user:" + userID
+}
+
+// byMember must run after JWTMiddleware, it keys on the caller as well so
+// that a user outside the organization can't drain the bucket of its admins
+func byMember(ctx echo.Context) string {
+	userID, _ := ctx.Get("UserID").(string)
+	return "organization:" + ctx.Param("organizationID") + ":user:" + userID
+}
+
+// rateLimit throttles a route with the named policy, a policy without a
+// rate lets everything through. Every limited response carries the
+// RateLimit headers, a refused one also Retry-After.
+func (h *Http) rateLimit(policy string, key rateKey) echo.MiddlewareFunc {
+	rate := h.rateLimits[policy]
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if rate.Unlimited() {
+			return next
+		}
+		return func(ctx echo.Context) error {
+			result, err := h.rateLimiter.Take(ctx.Request().Context(), policy, rate, key(ctx))
+			header := ctx.Response().Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+			if err != nil {
+				return err
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// seconds rounds up, a client waiting less than announced would be refused
+// again
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,434 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/scim"
+)
+
+const (
+	scimContentType = "application/scim+json"
+	// maxSCIMBody bounds a SCIM request, bulk requests are not supported
+	maxSCIMBody = 1 << 20
+)
+
+type SCIMService interface {
+	CreateToken(context.Context, string, string) (scim.Token, string, error)
+	FetchTokens(context.Context, string) ([]scim.Token, error)
+	RevokeToken(context.Context, string, string) (string, error)
+	Authenticate(context.Context, string, string) error
+	FetchUsers(context.Context, string, scim.Query) (scim.ListResponse, error)
+	GetUser(context.Context, string, string) (scim.UserResource, error)
+	CreateUser(context.Context, string, scim.UserResource) (scim.UserResource, error)
+	ReplaceUser(context.Context, string, string, scim.UserResource) (scim.UserResource, error)
+	PatchUser(context.Context, string, string, scim.PatchRequest) (scim.UserResource, error)
+	DeleteUser(context.Context, string, string) error
+	FetchGroups(context.Context, string, scim.Query) (scim.ListResponse, error)
+	GetGroup(context.Context, string, string) (scim.GroupResource, error)
+	CreateGroup(context.Context, string, scim.GroupResource) (scim.GroupResource, error)
+	ReplaceGroup(context.Context, string, string, scim.GroupResource) (scim.GroupResource, error)
+	PatchGroup(context.Context, string, string, scim.PatchRequest) (scim.GroupResource, error)
+	DeleteGroup(context.Context, string, string) error
+}
+
+type SCIMTokenRequest struct {
+	Name string `json:"name" validate:"trim,required,max=255"`
+}
+
+type SCIMTokenResponse struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	CreatedAt  int    `json:"created_at"`
+	LastUsedAt int    `json:"last_used_at"`
+}
+
+// CreateSCIMTokenResponse is the only response holding the token
+type CreateSCIMTokenResponse struct {
+	SCIMTokenResponse
+	Token string `json:"token"`
+}
+
+// SCIMError is the error body of the SCIM endpoints, RFC 7644 section
+// 3.12, they do not answer with problem documents
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// scimTypes are the scimType of the error codes that have one
+var scimTypes = map[string]string{
+	"invalid_filter":       "invalidFilter",
+	"invalid_path":         "invalidPath",
+	"invalid_value":        "invalidValue",
+	"invalid_syntax":       "invalidSyntax",
+	"invalid_request_body": "invalidSyntax",
+	"validation_failed":    "invalidValue",
+	"scim_not_a_member":    "invalidValue",
+	"no_target":            "noTarget",
+	"mutability":           "mutability",
+	"scim_user_exists":     "uniqueness",
+	"scim_account_exists":  "uniqueness",
+	"scim_group_exists":    "uniqueness",
+	"email_taken":          "uniqueness",
+}
+
+func tokenResponse(token scim.Token) SCIMTokenResponse {
+	return SCIMTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		CreatedAt:  token.CreatedAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
+
+func (h *Http) CreateSCIMTokenHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	body := SCIMTokenRequest{}
+	err = h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	token, secret, err := h.scimService.CreateToken(ctx.Request().Context(), organizationID, body.Name)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusCreated, CreateSCIMTokenResponse{
+		SCIMTokenResponse: tokenResponse(token),
+		Token:             secret,
+	})
+}
+
+func (h *Http) FetchSCIMTokensHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := h.scimService.FetchTokens(ctx.Request().Context(), organizationID)
+	if err != nil {
+		return err
+	}
+	response := make([]SCIMTokenResponse, len(tokens))
+	for i, token := range tokens {
+		response[i] = tokenResponse(token)
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+func (h *Http) RevokeSCIMTokenHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+	tokenID, err := h.uuidParam(ctx, "tokenID")
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.scimService.RevokeToken(ctx.Request().Context(), organizationID, tokenID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+// SCIMErrorMiddleware writes the errors of the SCIM endpoints as SCIM
+// errors, it must run first
+func (h *Http) SCIMErrorMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err == nil || c.Response().Committed {
+			return err
+		}
+
+		problem := h.problem(err)
+		if problem.Status >= http.StatusInternalServerError {
+			log.Println(c.Request().Method, c.Request().URL.Path, err)
+		}
+		detail := problem.Detail
+		for _, invalid := range problem.Errors {
+			detail += "; " + invalid.Field + " " + invalid.Message
+		}
+		if problem.Status == http.StatusUnauthorized {
+			c.Response().Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+		}
+		return h.scimJSON(c, problem.Status, SCIMError{
+			Schemas:  []string{scim.ErrorSchema},
+			Status:   strconv.Itoa(problem.Status),
+			ScimType: scimTypes[problem.Code],
+			Detail:   detail,
+		})
+	}
+}
+
+// SCIMAuthMiddleware accepts the SCIM tokens of the organization of the
+// path only, user access tokens are refused
+func (h *Http) SCIMAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		organizationID := c.Param("organizationID")
+		authHeader := c.Request().Header.Get("Authorization")
+		token := strings.TrimPrefix(authHeader, "Bearer ")
+		if token == "" || token == authHeader {
+			return MissingToken
+		}
+		if !strings.HasPrefix(token, "scim_") || h.validator.Var("organizationID", organizationID, "uuid") != nil {
+			return scim.InvalidToken
+		}
+		err := h.scimService.Authenticate(c.Request().Context(), organizationID, token)
+		if err != nil {
+			return err
+		}
+		return next(c)
+	}
+}
+
+func (h *Http) scimJSON(ctx echo.Context, status int, body interface{}) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return ctx.Blob(status, scimContentType, raw)
+}
+
+// readSCIM decodes a request body, providers send application/scim+json
+// which echo does not bind
+func readSCIM(ctx echo.Context, body interface{}) error {
+	err := json.NewDecoder(io.LimitReader(ctx.Request().Body, maxSCIMBody)).Decode(body)
+	if err != nil {
+		log.Println(err)
+		return scim.InvalidSyntax
+	}
+	return nil
+}
+
+func scimQuery(ctx echo.Context) (scim.Query, error) {
+	query := scim.Query{
+		Filter:     ctx.QueryParam("filter"),
+		StartIndex: 1,
+		Count:      scim.MaxResults,
+	}
+	var err error
+	if value := ctx.QueryParam("startIndex"); value != "" {
+		query.StartIndex, err = strconv.Atoi(value)
+		if err != nil {
+			return scim.Query{}, scim.InvalidValue
+		}
+	}
+	if value := ctx.QueryParam("count"); value != "" {
+		query.Count, err = strconv.Atoi(value)
+		if err != nil {
+			return scim.Query{}, scim.InvalidValue
+		}
+	}
+	return query, nil
+}
+
+// scimLocation is the address of a resource as the client reached the
+// server
+func scimLocation(ctx echo.Context, endpoint string, id string) string {
+	return ctx.Scheme() + "://" + ctx.Request().Host + "/scim/v2/" + ctx.Param("organizationID") + "/" + endpoint + "/" + id
+}
+
+func (h *Http) userResponse(ctx echo.Context, status int, resource scim.UserResource) error {
+	location := scimLocation(ctx, "Users", resource.ID)
+	resource.Meta.Location = location
+	if status == http.StatusCreated {
+		ctx.Response().Header().Set(echo.HeaderLocation, location)
+	}
+	return h.scimJSON(ctx, status, resource)
+}
+
+func (h *Http) groupResponse(ctx echo.Context, status int, resource scim.GroupResource) error {
+	location := scimLocation(ctx, "Groups", resource.ID)
+	resource.Meta.Location = location
+	if status == http.StatusCreated {
+		ctx.Response().Header().Set(echo.HeaderLocation, location)
+	}
+	return h.scimJSON(ctx, status, resource)
+}
+
+func (h *Http) SCIMServiceProviderConfigHandler(ctx echo.Context) error {
+	return h.scimJSON(ctx, http.StatusOK, scim.ServiceProviderConfig())
+}
+
+func (h *Http) SCIMResourceTypesHandler(ctx echo.Context) error {
+	types := scim.ResourceTypes()
+	return h.scimJSON(ctx, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.ListSchema},
+		TotalResults: len(types),
+		StartIndex:   1,
+		ItemsPerPage: len(types),
+		Resources:    types,
+	})
+}
+
+func (h *Http) FetchSCIMUsersHandler(ctx echo.Context) error {
+	query, err := scimQuery(ctx)
+	if err != nil {
+		return err
+	}
+	list, err := h.scimService.FetchUsers(ctx.Request().Context(), ctx.Param("organizationID"), query)
+	if err != nil {
+		return err
+	}
+	resources, _ := list.Resources.([]scim.UserResource)
+	for _, resource := range resources {
+		resource.Meta.Location = scimLocation(ctx, "Users", resource.ID)
+	}
+	return h.scimJSON(ctx, http.StatusOK, list)
+}
+
+func (h *Http) FetchSCIMUserHandler(ctx echo.Context) error {
+	resource, err := h.scimService.GetUser(ctx.Request().Context(), ctx.Param("organizationID"), ctx.Param("userID"))
+	if err != nil {
+		return err
+	}
+	return h.userResponse(ctx, http.StatusOK, resource)
+}
+
+func (h *Http) CreateSCIMUserHandler(ctx echo.Context) error {
+	body := scim.UserResource{}
+	err := readSCIM(ctx, &body)
+	if err != nil {
+		return err
+	}
+	resource, err := h.scimService.CreateUser(ctx.Request().Context(), ctx.Param("organizationID"), body)
+	if err != nil {
+		return err
+	}
+	return h.userResponse(ctx, http.StatusCreated, resource)
+}
+
+func (h *Http) ReplaceSCIMUserHandler(ctx echo.Context) error {
+	body := scim.UserResource{}
+	err := readSCIM(ctx, &body)
+	if err != nil {
+		return err
+	}
+	resource, err := h.scimService.ReplaceUser(ctx.Request().Context(), ctx.Param("organizationID"), ctx.Param("userID"), body)
+	if err != nil {
+		return err
+	}
+	return h.userResponse(ctx, http.StatusOK, resource)
+}
+
+func (h *Http) PatchSCIMUserHandler(ctx echo.Context) error {
+	body := scim.PatchRequest{}
+	err := readSCIM(ctx, &body)
+	if err != nil {
+		return err
+	}
+	resource, err := h.scimService.PatchUser(ctx.Request().Context(), ctx.Param("organizationID"), ctx.Param("userID"), body)
+	if err != nil {
+		return err
+	}
+	return h.userResponse(ctx, http.StatusOK, resource)
+}
+
+func (h *Http) DeleteSCIMUserHandler(ctx echo.Context) error {
+	err := h.scimService.DeleteUser(ctx.Request().Context(), ctx.Param("organizationID"), ctx.Param("userID"))
+	if err != nil {
+		return err
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (h *Http) FetchSCIMGroupsHandler(ctx echo.Context) error {
+	query, err := scimQuery(ctx)
+	if err != nil {
+		return err
+	}
+	list, err := h.scimService.FetchGroups(ctx.Request().Context(), ctx.Param("organizationID"), query)
+	if err != nil {
+		return err
+	}
+	resources, _ := list.Resources.([]scim.GroupResource)
+	for _, resource := range resources {
+		resource.Meta.Location = scimLocation(ctx, "Groups", resource.ID)
+	}
+	return h.scimJSON(ctx, http.StatusOK, list)
+}
+
+func (h *Http) FetchSCIMGroupHandler(ctx echo.Context) error {
+	resource, err := h.scimService.GetGroup(ctx.Request().Context(), ctx.Param("organizationID"), ctx.Param("groupID"))
+	if err != nil {
+		return err
+	}
+	return h.groupResponse(ctx, http.StatusOK, resource)
+}
+
+func (h *Http) CreateSCIMGroupHandler(ctx echo.Context) error {
+	body := scim.GroupResource{}
+	err := readSCIM(ctx, &body)
+	if err != nil {
+		return err
+	}
+	resource, err := h.scimService.CreateGroup(ctx.Request().Context(), ctx.Param("organizationID"), body)
+	if err != nil {
+		return err
+	}
+	return h.groupResponse(ctx, http.StatusCreated, resource)
+}
+
+func (h *Http) ReplaceSCIMGroupHandler(ctx echo.Context) error {
+	body := scim.GroupResource{}
+	err := readSCIM(ctx, &body)
+	if err != nil {
+		return err
+	}
+	resource, err := h.scimService.ReplaceGroup(ctx.Request().Context(), ctx.Param("organizationID"), ctx.Param("groupID"), body)
+	if err != nil {
+		return err
+	}
+	return h.groupResponse(ctx, http.StatusOK, resource)
+}
+
+func (h *Http) PatchSCIMGroupHandler(ctx echo.Context) error {
+	body := scim.PatchRequest{}
+	err := readSCIM(ctx, &body)
+	if err != nil {
+		return err
+	}
+	resource, err := h.scimService.PatchGroup(ctx.Request().Context(), ctx.Param("organizationID"), ctx.Param("groupID"), body)
+	if err != nil {
+		return err
+	}
+	return h.groupResponse(ctx, http.StatusOK, resource)
+}
+
+func (h *Http) DeleteSCIMGroupHandler(ctx echo.Context) error {
+	err := h.scimService.DeleteGroup(ctx.Request().Context(), ctx.Param("organizationID"), ctx.Param("groupID"))
+	if err != nil {
+		return err
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,264 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/team"
+)
+
+type TeamService interface {
+	FetchTeams(context.Context, string) ([]team.Team, error)
+	GetTeam(context.Context, string, string) (team.Team, error)
+	CreateTeam(context.Context, team.Team) (team.Team, error)
+	UpdateTeam(context.Context, team.Team) (team.Team, error)
+	DeleteTeam(context.Context, string, string) (string, error)
+	FetchTeamMembers(context.Context, string, string) ([]team.Member, error)
+	AddTeamMember(context.Context, string, string, string) (string, error)
+	RemoveTeamMember(context.Context, string, string, string) (string, error)
+	Permissions(context.Context, string, string) (team.Permissions, error)
+}
+
+// TeamRequest creates or replaces a team. Role and AppRoles are granted to
+// the members of the team and of its subteams, the app roles are checked
+// by the service.
+type TeamRequest struct {
+	Name        string      `json:"name" validate:"trim,required,max=100"`
+	Description string      `json:"description" validate:"trim,max=1000"`
+	ParentID    string      `json:"parent_id" validate:"trim,uuid"`
+	Role        member.Role `json:"role" validate:"trim,lower,max=32"`
+	AppRoles    []string    `json:"app_roles"`
+}
+
+type TeamResponse struct {
+	ID             string      `json:"id"`
+	OrganizationID string      `json:"organization_id"`
+	ParentID       string      `json:"parent_id"`
+	Name           string      `json:"name"`
+	Description    string      `json:"description"`
+	Role           member.Role `json:"role"`
+	AppRoles       []string    `json:"app_roles"`
+	CreatedAt      int         `json:"created_at"`
+	UpdatedAt      int         `json:"updated_at"`
+}
+
+type TeamMemberResponse struct {
+	TeamID    string `json:"team_id"`
+	UserID    string `json:"user_id"`
+	CreatedAt int    `json:"created_at"`
+}
+
+type PermissionsResponse struct {
+	OrganizationID string      `json:"organization_id"`
+	Role           member.Role `json:"role"`
+	AppRoles       []string    `json:"app_roles"`
+	Teams          []string    `json:"teams"`
+}
+
+func teamResponse(t team.Team) TeamResponse {
+	return TeamResponse{
+		ID:             t.ID,
+		OrganizationID: t.OrganizationID,
+		ParentID:       t.ParentID,
+		Name:           t.Name,
+		Description:    t.Description,
+		Role:           t.Role,
+		AppRoles:       t.AppRoles,
+		CreatedAt:      t.CreatedAt,
+		UpdatedAt:      t.UpdatedAt,
+	}
+}
+
+func (r TeamRequest) team(organizationID string, id string) team.Team {
+	return team.Team{
+		ID:             id,
+		OrganizationID: organizationID,
+		ParentID:       r.ParentID,
+		Name:           r.Name,
+		Description:    r.Description,
+		Role:           r.Role,
+		AppRoles:       r.AppRoles,
+	}
+}
+
+// teamParams reads the organization and the team of the path once the
+// signed in user is known to be an admin of the organization
+func (h *Http) teamParams(ctx echo.Context) (string, string, error) {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return "", "", err
+	}
+	teamID, err := h.uuidParam(ctx, "teamID")
+	if err != nil {
+		return "", "", err
+	}
+	return organizationID, teamID, h.requireOrganizationAdmin(ctx, organizationID)
+}
+
+func (h *Http) CreateTeamHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	body := TeamRequest{}
+	err = h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	created, err := h.teamService.CreateTeam(ctx.Request().Context(), body.team(organizationID, ""))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusCreated, teamResponse(created))
+}
+
+func (h *Http) FetchTeamsHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	teams, err := h.teamService.FetchTeams(ctx.Request().Context(), organizationID)
+	if err != nil {
+		return err
+	}
+	response := make([]TeamResponse, len(teams))
+	for i, t := range teams {
+		response[i] = teamResponse(t)
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+func (h *Http) FetchTeamHandler(ctx echo.Context) error {
+	organizationID, teamID, err := h.teamParams(ctx)
+	if err != nil {
+		return err
+	}
+
+	t, err := h.teamService.GetTeam(ctx.Request().Context(), organizationID, teamID)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, teamResponse(t))
+}
+
+func (h *Http) UpdateTeamHandler(ctx echo.Context) error {
+	organizationID, teamID, err := h.teamParams(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := TeamRequest{}
+	err = h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+
+	updated, err := h.teamService.UpdateTeam(ctx.Request().Context(), body.team(organizationID, teamID))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, teamResponse(updated))
+}
+
+func (h *Http) DeleteTeamHandler(ctx echo.Context) error {
+	organizationID, teamID, err := h.teamParams(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.teamService.DeleteTeam(ctx.Request().Context(), organizationID, teamID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) FetchTeamMembersHandler(ctx echo.Context) error {
+	organizationID, teamID, err := h.teamParams(ctx)
+	if err != nil {
+		return err
+	}
+
+	members, err := h.teamService.FetchTeamMembers(ctx.Request().Context(), organizationID, teamID)
+	if err != nil {
+		return err
+	}
+	response := make([]TeamMemberResponse, len(members))
+	for i, m := range members {
+		response[i] = TeamMemberResponse{
+			TeamID:    m.TeamID,
+			UserID:    m.UserID,
+			CreatedAt: m.CreatedAt,
+		}
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+func (h *Http) AddTeamMemberHandler(ctx echo.Context) error {
+	organizationID, teamID, err := h.teamParams(ctx)
+	if err != nil {
+		return err
+	}
+	userID, err := h.uuidParam(ctx, "userID")
+	if err != nil {
+		return err
+	}
+
+	result, err := h.teamService.AddTeamMember(ctx.Request().Context(), organizationID, teamID, userID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) RemoveTeamMemberHandler(ctx echo.Context) error {
+	organizationID, teamID, err := h.teamParams(ctx)
+	if err != nil {
+		return err
+	}
+	userID, err := h.uuidParam(ctx, "userID")
+	if err != nil {
+		return err
+	}
+
+	result, err := h.teamService.RemoveTeamMember(ctx.Request().Context(), organizationID, teamID, userID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+// FetchPermissionsHandler returns what the signed in user holds in the
+// organization, their own role merged with the grants of their teams
+func (h *Http) FetchPermissionsHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	permissions, err := h.teamService.Permissions(ctx.Request().Context(), organizationID, ctx.Get("UserID").(string))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, PermissionsResponse{
+		OrganizationID: organizationID,
+		Role:           permissions.Role,
+		AppRoles:       permissions.AppRoles,
+		Teams:          permissions.Teams,
+	})
+}
@@ -2,36 +2,112 @@ package http
 
 import (
 	"context"
-	"log"
+	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/member"
+	"microauth.io/core/internal/password"
+	"microauth.io/core/internal/team"
+	"microauth.io/core/internal/user"
 )
 
 var (
-	UserCreated          = "user created successfully"
-	InvalidEmailPassword = "invalid email address or password"
-	UnableSingup         = "unable to signup user"
+	UserCreated = "user created successfully"
+)
+
+var (
+	NoOrganization = errs.New(errs.NotFound, "no_organization", "no default or last organization to switch to, name one")
 )
 
 type UserService interface {
 	GenerateAccessToken(context.Context, string) (string, string, error)
-	Login(context.Context, string, string) (string, string, error)
-	CreateUser(context.Context, string, string, string, string) (string, error)
+	Login(context.Context, string, string, string) (string, string, error)
+	CreateUser(context.Context, string, string, string, string, ...password.Policy) (string, error)
+	ChangePassword(context.Context, string, string, string) (string, string, error)
+	GetProfile(context.Context, string) (user.Profile, error)
+	UpdateName(context.Context, string, string, string) (string, error)
+	RequestEmailChange(context.Context, string, string, string, string) (string, error)
+	ConfirmEmailChange(context.Context, string, string) (string, error)
+	GenerateOrganizationToken(context.Context, string, user.OrganizationClaims) (string, error)
+	SetDefaultOrganization(context.Context, string, string) (string, error)
+	RestoreUser(context.Context, string) (string, error)
 }
 
 // login request
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"trim,lower,required,email,max=254"`
+	Password string `json:"password" validate:"required,max=1024"`
 }
 
-// login request
+// signup request
 type SignupRequest struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
+	FirstName string `json:"first_name" validate:"trim,required,max=100"`
+	LastName  string `json:"last_name" validate:"trim,required,max=100"`
+	Email     string `json:"email" validate:"trim,lower,required,email,max=254"`
+	Password  string `json:"password" validate:"required,password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required,max=1024"`
+	NewPassword     string `json:"new_password" validate:"required,password"`
+}
+
+// UpdateProfileRequest renames the user, an empty name is kept
+type UpdateProfileRequest struct {
+	FirstName string `json:"first_name" validate:"trim,max=100"`
+	LastName  string `json:"last_name" validate:"trim,max=100"`
+}
+
+// ChangeEmailRequest sends a confirmation code to the new address, the
+// email changes once it is confirmed
+type ChangeEmailRequest struct {
+	Email    string `json:"email" validate:"trim,lower,required,email,max=254"`
+	Password string `json:"password" validate:"required,max=1024"`
+	Locale   string `json:"locale" validate:"trim,locale"`
+}
+
+type DefaultOrganizationRequest struct {
+	OrganizationID string `json:"organization_id" validate:"trim,required,uuid"`
+}
+
+type ConfirmEmailRequest struct {
+	Code string `json:"code" validate:"trim,upper,required,alphanum,max=16"`
+}
+
+type ProfileResponse struct {
+	ID              string `json:"id"`
+	FirstName       string `json:"first_name"`
+	LastName        string `json:"last_name"`
+	Email           string `json:"email"`
+	IsEmailVerified bool   `json:"is_email_verified"`
+	IsAdmin         bool   `json:"is_admin"`
+	// PendingEmail is the address waiting for confirmation, empty when
+	// there is none
+	PendingEmail string `json:"pending_email"`
+	// DefaultOrganizationID and LastOrganizationID are empty when there is
+	// none, the user may no longer be a member of either
+	DefaultOrganizationID string `json:"default_organization_id"`
+	LastOrganizationID    string `json:"last_organization_id"`
+	CreatedAt             int    `json:"created_at"`
+	UpdatedAt             int    `json:"updated_at"`
+}
+
+func profileResponse(profile user.Profile) ProfileResponse {
+	return ProfileResponse{
+		ID:                    profile.ID,
+		FirstName:             profile.FirstName,
+		LastName:              profile.LastName,
+		Email:                 profile.Email,
+		IsEmailVerified:       profile.IsEmailVerified,
+		IsAdmin:               profile.IsAdmin,
+		PendingEmail:          profile.PendingEmail,
+		DefaultOrganizationID: profile.DefaultOrganizationID,
+		LastOrganizationID:    profile.LastOrganizationID,
+		CreatedAt:             profile.CreatedAt,
+		UpdatedAt:             profile.UpdatedAt,
+	}
 }
 
 // access token and refresh tokens to be returned
@@ -41,22 +117,20 @@ type Tokens struct {
 }
 
 type RefreshTokenRequest struct {
-	AccessToken string `json:"access_token"`
+	AccessToken string `json:"access_token" validate:"trim,required"`
 }
 
 func (h *Http) RefreshTokenHandler(ctx echo.Context) error {
 	body := RefreshTokenRequest{}
-	err := ctx.Bind(&body)
+	err := h.bind(ctx, &body)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusBadRequest, InvalidRequestBody)
+		return err
 	}
 
 	// Generate a new access token and refresh token
 	newAccessToken, newRefreshToken, err := h.userService.GenerateAccessToken(ctx.Request().Context(), body.AccessToken)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusInternalServerError, InternalServerError)
+		return err
 	}
 
 	tokens := Tokens{
@@ -68,15 +142,13 @@ func (h *Http) RefreshTokenHandler(ctx echo.Context) error {
 
 func (h *Http) LoginHandler(ctx echo.Context) error {
 	body := LoginRequest{}
-	err := ctx.Bind(&body)
+	err := h.bind(ctx, &body)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusBadRequest, InvalidRequestBody)
+		return err
 	}
-	accessToken, refreshToken, err := h.userService.Login(ctx.Request().Context(), body.Email, body.Password)
+	accessToken, refreshToken, err := h.userService.Login(ctx.Request().Context(), body.Email, body.Password, ctx.RealIP())
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusBadRequest, InvalidEmailPassword)
+		return err
 	}
 
 	tokens := Tokens{
@@ -86,17 +158,232 @@ func (h *Http) LoginHandler(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, tokens)
 }
 
+type OrganizationTokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// SwitchOrganizationRequest names the organization to work in, without one
+// it is the default organization of the user, else the last one. Default
+// also makes it the default organization.
+type SwitchOrganizationRequest struct {
+	OrganizationID string `json:"organization_id" validate:"trim,uuid"`
+	Default        bool   `json:"default"`
+}
+
+type SwitchOrganizationResponse struct {
+	OrganizationID string      `json:"organization_id"`
+	Role           member.Role `json:"role"`
+	AccessToken    string      `json:"access_token"`
+}
+
+// OrganizationTokenHandler issues an access token scoped to the
+// organization, it carries the permissions of the signed in user in it
+func (h *Http) OrganizationTokenHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	_, accessToken, err := h.organizationToken(ctx.Request().Context(), organizationID, ctx.Get("UserID").(string))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, OrganizationTokenResponse{AccessToken: accessToken})
+}
+
+// SwitchOrganizationHandler issues an access token scoped to the named
+// organization, or to the default or last organization of the signed in
+// user while they are still a member of it
+func (h *Http) SwitchOrganizationHandler(ctx echo.Context) error {
+	body := SwitchOrganizationRequest{}
+	err := h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+	userID := ctx.Get("UserID").(string)
+
+	candidates := []string{body.OrganizationID}
+	if body.OrganizationID == "" {
+		profile, err := h.userService.GetProfile(ctx.Request().Context(), userID)
+		if err != nil {
+			return err
+		}
+		candidates = []string{profile.DefaultOrganizationID, profile.LastOrganizationID}
+	}
+	for _, organizationID := range candidates {
+		if organizationID == "" {
+			continue
+		}
+		permissions, accessToken, err := h.organizationToken(ctx.Request().Context(), organizationID, userID)
+		if errors.Is(err, member.NotAMember) && body.OrganizationID == "" {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if body.Default {
+			_, err = h.userService.SetDefaultOrganization(ctx.Request().Context(), userID, organizationID)
+			if err != nil {
+				return err
+			}
+		}
+		return ctx.JSON(http.StatusOK, SwitchOrganizationResponse{
+			OrganizationID: organizationID,
+			Role:           permissions.Role,
+			AccessToken:    accessToken,
+		})
+	}
+	return NoOrganization
+}
+
+// organizationToken issues the access token of the member scoped to the
+// organization, it fails with NotAMember for anyone else
+func (h *Http) organizationToken(ctx context.Context, organizationID string, userID string) (team.Permissions, string, error) {
+	permissions, err := h.teamService.Permissions(ctx, organizationID, userID)
+	if err != nil {
+		return team.Permissions{}, "", err
+	}
+	accessToken, err := h.userService.GenerateOrganizationToken(ctx, userID, user.OrganizationClaims{
+		OrganizationID: organizationID,
+		Role:           string(permissions.Role),
+		AppRoles:       permissions.AppRoles,
+		Teams:          permissions.Teams,
+	})
+	if err != nil {
+		return team.Permissions{}, "", err
+	}
+	return permissions, accessToken, nil
+}
+
 func (h *Http) SignupHandler(ctx echo.Context) error {
 	body := SignupRequest{}
-	err := ctx.Bind(&body)
+	err := h.bind(ctx, &body)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusBadRequest, InvalidRequestBody)
+		return err
 	}
 	_, err = h.userService.CreateUser(ctx.Request().Context(), body.FirstName, body.LastName, body.Email, body.Password)
 	if err != nil {
-		log.Println(err)
-		return ctx.String(http.StatusBadRequest, UnableSingup)
+		return err
 	}
 	return ctx.JSON(http.StatusCreated, "account created")
 }
+
+// ChangePasswordHandler answers with new tokens, the other sessions of the
+// user are signed out
+func (h *Http) ChangePasswordHandler(ctx echo.Context) error {
+	body := ChangePasswordRequest{}
+	err := h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+	accessToken, refreshToken, err := h.userService.ChangePassword(ctx.Request().Context(), ctx.Get("UserID").(string), body.CurrentPassword, body.NewPassword)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+func (h *Http) FetchProfileHandler(ctx echo.Context) error {
+	profile, err := h.userService.GetProfile(ctx.Request().Context(), ctx.Get("UserID").(string))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, profileResponse(profile))
+}
+
+// SetDefaultOrganizationHandler makes an organization of the signed in
+// user the one they switch to without naming one
+func (h *Http) SetDefaultOrganizationHandler(ctx echo.Context) error {
+	body := DefaultOrganizationRequest{}
+	err := h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+	userID := ctx.Get("UserID").(string)
+
+	_, err = h.teamService.Permissions(ctx.Request().Context(), body.OrganizationID, userID)
+	if err != nil {
+		return err
+	}
+	result, err := h.userService.SetDefaultOrganization(ctx.Request().Context(), userID, body.OrganizationID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) ClearDefaultOrganizationHandler(ctx echo.Context) error {
+	result, err := h.userService.SetDefaultOrganization(ctx.Request().Context(), ctx.Get("UserID").(string), "")
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) UpdateProfileHandler(ctx echo.Context) error {
+	body := UpdateProfileRequest{}
+	err := h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+	result, err := h.userService.UpdateName(ctx.Request().Context(), ctx.Get("UserID").(string), body.FirstName, body.LastName)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) ChangeEmailHandler(ctx echo.Context) error {
+	body := ChangeEmailRequest{}
+	err := h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+	result, err := h.userService.RequestEmailChange(ctx.Request().Context(), ctx.Get("UserID").(string), body.Password, body.Email, body.Locale)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusAccepted, result)
+}
+
+func (h *Http) ConfirmEmailHandler(ctx echo.Context) error {
+	body := ConfirmEmailRequest{}
+	err := h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+	result, err := h.userService.ConfirmEmailChange(ctx.Request().Context(), ctx.Get("UserID").(string), body.Code)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+// AdminDeleteUserHandler removes an account and its memberships, they can
+// be restored until the retention window is over
+func (h *Http) AdminDeleteUserHandler(ctx echo.Context) error {
+	userID, err := h.uuidParam(ctx, "userID")
+	if err != nil {
+		return err
+	}
+	result, err := h.privacyService.RemoveUser(ctx.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) AdminRestoreUserHandler(ctx echo.Context) error {
+	userID, err := h.uuidParam(ctx, "userID")
+	if err != nil {
+		return err
+	}
+	result, err := h.userService.RestoreUser(ctx.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
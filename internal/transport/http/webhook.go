@@ -0,0 +1,247 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"microauth.io/core/internal/webhook"
+)
+
+type WebhookService interface {
+	CreateEndpoint(context.Context, string, string, []string, bool) (webhook.Endpoint, error)
+	GetEndpoint(context.Context, string, string) (webhook.Endpoint, error)
+	FetchEndpoints(context.Context, string) ([]webhook.Endpoint, error)
+	UpdateEndpoint(context.Context, string, string, string, []string, bool) (webhook.Endpoint, error)
+	DeleteEndpoint(context.Context, string, string) (string, error)
+	FetchDeliveries(context.Context, string, string, webhook.Status, int) ([]webhook.Delivery, error)
+	Redeliver(context.Context, string, string, string) (webhook.Delivery, error)
+}
+
+// WebhookRequest creates or replaces an endpoint, Active defaults to true.
+// The event types are checked by the service.
+type WebhookRequest struct {
+	URL    string   `json:"url" validate:"trim,required,url,max=2048"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+type WebhookDeliveriesQuery struct {
+	Status string `query:"status" json:"status" validate:"trim,lower,max=32"`
+	Limit  string `query:"limit" json:"limit" validate:"trim,numeric"`
+}
+
+type WebhookResponse struct {
+	ID             string   `json:"id"`
+	OrganizationID string   `json:"organization_id"`
+	URL            string   `json:"url"`
+	Events         []string `json:"events"`
+	Active         bool     `json:"active"`
+	CreatedAt      int      `json:"created_at"`
+	UpdatedAt      int      `json:"updated_at"`
+}
+
+// CreateWebhookResponse is the only response holding the secret
+type CreateWebhookResponse struct {
+	WebhookResponse
+	Secret string `json:"secret"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID             string          `json:"id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         webhook.Status  `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status"`
+	LastError      string          `json:"last_error"`
+	NextAttemptAt  int             `json:"next_attempt_at"`
+	CreatedAt      int             `json:"created_at"`
+	UpdatedAt      int             `json:"updated_at"`
+}
+
+func webhookResponse(endpoint webhook.Endpoint) WebhookResponse {
+	return WebhookResponse{
+		ID:             endpoint.ID,
+		OrganizationID: endpoint.OrganizationID,
+		URL:            endpoint.URL,
+		Events:         endpoint.Events,
+		Active:         endpoint.Active,
+		CreatedAt:      endpoint.CreatedAt,
+		UpdatedAt:      endpoint.UpdatedAt,
+	}
+}
+
+func deliveryResponse(delivery webhook.Delivery) WebhookDeliveryResponse {
+	return WebhookDeliveryResponse{
+		ID:             delivery.ID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Payload:        json.RawMessage(delivery.Payload),
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseStatus: delivery.ResponseStatus,
+		LastError:      delivery.LastError,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		CreatedAt:      delivery.CreatedAt,
+		UpdatedAt:      delivery.UpdatedAt,
+	}
+}
+
+// webhookParams reads the organization and the webhook of the path once
+// the signed in user is known to be an admin of the organization
+func (h *Http) webhookParams(ctx echo.Context) (string, string, error) {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return "", "", err
+	}
+	webhookID, err := h.uuidParam(ctx, "webhookID")
+	if err != nil {
+		return "", "", err
+	}
+	return organizationID, webhookID, h.requireOrganizationAdmin(ctx, organizationID)
+}
+
+func (r WebhookRequest) active() bool {
+	return r.Active == nil || *r.Active
+}
+
+func (h *Http) CreateWebhookHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	body := WebhookRequest{}
+	err = h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := h.webhookService.CreateEndpoint(ctx.Request().Context(), organizationID, body.URL, body.Events, body.active())
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusCreated, CreateWebhookResponse{
+		WebhookResponse: webhookResponse(endpoint),
+		Secret:          endpoint.Secret,
+	})
+}
+
+func (h *Http) FetchWebhooksHandler(ctx echo.Context) error {
+	organizationID, err := h.uuidParam(ctx, "organizationID")
+	if err != nil {
+		return err
+	}
+
+	err = h.requireOrganizationAdmin(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	endpoints, err := h.webhookService.FetchEndpoints(ctx.Request().Context(), organizationID)
+	if err != nil {
+		return err
+	}
+	response := make([]WebhookResponse, len(endpoints))
+	for i, endpoint := range endpoints {
+		response[i] = webhookResponse(endpoint)
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+func (h *Http) FetchWebhookHandler(ctx echo.Context) error {
+	organizationID, webhookID, err := h.webhookParams(ctx)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := h.webhookService.GetEndpoint(ctx.Request().Context(), organizationID, webhookID)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, webhookResponse(endpoint))
+}
+
+func (h *Http) UpdateWebhookHandler(ctx echo.Context) error {
+	organizationID, webhookID, err := h.webhookParams(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := WebhookRequest{}
+	err = h.bind(ctx, &body)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := h.webhookService.UpdateEndpoint(ctx.Request().Context(), organizationID, webhookID, body.URL, body.Events, body.active())
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusOK, webhookResponse(endpoint))
+}
+
+func (h *Http) DeleteWebhookHandler(ctx echo.Context) error {
+	organizationID, webhookID, err := h.webhookParams(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.webhookService.DeleteEndpoint(ctx.Request().Context(), organizationID, webhookID)
+	if err != nil {
+		return err
+	}
+	return ctx.String(http.StatusOK, result)
+}
+
+func (h *Http) FetchWebhookDeliveriesHandler(ctx echo.Context) error {
+	organizationID, webhookID, err := h.webhookParams(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := WebhookDeliveriesQuery{}
+	err = h.bind(ctx, &query)
+	if err != nil {
+		return err
+	}
+	// the limit was validated, it parses
+	limit, _ := strconv.Atoi(query.Limit)
+
+	deliveries, err := h.webhookService.FetchDeliveries(ctx.Request().Context(), organizationID, webhookID, webhook.Status(query.Status), limit)
+	if err != nil {
+		return err
+	}
+	response := make([]WebhookDeliveryResponse, len(deliveries))
+	for i, delivery := range deliveries {
+		response[i] = deliveryResponse(delivery)
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+func (h *Http) RedeliverWebhookHandler(ctx echo.Context) error {
+	organizationID, webhookID, err := h.webhookParams(ctx)
+	if err != nil {
+		return err
+	}
+	deliveryID, err := h.uuidParam(ctx, "deliveryID")
+	if err != nil {
+		return err
+	}
+
+	delivery, err := h.webhookService.Redeliver(ctx.Request().Context(), organizationID, webhookID, deliveryID)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(http.StatusAccepted, deliveryResponse(delivery))
+}
@@ -2,23 +2,60 @@ package user
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/google/uuid"
+	"microauth.io/core/internal/audit"
+	mailer "microauth.io/core/internal/email"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/hasher"
+	"microauth.io/core/internal/outbox"
+	"microauth.io/core/internal/password"
 )
 
 var (
-	UserCreationFailed   = errors.New("unable to create new user")
-	UnableToFindUser     = errors.New("unable to find user")
-	MethodNotImplemented = errors.New("method not implemented")
-	InvalidPassword      = errors.New("wrong password")
-	PasswordHashFailed   = errors.New("failed while hashing password")
-	TokenGenFailed       = errors.New("unable to generate token")
-	InvalidRefreshToken  = errors.New("invalid refresh token")
+	UserCreationFailed   = errs.New(errs.Internal, "user_creation_failed", "unable to create new user")
+	EmailTaken           = errs.New(errs.Conflict, "email_taken", "email address is already registered")
+	UnableToFindUser     = errs.New(errs.NotFound, "user_not_found", "unable to find user")
+	FetchUserFailed      = errs.New(errs.Internal, "fetch_user_failed", "unable to fetch user")
+	MethodNotImplemented = errs.New(errs.Internal, "not_implemented", "method not implemented")
+	InvalidCredentials   = errs.New(errs.Unauthenticated, "invalid_credentials", "invalid email address or password")
+	PasswordHashFailed   = errs.New(errs.Internal, "password_hash_failed", "failed while hashing password")
+	TokenGenFailed       = errs.New(errs.Internal, "token_generation_failed", "unable to generate token")
+	InvalidRefreshToken  = errs.New(errs.Unauthenticated, "invalid_refresh_token", "invalid refresh token")
+	WrongPassword        = errs.New(errs.Forbidden, "wrong_password", "current password is incorrect")
+	PasswordUpdateFailed = errs.New(errs.Internal, "password_update_failed", "unable to update password")
+	UserImportFailed     = errs.New(errs.Internal, "user_import_failed", "unable to import user")
+	NameUpdateFailed     = errs.New(errs.Internal, "name_update_failed", "unable to update name")
+	EmailChangeFailed    = errs.New(errs.Internal, "email_change_failed", "unable to change email address")
+	SameEmail            = errs.New(errs.Invalid, "same_email", "the new email address is the current one")
+	EmailChangeNotFound  = errs.New(errs.NotFound, "email_change_not_found", "no email change is pending")
+	InvalidEmailCode     = errs.New(errs.Unprocessable, "invalid_email_change_code", "invalid email change code")
+	EmailChangeExpired   = errs.New(errs.Gone, "email_change_expired", "the email change code expired, ask for a new one")
+	UserDeleteFailed     = errs.New(errs.Internal, "user_delete_failed", "unable to delete user")
+	UserRestoreFailed    = errs.New(errs.Internal, "user_restore_failed", "unable to restore user")
+	DeletedUserNotFound  = errs.New(errs.NotFound, "deleted_user_not_found", "no deleted user to restore, it may have been purged")
+	PreferenceFailed     = errs.New(errs.Internal, "organization_preference_failed", "unable to save the default organization")
 	UserCreated          = "user created"
+	PasswordChanged      = "password changed"
+	NameUpdated          = "name updated"
+	EmailChangeRequested = "email change requested"
+	EmailChanged         = "email changed"
+	UserDeleted          = "user deleted"
+	UserRestored         = "user restored"
+	PreferenceSaved      = "default organization set"
+	PreferenceCleared    = "default organization cleared"
 )
 
 type User struct {
@@ -27,69 +64,730 @@ type User struct {
 	LastName        string
 	Email           string
 	IsEmailVerified bool
+	IsAdmin         bool
 	Password        string
 	ResetOtp        string
 	ResetExpiry     int
-	CreatedAt       int
-	UpdatedAt       int
+	// SessionVersion is carried by refresh tokens, bumping it revokes every
+	// refresh token issued before
+	SessionVersion int
+	CreatedAt      int
+	UpdatedAt      int
+	// DeletedAt is when an administrator removed the account, 0 while it
+	// is not
+	DeletedAt int
+	// ProvisionedBy is the organization whose identity provider created
+	// the account, empty for any other account
+	ProvisionedBy string
+}
+
+// EmailChange is an address the user asked to move to, it replaces the
+// current one once the code sent to it is confirmed. Only the hash of the
+// code is stored.
+type EmailChange struct {
+	UserID    string
+	Email     string
+	CodeHash  string
+	ExpiresAt int
+	CreatedAt int
+}
+
+// OrganizationPreference holds the organization the user picked to work in
+// by default and the last one they were issued a token for, an ID is empty
+// when there is none. Neither is checked against the memberships of the
+// user, they may have left the organization since.
+type OrganizationPreference struct {
+	UserID                string
+	DefaultOrganizationID string
+	LastOrganizationID    string
+	UpdatedAt             int
+}
+
+// Profile is the signed in user with the address waiting for confirmation,
+// if any, and their organization preference
+type Profile struct {
+	User
+	PendingEmail          string
+	DefaultOrganizationID string
+	LastOrganizationID    string
+}
+
+// OrganizationClaims scope an access token to one organization
+type OrganizationClaims struct {
+	OrganizationID string
+	Role           string
+	AppRoles       []string
+	Teams          []string
 }
 
 type UserStore interface {
+	WithTx(context.Context, func(context.Context) error) error
 	GetUserByEmail(context.Context, string) (User, error)
+	GetUserByID(context.Context, string) (User, error)
+	// EmailExists also counts the deleted users
+	EmailExists(context.Context, string) (bool, error)
 	InsertUser(context.Context, string, string, string, string, bool) (string, error)
+	UpdateUserPassword(context.Context, string, string) error
+	UpdateUserName(context.Context, string, string, string) error
+	UpdateUserProvisioner(context.Context, string, string) error
+	// UpdateUserEmail also marks the address as verified
+	UpdateUserEmail(context.Context, string, string) error
+	// RevokeUserSessions bumps the session version of the user
+	RevokeUserSessions(context.Context, string) error
+	FetchUserPasswordPolicies(context.Context, string) ([]password.Policy, error)
+	// SaveEmailChange replaces the pending change of the user
+	SaveEmailChange(context.Context, EmailChange) error
+	GetEmailChange(context.Context, string) (EmailChange, error)
+	DeleteEmailChange(context.Context, string) error
+	GetOrganizationPreference(context.Context, string) (OrganizationPreference, error)
+	// SetDefaultOrganization and SetLastOrganization fail with a foreign
+	// key violation when the organization doesn't exist, an empty ID
+	// clears it
+	SetDefaultOrganization(context.Context, string, string) error
+	SetLastOrganization(context.Context, string, string) error
+	// DeleteUser marks the user and their memberships deleted,
+	// RestoreUser brings both back
+	DeleteUser(context.Context, string) error
+	RestoreUser(context.Context, string) error
+}
+
+type Options struct {
+	AccessTokenSecret  string
+	RefreshTokenSecret string
+	AccessTokenTTL     time.Duration
+	RefreshTokenTTL    time.Duration
+	// PasswordPolicy applies to every password, organizations can only
+	// tighten it
+	PasswordPolicy password.Policy
+	// PasswordChecker defaults to one without a breach corpus
+	PasswordChecker *password.Checker
+	// Hasher defaults to hasher.Default
+	Hasher *hasher.Hasher
+	// LoginGuard throttles password guessing, there is none by default
+	LoginGuard LoginGuard
+	// Auditor records sign ins and account changes, nothing is recorded by
+	// default
+	Auditor Auditor
+	// ClientURL is the base url of the client confirming email changes
+	ClientURL         string
+	EmailChangeExpiry time.Duration
+	// EmailService and OutboxService send the codes of email changes, the
+	// email can't be changed without them
+	EmailService  EmailService
+	OutboxService OutboxService
+}
+
+type EmailService interface {
+	Render(string, string, string, mailer.Branding, interface{}) (mailer.Message, error)
+}
+
+type OutboxService interface {
+	Enqueue(context.Context, outbox.Message) (string, error)
+}
+
+type Auditor interface {
+	Record(context.Context, audit.Event)
+}
+
+type noAuditor struct{}
+
+func (noAuditor) Record(context.Context, audit.Event) {}
+
+// LoginGuard tracks failed logins per account and client address
+type LoginGuard interface {
+	// Check fails while the account or the address has to wait
+	Check(ctx context.Context, email string, ip string) error
+	Failed(ctx context.Context, email string, ip string) error
+	Succeeded(ctx context.Context, email string, ip string) error
 }
 
+type noGuard struct{}
+
+func (noGuard) Check(context.Context, string, string) error     { return nil }
+func (noGuard) Failed(context.Context, string, string) error    { return nil }
+func (noGuard) Succeeded(context.Context, string, string) error { return nil }
+
 type Service struct {
-	store UserStore
+	store   UserStore
+	options Options
+	// dummyHash is verified for unknown emails so they take as long as a
+	// wrong password
+	dummyHash string
 }
 
-func New(store UserStore) *Service {
+func New(store UserStore, options Options) *Service {
+	if options.PasswordChecker == nil {
+		options.PasswordChecker = password.NewChecker(nil)
+	}
+	if options.Hasher == nil {
+		options.Hasher = hasher.Default()
+	}
+	if options.LoginGuard == nil {
+		options.LoginGuard = noGuard{}
+	}
+	if options.Auditor == nil {
+		options.Auditor = noAuditor{}
+	}
+	dummyHash, err := options.Hasher.Hash(uuid.New().String())
+	if err != nil {
+		log.Println(err)
+	}
 	return &Service{
-		store: store,
+		store:     store,
+		options:   options,
+		dummyHash: dummyHash,
 	}
 }
 
+// NormalizeEmail is the form emails are stored and looked up in, the
+// database holds a unique index on the lowercased address
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 func (s *Service) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	user, err := s.store.GetUserByEmail(ctx, email)
+	user, err := s.store.GetUserByEmail(ctx, NormalizeEmail(email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, UnableToFindUser
+	}
 	if err != nil {
 		log.Println(err)
-		return User{}, UnableToFindUser
+		return User{}, FetchUserFailed
 	}
 	return user, nil
 }
 
-func (s *Service) CreateUser(ctx context.Context, firstName string, lastName string, email string, password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// CheckPassword returns password.Violations when password fails the server
+// policy or one of policies. Personal is the names and the email address
+// of the user.
+func (s *Service) CheckPassword(password string, policies []password.Policy, personal ...string) error {
+	policy := s.options.PasswordPolicy
+	for _, p := range policies {
+		policy = policy.Merge(p)
+	}
+	return s.options.PasswordChecker.Check(policy, password, personal...)
+}
+
+// VerifyPassword fails with WrongPassword unless password is the current
+// password of the user
+func (s *Service) VerifyPassword(ctx context.Context, userID string, password string) error {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UnableToFindUser
+	}
+	if err != nil {
+		log.Println(err)
+		return FetchUserFailed
+	}
+	match, _, err := s.options.Hasher.Verify(password, user.Password)
+	if err != nil {
+		log.Println(err)
+	}
+	if !match {
+		return WrongPassword
+	}
+	return nil
+}
+
+// CreateUser creates an account, the password must also satisfy policies,
+// those of the organizations the user joins on signup
+func (s *Service) CreateUser(ctx context.Context, firstName string, lastName string, email string, password string, policies ...password.Policy) (string, error) {
+	email = NormalizeEmail(email)
+	err := s.CheckPassword(password, policies, firstName, lastName, email)
+	if err != nil {
+		return "", err
+	}
+
+	// The unique index still guards against concurrent signups
+	taken, err := s.store.EmailExists(ctx, email)
+	if err != nil {
+		log.Println(err)
+		return "", UserCreationFailed
+	}
+	if taken {
+		return "", EmailTaken
+	}
+
+	hashedPassword, err := s.options.Hasher.Hash(password)
 	if err != nil {
+		log.Println(err)
 		return "", PasswordHashFailed
 	}
-	userID, err := s.store.InsertUser(ctx, firstName, lastName, email, string(hashedPassword), false)
+	userID, err := s.store.InsertUser(ctx, firstName, lastName, email, hashedPassword, false)
 	if err != nil {
 		log.Println(err)
 		return "", UserCreationFailed
 	}
+	s.options.Auditor.Record(ctx, audit.Event{
+		Action: audit.UserCreated,
+		Target: audit.UserTarget(userID),
+	})
 	return userID, nil
 }
 
-func (s *Service) GenerateAccessToken(ctx context.Context, refreshToken string) (string, string, error) {
+// ChangePassword replaces the password of a signed in user who still knows
+// the current one. Every refresh token issued before is revoked, the
+// returned tokens keep the current session signed in.
+func (s *Service) ChangePassword(ctx context.Context, userID string, currentPassword string, newPassword string) (string, string, error) {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", UnableToFindUser
+	}
+	if err != nil {
+		log.Println(err)
+		return "", "", FetchUserFailed
+	}
 
-	token, err := jwt.Parse(refreshToken, func(token *jwt.Token) (interface{}, error) {
-		// Provide the JWT secret key for token verification
-		return []byte("refreshtokensecret"), nil
+	match, _, err := s.options.Hasher.Verify(currentPassword, user.Password)
+	if err != nil {
+		log.Println(err)
+	}
+	if !match {
+		return "", "", WrongPassword
+	}
+
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		err := s.SetPassword(ctx, user, newPassword)
+		if err != nil {
+			return err
+		}
+		err = s.store.RevokeUserSessions(ctx, user.ID)
+		if err != nil {
+			log.Println(err)
+			return PasswordUpdateFailed
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	s.options.Auditor.Record(ctx, audit.Event{
+		Action: audit.UserPasswordChanged,
+		Target: audit.UserTarget(user.ID),
 	})
 
-	refreshTokenClaims, ok := token.Claims.(jwt.MapClaims)
+	user, err = s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Println(err)
+		return "", "", FetchUserFailed
+	}
+	return s.issueTokens(user)
+}
 
-	if !ok {
-		return "", "", InvalidRefreshToken
+// GetProfile returns the user with the address of their pending email
+// change, an expired change is left out
+func (s *Service) GetProfile(ctx context.Context, userID string) (Profile, error) {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Profile{}, UnableToFindUser
+	}
+	if err != nil {
+		log.Println(err)
+		return Profile{}, FetchUserFailed
+	}
+
+	profile := Profile{User: user}
+	change, err := s.store.GetEmailChange(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		return Profile{}, FetchUserFailed
+	}
+	if err == nil && int64(change.ExpiresAt) >= time.Now().Unix() {
+		profile.PendingEmail = change.Email
+	}
+	preference, err := s.store.GetOrganizationPreference(ctx, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		return Profile{}, FetchUserFailed
+	}
+	profile.DefaultOrganizationID = preference.DefaultOrganizationID
+	profile.LastOrganizationID = preference.LastOrganizationID
+	return profile, nil
+}
+
+// SetDefaultOrganization makes the organization the one the user switches
+// to when they don't name one, the caller checked the membership. An empty
+// ID clears it.
+func (s *Service) SetDefaultOrganization(ctx context.Context, userID string, organizationID string) (string, error) {
+	err := s.store.SetDefaultOrganization(ctx, userID, organizationID)
+	if err != nil {
+		log.Println(err)
+		return "", PreferenceFailed
+	}
+	if organizationID == "" {
+		return PreferenceCleared, nil
+	}
+	return PreferenceSaved, nil
+}
+
+// UpdateName replaces the names of the user, an empty name is kept
+func (s *Service) UpdateName(ctx context.Context, userID string, firstName string, lastName string) (string, error) {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", UnableToFindUser
+	}
+	if err != nil {
+		log.Println(err)
+		return "", FetchUserFailed
+	}
+	if firstName == "" {
+		firstName = user.FirstName
+	}
+	if lastName == "" {
+		lastName = user.LastName
 	}
 
+	err = s.store.UpdateUserName(ctx, userID, firstName, lastName)
 	if err != nil {
 		log.Println(err)
+		return "", NameUpdateFailed
+	}
+	s.options.Auditor.Record(ctx, audit.Event{
+		Action: audit.UserNameChanged,
+		Target: audit.UserTarget(userID),
+	})
+	return NameUpdated, nil
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateCode returns a random code of 8 letters and digits, the
+// ambiguous 0, 1, I and O left out
+func generateCode() (string, error) {
+	const chars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	raw := make([]byte, 8)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	for i, b := range raw {
+		raw[i] = chars[int(b)%len(chars)]
+	}
+	return string(raw), nil
+}
+
+// RequestEmailChange sends a code to the new address of the user, who
+// proves they still know their password. The email changes once the code
+// is confirmed, a new request replaces a pending one.
+func (s *Service) RequestEmailChange(ctx context.Context, userID string, currentPassword string, email string, locale string) (string, error) {
+	email = NormalizeEmail(email)
+	user, err := s.store.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", UnableToFindUser
+	}
+	if err != nil {
+		log.Println(err)
+		return "", FetchUserFailed
+	}
+
+	match, _, err := s.options.Hasher.Verify(currentPassword, user.Password)
+	if err != nil {
+		log.Println(err)
+	}
+	if !match {
+		return "", WrongPassword
+	}
+	if email == user.Email {
+		return "", SameEmail
+	}
+	taken, err := s.store.EmailExists(ctx, email)
+	if err != nil {
+		log.Println(err)
+		return "", EmailChangeFailed
+	}
+	if taken {
+		return "", EmailTaken
+	}
+	if s.options.EmailService == nil || s.options.OutboxService == nil {
+		log.Println("email changes need an email and an outbox service")
+		return "", EmailChangeFailed
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		log.Println(err)
+		return "", EmailChangeFailed
+	}
+	now := time.Now()
+	expiry := now.Add(s.options.EmailChangeExpiry)
+	data := mailer.EmailChangeData{
+		Code:      code,
+		URL:       fmt.Sprintf("%s/account/email?code=%s", s.options.ClientURL, url.QueryEscape(code)),
+		ExpiresAt: expiry.UTC().Format(time.RFC1123),
+	}
+	msg, err := s.options.EmailService.Render(email, mailer.EmailChangeTemplate, locale, mailer.Branding{}, data)
+	if err != nil {
+		log.Println(err)
+		return "", EmailChangeFailed
+	}
+	raw, err := msg.Bytes()
+	if err != nil {
+		log.Println(err)
+		return "", EmailChangeFailed
+	}
+
+	// The code is stored and its email queued in the same transaction
+	message := outbox.NewMessage(msg.From.Address, msg.Recipients(), msg.Subject, raw)
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		err := s.store.SaveEmailChange(ctx, EmailChange{
+			UserID:    userID,
+			Email:     email,
+			CodeHash:  hashCode(code),
+			ExpiresAt: int(expiry.Unix()),
+			CreatedAt: int(now.Unix()),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = s.options.OutboxService.Enqueue(ctx, message)
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+		return "", EmailChangeFailed
+	}
+	s.options.Auditor.Record(ctx, audit.Event{
+		Action:   audit.UserEmailChangeRequested,
+		Target:   audit.UserTarget(userID),
+		Metadata: map[string]string{"email": email},
+	})
+	return EmailChangeRequested, nil
+}
+
+// ConfirmEmailChange moves the user to the address of their pending change
+// when code is the one sent to it, the address is then verified
+func (s *Service) ConfirmEmailChange(ctx context.Context, userID string, code string) (string, error) {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", UnableToFindUser
+	}
+	if err != nil {
+		log.Println(err)
+		return "", FetchUserFailed
+	}
+
+	change, err := s.store.GetEmailChange(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", EmailChangeNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", EmailChangeFailed
+	}
+	if int64(change.ExpiresAt) < time.Now().Unix() {
+		return "", EmailChangeExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(hashCode(code)), []byte(change.CodeHash)) != 1 {
+		return "", InvalidEmailCode
+	}
+
+	// The unique index still guards against an account created since
+	taken, err := s.store.EmailExists(ctx, change.Email)
+	if err != nil {
+		log.Println(err)
+		return "", EmailChangeFailed
+	}
+	if taken {
+		return "", EmailTaken
+	}
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		err := s.store.UpdateUserEmail(ctx, userID, change.Email)
+		if err != nil {
+			return err
+		}
+		return s.store.DeleteEmailChange(ctx, userID)
+	})
+	if err != nil {
+		log.Println(err)
+		return "", EmailChangeFailed
+	}
+	s.options.Auditor.Record(ctx, audit.Event{
+		Action:   audit.UserEmailChanged,
+		Target:   audit.UserTarget(userID),
+		Metadata: map[string]string{"previous_email": user.Email, "email": change.Email},
+	})
+	return EmailChanged, nil
+}
+
+// SetPassword checks password against the policies of the server and of
+// every organization of the user before storing it. Every flow replacing a
+// password, such as a reset, goes through it.
+func (s *Service) SetPassword(ctx context.Context, user User, password string) error {
+	policies, err := s.store.FetchUserPasswordPolicies(ctx, user.ID)
+	if err != nil {
+		log.Println(err)
+		return PasswordUpdateFailed
+	}
+	err = s.CheckPassword(password, policies, user.FirstName, user.LastName, user.Email)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.options.Hasher.Hash(password)
+	if err != nil {
+		log.Println(err)
+		return PasswordHashFailed
+	}
+	err = s.store.UpdateUserPassword(ctx, user.ID, hashedPassword)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UnableToFindUser
+	}
+	if err != nil {
+		log.Println(err)
+		return PasswordUpdateFailed
+	}
+	return nil
+}
+
+// ImportUser creates an account migrated from another system with the hash
+// that system stored, see hasher.Hasher.Import for the accepted formats. The
+// password is replaced by a hash of the configured algorithm on the first
+// login.
+func (s *Service) ImportUser(ctx context.Context, firstName string, lastName string, email string, passwordHash string, isEmailVerified bool) (string, error) {
+	email = NormalizeEmail(email)
+	hashedPassword, err := s.options.Hasher.Import(passwordHash)
+	if err != nil {
+		return "", err
+	}
+
+	taken, err := s.store.EmailExists(ctx, email)
+	if err != nil {
+		log.Println(err)
+		return "", UserImportFailed
+	}
+	if taken {
+		return "", EmailTaken
+	}
+
+	userID, err := s.store.InsertUser(ctx, firstName, lastName, email, hashedPassword, isEmailVerified)
+	if err != nil {
+		log.Println(err)
+		return "", UserImportFailed
+	}
+	s.options.Auditor.Record(ctx, audit.Event{
+		Action: audit.UserImported,
+		Target: audit.UserTarget(userID),
+	})
+	return userID, nil
+}
+
+// ProvisionUser creates an account for the identity provider of the
+// organization, the address is verified by the provider. Without a
+// password the account has none and signing in with a password fails until
+// one is set.
+func (s *Service) ProvisionUser(ctx context.Context, organizationID string, firstName string, lastName string, email string, password string, policies ...password.Policy) (string, error) {
+	email = NormalizeEmail(email)
+	hashedPassword := ""
+	if password != "" {
+		err := s.CheckPassword(password, policies, firstName, lastName, email)
+		if err != nil {
+			return "", err
+		}
+		hashedPassword, err = s.options.Hasher.Hash(password)
+		if err != nil {
+			log.Println(err)
+			return "", PasswordHashFailed
+		}
+	}
+
+	taken, err := s.store.EmailExists(ctx, email)
+	if err != nil {
+		log.Println(err)
+		return "", UserCreationFailed
+	}
+	if taken {
+		return "", EmailTaken
+	}
+
+	var userID string
+	err = s.store.WithTx(ctx, func(ctx context.Context) error {
+		userID, err = s.store.InsertUser(ctx, firstName, lastName, email, hashedPassword, true)
+		if err != nil {
+			return err
+		}
+		return s.store.UpdateUserProvisioner(ctx, userID, organizationID)
+	})
+	if err != nil {
+		log.Println(err)
+		return "", UserCreationFailed
+	}
+	s.options.Auditor.Record(ctx, audit.Event{
+		OrganizationID: organizationID,
+		Action:         audit.UserProvisioned,
+		Target:         audit.UserTarget(userID),
+	})
+	return userID, nil
+}
+
+// DeleteUser removes the account and the memberships left until
+// RestoreUser brings them back, the account can't sign in or refresh its
+// tokens meanwhile. privacy.Service.RemoveUser removes the memberships
+// first. The privacy purge erases it once the retention window is over.
+func (s *Service) DeleteUser(ctx context.Context, id string) (string, error) {
+	err := s.store.DeleteUser(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", UnableToFindUser
+	}
+	if err != nil {
+		log.Println(err)
+		return "", UserDeleteFailed
+	}
+	s.options.Auditor.Record(ctx, audit.Event{
+		Action: audit.UserRemoved,
+		Target: audit.UserTarget(id),
+	})
+	return UserDeleted, nil
+}
+
+// RestoreUser brings back a deleted account with the memberships deleted
+// along with it
+func (s *Service) RestoreUser(ctx context.Context, id string) (string, error) {
+	err := s.store.RestoreUser(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", DeletedUserNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", UserRestoreFailed
+	}
+	s.options.Auditor.Record(ctx, audit.Event{
+		Action: audit.UserRestored,
+		Target: audit.UserTarget(id),
+	})
+	return UserRestored, nil
+}
+
+// rehash replaces a hash of an outdated algorithm or cost once the password
+// is known, a failure only delays it to the next login
+func (s *Service) rehash(ctx context.Context, userID string, password string) {
+	hashedPassword, err := s.options.Hasher.Hash(password)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = s.store.UpdateUserPassword(ctx, userID, hashedPassword)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Service) GenerateAccessToken(ctx context.Context, refreshToken string) (string, string, error) {
+
+	token, err := jwt.Parse(refreshToken, func(token *jwt.Token) (interface{}, error) {
+		// Provide the JWT secret key for token verification
+		return []byte(s.options.RefreshTokenSecret), nil
+	})
+
+	// a malformed token parses to nil
+	if err != nil || token == nil || !token.Valid {
 		return "", "", InvalidRefreshToken
 	}
 
-	if !token.Valid {
+	refreshTokenClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
 		return "", "", InvalidRefreshToken
 	}
 
@@ -98,14 +796,24 @@ func (s *Service) GenerateAccessToken(ctx context.Context, refreshToken string)
 		return "", "", InvalidRefreshToken
 	}
 
+	// a refresh token dies with the account and when its sessions are
+	// revoked, tokens issued before session versions carry none
+	user, err := s.store.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", InvalidRefreshToken
+	}
+	if err != nil {
+		log.Println(err)
+		return "", "", FetchUserFailed
+	}
+	version, _ := refreshTokenClaims["sv"].(float64)
+	if int(version) != user.SessionVersion {
+		return "", "", InvalidRefreshToken
+	}
+
 	// Generate a new access token
-	currentTime := time.Now()
-	accessTokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  userID,
-		"exp": currentTime.Add(time.Hour).Unix(),
-		"iat": currentTime.Unix(),
-	})
-	accessToken, err := accessTokenClaims.SignedString([]byte("accesstokensecret")) // Replace with your actual access token secret key
+	accessTokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, s.accessClaims(user, time.Now()))
+	accessToken, err := accessTokenClaims.SignedString([]byte(s.options.AccessTokenSecret))
 	if err != nil {
 		return "", "", TokenGenFailed
 	}
@@ -113,39 +821,110 @@ func (s *Service) GenerateAccessToken(ctx context.Context, refreshToken string)
 	return accessToken, refreshToken, nil
 }
 
-func (s *Service) Login(ctx context.Context, email string, password string) (string, string, error) {
-	user, err := s.store.GetUserByEmail(ctx, email)
+// GenerateOrganizationToken issues an access token of the user carrying the
+// claims of an organization, the caller checked the membership. It is not
+// refreshed, a new one is requested once it expires. The organization
+// becomes the last one of the user.
+func (s *Service) GenerateOrganizationToken(ctx context.Context, userID string, claims OrganizationClaims) (string, error) {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", UnableToFindUser
+	}
 	if err != nil {
 		log.Println(err)
-		return "", "", UnableToFindUser
+		return "", FetchUserFailed
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+
+	organizationClaims := s.accessClaims(user, time.Now())
+	organizationClaims["org"] = claims.OrganizationID
+	organizationClaims["org_role"] = claims.Role
+	organizationClaims["app_roles"] = claims.AppRoles
+	organizationClaims["teams"] = claims.Teams
+	accessTokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, organizationClaims)
+	accessToken, err := accessTokenClaims.SignedString([]byte(s.options.AccessTokenSecret))
 	if err != nil {
 		log.Println(err)
-		return "", "", InvalidPassword
+		return "", TokenGenFailed
 	}
 
-	currentTime := time.Now()
+	// the token is issued even when the preference can't be saved
+	err = s.store.SetLastOrganization(ctx, userID, claims.OrganizationID)
+	if err != nil {
+		log.Println(err)
+	}
+	return accessToken, nil
+}
 
-	accessTokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":    user.ID,
-		"email": user.Email,
-		"exp":   currentTime.Add(time.Hour).Unix(),
-		"iat":   currentTime.Unix(),
+// Login checks the credentials of a user signing in from ip. An unknown
+// email and a wrong password are reported the same way and take as long.
+func (s *Service) Login(ctx context.Context, email string, password string, ip string) (string, string, error) {
+	email = NormalizeEmail(email)
+	err := s.options.LoginGuard.Check(ctx, email, ip)
+	if err != nil {
+		s.recordLoginFailure(ctx, email, "", "throttled")
+		return "", "", err
+	}
+
+	user, err := s.store.GetUserByEmail(ctx, email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		return "", "", FetchUserFailed
+	}
+	encoded := user.Password
+	if err != nil || encoded == "" {
+		// provisioned accounts without a password take as long as well
+		encoded = s.dummyHash
+	}
+	match, rehash, verifyErr := s.options.Hasher.Verify(password, encoded)
+	if verifyErr != nil {
+		log.Println(verifyErr)
+	}
+	if err != nil || !match {
+		s.recordLoginFailure(ctx, email, user.ID, "invalid_credentials")
+		err = s.options.LoginGuard.Failed(ctx, email, ip)
+		if err != nil {
+			return "", "", err
+		}
+		return "", "", InvalidCredentials
+	}
+
+	err = s.options.LoginGuard.Succeeded(ctx, email, ip)
+	if err != nil {
+		return "", "", err
+	}
+	if rehash {
+		s.rehash(ctx, user.ID, password)
+	}
+	s.options.Auditor.Record(ctx, audit.Event{
+		ActorID: user.ID,
+		Action:  audit.UserLoggedIn,
+		Target:  audit.UserTarget(user.ID),
 	})
 
+	return s.issueTokens(user)
+}
+
+// issueTokens returns a new access token and refresh token of the user, the
+// refresh token carries their session version
+func (s *Service) issueTokens(user User) (string, string, error) {
+	currentTime := time.Now()
+
+	accessTokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, s.accessClaims(user, currentTime))
+
 	refreshTokenClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  user.ID,
-		"exp": time.Date(currentTime.Year(), currentTime.Month()+1, currentTime.Day(), 0, 0, 0, 0, currentTime.Location()).Unix(),
-		"iat": currentTime.Unix(),
+		"id":    user.ID,
+		"admin": user.IsAdmin,
+		"sv":    user.SessionVersion,
+		"exp":   currentTime.Add(s.options.RefreshTokenTTL).Unix(),
+		"iat":   currentTime.Unix(),
 	})
 
-	accessToken, err := accessTokenClaims.SignedString([]byte("accesstokensecret"))
+	accessToken, err := accessTokenClaims.SignedString([]byte(s.options.AccessTokenSecret))
 	if err != nil {
 		log.Println(err)
 		return "", "", TokenGenFailed
 	}
-	refreshToken, err := refreshTokenClaims.SignedString([]byte("refreshtokensecret"))
+	refreshToken, err := refreshTokenClaims.SignedString([]byte(s.options.RefreshTokenSecret))
 	if err != nil {
 		log.Println(err)
 		return "", "", TokenGenFailed
@@ -153,3 +932,28 @@ func (s *Service) Login(ctx context.Context, email string, password string) (str
 
 	return accessToken, refreshToken, nil
 }
+
+// accessClaims are the claims of every access token of the user, issued at
+// login, on refresh and for an organization
+func (s *Service) accessClaims(user User, now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"id":    user.ID,
+		"email": user.Email,
+		"admin": user.IsAdmin,
+		"exp":   now.Add(s.options.AccessTokenTTL).Unix(),
+		"iat":   now.Unix(),
+	}
+}
+
+// recordLoginFailure records a refused login, userID is empty for an
+// unknown email
+func (s *Service) recordLoginFailure(ctx context.Context, email string, userID string, reason string) {
+	event := audit.Event{
+		Action:   audit.UserLoginFailed,
+		Metadata: map[string]string{"email": email, "reason": reason},
+	}
+	if userID != "" {
+		event.Target = audit.UserTarget(userID)
+	}
+	s.options.Auditor.Record(ctx, event)
+}
@@ -0,0 +1,121 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// userStore only knows its users, the other methods are not called
+type userStore struct {
+	UserStore
+	users map[string]User
+}
+
+func (s userStore) GetUserByID(ctx context.Context, id string) (User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func claimsOf(t *testing.T, token string, secret string) jwt.MapClaims {
+	t.Helper()
+	parsed, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return parsed.Claims.(jwt.MapClaims)
+}
+
+func TestTokenClaims(t *testing.T) {
+	user := User{ID: "user-1", Email: "ada@example.com", IsAdmin: true, SessionVersion: 2}
+	service := New(userStore{users: map[string]User{user.ID: user}}, Options{
+		AccessTokenSecret:  "access",
+		RefreshTokenSecret: "refresh",
+		AccessTokenTTL:     time.Minute,
+		RefreshTokenTTL:    time.Hour,
+	})
+
+	access, refresh, err := service.issueTokens(user)
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+	refreshed, _, err := service.GenerateAccessToken(context.Background(), refresh)
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+
+	for name, token := range map[string]string{"login": access, "refresh": refreshed} {
+		claims := claimsOf(t, token, "access")
+		if claims["id"] != user.ID || claims["email"] != user.Email || claims["admin"] != true {
+			t.Errorf("%s token claims = %v, want the id, email and admin of the user", name, claims)
+		}
+		if _, ok := claims["exp"]; !ok {
+			t.Errorf("%s token has no expiry", name)
+		}
+	}
+}
+
+func TestRefreshRejected(t *testing.T) {
+	user := User{ID: "user-1", Email: "ada@example.com", SessionVersion: 1}
+	store := userStore{users: map[string]User{user.ID: user}}
+	service := New(store, Options{
+		AccessTokenSecret:  "access",
+		RefreshTokenSecret: "refresh",
+		AccessTokenTTL:     time.Minute,
+		RefreshTokenTTL:    time.Hour,
+	})
+
+	access, refresh, err := service.issueTokens(user)
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+	parts := strings.Split(refresh, ".")
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := strings.Replace(string(claims), `"sv":1`, `"sv":2`, 1)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + parts[2]
+	other, _, err := New(store, Options{AccessTokenSecret: "access", RefreshTokenSecret: "other", RefreshTokenTTL: time.Hour}).issueTokens(user)
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		// revoke bumps the session version before refreshing
+		revoke bool
+	}{
+		{"malformed", "x", false},
+		{"empty", "", false},
+		{"tampered", tampered, false},
+		{"other secret", other, false},
+		{"access token", access, false},
+		{"revoked session", refresh, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.revoke {
+				revoked := user
+				revoked.SessionVersion++
+				store.users[user.ID] = revoked
+				defer func() { store.users[user.ID] = user }()
+			}
+			if _, _, err := service.GenerateAccessToken(context.Background(), test.token); !errors.Is(err, InvalidRefreshToken) {
+				t.Errorf("GenerateAccessToken: got %v, want InvalidRefreshToken", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+// Package validate checks request structs against their `validate` tags.
+//
+// A tag is a comma separated list applied in order. Transforms rewrite the
+// field in place, rules check it:
+//
+//	Email string `json:"email" validate:"trim,lower,required,email,max=254"`
+//
+// Every rule but required accepts an empty value, so optional fields only
+// need the rules for their content. Only string fields are supported.
+package validate
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"microauth.io/core/internal/errs"
+)
+
+var (
+	Failed = errs.New(errs.Invalid, "validation_failed", "request validation failed")
+)
+
+// FieldError describes one invalid field, Code is the name of the failed
+// rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors lists every invalid field of a request, it unwraps to Failed
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, field := range e {
+		messages[i] = field.Field + " " + field.Message
+	}
+	return Failed.Message + ": " + strings.Join(messages, "; ")
+}
+
+func (e Errors) Unwrap() error {
+	return Failed
+}
+
+// Rule reports whether a non empty value is valid, param is the text after
+// the = of the tag
+type Rule struct {
+	Check func(value string, param string) bool
+	// Message is formatted with the param
+	Message string
+}
+
+type Transform func(string) string
+
+type Validator struct {
+	rules      map[string]Rule
+	transforms map[string]Transform
+}
+
+var (
+	hostnameLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	hexColor      = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	localeTag     = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$`)
+	alphanumeric  = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+	digits        = regexp.MustCompile(`^[0-9]{1,18}$`)
+)
+
+func New() *Validator {
+	v := &Validator{
+		rules:      make(map[string]Rule),
+		transforms: make(map[string]Transform),
+	}
+
+	v.RegisterTransform("trim", strings.TrimSpace)
+	v.RegisterTransform("lower", strings.ToLower)
+	v.RegisterTransform("upper", strings.ToUpper)
+
+	v.RegisterRule("min", Rule{
+		Check: func(value string, param string) bool {
+			return utf8.RuneCountInString(value) >= atoi(param)
+		},
+		Message: "must be at least %s characters",
+	})
+	v.RegisterRule("max", Rule{
+		Check: func(value string, param string) bool {
+			return utf8.RuneCountInString(value) <= atoi(param)
+		},
+		Message: "must be at most %s characters",
+	})
+	v.RegisterRule("len", Rule{
+		Check: func(value string, param string) bool {
+			return utf8.RuneCountInString(value) == atoi(param)
+		},
+		Message: "must be exactly %s characters",
+	})
+	v.RegisterRule("email", Rule{Check: Email, Message: "must be a valid email address"})
+	v.RegisterRule("hostname", Rule{Check: Hostname, Message: "must be a valid domain name"})
+	v.RegisterRule("uuid", Rule{Check: UUID, Message: "must be a valid uuid"})
+	v.RegisterRule("ip", Rule{
+		Check: func(value string, param string) bool {
+			return net.ParseIP(value) != nil
+		},
+		Message: "must be an ip address",
+	})
+	v.RegisterRule("url", Rule{
+		Check: func(value string, param string) bool {
+			u, err := url.Parse(value)
+			return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+		},
+		Message: "must be an absolute http(s) url",
+	})
+	v.RegisterRule("hexcolor", Rule{
+		Check: func(value string, param string) bool {
+			return hexColor.MatchString(value)
+		},
+		Message: "must be a hex color such as #1a2b3c",
+	})
+	v.RegisterRule("locale", Rule{
+		Check: func(value string, param string) bool {
+			return len(value) <= 35 && localeTag.MatchString(value)
+		},
+		Message: "must be a language tag such as en or fr-CA",
+	})
+	v.RegisterRule("alphanum", Rule{
+		Check: func(value string, param string) bool {
+			return alphanumeric.MatchString(value)
+		},
+		Message: "must only contain letters and digits",
+	})
+	v.RegisterRule("numeric", Rule{
+		Check: func(value string, param string) bool {
+			return digits.MatchString(value)
+		},
+		Message: "must be a whole number",
+	})
+
+	return v
+}
+
+func (v *Validator) RegisterRule(name string, rule Rule) {
+	v.rules[name] = rule
+}
+
+func (v *Validator) RegisterTransform(name string, transform Transform) {
+	v.transforms[name] = transform
+}
+
+// Validate applies the tags of the struct i points to and returns Errors
+// when a field is invalid. It satisfies echo.Validator.
+func (v *Validator) Validate(i interface{}) error {
+	value := reflect.ValueOf(i)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		panic("validate: Validate needs a pointer to a struct")
+	}
+	value = value.Elem()
+
+	var invalid Errors
+	for n := 0; n < value.NumField(); n++ {
+		field := value.Type().Field(n)
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok || field.Type.Kind() != reflect.String {
+			continue
+		}
+		if fieldErr, ok := v.field(value.Field(n), fieldName(field), tag); !ok {
+			invalid = append(invalid, fieldErr)
+		}
+	}
+
+	if len(invalid) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// Var checks a single value, such as a path parameter, against a tag
+func (v *Validator) Var(name string, value string, tag string) error {
+	if fieldErr, ok := v.field(reflect.ValueOf(&value).Elem(), name, tag); !ok {
+		return Errors{fieldErr}
+	}
+	return nil
+}
+
+func (v *Validator) field(value reflect.Value, name string, tag string) (FieldError, bool) {
+	for _, item := range strings.Split(tag, ",") {
+		ruleName, param, _ := strings.Cut(strings.TrimSpace(item), "=")
+
+		if transform, ok := v.transforms[ruleName]; ok {
+			value.SetString(transform(value.String()))
+			continue
+		}
+
+		current := value.String()
+		if ruleName == "required" {
+			if current == "" {
+				return FieldError{Field: name, Code: ruleName, Message: "is required"}, false
+			}
+			continue
+		}
+
+		rule, ok := v.rules[ruleName]
+		if !ok {
+			panic("validate: unknown rule " + ruleName)
+		}
+		if current != "" && !rule.Check(current, param) {
+			message := rule.Message
+			if strings.Contains(message, "%s") {
+				message = fmt.Sprintf(message, param)
+			}
+			return FieldError{Field: name, Code: ruleName, Message: message}, false
+		}
+	}
+	return FieldError{}, true
+}
+
+// fieldName is the json name of the field, it is what clients know
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func atoi(param string) int {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		panic("validate: invalid rule parameter " + param)
+	}
+	return n
+}
+
+// Email accepts a bare address, display names and comments are rejected
+func Email(value string, _ string) bool {
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Name != "" || address.Address != value {
+		return false
+	}
+	at := strings.LastIndex(value, "@")
+	return at > 0 && Hostname(value[at+1:], "")
+}
+
+// Hostname accepts fully qualified domain names such as example.com
+func Hostname(value string, _ string) bool {
+	if len(value) > 253 {
+		return false
+	}
+	labels := strings.Split(value, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if !hostnameLabel.MatchString(strings.ToLower(label)) {
+			return false
+		}
+	}
+	return true
+}
+
+func UUID(value string, _ string) bool {
+	_, err := uuid.Parse(value)
+	return err == nil && len(value) == 36
+}
+
+// Password is the rule of the password policy, the maximum is in bytes
+// because bcrypt ignores everything past 72 of them
+func Password(minLength int, maxLength int) Rule {
+	return Rule{
+		Check: func(value string, _ string) bool {
+			return utf8.RuneCountInString(value) >= minLength && len(value) <= maxLength
+		},
+		Message: fmt.Sprintf("must be at least %d characters and at most %d bytes", minLength, maxLength),
+	}
+}
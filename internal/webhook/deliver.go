@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"microauth.io/core/internal/worker"
+)
+
+const (
+	EventIDHeader    = "Microauth-Event-Id"
+	EventTypeHeader  = "Microauth-Event-Type"
+	DeliveryIDHeader = "Microauth-Delivery-Id"
+	TimestampHeader  = "Microauth-Timestamp"
+	SignatureHeader  = "Microauth-Signature"
+)
+
+// Sign is the signature header of a delivery: the hex HMAC-SHA256, keyed
+// with the endpoint secret, of the timestamp header, a dot and the body.
+// Receivers compute it the same way and reject old timestamps to stop
+// replays.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run polls the due deliveries and posts them with a pool of workers until
+// the context is cancelled. In flight deliveries finish before Run
+// returns.
+func (s *Service) Run(ctx context.Context) {
+	worker.Run(ctx, s.options.Options, s.store.ClaimWebhookDeliveries, s.deliver)
+}
+
+func (s *Service) deliver(delivery Delivery) {
+	// deliveries are not tied to the poll context so a shutdown does not
+	// abort a request half way through
+	ctx := context.Background()
+
+	endpoint, err := s.store.GetWebhookEndpoint(ctx, delivery.OrganizationID, delivery.EndpointID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !endpoint.Active {
+		s.update(ctx, delivery.ID, Dead, 0, "webhook is disabled", delivery.NextAttemptAt)
+		return
+	}
+
+	status, err := s.post(ctx, endpoint, delivery)
+	if err == nil {
+		s.update(ctx, delivery.ID, Delivered, status, "", delivery.NextAttemptAt)
+		return
+	}
+
+	log.Println("webhook delivery failed:", delivery.ID, err)
+
+	next := Pending
+	nextAttemptAt, dead := s.options.Retry(delivery.Attempts)
+	if dead {
+		next = Dead
+	}
+	s.update(ctx, delivery.ID, next, status, err.Error(), nextAttemptAt)
+}
+
+func (s *Service) update(ctx context.Context, id string, status Status, responseStatus int, lastError string, nextAttemptAt int) {
+	err := s.store.UpdateWebhookDeliveryStatus(ctx, id, status, responseStatus, lastError, nextAttemptAt)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// newClient posts the deliveries. Unless AllowInsecure is set it only
+// connects to public addresses: the check runs on the address actually
+// dialed, after the name is resolved, so a name resolving to a public
+// address when the endpoint is saved and to a private one when it is
+// called is refused too. Redirects are not followed, they fail like any
+// other status but 2xx.
+func newClient(options Options) *http.Client {
+	dialer := &net.Dialer{Timeout: options.Timeout}
+	if !options.AllowInsecure {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+				return fmt.Errorf("%s: %s", PrivateURL.Message, host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: options.Timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: options.Timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// blockedIP reports the addresses of the server's own networks, which an
+// organization must not reach through its webhooks
+func blockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// checkScheme requires https unless AllowInsecure is set
+func (s *Service) checkScheme(target *url.URL) error {
+	if target.Scheme == "https" || (target.Scheme == "http" && s.options.AllowInsecure) {
+		return nil
+	}
+	return InsecureURL
+}
+
+// post sends the delivery and returns the response status, any status but
+// 2xx fails
+func (s *Service) post(ctx context.Context, endpoint Endpoint, delivery Delivery) (int, error) {
+	target, err := url.Parse(endpoint.URL)
+	if err != nil {
+		return 0, err
+	}
+	// endpoints saved before https was required are not posted to
+	if err := s.checkScheme(target); err != nil {
+		return 0, err
+	}
+	payload := []byte(delivery.Payload)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "microauth-webhooks")
+	request.Header.Set(EventIDHeader, delivery.EventID)
+	request.Header.Set(EventTypeHeader, delivery.EventType)
+	request.Header.Set(DeliveryIDHeader, delivery.ID)
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, Sign(endpoint.Secret, timestamp, payload))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("endpoint answered %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
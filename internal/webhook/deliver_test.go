@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		payload   string
+		want      string
+	}{
+		{"delivery", "whsec_test", 1700000000, `{"type":"member.added"}`, "v1=6f5583300f535dd7a8b30367fe3f03b779442240b22a2ab5a963a42eade6786e"},
+		{"other secret", "whsec_other", 1700000000, `{"type":"member.added"}`, "v1=8cf82fcee716d54e525a372ea833f0b945eea8952900c39c8ad6b962b0a43b01"},
+		{"empty body", "whsec_test", 1700000000, ``, "v1=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Sign(test.secret, test.timestamp, []byte(test.payload)); got != test.want {
+				t.Errorf("Sign = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSignCoversTimestamp(t *testing.T) {
+	payload := []byte(`{"type":"member.added"}`)
+	if Sign("whsec_test", 1700000000, payload) == Sign("whsec_test", 1700000001, payload) {
+		t.Error("Sign does not change with the timestamp, a delivery could be replayed")
+	}
+}
+
+// recorder answers every request with status and keeps the last one
+type recorder struct {
+	status  int
+	request *http.Request
+	body    string
+}
+
+func (r *recorder) Do(request *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.request = request
+	r.body = string(body)
+	return &http.Response{StatusCode: r.status, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+}
+
+func TestPost(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		fails  bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"no content", http.StatusNoContent, false},
+		{"redirect", http.StatusFound, true},
+		{"server error", http.StatusInternalServerError, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &recorder{status: test.status}
+			service := New(nil, DefaultOptions())
+			service.client = client
+			endpoint := Endpoint{URL: "https://hooks.example.com/microauth", Secret: "whsec_test"}
+			delivery := Delivery{ID: "delivery-1", EventID: "event-1", EventType: "member.added", Payload: `{"type":"member.added"}`}
+
+			status, err := service.post(context.Background(), endpoint, delivery)
+			if status != test.status || (err != nil) != test.fails {
+				t.Fatalf("post = %d, %v, want %d failing %v", status, err, test.status, test.fails)
+			}
+
+			header := client.request.Header
+			if header.Get(EventIDHeader) != "event-1" || header.Get(EventTypeHeader) != "member.added" || header.Get(DeliveryIDHeader) != "delivery-1" {
+				t.Errorf("headers = %v, want the event and the delivery", header)
+			}
+			timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+			if err != nil {
+				t.Fatalf("%s: %v", TimestampHeader, err)
+			}
+			if header.Get(SignatureHeader) != Sign("whsec_test", timestamp, []byte(client.body)) {
+				t.Errorf("%s = %q does not sign the body with the timestamp", SignatureHeader, header.Get(SignatureHeader))
+			}
+		})
+	}
+}
+
+func TestBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"0.0.0.0", true},
+		{"::ffff:127.0.0.1", true},
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+	}
+	for _, test := range tests {
+		if got := blockedIP(net.ParseIP(test.ip)); got != test.blocked {
+			t.Errorf("blockedIP(%s) = %v, want %v", test.ip, got, test.blocked)
+		}
+	}
+}
+
+func TestValidURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		insecure bool
+		err      error
+	}{
+		{"https://93.184.216.34/hooks", false, nil},
+		{"http://93.184.216.34/hooks", false, InsecureURL},
+		{"ftp://93.184.216.34/hooks", false, InsecureURL},
+		{"https:///hooks", false, InsecureURL},
+		{"https://127.0.0.1/hooks", false, PrivateURL},
+		{"https://localhost/hooks", false, PrivateURL},
+		{"https://[::1]:8443/hooks", false, PrivateURL},
+		{"https://169.254.169.254/latest/meta-data", false, PrivateURL},
+		{"http://localhost:3000/hooks", true, nil},
+		{"ftp://localhost/hooks", true, InsecureURL},
+	}
+	for _, test := range tests {
+		options := DefaultOptions()
+		options.AllowInsecure = test.insecure
+		service := New(nil, options)
+		if err := service.validURL(context.Background(), test.url); !errors.Is(err, test.err) {
+			t.Errorf("validURL(%q) with insecure %v: got %v, want %v", test.url, test.insecure, err, test.err)
+		}
+	}
+}
+
+func TestPostPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	tls := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tls.Close()
+	delivery := Delivery{ID: "delivery-1", Payload: `{}`}
+
+	service := New(nil, DefaultOptions())
+	if _, err := service.post(context.Background(), Endpoint{URL: server.URL}, delivery); !errors.Is(err, InsecureURL) {
+		t.Errorf("post over http: got %v, want InsecureURL", err)
+	}
+	if _, err := service.post(context.Background(), Endpoint{URL: tls.URL}, delivery); err == nil || !strings.Contains(err.Error(), PrivateURL.Message) {
+		t.Errorf("post to a loopback address: got %v, want it refused", err)
+	}
+
+	options := DefaultOptions()
+	options.AllowInsecure = true
+	service = New(nil, options)
+	if status, err := service.post(context.Background(), Endpoint{URL: server.URL}, delivery); err != nil || status != http.StatusOK {
+		t.Errorf("post with AllowInsecure = %d, %v, want 200", status, err)
+	}
+}
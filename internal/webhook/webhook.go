@@ -0,0 +1,468 @@
+// Package webhook tells the services of an organization about identity
+// changes. An organization registers endpoints subscribed to event types,
+// every published event is queued as one delivery per subscribed endpoint
+// and posted by the workers of Run, signed with the secret of the endpoint.
+// The deliveries are kept as the delivery log.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"microauth.io/core/internal/errs"
+	"microauth.io/core/internal/worker"
+)
+
+const (
+	UserCreated         = "user.created"
+	MemberAdded         = "member.added"
+	MemberUpdated       = "member.updated"
+	MemberRemoved       = "member.removed"
+	InviteSent          = "invite.sent"
+	InviteAccepted      = "invite.accepted"
+	OrganizationUpdated = "organization.updated"
+
+	// DefaultLimit and MaxLimit bound a page of the delivery log
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// EventTypes are the types an endpoint subscribes to
+var EventTypes = []string{UserCreated, MemberAdded, MemberUpdated, MemberRemoved, InviteSent, InviteAccepted, OrganizationUpdated}
+
+type Status string
+
+const (
+	Pending   Status = "pending"
+	Sending   Status = "sending"
+	Delivered Status = "delivered"
+	Dead      Status = "dead"
+)
+
+var (
+	EndpointNotFound      = errs.New(errs.NotFound, "webhook_not_found", "webhook not found")
+	FetchEndpointsFailed  = errs.New(errs.Internal, "fetch_webhooks_failed", "unable to fetch webhooks")
+	EndpointCreateFailed  = errs.New(errs.Internal, "webhook_creation_failed", "unable to create webhook")
+	EndpointUpdateFailed  = errs.New(errs.Internal, "webhook_update_failed", "unable to update webhook")
+	EndpointDeleteFailed  = errs.New(errs.Internal, "webhook_delete_failed", "unable to delete webhook")
+	UnknownEventType      = errs.New(errs.Invalid, "unknown_event_type", "unknown webhook event type")
+	EventsRequired        = errs.New(errs.Invalid, "events_required", "subscribe to at least one event type")
+	DeliveryNotFound      = errs.New(errs.NotFound, "webhook_delivery_not_found", "webhook delivery not found")
+	FetchDeliveriesFailed = errs.New(errs.Internal, "fetch_webhook_deliveries_failed", "unable to fetch webhook deliveries")
+	RedeliverFailed       = errs.New(errs.Internal, "redeliver_failed", "unable to redeliver webhook")
+	InvalidStatus         = errs.New(errs.Invalid, "invalid_delivery_status", "unknown delivery status")
+	InvalidLimit          = errs.New(errs.Invalid, "invalid_limit", "limit must be between 1 and 200")
+	DeliveryInProgress    = errs.New(errs.Conflict, "delivery_in_progress", "the delivery is not finished yet")
+	EndpointDisabled      = errs.New(errs.Unprocessable, "webhook_disabled", "the webhook is disabled")
+	InsecureURL           = errs.New(errs.Invalid, "insecure_webhook_url", "webhook url must use https")
+	PrivateURL            = errs.New(errs.Invalid, "private_webhook_url", "webhook url must not point to a private, loopback or link-local address")
+	EndpointDeleted       = "webhook deleted"
+)
+
+// Endpoint receives the events of its organization it subscribed to.
+// Secret signs the deliveries, it is only shown when the endpoint is
+// created.
+type Endpoint struct {
+	ID             string
+	OrganizationID string
+	URL            string
+	Secret         string
+	Events         []string
+	Active         bool
+	CreatedAt      int
+	UpdatedAt      int
+}
+
+// Subscribed reports whether the endpoint wants events of the type
+func (e Endpoint) Subscribed(eventType string) bool {
+	for _, subscribed := range e.Events {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one event sent to one endpoint. Payload is the posted body,
+// a redelivery is a new delivery of the same event.
+type Delivery struct {
+	ID             string
+	EndpointID     string
+	OrganizationID string
+	EventID        string
+	EventType      string
+	Payload        string
+	Status         Status
+	Attempts       int
+	ResponseStatus int
+	LastError      string
+	NextAttemptAt  int
+	CreatedAt      int
+	UpdatedAt      int
+}
+
+// Event is the payload of a delivery
+type Event struct {
+	ID             string      `json:"id"`
+	Type           string      `json:"type"`
+	OrganizationID string      `json:"organization_id"`
+	CreatedAt      int         `json:"created_at"`
+	Data           interface{} `json:"data"`
+}
+
+// UserData is the data of user.created
+type UserData struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// MemberData is the data of the member events, the previous roles are only
+// set on member.updated
+type MemberData struct {
+	UserID          string `json:"user_id"`
+	Role            string `json:"role,omitempty"`
+	AppRole         string `json:"app_role,omitempty"`
+	PreviousRole    string `json:"previous_role,omitempty"`
+	PreviousAppRole string `json:"previous_app_role,omitempty"`
+}
+
+// InviteData is the data of the invite events
+type InviteData struct {
+	Email     string `json:"email"`
+	ExpiresAt int    `json:"expires_at,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	NewUser   bool   `json:"new_user,omitempty"`
+}
+
+// OrganizationData is the data of organization.updated
+type OrganizationData struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Domain string `json:"domain"`
+}
+
+type Store interface {
+	InsertWebhookEndpoint(context.Context, Endpoint) (string, error)
+	GetWebhookEndpoint(context.Context, string, string) (Endpoint, error)
+	FetchWebhookEndpoints(context.Context, string) ([]Endpoint, error)
+	UpdateWebhookEndpoint(context.Context, Endpoint) error
+	DeleteWebhookEndpoint(context.Context, string, string) error
+	InsertWebhookDelivery(context.Context, Delivery) (string, error)
+	GetWebhookDelivery(context.Context, string, string) (Delivery, error)
+	// FetchWebhookDeliveries returns up to limit deliveries of the
+	// endpoint, newest first, of any status when the status is empty
+	FetchWebhookDeliveries(context.Context, string, Status, int) ([]Delivery, error)
+	// ClaimWebhookDeliveries marks due deliveries as sending until the
+	// lease expires and increments their attempts
+	ClaimWebhookDeliveries(context.Context, int, int) ([]Delivery, error)
+	UpdateWebhookDeliveryStatus(context.Context, string, Status, int, string, int) error
+}
+
+// Doer posts the deliveries, *http.Client satisfies it
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+type Options struct {
+	worker.Options
+	// Timeout bounds one delivery attempt
+	Timeout time.Duration
+	// AllowInsecure lets endpoints use http and reach private, loopback
+	// and link-local addresses, for local development only
+	AllowInsecure bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Options: worker.Options{
+			Workers:      4,
+			BatchSize:    16,
+			MaxAttempts:  10,
+			BaseBackoff:  30 * time.Second,
+			MaxBackoff:   6 * time.Hour,
+			PollInterval: 5 * time.Second,
+			Lease:        5 * time.Minute,
+		},
+		Timeout: 10 * time.Second,
+	}
+}
+
+type Service struct {
+	store   Store
+	client  Doer
+	options Options
+}
+
+func New(store Store, options Options) *Service {
+	return &Service{
+		store:   store,
+		client:  newClient(options),
+		options: options,
+	}
+}
+
+func validEvents(events []string) error {
+	if len(events) == 0 {
+		return EventsRequired
+	}
+	known := Endpoint{Events: EventTypes}
+	for _, event := range events {
+		if !known.Subscribed(event) {
+			return UnknownEventType
+		}
+	}
+	return nil
+}
+
+// validURL refuses the urls deliveries can't be posted to, newClient still
+// checks the address of every delivery
+func (s *Service) validURL(ctx context.Context, raw string) error {
+	target, err := url.Parse(raw)
+	if err != nil || target.Hostname() == "" {
+		return InsecureURL
+	}
+	err = s.checkScheme(target)
+	if err != nil || s.options.AllowInsecure {
+		return err
+	}
+	// a name that does not resolve yet is checked when it is dialed
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, target.Hostname())
+	if err != nil {
+		return nil
+	}
+	for _, address := range addresses {
+		if blockedIP(address.IP) {
+			return PrivateURL
+		}
+	}
+	return nil
+}
+
+func newSecret() (string, error) {
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func (s *Service) CreateEndpoint(ctx context.Context, organizationID string, url string, events []string, active bool) (Endpoint, error) {
+	err := validEvents(events)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	err = s.validURL(ctx, url)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	secret, err := newSecret()
+	if err != nil {
+		log.Println(err)
+		return Endpoint{}, EndpointCreateFailed
+	}
+
+	now := int(time.Now().Unix())
+	endpoint := Endpoint{
+		ID:             uuid.New().String(),
+		OrganizationID: organizationID,
+		URL:            url,
+		Secret:         secret,
+		Events:         events,
+		Active:         active,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	_, err = s.store.InsertWebhookEndpoint(ctx, endpoint)
+	if err != nil {
+		log.Println(err)
+		return Endpoint{}, EndpointCreateFailed
+	}
+	return endpoint, nil
+}
+
+func (s *Service) GetEndpoint(ctx context.Context, organizationID string, id string) (Endpoint, error) {
+	endpoint, err := s.store.GetWebhookEndpoint(ctx, organizationID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Endpoint{}, EndpointNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Endpoint{}, FetchEndpointsFailed
+	}
+	return endpoint, nil
+}
+
+func (s *Service) FetchEndpoints(ctx context.Context, organizationID string) ([]Endpoint, error) {
+	endpoints, err := s.store.FetchWebhookEndpoints(ctx, organizationID)
+	if err != nil {
+		log.Println(err)
+		return []Endpoint{}, FetchEndpointsFailed
+	}
+	return endpoints, nil
+}
+
+func (s *Service) UpdateEndpoint(ctx context.Context, organizationID string, id string, url string, events []string, active bool) (Endpoint, error) {
+	err := validEvents(events)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	err = s.validURL(ctx, url)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	endpoint, err := s.GetEndpoint(ctx, organizationID, id)
+	if err != nil {
+		return Endpoint{}, err
+	}
+
+	endpoint.URL = url
+	endpoint.Events = events
+	endpoint.Active = active
+	endpoint.UpdatedAt = int(time.Now().Unix())
+	err = s.store.UpdateWebhookEndpoint(ctx, endpoint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Endpoint{}, EndpointNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Endpoint{}, EndpointUpdateFailed
+	}
+	return endpoint, nil
+}
+
+// DeleteEndpoint deletes the endpoint with its delivery log
+func (s *Service) DeleteEndpoint(ctx context.Context, organizationID string, id string) (string, error) {
+	err := s.store.DeleteWebhookEndpoint(ctx, organizationID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", EndpointNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return "", EndpointDeleteFailed
+	}
+	return EndpointDeleted, nil
+}
+
+// Publish queues the event for every active endpoint of the organization
+// subscribed to its type. Called with a transactional context the
+// deliveries are only sent if the transaction commits. Failures are
+// logged, they never fail the change being published.
+func (s *Service) Publish(ctx context.Context, organizationID string, eventType string, data interface{}) {
+	endpoints, err := s.store.FetchWebhookEndpoints(ctx, organizationID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	now := int(time.Now().Unix())
+	event := Event{
+		ID:             uuid.New().String(),
+		Type:           eventType,
+		OrganizationID: organizationID,
+		CreatedAt:      now,
+		Data:           data,
+	}
+	var payload []byte
+	for _, endpoint := range endpoints {
+		if !endpoint.Active || !endpoint.Subscribed(eventType) {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(event)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		}
+		_, err = s.store.InsertWebhookDelivery(ctx, newDelivery(endpoint, event.ID, eventType, string(payload)))
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func newDelivery(endpoint Endpoint, eventID string, eventType string, payload string) Delivery {
+	now := int(time.Now().Unix())
+	return Delivery{
+		ID:             uuid.New().String(),
+		EndpointID:     endpoint.ID,
+		OrganizationID: endpoint.OrganizationID,
+		EventID:        eventID,
+		EventType:      eventType,
+		Payload:        payload,
+		Status:         Pending,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+}
+
+// FetchDeliveries returns the delivery log of the endpoint, newest first
+func (s *Service) FetchDeliveries(ctx context.Context, organizationID string, endpointID string, status Status, limit int) ([]Delivery, error) {
+	switch status {
+	case "", Pending, Sending, Delivered, Dead:
+	default:
+		return []Delivery{}, InvalidStatus
+	}
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	if limit < 1 || limit > MaxLimit {
+		return []Delivery{}, InvalidLimit
+	}
+	_, err := s.GetEndpoint(ctx, organizationID, endpointID)
+	if err != nil {
+		return []Delivery{}, err
+	}
+
+	deliveries, err := s.store.FetchWebhookDeliveries(ctx, endpointID, status, limit)
+	if err != nil {
+		log.Println(err)
+		return []Delivery{}, FetchDeliveriesFailed
+	}
+	return deliveries, nil
+}
+
+// Redeliver queues the event of a delivery again as a new delivery, the
+// original stays in the log
+func (s *Service) Redeliver(ctx context.Context, organizationID string, endpointID string, deliveryID string) (Delivery, error) {
+	endpoint, err := s.GetEndpoint(ctx, organizationID, endpointID)
+	if err != nil {
+		return Delivery{}, err
+	}
+	if !endpoint.Active {
+		return Delivery{}, EndpointDisabled
+	}
+	delivery, err := s.store.GetWebhookDelivery(ctx, endpointID, deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Delivery{}, DeliveryNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return Delivery{}, FetchDeliveriesFailed
+	}
+	if delivery.Status == Pending || delivery.Status == Sending {
+		return Delivery{}, DeliveryInProgress
+	}
+
+	redelivery := newDelivery(endpoint, delivery.EventID, delivery.EventType, delivery.Payload)
+	_, err = s.store.InsertWebhookDelivery(ctx, redelivery)
+	if err != nil {
+		log.Println(err)
+		return Delivery{}, RedeliverFailed
+	}
+	return redelivery, nil
+}
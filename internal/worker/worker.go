@@ -0,0 +1,105 @@
+// Package worker runs the background deliveries of the outbox and the
+// webhooks. A poller claims the due jobs for a lease and hands them to a
+// pool of workers, a job that fails is tried again after a growing delay
+// until it runs out of attempts.
+package worker
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Options struct {
+	Workers     int
+	BatchSize   int
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// PollInterval is the interval between polls for due jobs
+	PollInterval time.Duration
+	// Lease is how long a claimed job is kept from the other polls, a job
+	// still claimed once it is over is picked up again
+	Lease time.Duration
+}
+
+// Run polls claim for due jobs and handles them with a pool of workers
+// until the context is cancelled. claim takes the number of jobs to claim
+// and the end of their lease as a unix time. In flight jobs finish before
+// Run returns.
+func Run[T any](ctx context.Context, options Options, claim func(context.Context, int, int) ([]T, error), handle func(T)) {
+	jobs := make(chan T)
+
+	var wg sync.WaitGroup
+	for i := 0; i < options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				handle(job)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		dispatch(ctx, options, claim, jobs)
+
+		select {
+		case <-ctx.Done():
+			close(jobs)
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch claims batches of jobs until one comes back short
+func dispatch[T any](ctx context.Context, options Options, claim func(context.Context, int, int) ([]T, error), jobs chan<- T) {
+	for {
+		leaseUntil := int(time.Now().Add(options.Lease).Unix())
+		claimed, err := claim(ctx, options.BatchSize, leaseUntil)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		for _, job := range claimed {
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				// the lease expires and another poll picks the rest up
+				return
+			}
+		}
+
+		if len(claimed) < options.BatchSize {
+			return
+		}
+	}
+}
+
+// Retry returns when a job whose attempts failed is tried again as a unix
+// time, and whether it is given up instead
+func (o Options) Retry(attempts int) (int, bool) {
+	return int(time.Now().Add(o.backoff(attempts)).Unix()), attempts >= o.MaxAttempts
+}
+
+// backoff doubles the delay for every attempt, capped at MaxBackoff, and
+// spreads retries over the upper half of the window
+func (o Options) backoff(attempts int) time.Duration {
+	delay := o.BaseBackoff
+	for i := 1; i < attempts && delay < o.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > o.MaxBackoff {
+		delay = o.MaxBackoff
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	options := Options{BaseBackoff: 30 * time.Second, MaxBackoff: 10 * time.Minute}
+	tests := []struct {
+		attempts int
+		// window is the delay before spreading it over its upper half
+		window time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{6, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			if delay := options.backoff(test.attempts); delay < test.window/2 || delay > test.window {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", test.attempts, delay, test.window/2, test.window)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	options := Options{MaxAttempts: 3, BaseBackoff: time.Minute, MaxBackoff: time.Hour}
+	for attempts := 1; attempts <= 4; attempts++ {
+		at, dead := options.Retry(attempts)
+		if dead != (attempts >= 3) {
+			t.Errorf("Retry(%d) gives up %v, want %v", attempts, dead, attempts >= 3)
+		}
+		if now := int(time.Now().Unix()); at < now+30 {
+			t.Errorf("Retry(%d) at %d, want after %d", attempts, at, now+30)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	// 5 due jobs are claimed in batches of 2 within one poll
+	var mu sync.Mutex
+	due := []int{1, 2, 3, 4, 5}
+	batches := 0
+	claim := func(ctx context.Context, limit int, leaseUntil int) ([]int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if leaseUntil <= int(time.Now().Unix()) {
+			t.Errorf("lease until %d is over", leaseUntil)
+		}
+		batches++
+		n := limit
+		if n > len(due) {
+			n = len(due)
+		}
+		claimed := due[:n]
+		due = due[n:]
+		return claimed, nil
+	}
+	handled := []int{}
+	done := make(chan struct{}, 5)
+	handle := func(job int) {
+		mu.Lock()
+		handled = append(handled, job)
+		mu.Unlock()
+		done <- struct{}{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		Run(ctx, Options{Workers: 3, BatchSize: 2, PollInterval: time.Hour, Lease: time.Minute}, claim, handle)
+		close(stopped)
+	}()
+	for i := 0; i < 5; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handled %v, want the 5 jobs", handled)
+		}
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return once cancelled")
+	}
+
+	sort.Ints(handled)
+	if len(handled) != 5 || handled[0] != 1 || handled[4] != 5 {
+		t.Errorf("handled %v, want 1 to 5", handled)
+	}
+	if batches != 3 {
+		t.Errorf("claimed %d batches, want 3 until one comes back short", batches)
+	}
+}
@@ -0,0 +1,26 @@
+// Package migrations embeds the sql migrations so the server binary can
+// apply them without the golang-migrate cli. Every backend has its own
+// directory, the versions of both directories match.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+)
+
+var UnsupportedDriver = errors.New("no migrations for database driver")
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// Source returns the migrations of a sql driver
+func Source(driver string) (fs.FS, error) {
+	switch driver {
+	case "postgres":
+		return fs.Sub(files, "postgres")
+	case "sqlite3":
+		return fs.Sub(files, "sqlite")
+	}
+	return nil, UnsupportedDriver
+}
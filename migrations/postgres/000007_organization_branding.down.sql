@@ -0,0 +1,3 @@
+ALTER TABLE organizations DROP COLUMN sender_name;
+ALTER TABLE organizations DROP COLUMN primary_color;
+ALTER TABLE organizations DROP COLUMN logo_url;
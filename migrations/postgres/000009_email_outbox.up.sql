@@ -0,0 +1,15 @@
+CREATE TABLE email_outbox (
+    id              VARCHAR(36) PRIMARY KEY,
+    sender          VARCHAR(255) NOT NULL,
+    recipients      TEXT NOT NULL,
+    subject         TEXT NOT NULL,
+    message         TEXT NOT NULL,
+    status          VARCHAR(32) NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    last_error      TEXT NOT NULL DEFAULT '',
+    next_attempt_at INTEGER NOT NULL,
+    created_at      INTEGER NOT NULL,
+    updated_at      INTEGER NOT NULL
+);
+
+CREATE INDEX email_outbox_due ON email_outbox (status, next_attempt_at);
@@ -0,0 +1 @@
+ALTER TABLE organizations ADD COLUMN password_policy TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+CREATE TABLE rate_limit_buckets (
+    bucket_key VARCHAR(400) PRIMARY KEY,
+    full_at    BIGINT NOT NULL
+);
+
+CREATE INDEX rate_limit_buckets_full_at ON rate_limit_buckets (full_at);
@@ -0,0 +1,14 @@
+CREATE TABLE audit_events (
+    id               BIGSERIAL PRIMARY KEY,
+    actor_id         VARCHAR(36) NOT NULL,
+    organization_id  VARCHAR(36) NOT NULL,
+    action           VARCHAR(100) NOT NULL,
+    target           VARCHAR(400) NOT NULL,
+    ip               VARCHAR(45) NOT NULL,
+    user_agent       VARCHAR(512) NOT NULL,
+    metadata         TEXT NOT NULL,
+    created_at       INTEGER NOT NULL
+);
+
+CREATE INDEX audit_events_organization ON audit_events (organization_id, id);
+CREATE INDEX audit_events_actor ON audit_events (actor_id, id);
@@ -0,0 +1,21 @@
+ALTER TABLE audit_events ADD COLUMN sequence BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE audit_events ADD COLUMN previous_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE audit_events ADD COLUMN hash VARCHAR(64) NOT NULL DEFAULT '';
+
+-- events recorded before chaining keep sequence 0
+CREATE UNIQUE INDEX audit_events_chain ON audit_events (organization_id, sequence) WHERE sequence > 0;
+
+CREATE TABLE audit_chains (
+    organization_id  VARCHAR(36) PRIMARY KEY,
+    sequence         BIGINT NOT NULL,
+    hash             VARCHAR(64) NOT NULL
+);
+
+CREATE TABLE audit_checkpoints (
+    organization_id  VARCHAR(36) NOT NULL,
+    sequence         BIGINT NOT NULL,
+    hash             VARCHAR(64) NOT NULL,
+    signature        VARCHAR(100) NOT NULL,
+    created_at       INTEGER NOT NULL,
+    PRIMARY KEY (organization_id, sequence)
+);
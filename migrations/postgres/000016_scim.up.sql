@@ -0,0 +1,35 @@
+CREATE TABLE scim_tokens (
+    id               VARCHAR(36) PRIMARY KEY,
+    organization_id  VARCHAR(36) NOT NULL,
+    name             VARCHAR(255) NOT NULL,
+    token_hash       VARCHAR(64) NOT NULL UNIQUE,
+    created_at       INTEGER NOT NULL,
+    last_used_at     INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (organization_id) REFERENCES organizations (id) ON DELETE CASCADE
+);
+
+CREATE INDEX scim_tokens_organization ON scim_tokens (organization_id);
+
+CREATE TABLE scim_identities (
+    organization_id  VARCHAR(36) NOT NULL,
+    user_id          VARCHAR(36) NOT NULL,
+    external_id      VARCHAR(255) NOT NULL DEFAULT '',
+    created_at       INTEGER NOT NULL,
+    updated_at       INTEGER NOT NULL,
+    PRIMARY KEY (organization_id, user_id),
+    FOREIGN KEY (organization_id) REFERENCES organizations (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE scim_groups (
+    id               VARCHAR(36) PRIMARY KEY,
+    organization_id  VARCHAR(36) NOT NULL,
+    display_name     VARCHAR(255) NOT NULL,
+    external_id      VARCHAR(255) NOT NULL DEFAULT '',
+    kind             VARCHAR(32) NOT NULL,
+    value            VARCHAR(255) NOT NULL,
+    created_at       INTEGER NOT NULL,
+    updated_at       INTEGER NOT NULL,
+    UNIQUE (organization_id, display_name),
+    FOREIGN KEY (organization_id) REFERENCES organizations (id) ON DELETE CASCADE
+);
@@ -0,0 +1,13 @@
+-- a redacted event keeps the hash it was chained with, its fields no longer
+-- match it
+ALTER TABLE audit_events ADD COLUMN redacted BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE account_deletions (
+    user_id       VARCHAR(36) PRIMARY KEY,
+    requested_by  VARCHAR(36) NOT NULL,
+    requested_at  INTEGER NOT NULL,
+    delete_after  INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX account_deletions_due ON account_deletions (delete_after);
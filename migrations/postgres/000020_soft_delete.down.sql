@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS members_deleted;
+DROP INDEX IF EXISTS organizations_deleted;
+DROP INDEX IF EXISTS users_deleted;
+
+ALTER TABLE members DROP COLUMN deleted_at;
+ALTER TABLE organizations DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN deleted_at;
@@ -0,0 +1,26 @@
+-- the owner of an existing organization is its admin with the oldest
+-- account, or its oldest member when it has no admin. The members of a
+-- deleted organization were deleted with it.
+UPDATE members SET role = 'owner'
+WHERE id IN (
+    SELECT (
+        SELECT m.id FROM members m
+        JOIN users u ON u.id = m.user_id
+        WHERE m.organization_id = o.id AND m.deleted_at = o.deleted_at
+        ORDER BY m.role = 'admin' DESC, u.created_at, m.id
+        LIMIT 1
+    )
+    FROM organizations o
+);
+
+-- an organization has at most one pending transfer, it goes with the
+-- membership of either side
+CREATE TABLE ownership_transfers (
+    organization_id  VARCHAR(36) PRIMARY KEY,
+    from_user_id     VARCHAR(36) NOT NULL,
+    to_user_id       VARCHAR(36) NOT NULL,
+    created_at       INTEGER NOT NULL,
+    expires_at       INTEGER NOT NULL,
+    FOREIGN KEY (organization_id, from_user_id) REFERENCES members (organization_id, user_id) ON DELETE CASCADE,
+    FOREIGN KEY (organization_id, to_user_id) REFERENCES members (organization_id, user_id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- the organization whose identity provider created the account, it alone
+-- changes the name of the account through scim. The accounts provisioned
+-- before are left to no organization.
+ALTER TABLE users ADD COLUMN provisioned_by VARCHAR(36) NOT NULL DEFAULT '';
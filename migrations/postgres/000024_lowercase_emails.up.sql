@@ -0,0 +1,6 @@
+-- emails are compared in lower case. Accounts created before under a mixed
+-- case address are lowercased, the update fails on two accounts that only
+-- differ by case and they have to be merged by hand before migrating.
+UPDATE users SET email = LOWER(email);
+UPDATE member_invite SET email = LOWER(email);
+CREATE UNIQUE INDEX users_email_lower ON users (LOWER(email));
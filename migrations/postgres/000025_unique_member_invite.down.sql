@@ -0,0 +1 @@
+DROP INDEX IF EXISTS member_invite_email_organization;
@@ -0,0 +1,9 @@
+-- an email has one pending invite per organization, inviting it again
+-- replaces it. Only the newest of the invites sent before is kept.
+DELETE FROM member_invite WHERE EXISTS (
+    SELECT 1 FROM member_invite newer
+    WHERE newer.email = member_invite.email
+    AND newer.organization_id = member_invite.organization_id
+    AND (newer.created_at > member_invite.created_at OR (newer.created_at = member_invite.created_at AND newer.id > member_invite.id))
+);
+CREATE UNIQUE INDEX member_invite_email_organization ON member_invite (email, organization_id);
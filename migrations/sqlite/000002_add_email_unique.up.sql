@@ -0,0 +1 @@
+CREATE UNIQUE INDEX unique_email ON users (email);
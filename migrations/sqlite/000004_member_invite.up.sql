@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS member_invite (
+    id         VARCHAR(255) PRIMARY KEY,
+    email      VARCHAR(255) NOT NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
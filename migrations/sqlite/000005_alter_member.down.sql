@@ -0,0 +1 @@
+ALTER TABLE member_invite DROP COLUMN code;
@@ -0,0 +1,12 @@
+CREATE TABLE member_invite_old (
+    id         VARCHAR(255) PRIMARY KEY,
+    email      VARCHAR(255) NOT NULL,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    code       VARCHAR(255) NOT NULL
+);
+INSERT INTO member_invite_old (id, email, created_at, updated_at, expires_at, code)
+SELECT id, email, created_at, updated_at, expires_at, code FROM member_invite;
+DROP TABLE member_invite;
+ALTER TABLE member_invite_old RENAME TO member_invite;
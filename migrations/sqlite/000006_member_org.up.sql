@@ -0,0 +1,13 @@
+-- sqlite can't add a constraint to an existing table, the table is rebuilt
+CREATE TABLE member_invite_new (
+    id              VARCHAR(255) PRIMARY KEY,
+    email           VARCHAR(255) NOT NULL,
+    created_at      INTEGER NOT NULL,
+    updated_at      INTEGER NOT NULL,
+    expires_at      INTEGER NOT NULL,
+    code            VARCHAR(255) NOT NULL,
+    organization_id VARCHAR(255) NOT NULL,
+    CONSTRAINT fk_organization_id FOREIGN KEY (organization_id) REFERENCES organizations (id)
+);
+DROP TABLE member_invite;
+ALTER TABLE member_invite_new RENAME TO member_invite;
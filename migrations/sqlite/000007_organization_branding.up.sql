@@ -0,0 +1,3 @@
+ALTER TABLE organizations ADD COLUMN logo_url VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE organizations ADD COLUMN primary_color VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE organizations ADD COLUMN sender_name VARCHAR(255) NOT NULL DEFAULT '';
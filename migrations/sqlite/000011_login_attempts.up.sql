@@ -0,0 +1,7 @@
+CREATE TABLE login_attempts (
+    subject      VARCHAR(320) PRIMARY KEY,
+    failures     INTEGER NOT NULL,
+    last_failure INTEGER NOT NULL
+);
+
+CREATE INDEX login_attempts_last_failure ON login_attempts (last_failure);
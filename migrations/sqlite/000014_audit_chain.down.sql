@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS audit_checkpoints;
+DROP TABLE IF EXISTS audit_chains;
+DROP INDEX IF EXISTS audit_events_chain;
+ALTER TABLE audit_events DROP COLUMN hash;
+ALTER TABLE audit_events DROP COLUMN previous_hash;
+ALTER TABLE audit_events DROP COLUMN sequence;
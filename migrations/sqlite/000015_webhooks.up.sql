@@ -0,0 +1,33 @@
+CREATE TABLE webhook_endpoints (
+    id               VARCHAR(36) PRIMARY KEY,
+    organization_id  VARCHAR(36) NOT NULL,
+    url              TEXT NOT NULL,
+    secret           VARCHAR(100) NOT NULL,
+    events           TEXT NOT NULL,
+    active           BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at       INTEGER NOT NULL,
+    updated_at       INTEGER NOT NULL,
+    FOREIGN KEY (organization_id) REFERENCES organizations (id) ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_endpoints_organization ON webhook_endpoints (organization_id);
+
+CREATE TABLE webhook_deliveries (
+    id               VARCHAR(36) PRIMARY KEY,
+    endpoint_id      VARCHAR(36) NOT NULL,
+    organization_id  VARCHAR(36) NOT NULL,
+    event_id         VARCHAR(36) NOT NULL,
+    event_type       VARCHAR(100) NOT NULL,
+    payload          TEXT NOT NULL,
+    status           VARCHAR(32) NOT NULL,
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    response_status  INTEGER NOT NULL DEFAULT 0,
+    last_error       TEXT NOT NULL DEFAULT '',
+    next_attempt_at  INTEGER NOT NULL,
+    created_at       INTEGER NOT NULL,
+    updated_at       INTEGER NOT NULL,
+    FOREIGN KEY (endpoint_id) REFERENCES webhook_endpoints (id) ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+CREATE INDEX webhook_deliveries_endpoint ON webhook_deliveries (endpoint_id, created_at);
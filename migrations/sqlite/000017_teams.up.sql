@@ -0,0 +1,28 @@
+CREATE TABLE teams (
+    id               VARCHAR(36) PRIMARY KEY,
+    organization_id  VARCHAR(36) NOT NULL,
+    parent_id        VARCHAR(36),
+    name             VARCHAR(100) NOT NULL,
+    description      VARCHAR(1000) NOT NULL DEFAULT '',
+    role             VARCHAR(255) NOT NULL DEFAULT '',
+    app_roles        TEXT NOT NULL DEFAULT '',
+    created_at       INTEGER NOT NULL,
+    updated_at       INTEGER NOT NULL,
+    UNIQUE (organization_id, name),
+    FOREIGN KEY (organization_id) REFERENCES organizations (id) ON DELETE CASCADE,
+    FOREIGN KEY (parent_id) REFERENCES teams (id)
+);
+
+CREATE INDEX teams_parent ON teams (parent_id);
+
+CREATE TABLE team_members (
+    team_id          VARCHAR(36) NOT NULL,
+    organization_id  VARCHAR(36) NOT NULL,
+    user_id          VARCHAR(36) NOT NULL,
+    created_at       INTEGER NOT NULL,
+    PRIMARY KEY (team_id, user_id),
+    FOREIGN KEY (team_id) REFERENCES teams (id) ON DELETE CASCADE,
+    FOREIGN KEY (organization_id, user_id) REFERENCES members (organization_id, user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX team_members_user ON team_members (organization_id, user_id);
@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN session_version INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE email_changes (
+    user_id     VARCHAR(36) PRIMARY KEY,
+    email       VARCHAR(255) NOT NULL,
+    code_hash   VARCHAR(64) NOT NULL,
+    expires_at  INTEGER NOT NULL,
+    created_at  INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS account_deletions;
+ALTER TABLE audit_events DROP COLUMN redacted;
@@ -0,0 +1,9 @@
+-- a deleted row keeps its unique keys until it is purged, 0 while it is
+-- not deleted
+ALTER TABLE users ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE organizations ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE members ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX users_deleted ON users (deleted_at) WHERE deleted_at > 0;
+CREATE INDEX organizations_deleted ON organizations (deleted_at) WHERE deleted_at > 0;
+CREATE INDEX members_deleted ON members (deleted_at) WHERE deleted_at > 0;
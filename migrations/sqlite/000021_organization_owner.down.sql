@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS ownership_transfers;
+
+UPDATE members SET role = 'admin' WHERE role = 'owner';
@@ -0,0 +1,11 @@
+-- the organization a user works in by default and the last one they were
+-- issued a token for, either is cleared once the organization is purged
+CREATE TABLE organization_preferences (
+    user_id                  VARCHAR(36) PRIMARY KEY,
+    default_organization_id  VARCHAR(36),
+    last_organization_id     VARCHAR(36),
+    updated_at               INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (default_organization_id) REFERENCES organizations (id) ON DELETE SET NULL,
+    FOREIGN KEY (last_organization_id) REFERENCES organizations (id) ON DELETE SET NULL
+);